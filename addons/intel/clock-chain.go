@@ -368,7 +368,7 @@ func (c *ClockChain) EnableE810Outputs() error {
 			glog.Errorf("failed to set SDP22 pin via sysfs: %s", err)
 		}
 		sma2Cmds := c.DpllPins.GetCommandsForPluginPinSet(c.LeadingNIC.DpllClockID, map[string]string{"SMA2": "2 2"})
-		err = c.DpllPins.ApplyPinCommands(sma2Cmds)
+		err = c.DpllPins.ApplyPinCommands(e810PinSource("sma2-output"), sma2Cmds)
 		if err != nil {
 			glog.Errorf("failed to set SMA2 pin to output: %s", err)
 		}
@@ -439,7 +439,7 @@ func (c *ClockChain) InitPinsTBC() error {
 	}
 	commands = append(commands, commandsGnss...)
 
-	err = BatchPinSet(commands)
+	err = BatchPinSet(e810PinSource("tbc-init"), commands)
 	// event if there was an error we still need to refresh the pin state.
 	fetchErr := c.DpllPins.FetchPins()
 	return errors.Join(err, fetchErr)
@@ -468,7 +468,7 @@ func (c *ClockChain) EnterHoldoverTBC() error {
 	if err != nil {
 		return err
 	}
-	err = BatchPinSet(commands)
+	err = BatchPinSet(e810PinSource("tbc-holdover"), commands)
 	// event if there was an error we still need to refresh the pin state.
 	fetchErr := c.DpllPins.FetchPins()
 	return errors.Join(err, fetchErr)
@@ -497,7 +497,7 @@ func (c *ClockChain) EnterNormalTBC() error {
 	if err != nil {
 		return err
 	}
-	err = BatchPinSet(commands)
+	err = BatchPinSet(e810PinSource("tbc-normal"), commands)
 	// event if there was an error we still need to refresh the pin state.
 	fetchErr := c.DpllPins.FetchPins()
 	return errors.Join(err, fetchErr)
@@ -594,7 +594,7 @@ func (c *ClockChain) SetPinDefaults() error {
 	if err != nil {
 		return err
 	}
-	err = BatchPinSet(commands)
+	err = BatchPinSet(e810PinSource("pin-defaults"), commands)
 	// event if there was an error we still need to refresh the pin state.
 	fetchErr := c.DpllPins.FetchPins()
 	return errors.Join(err, fetchErr)
@@ -602,6 +602,11 @@ func (c *ClockChain) SetPinDefaults() error {
 
 // BatchPinSet function pointer allows mocking of BatchPinSet. Delegates to
 // hardwareconfig.BatchPinSet so both packages share one implementation
-// (dial, send, read-back-and-log-as-table, RHEL-137801 workaround) instead
-// of maintaining two near-identical copies.
+// (dial, send, read-back-and-log-as-table, pin audit log, RHEL-137801
+// workaround) instead of maintaining two near-identical copies.
 var BatchPinSet = hardwareconfig.BatchPinSet
+
+// e810PinSource returns the pin audit source of an e810 plugin action
+func e810PinSource(action string) hardwareconfig.PinCommandSource {
+	return hardwareconfig.PinCommandSource{Component: pluginNameE810, Condition: action}
+}
@@ -7,11 +7,12 @@ import (
 
 	"github.com/golang/glog"
 	dpll "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/hardwareconfig"
 )
 
 // DPLLPins abstracts DPLL pin operations for mocking in tests.
 type DPLLPins interface {
-	ApplyPinCommands(commands []dpll.PinParentDeviceCtl) error
+	ApplyPinCommands(source hardwareconfig.PinCommandSource, commands []dpll.PinParentDeviceCtl) error
 	FetchPins() error
 	GetByLabel(label string, clockID uint64) *dpll.PinInfo
 	GetAllPinsByLabel(label string) []*dpll.PinInfo
@@ -135,8 +136,8 @@ func (d *dpllPins) GetCommandsForPluginPinSet(clockID uint64, pinset pinSet) []d
 	return pinCommands
 }
 
-func (d *dpllPins) ApplyPinCommands(commands []dpll.PinParentDeviceCtl) error {
-	err := BatchPinSet(commands)
+func (d *dpllPins) ApplyPinCommands(source hardwareconfig.PinCommandSource, commands []dpll.PinParentDeviceCtl) error {
+	err := BatchPinSet(source, commands)
 	// event if there was an error we still need to refresh the pin state.
 	fetchErr := d.FetchPins()
 	return errors.Join(err, fetchErr)
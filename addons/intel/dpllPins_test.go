@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	dpll "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/hardwareconfig"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -456,8 +457,9 @@ func TestApplyPinCommands(t *testing.T) {
 		},
 	}
 
-	err := DpllPins.ApplyPinCommands(cmds)
+	err := DpllPins.ApplyPinCommands(e810PinSource("profile-pins"), cmds)
 	assert.NoError(t, err)
 	assert.NotNil(t, mockPinSet.commands)
 	assert.Len(t, mockPinSet.commands, 1)
+	assert.Equal(t, []hardwareconfig.PinCommandSource{{Component: pluginNameE810, Condition: "profile-pins"}}, mockPinSet.sources)
 }
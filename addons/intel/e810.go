@@ -139,7 +139,7 @@ func OnPTPConfigChangeE810(data *interface{}, nodeProfile *ptpv1.PtpProfile) err
 					if hasSysfsSMAPins(device) {
 						err = pinConfig.applyPinSet(device, defaultE810PinConfig)
 					} else {
-						err = DpllPins.ApplyPinCommands(DpllPins.GetCommandsForPluginPinSet(clockIDs[device], defaultE810PinConfig))
+						err = DpllPins.ApplyPinCommands(e810PinSource("default-pin-config"), DpllPins.GetCommandsForPluginPinSet(clockIDs[device], defaultE810PinConfig))
 					}
 					if err != nil {
 						glog.Errorf("e810 failed to set default Pin configuration for %s: %s", device, err)
@@ -165,7 +165,7 @@ func OnPTPConfigChangeE810(data *interface{}, nodeProfile *ptpv1.PtpProfile) err
 							glog.Warningf("SMA input detected but GNSS-1PPS pin not found for clockID %d", clockIDs[device])
 						}
 					}
-					err = DpllPins.ApplyPinCommands(commands)
+					err = DpllPins.ApplyPinCommands(e810PinSource("profile-pins"), commands)
 				}
 				if err != nil {
 					glog.Errorf("e810 failed to set Pin configuration for %s: %s", device, err)
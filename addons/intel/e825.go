@@ -10,6 +10,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll"
 	dpll_netlink "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/hardwareconfig"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/plugin"
 	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
 )
@@ -286,7 +287,7 @@ func (d *E825PluginData) setupGnss(gnss GnssOptions) error {
 		return errors.New("no GNSS pins found")
 	}
 	glog.Infof("Will %s %d GNSS pins: %v", action, len(commands), affectedPins)
-	return BatchPinSet(commands)
+	return BatchPinSet(hardwareconfig.PinCommandSource{Component: pluginNameE825, Condition: "gnss-" + action}, commands)
 }
 
 // setupDpllInputPins enables DPLL input pins for T-BC by setting their PPS parent to selectable
@@ -328,7 +329,7 @@ func (d *E825PluginData) setupDpllInputPins() error {
 		return nil
 	}
 	glog.Infof("Will enable %d DPLL input pins for T-BC (PPS parent only): %v", len(commands), affectedPins)
-	return BatchPinSet(commands)
+	return BatchPinSet(hardwareconfig.PinCommandSource{Component: pluginNameE825, Condition: "tbc-input-pins"}, commands)
 }
 
 // AfterRunPTPCommandE825 performs actions after certain PTP commands for e825 plugin
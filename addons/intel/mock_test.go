@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	dpll "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/hardwareconfig"
 	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
 	"github.com/stretchr/testify/assert"
 	"sigs.k8s.io/yaml"
@@ -17,15 +18,18 @@ import (
 // mockBatchPinSet is a simple mock to unit-test pin set operations
 type mockBatchPinSet struct {
 	commands []dpll.PinParentDeviceCtl
+	sources  []hardwareconfig.PinCommandSource
 }
 
-func (m *mockBatchPinSet) mock(commands []dpll.PinParentDeviceCtl) error {
+func (m *mockBatchPinSet) mock(source hardwareconfig.PinCommandSource, commands []dpll.PinParentDeviceCtl) error {
 	m.commands = append(m.commands, commands...)
+	m.sources = append(m.sources, source)
 	return nil
 }
 
 func (m *mockBatchPinSet) reset() {
 	m.commands = m.commands[:0]
+	m.sources = m.sources[:0]
 }
 
 func setupBatchPinSetMock() (*mockBatchPinSet, func()) {
@@ -389,8 +393,8 @@ func (m *mockedDPLLPins) GetCommandsForPluginPinSet(clockID uint64, pinset pinSe
 	return m.pins.GetCommandsForPluginPinSet(clockID, pinset)
 }
 
-func (m *mockedDPLLPins) ApplyPinCommands(commands []dpll.PinParentDeviceCtl) error {
-	return BatchPinSet(commands)
+func (m *mockedDPLLPins) ApplyPinCommands(source hardwareconfig.PinCommandSource, commands []dpll.PinParentDeviceCtl) error {
+	return BatchPinSet(source, commands)
 }
 
 func setupMockDPLLPins(pins ...*dpll.PinInfo) (*mockedDPLLPins, func()) {
@@ -104,25 +104,22 @@ var SendDelayCompensation = sendDelayCompensation
 
 func sendDelayCompensation(comp *[]delayCompensation, pins DPLLPins) error {
 	glog.Info(comp)
-	conn, err := dpll.Dial(nil)
-	if err != nil {
-		return fmt.Errorf("failed to dial DPLL: %v", err)
-	}
-	//nolint:errcheck
-	defer conn.Close()
-
+	commands := make([]dpll.PinParentDeviceCtl, 0, len(*comp))
 	for _, dc := range *comp {
 		pin := pins.GetByLabel(dc.pinLabel, dc.clockID)
 		if pin == nil {
 			glog.Warningf("pin %s not found for clock ID %d; skipping phase adjustment", dc.pinLabel, dc.clockID)
 			continue
 		}
-		err = conn.PinPhaseAdjust(dpll.PinPhaseAdjustRequest{ID: pin.ID, PhaseAdjust: dc.DelayPs})
-		if err != nil {
-			return fmt.Errorf("failed to send phase adjustment to %s clock id %d: %v",
-				pin.BoardLabel, dc.clockID, err)
-		}
-		glog.Infof("set phaseAdjust of pin %s at clock ID %x to %d ps", pin.BoardLabel, pin.ClockID, dc.DelayPs)
+		phaseAdjust := dc.DelayPs
+		commands = append(commands, dpll.PinParentDeviceCtl{ID: pin.ID, PhaseAdjust: &phaseAdjust})
+		glog.Infof("setting phaseAdjust of pin %s at clock ID %x to %d ps", pin.BoardLabel, pin.ClockID, dc.DelayPs)
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+	if err := BatchPinSet(e810PinSource("delay-compensation"), commands); err != nil {
+		return fmt.Errorf("failed to send phase adjustments: %w", err)
 	}
 	return nil
 }
@@ -4,7 +4,10 @@ import (
 	"os"
 	"testing"
 
+	dpll "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/hardwareconfig"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_ParseVpd(t *testing.T) {
@@ -16,3 +19,25 @@ func Test_ParseVpd(t *testing.T) {
 	assert.Equal(t, "M56954-005", vpd.PartNumber)
 	assert.Equal(t, "507C6F1FB174", vpd.SerialNumber)
 }
+
+func Test_SendDelayCompensation(t *testing.T) {
+	mockPinSet, restore := setupBatchPinSetMock()
+	defer restore()
+	pins := dpllPins{
+		{ID: 5, ClockID: 1, BoardLabel: "SMA1"},
+		{ID: 6, ClockID: 1, BoardLabel: "GNSS-1PPS"},
+	}
+	comps := []delayCompensation{
+		{DelayPs: -1200, pinLabel: "SMA1", clockID: 1},
+		{DelayPs: 800, pinLabel: "GNSS-1PPS", clockID: 1},
+		{DelayPs: 100, pinLabel: "SMA2", clockID: 1},
+	}
+	err := sendDelayCompensation(&comps, &pins)
+	require.NoError(t, err)
+	require.Len(t, mockPinSet.commands, 2, "unknown pins are skipped")
+	assert.Equal(t, uint32(5), mockPinSet.commands[0].ID)
+	assert.Equal(t, int32(-1200), *mockPinSet.commands[0].PhaseAdjust)
+	assert.Equal(t, dpll.PinParentDeviceCtl{ID: 6, PhaseAdjust: mockPinSet.commands[1].PhaseAdjust}, mockPinSet.commands[1])
+	assert.Equal(t, int32(800), *mockPinSet.commands[1].PhaseAdjust)
+	assert.Equal(t, []hardwareconfig.PinCommandSource{{Component: pluginNameE810, Condition: "delay-compensation"}}, mockPinSet.sources)
+}
@@ -6,25 +6,54 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/cep"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/config"
 
 	"github.com/golang/glog"
+	"k8s.io/client-go/kubernetes"
 )
 
 var (
-	socket    string
-	port      int
-	storePath string
-	nodeName  string
+	socket        string
+	port          int
+	metricsPort   int
+	daemonTimeout time.Duration
+	storePath     string
+	nodeName      string
+	delivery      = cep.DefaultDeliveryConfig()
+
+	apiTLS         cep.TLSFiles
+	tokenFile      string
+	tokenReview    bool
+	tokenAudiences string
+	notifyTLS      cep.TLSFiles
 )
 
 func main() {
 	flag.StringVar(&socket, "socket", "/var/run/ptp/events.sock", "Path to daemon IPC Unix socket.")
 	flag.IntVar(&port, "api-port", 9043, "The port the REST API endpoint binds to.")
-	flag.StringVar(&storePath, "store-path", "/var/run/ptp", "Directory for persistent subscription storage.")
+	flag.IntVar(&metricsPort, "metrics-port", 9044, "The port /metrics, /healthz and /readyz bind to.")
+	flag.DurationVar(&daemonTimeout, "daemon-timeout", cep.DefaultDaemonTimeout, "How long the daemon may stay disconnected before /healthz fails, 0 disables it.")
+	flag.StringVar(&storePath, "store-path", "/var/run/ptp", "Directory for persistent subscription and event storage.")
+	flag.IntVar(&delivery.QueueSize, "delivery-queue-size", delivery.QueueSize, "Number of events queued per subscriber before the oldest is dropped.")
+	flag.DurationVar(&delivery.ExpireAfter, "delivery-expire-after", delivery.ExpireAfter, "How long deliveries to a subscriber fail before it expires, 0 disables expiry.")
+	flag.Func("delivery-expire-action", "Action on expired subscribers, evict or inactive (default evict).", func(s string) error {
+		delivery.ExpireAction = cep.ExpireAction(s)
+		return nil
+	})
+	flag.StringVar(&apiTLS.CertFile, "api-tls-cert", "", "Certificate file to serve the REST API over TLS, reloaded on change.")
+	flag.StringVar(&apiTLS.KeyFile, "api-tls-key", "", "Private key file of the REST API certificate.")
+	flag.StringVar(&apiTLS.CAFile, "api-client-ca", "", "CA file to require and verify REST API client certificates (mTLS).")
+	flag.StringVar(&tokenFile, "api-token-file", "", "File of bearer tokens accepted by the REST API, one per line, reloaded on change.")
+	flag.BoolVar(&tokenReview, "api-token-review", false, "Validate REST API bearer tokens with the Kubernetes TokenReview API.")
+	flag.StringVar(&tokenAudiences, "api-token-audiences", "", "Comma separated audiences the reviewed tokens must be issued for, required by --api-token-review.")
+	flag.StringVar(&notifyTLS.CAFile, "notify-tls-ca", "", "CA file to verify HTTPS subscriber endpoints, the system roots if empty.")
+	flag.StringVar(&notifyTLS.CertFile, "notify-tls-cert", "", "Client certificate file presented to HTTPS subscriber endpoints.")
+	flag.StringVar(&notifyTLS.KeyFile, "notify-tls-key", "", "Private key file of the subscriber client certificate.")
 	flag.Parse()
 
 	nodeName = os.Getenv("NODE_NAME")
@@ -47,12 +76,30 @@ func main() {
 		close(closeCh)
 	}()
 
+	cep.RegisterMetrics()
 	cache := cep.NewEventCache(nodeName)
-	ps := cep.NewPubSub(filepath.Join(storePath, "subscriptions.json"), cep.NewHTTPWriterFunc(2*time.Second))
+	cache.SetStorePath(filepath.Join(storePath, "events.json"))
+	if err := cache.LoadFromDisk(); err != nil {
+		glog.Errorf("failed to load cached events from %s: %v", storePath, err)
+	}
+	writerFunc := cep.NewHTTPWriterFunc(2 * time.Second)
+	if notifyTLS != (cep.TLSFiles{}) {
+		var err error
+		if writerFunc, err = cep.NewTLSHTTPWriterFunc(2*time.Second, notifyTLS, closeCh); err != nil {
+			glog.Fatalf("invalid subscriber TLS settings: %v", err)
+		}
+	}
+	ps := cep.NewPubSub(filepath.Join(storePath, "subscriptions.json"), writerFunc)
+	if err := ps.SetDeliveryConfig(delivery); err != nil {
+		glog.Fatalf("invalid delivery settings: %v", err)
+	}
 	if err := ps.LoadFromDisk(); err != nil {
 		glog.Errorf("failed to load subscriptions from %s: %v", storePath, err)
 	}
 	proxy := cep.NewCloudEventProxy(cache, ps)
+	if err := proxy.SetServerConfig(cep.ServerConfig{TLS: apiTLS, Authenticators: authenticators(closeCh)}, closeCh); err != nil {
+		glog.Fatalf("invalid API server settings: %v", err)
+	}
 
 	os.Remove(socket)
 	ln, err := net.Listen("unix", socket)
@@ -74,16 +121,54 @@ func main() {
 				continue
 			}
 			glog.Info("daemon connected")
-			proxy.Listen(conn)
+			proxy.Serve(conn)
 			conn.Close()
 			glog.Warning("daemon disconnected")
 		}
 	}()
 
 	go proxy.ListenAndServe(port)
+	go proxy.ListenAndServeHealth(metricsPort, daemonTimeout)
 
 	<-closeCh
 	ln.Close()
 	os.Remove(socket)
+	cache.Flush()
 	glog.Info("cloud-event-proxy exiting")
 }
+
+// authenticators returns the bearer token authenticators enabled by the flags
+func authenticators(stopCh <-chan struct{}) []cep.TokenAuthenticator {
+	var auths []cep.TokenAuthenticator
+	if tokenFile != "" {
+		a, err := cep.NewFileTokenAuthenticator(tokenFile)
+		if err != nil {
+			glog.Fatalf("invalid API token file: %v", err)
+		}
+		if err = a.Watch(stopCh); err != nil {
+			glog.Errorf("failed to watch %s, tokens will not be reloaded: %v", tokenFile, err)
+		}
+		auths = append(auths, a)
+	}
+	if tokenReview {
+		cfg, err := config.GetKubeConfig()
+		if err != nil {
+			glog.Fatalf("get kubeconfig for token review failed: %v", err)
+		}
+		kubeClient, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			glog.Fatalf("cannot create kube client for token review: %v", err)
+		}
+		var audiences []string
+		if tokenAudiences != "" {
+			audiences = strings.Split(tokenAudiences, ",")
+		}
+		a, err := cep.NewTokenReviewAuthenticator(kubeClient.AuthenticationV1().TokenReviews(),
+			kubeClient.AuthorizationV1().SubjectAccessReviews(), audiences)
+		if err != nil {
+			glog.Fatalf("invalid API token review: %v", err)
+		}
+		auths = append(auths, a)
+	}
+	return auths
+}
@@ -0,0 +1,58 @@
+// dpllctl inspects and controls the kernel DPLL devices and pins, see pkg/dpllctl
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/config"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpllctl"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/hardwareconfig"
+)
+
+func main() {
+	namespace := os.Getenv("NAME_SPACE")
+	if namespace == "" {
+		namespace = "openshift-ptp"
+	}
+	flag.StringVar(&namespace, "namespace", namespace, "Namespace of the board-label-mapping ConfigMap.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: dpllctl [-namespace ns] <command> [flags]\n\n")
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output())
+		_ = dpllctl.New().Run(context.Background(), []string{"help"})
+	}
+	flag.Parse()
+
+	cli := dpllctl.New()
+	// The board label mapping of the daemon is loaded from its ConfigMap when needed,
+	// without Kubernetes access the pins keep their board labels
+	cli.LoadBoardLabelMap = func(hwDefPath string) (hardwareconfig.BoardLabelMap, error) {
+		cfg, err := config.GetKubeConfig()
+		if err != nil {
+			return nil, err
+		}
+		kubeClient, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return hardwareconfig.NewBoardLabelMapLoader(kubeClient, namespace).LoadBoardLabelMap(hwDefPath)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := cli.Run(ctx, flag.Args()); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "dpllctl: %v\n", err)
+		}
+		stop()
+		os.Exit(1)
+	}
+}
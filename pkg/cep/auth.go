@@ -0,0 +1,252 @@
+package cep
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	authv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	authv1client "k8s.io/client-go/kubernetes/typed/authentication/v1"
+	authzv1client "k8s.io/client-go/kubernetes/typed/authorization/v1"
+)
+
+// errUnauthenticated is returned by a TokenAuthenticator for tokens it does not accept
+var errUnauthenticated = errors.New("invalid bearer token")
+
+// errForbidden is returned by a TokenAuthenticator for valid tokens of users denied access to the API
+var errForbidden = errors.New("access denied")
+
+// TokenAuthenticator validates the bearer tokens of API requests.
+type TokenAuthenticator interface {
+	// Authenticate returns the user the token belongs to, or an error when it is not accepted.
+	Authenticate(ctx context.Context, token string) (string, error)
+}
+
+// FileTokenAuthenticator accepts the tokens listed in a file, one per line. Empty lines and lines
+// starting with # are ignored.
+type FileTokenAuthenticator struct {
+	path   string
+	mu     sync.RWMutex
+	tokens [][]byte
+}
+
+// NewFileTokenAuthenticator loads the tokens of the file.
+func NewFileTokenAuthenticator(path string) (*FileTokenAuthenticator, error) {
+	a := &FileTokenAuthenticator{path: path}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reads the tokens of the file, keeping the previous tokens on error.
+func (a *FileTokenAuthenticator) Reload() error {
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return fmt.Errorf("failed to read token file: %w", err)
+	}
+	var tokens [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, []byte(line))
+	}
+	a.mu.Lock()
+	a.tokens = tokens
+	a.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the tokens when the file changes until stopCh is closed.
+func (a *FileTokenAuthenticator) Watch(stopCh <-chan struct{}) error {
+	return watchFiles(stopCh, func() {
+		if err := a.Reload(); err != nil {
+			glog.Errorf("failed to reload tokens, keeping previous tokens: %v", err)
+		}
+	}, a.path)
+}
+
+// Authenticate implements TokenAuthenticator.
+func (a *FileTokenAuthenticator) Authenticate(_ context.Context, token string) (string, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	found := 0
+	for _, t := range a.tokens {
+		found |= subtle.ConstantTimeCompare(t, []byte(token))
+	}
+	if found == 0 {
+		return "", errUnauthenticated
+	}
+	return "token-file", nil
+}
+
+// Reviewed tokens are cached to keep the API server out of the request path. Rejected tokens are
+// cached for a shorter time, so that a client retrying with them does not load the API server either.
+const (
+	tokenReviewCacheTTL         = time.Minute
+	tokenReviewRejectedCacheTTL = 10 * time.Second
+)
+
+// TokenReviewAccessPath and TokenReviewAccessVerb are the non-resource URL and verb the users of
+// reviewed tokens must be granted by RBAC to access the API
+const (
+	TokenReviewAccessPath = "/api/ocloudNotifications/v2"
+	TokenReviewAccessVerb = "get"
+)
+
+type tokenReviewEntry struct {
+	user    string
+	err     error
+	expires time.Time
+}
+
+// TokenReviewAuthenticator validates tokens with the Kubernetes TokenReview API, and authorizes their
+// users with the SubjectAccessReview API.
+type TokenReviewAuthenticator struct {
+	client    authv1client.TokenReviewInterface
+	sar       authzv1client.SubjectAccessReviewInterface
+	audiences []string
+	mu        sync.Mutex
+	cache     map[[sha256.Size]byte]tokenReviewEntry
+}
+
+// NewTokenReviewAuthenticator returns a TokenAuthenticator accepting the tokens issued for one of the
+// audiences to users allowed TokenReviewAccessVerb on TokenReviewAccessPath.
+func NewTokenReviewAuthenticator(client authv1client.TokenReviewInterface, sar authzv1client.SubjectAccessReviewInterface,
+	audiences []string) (*TokenReviewAuthenticator, error) {
+	if len(audiences) == 0 {
+		return nil, fmt.Errorf("token review requires an audience")
+	}
+	return &TokenReviewAuthenticator{
+		client:    client,
+		sar:       sar,
+		audiences: audiences,
+		cache:     make(map[[sha256.Size]byte]tokenReviewEntry),
+	}, nil
+}
+
+// Authenticate implements TokenAuthenticator.
+func (a *TokenReviewAuthenticator) Authenticate(ctx context.Context, token string) (string, error) {
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+	a.mu.Lock()
+	entry, ok := a.cache[key]
+	a.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.user, entry.err
+	}
+
+	user, err := a.review(ctx, token)
+	ttl := tokenReviewCacheTTL
+	switch {
+	case errors.Is(err, errUnauthenticated) || errors.Is(err, errForbidden):
+		ttl = tokenReviewRejectedCacheTTL
+	case err != nil:
+		// the review failed, ask again on the next request
+		return "", err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, e := range a.cache {
+		if now.After(e.expires) {
+			delete(a.cache, k)
+		}
+	}
+	a.cache[key] = tokenReviewEntry{user: user, err: err, expires: now.Add(ttl)}
+	return user, err
+}
+
+// review authenticates the token and authorizes its user
+func (a *TokenReviewAuthenticator) review(ctx context.Context, token string) (string, error) {
+	review, err := a.client.Create(ctx, &authv1.TokenReview{
+		Spec: authv1.TokenReviewSpec{Token: token, Audiences: a.audiences},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("token review failed: %w", err)
+	}
+	if !review.Status.Authenticated {
+		if review.Status.Error != "" {
+			return "", fmt.Errorf("%w: %s", errUnauthenticated, review.Status.Error)
+		}
+		return "", errUnauthenticated
+	}
+	// authenticators ignoring the requested audiences report none
+	if !slices.ContainsFunc(review.Status.Audiences, func(aud string) bool { return slices.Contains(a.audiences, aud) }) {
+		return "", fmt.Errorf("%w: not issued for audiences %s", errUnauthenticated, strings.Join(a.audiences, ","))
+	}
+
+	user := review.Status.User
+	extra := make(map[string]authzv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authzv1.ExtraValue(v)
+	}
+	access, err := a.sar.Create(ctx, &authzv1.SubjectAccessReview{
+		Spec: authzv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+			NonResourceAttributes: &authzv1.NonResourceAttributes{
+				Path: TokenReviewAccessPath,
+				Verb: TokenReviewAccessVerb,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("subject access review failed: %w", err)
+	}
+	if !access.Status.Allowed {
+		return "", fmt.Errorf("%w: %s may not %s %s", errForbidden, user.Username, TokenReviewAccessVerb, TokenReviewAccessPath)
+	}
+	return user.Username, nil
+}
+
+// requireToken rejects requests without a bearer token accepted by one of the authenticators
+func requireToken(next http.Handler, authenticators []TokenAuthenticator) http.Handler {
+	if len(authenticators) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "bearer token required", http.StatusUnauthorized)
+			return
+		}
+		var errs []error
+		for _, a := range authenticators {
+			user, err := a.Authenticate(r.Context(), token)
+			if err == nil {
+				glog.V(4).Infof("authenticated %s %s from %s as %s", r.Method, r.URL.Path, r.RemoteAddr, user)
+				next.ServeHTTP(w, r)
+				return
+			}
+			errs = append(errs, err)
+		}
+		err := errors.Join(errs...)
+		glog.Warningf("rejected %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+		if errors.Is(err, errForbidden) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,159 @@
+package cep
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRequireToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "tokens")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("# consumers\nsecret-a\n\n  secret-b  \n"), 0o600))
+	auth, err := NewFileTokenAuthenticator(tokenFile)
+	require.NoError(t, err)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	require.NoError(t, auth.Watch(stopCh))
+
+	proxy := NewCloudEventProxy(NewEventCache("worker-0"), NewPubSub(filepath.Join(t.TempDir(), "subscriptions.json"), nil))
+	require.NoError(t, proxy.SetServerConfig(ServerConfig{Authenticators: []TokenAuthenticator{auth}}, stopCh))
+	server := httptest.NewServer(proxy.Handler())
+	defer server.Close()
+
+	get := func(authorization string) int {
+		req, reqErr := http.NewRequest(http.MethodGet, server.URL+apiBase+subscriptionsPath, nil)
+		require.NoError(t, reqErr)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, reqErr := http.DefaultClient.Do(req)
+		require.NoError(t, reqErr)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get(""))
+	assert.Equal(t, http.StatusUnauthorized, get("Basic c2VjcmV0LWE="))
+	assert.Equal(t, http.StatusUnauthorized, get("Bearer secret"))
+	assert.Equal(t, http.StatusUnauthorized, get("Bearer # consumers"))
+	assert.Equal(t, http.StatusOK, get("Bearer secret-a"))
+	assert.Equal(t, http.StatusOK, get("Bearer secret-b"))
+
+	// tokens are reloaded when the file changes
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret-c\n"), 0o600))
+	require.Eventually(t, func() bool { return get("Bearer secret-c") == http.StatusOK }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, http.StatusUnauthorized, get("Bearer secret-a"))
+}
+
+func TestTokenReviewAuthenticator(t *testing.T) {
+	client := fake.NewClientset()
+	reviews, accessReviews := 0, 0
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authv1.TokenReview)
+		assert.Equal(t, []string{"cloud-event-proxy"}, review.Spec.Audiences)
+		switch review.Spec.Token {
+		case "valid":
+			review.Status = authv1.TokenReviewStatus{Authenticated: true, Audiences: review.Spec.Audiences,
+				User: authv1.UserInfo{Username: "system:serviceaccount:app:consumer", Groups: []string{"system:serviceaccounts"}}}
+		case "denied":
+			review.Status = authv1.TokenReviewStatus{Authenticated: true, Audiences: review.Spec.Audiences,
+				User: authv1.UserInfo{Username: "system:serviceaccount:app:other"}}
+		case "other-audience":
+			review.Status = authv1.TokenReviewStatus{Authenticated: true, Audiences: []string{"https://kubernetes.default.svc"},
+				User: authv1.UserInfo{Username: "system:serviceaccount:app:consumer"}}
+		default:
+			review.Status = authv1.TokenReviewStatus{Error: "token expired"}
+		}
+		return true, review, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		accessReviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authzv1.SubjectAccessReview)
+		assert.Equal(t, &authzv1.NonResourceAttributes{Path: TokenReviewAccessPath, Verb: TokenReviewAccessVerb}, review.Spec.NonResourceAttributes)
+		review.Status.Allowed = review.Spec.User == "system:serviceaccount:app:consumer" &&
+			assert.Equal(t, []string{"system:serviceaccounts"}, review.Spec.Groups)
+		return true, review, nil
+	})
+
+	_, err := NewTokenReviewAuthenticator(client.AuthenticationV1().TokenReviews(), client.AuthorizationV1().SubjectAccessReviews(), nil)
+	assert.Error(t, err, "an audience is required")
+	auth, err := NewTokenReviewAuthenticator(client.AuthenticationV1().TokenReviews(), client.AuthorizationV1().SubjectAccessReviews(),
+		[]string{"cloud-event-proxy"})
+	require.NoError(t, err)
+
+	user, err := auth.Authenticate(context.Background(), "valid")
+	require.NoError(t, err)
+	assert.Equal(t, "system:serviceaccount:app:consumer", user)
+	_, err = auth.Authenticate(context.Background(), "valid")
+	require.NoError(t, err)
+	assert.Equal(t, 1, reviews, "accepted tokens are cached")
+	assert.Equal(t, 1, accessReviews)
+
+	_, err = auth.Authenticate(context.Background(), "denied")
+	assert.ErrorIs(t, err, errForbidden)
+	assert.Equal(t, 2, accessReviews)
+
+	_, err = auth.Authenticate(context.Background(), "other-audience")
+	assert.ErrorIs(t, err, errUnauthenticated)
+	assert.Equal(t, 2, accessReviews, "tokens of other audiences are not authorized")
+
+	_, err = auth.Authenticate(context.Background(), "expired")
+	assert.ErrorIs(t, err, errUnauthenticated)
+	assert.ErrorContains(t, err, "token expired")
+
+	// users denied access are forbidden
+	proxy := NewCloudEventProxy(NewEventCache("worker-0"), NewPubSub(filepath.Join(t.TempDir(), "subscriptions.json"), nil))
+	require.NoError(t, proxy.SetServerConfig(ServerConfig{Authenticators: []TokenAuthenticator{auth}}, nil))
+	for token, status := range map[string]int{"valid": http.StatusOK, "denied": http.StatusForbidden, "expired": http.StatusUnauthorized} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, apiBase+subscriptionsPath, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		proxy.Handler().ServeHTTP(rec, req)
+		assert.Equal(t, status, rec.Code, token)
+	}
+
+	// rejected tokens are cached for a shorter time
+	for _, token := range []string{"denied", "other-audience", "expired"} {
+		_, err = auth.Authenticate(context.Background(), token)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 4, reviews)
+	auth.mu.Lock()
+	for key, entry := range auth.cache {
+		if key != sha256.Sum256([]byte("valid")) {
+			assert.WithinDuration(t, time.Now().Add(tokenReviewRejectedCacheTTL), entry.expires, time.Second)
+			entry.expires = time.Now()
+			auth.cache[key] = entry
+		}
+	}
+	auth.mu.Unlock()
+	_, err = auth.Authenticate(context.Background(), "expired")
+	assert.Error(t, err)
+	assert.Equal(t, 5, reviews, "rejections past their cache time are reviewed again")
+
+	// API errors are not cached
+	client.PrependReactor("create", "tokenreviews", func(k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		return true, nil, errors.New("connection refused")
+	})
+	for i := 0; i < 2; i++ {
+		_, err = auth.Authenticate(context.Background(), "unreviewed")
+		assert.ErrorContains(t, err, "connection refused")
+	}
+	assert.Equal(t, 7, reviews)
+}
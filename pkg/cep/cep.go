@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
@@ -22,6 +23,28 @@ import (
 const apiBase = "/api/ocloudNotifications/v2/"
 const subscriptionsPath = "subscriptions"
 
+// staleHeader marks CurrentState responses holding state restored from disk that the daemon did not report yet
+const staleHeader = "X-Event-Stale"
+
+// Offsets are not part of the O-RAN spec, their periodic summaries are served on a resource of their own
+const (
+	OffsetSummaryChange   ptp.EventType     = "event.sync.ptp-status.offset-summary-change"
+	OffsetSummaryResource ptp.EventResource = "/sync/ptp-status/offset-summary"
+)
+
+// Port roles are not part of the O-RAN spec either, they let applications follow upstream port failover
+const (
+	PortStateChange   ptp.EventType     = "event.sync.ptp-status.port-state-change"
+	PortStateResource ptp.EventResource = "/sync/ptp-status/port-state"
+)
+
+// Time error mask verdicts are not part of the O-RAN spec, they report the MTIE and TDEV of the offset
+// streams against their ITU-T masks
+const (
+	TimeErrorMaskChange   ptp.EventType     = "event.sync.ptp-status.time-error-mask-change"
+	TimeErrorMaskResource ptp.EventResource = "/sync/ptp-status/time-error-mask"
+)
+
 // oranMapping maps IPC events to the appropriate CloudEvent
 func oranMapping(ipcType string) (eventType ptp.EventType, source ptp.EventResource, ok bool) {
 	switch ipcType {
@@ -39,6 +62,12 @@ func oranMapping(ipcType string) (eventType ptp.EventType, source ptp.EventResou
 		return ptp.SynceClockQualityChange, ptp.SynceClockQuality, true
 	case ipc.TypeSyncState:
 		return ptp.SyncStateChange, ptp.SyncStatusState, true
+	case ipc.TypeOffsetSummary:
+		return OffsetSummaryChange, OffsetSummaryResource, true
+	case ipc.TypePortState:
+		return PortStateChange, PortStateResource, true
+	case ipc.TypeTimeErrorMask:
+		return TimeErrorMaskChange, TimeErrorMaskResource, true
 	default:
 		return "", "", false
 	}
@@ -72,17 +101,27 @@ func metricDV(resource string, value interface{}) event.DataValue {
 	}
 }
 
-// TODO: replace placeholder offsets with real values from the daemon via IPC.
 // Offsets are not part of the O-RAN spec but were included in cloud-event-proxy v1.
-// Including offsets in state change events either makes them noisier (firing on every
-// offset change) or loses offset tracking when the state is not changing. A separate
-// IPC message for periodic offset reporting would avoid both problems and should be considered.
-// Otherwise unless applications are given prometheus metric access this will be invisible to
-// them. If applications need offset visibility it should be given first class API support,
-// periodic summaries with min/max/avg over a window.
-const placeholderOffset = int64(0)
-
-func buildDataValues(resourceAddr string, v ipc.Value) []event.DataValue {
+// State change events carry the mean offset of the latest offset summary of their source,
+// updated in the cache without publishing so that offset changes don't make them noisier.
+// Applications that need offset visibility subscribe to OffsetSummaryResource.
+
+// unknownOffset is reported until the first offset summary of the source arrives
+const unknownOffset = int64(0)
+
+// unknownGPSFix is reported as the GNSS fix, which is not part of the IPC GNSS state
+const unknownGPSFix = int64(0)
+
+// offsetStateTypes maps the source of an offset summary to the state message reporting its offset.
+// A state resource carries the offset of one source only: ts2phc reports on the same lock-state
+// resource as ptp4l, so like the DPLL its offset is only served on the offset summary resource.
+var offsetStateTypes = map[string]string{
+	"ptp4l":   ipc.TypePTPState,
+	"phc2sys": ipc.TypeOSClockState,
+	"gnss":    ipc.TypeGNSSState,
+}
+
+func buildDataValues(resourceAddr string, v ipc.Value, offset int64) []event.DataValue {
 	switch val := v.(type) {
 	case ipc.StateValue:
 		dv := event.DataValue{
@@ -91,7 +130,7 @@ func buildDataValues(resourceAddr string, v ipc.Value) []event.DataValue {
 			ValueType: event.ENUMERATION,
 			Value:     val.State,
 		}
-		return []event.DataValue{dv, metricDV(resourceAddr, placeholderOffset)}
+		return []event.DataValue{dv, metricDV(resourceAddr, offset)}
 	case ipc.GNSSStateValue:
 		dv := event.DataValue{
 			Resource:  resourceAddr,
@@ -100,8 +139,8 @@ func buildDataValues(resourceAddr string, v ipc.Value) []event.DataValue {
 			Value:     val.State,
 		}
 		return []event.DataValue{dv,
-			metricDV(resourceAddr, placeholderOffset),
-			metricDV(path.Join(resourceAddr, "gpsFix"), placeholderOffset),
+			metricDV(resourceAddr, offset),
+			metricDV(path.Join(resourceAddr, "gpsFix"), unknownGPSFix),
 		}
 	case ipc.SyncStateValue:
 		return []event.DataValue{{
@@ -110,6 +149,20 @@ func buildDataValues(resourceAddr string, v ipc.Value) []event.DataValue {
 			ValueType: event.ENUMERATION,
 			Value:     val.State,
 		}}
+	case ipc.PortStateValue:
+		return []event.DataValue{{
+			Resource:  resourceAddr,
+			DataType:  event.NOTIFICATION,
+			ValueType: event.ENUMERATION,
+			Value:     val.Role,
+		}}
+	case ipc.TimeErrorMaskValue:
+		return []event.DataValue{{
+			Resource:  path.Join(resourceAddr, val.Source, val.Statistic),
+			DataType:  event.NOTIFICATION,
+			ValueType: event.ENUMERATION,
+			Value:     val.Verdict,
+		}}
 	case ipc.SyncEStateValue:
 		return []event.DataValue{metricDV(resourceAddr, val.State)}
 	case ipc.ClockClassValue:
@@ -119,6 +172,14 @@ func buildDataValues(resourceAddr string, v ipc.Value) []event.DataValue {
 			metricDV(path.Join(resourceAddr, "Ql"), float64(val.QL)),
 			metricDV(path.Join(resourceAddr, "extQl"), float64(val.ExtendedQL)),
 		}
+	case ipc.OffsetSummaryValue:
+		offsetAddr := path.Join(resourceAddr, val.Source, "offset")
+		return []event.DataValue{
+			metricDV(path.Join(offsetAddr, "min"), val.Min),
+			metricDV(path.Join(offsetAddr, "max"), val.Max),
+			metricDV(path.Join(offsetAddr, "mean"), val.Mean),
+			metricDV(path.Join(offsetAddr, "stddev"), val.StdDev),
+		}
 	default:
 		return nil
 	}
@@ -127,7 +188,7 @@ func buildDataValues(resourceAddr string, v ipc.Value) []event.DataValue {
 func setDataValue(e *event.Event, dv event.DataValue) bool {
 	for i, existing := range e.Data.Values {
 		if existing.Resource == dv.Resource && existing.DataType == dv.DataType {
-			if existing.Value == dv.Value {
+			if valuesEqual(existing.Value, dv.Value) {
 				return false
 			}
 			e.Data.Values[i] = dv
@@ -142,52 +203,217 @@ func setDataValue(e *event.Event, dv event.DataValue) bool {
 type CloudEventProxy struct {
 	cache  *EventCache
 	pubSub *PubSub
+	// publishMu serializes the cache updates and their publishing with the replays of new subscriptions
+	publishMu sync.Mutex
+	// session is the protocol negotiated with the last daemon hello, nil until the daemon sent one.
+	// It is kept across connections, as the daemon says hello on its event connection only.
+	session   *ipc.Session
+	sessionMu sync.Mutex
+	// tls serves the REST API over TLS when set
+	tls *tlsReloader
+	// authenticators validate the bearer token of API requests, none disables authentication
+	authenticators []TokenAuthenticator
+	health         *daemonHealth
+}
+
+// ServerConfig secures the REST API.
+type ServerConfig struct {
+	// TLS serves the API over TLS when CertFile and KeyFile are set, and requires client certificates
+	// signed by CAFile when it is set
+	TLS TLSFiles
+	// Authenticators validate the bearer token of API requests, none disables token authentication
+	Authenticators []TokenAuthenticator
 }
 
 // NewCloudEventProxy creates a CloudEventProxy with the given cache and pubsub.
 func NewCloudEventProxy(cache *EventCache, pubsub *PubSub) *CloudEventProxy {
-	return &CloudEventProxy{cache: cache, pubSub: pubsub}
+	return &CloudEventProxy{cache: cache, pubSub: pubsub, health: newDaemonHealth()}
+}
+
+// Serve opens the daemon connection with our hello, then handles the messages of the daemon like Listen
+// until the connection is closed.
+func (l *CloudEventProxy) Serve(conn io.ReadWriter) {
+	l.health.setConnected(true)
+	defer l.health.setConnected(false)
+	if err := ipc.Encode(conn, []ipc.Message{ipc.NewHello()}); err != nil {
+		glog.Errorf("failed to send IPC hello to daemon: %v", err)
+	}
+	if l.listen(conn) {
+		// the daemon replays its state on its event connection, the one it says hello on, again
+		// when it reconnects
+		l.health.setReplayed(false)
+	}
 }
 
 // Listen reads from r, and handles events written to the stream.
 func (l *CloudEventProxy) Listen(r io.Reader) {
+	l.listen(r)
+}
+
+// listen handles the events written to r until it is closed, and returns whether the daemon said
+// hello on it
+func (l *CloudEventProxy) listen(r io.Reader) bool {
+	hello := false
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Bytes()
 		if len(line) == 0 {
 			continue
 		}
+		if c, ok := ipc.ParseLegacyControl(string(line)); ok {
+			IPCMessagesReceived.WithLabelValues(ipc.TypeControl).Inc()
+			l.handleControl(c)
+			continue
+		}
 		var msg ipc.Message
 		if err := json.Unmarshal(line, &msg); err != nil {
 			glog.Errorf("IPC unmarshal error, skipping message: %v", err)
+			IPCParseErrors.Inc()
 			continue
 		}
-		if msg.Version != ipc.Version {
-			glog.Warningf("IPC version mismatch: got %d, want %d", msg.Version, ipc.Version)
+		if peer, ok := msg.Values.(ipc.HelloValue); ok {
+			IPCMessagesReceived.WithLabelValues(ipc.TypeHello).Inc()
+			l.handleHello(peer)
+			hello = true
 			continue
 		}
+		if msg.Version < ipc.MinVersion || msg.Version > ipc.Version {
+			glog.Warningf("IPC version mismatch: got %d, want %d..%d", msg.Version, ipc.MinVersion, ipc.Version)
+			IPCRejectedMessages.WithLabelValues("version").Inc()
+			continue
+		}
+		if !ipc.KnownType(msg.Type) {
+			glog.Warningf("unknown IPC message type %q, skipping message", msg.Type)
+			IPCRejectedMessages.WithLabelValues("type").Inc()
+			continue
+		}
+		if !l.ipcSession().Supports(msg.Type) {
+			glog.Warningf("IPC message type %q not negotiated with the daemon, skipping message", msg.Type)
+			IPCRejectedMessages.WithLabelValues("session").Inc()
+			continue
+		}
+		IPCMessagesReceived.WithLabelValues(msg.Type).Inc()
 
 		switch msg.Type {
+		case ipc.TypeControl:
+			if c, ok := msg.Values.(ipc.ControlValue); ok {
+				l.handleControl(c)
+			}
+			continue
 		case ipc.TypeCacheClear:
 			glog.Info("received cache_clear from daemon, clearing event cache")
 			l.cache.Clear()
 			continue
 		case ipc.TypeStatusResponse:
-			// TODO: handle this
-			glog.Info("received status_response from daemon")
+			glog.Info("received status_response from daemon, dropping state it did not replay")
+			l.cache.Reconcile()
+			l.health.setReplayed(true)
 			continue
 		case ipc.TypeStatusRequest:
 			glog.Warning("received status_request from daemon (unexpected direction)")
 			continue
 		}
 
-		if newEvent := l.cache.Update(msg); newEvent != nil && l.pubSub != nil {
-			l.pubSub.Publish(newEvent)
-		}
+		l.publish(msg)
 	}
 	if err := scanner.Err(); err != nil {
 		glog.Errorf("IPC reader error: %v", err)
 	}
+	return hello
+}
+
+// publish updates the cache with the message and publishes the resulting event
+func (l *CloudEventProxy) publish(msg ipc.Message) {
+	l.publishMu.Lock()
+	defer l.publishMu.Unlock()
+	if newEvent := l.cache.Update(msg); newEvent != nil && l.pubSub != nil {
+		l.pubSub.Publish(newEvent)
+	}
+}
+
+// subscribe registers a subscription with add and, when replay is set, queues the cached events matching
+// the resource for it. No cache update is published in between, so the subscriber neither misses a change
+// nor receives a replayed event older than the events published to it.
+func (l *CloudEventProxy) subscribe(resource string, replay bool, add func() string) string {
+	l.publishMu.Lock()
+	defer l.publishMu.Unlock()
+	id := add()
+	if replay {
+		l.pubSub.Replay(id, l.cache.Matching(resource))
+	}
+	return id
+}
+
+// SetServerConfig secures the REST API served by ListenAndServe. The TLS files are reloaded when
+// they change until stopCh is closed.
+func (l *CloudEventProxy) SetServerConfig(cfg ServerConfig, stopCh <-chan struct{}) error {
+	l.authenticators = cfg.Authenticators
+	if cfg.TLS.CertFile == "" && cfg.TLS.KeyFile == "" {
+		if cfg.TLS.CAFile != "" {
+			return fmt.Errorf("client certificate authentication requires TLS")
+		}
+		return nil
+	}
+	r, err := newTLSReloader(cfg.TLS)
+	if err != nil {
+		return err
+	}
+	if err = r.watch(stopCh); err != nil {
+		glog.Errorf("failed to watch TLS files, certificates will not be reloaded: %v", err)
+	}
+	l.tls = r
+	return nil
+}
+
+// ipcSession returns the protocol negotiated with the daemon, nil when only protocol version 1 is spoken
+func (l *CloudEventProxy) ipcSession() *ipc.Session {
+	l.sessionMu.Lock()
+	defer l.sessionMu.Unlock()
+	return l.session
+}
+
+// handleHello negotiates the protocol with the daemon hello. The message types introduced after
+// protocol version 1 are accepted only when the session supports them.
+func (l *CloudEventProxy) handleHello(peer ipc.HelloValue) {
+	session, err := ipc.Negotiate(peer)
+	l.sessionMu.Lock()
+	l.session = session
+	l.sessionMu.Unlock()
+	if err != nil {
+		glog.Errorf("IPC handshake with daemon failed, speaking protocol version %d: %v", ipc.MinVersion, err)
+		IPCHandshakes.WithLabelValues("failed").Inc()
+		return
+	}
+	result := "ok"
+	if session.Version < ipc.Version {
+		result = "downgraded"
+	}
+	glog.Infof("negotiated IPC protocol version %d with daemon", session.Version)
+	if unknown := peer.UnknownTypes(); len(unknown) > 0 {
+		result = "downgraded"
+		glog.Warningf("daemon supports IPC message types %s unknown to cloud-event-proxy, they will be skipped", strings.Join(unknown, ", "))
+	}
+	IPCHandshakes.WithLabelValues(result).Inc()
+}
+
+// handleControl applies a control command of the daemon
+func (l *CloudEventProxy) handleControl(c ipc.ControlValue) {
+	switch c.Command {
+	case ipc.CommandRestart:
+		glog.Info("received restart from daemon, clearing event cache")
+		l.cache.Clear()
+		l.health.setReplayed(false)
+	case ipc.CommandLiveStart:
+		glog.Info("received live_start from daemon, following data is live")
+		// the live start is per process, daemons saying hello end their full replay with a
+		// status_response instead
+		if l.ipcSession() == nil {
+			l.health.setReplayed(true)
+		}
+	default:
+		glog.Warningf("unknown control command %q from daemon", c.Command)
+		IPCRejectedMessages.WithLabelValues("command").Inc()
+	}
 }
 
 // Handler returns the HTTP handler for the CloudEvent REST API.
@@ -197,20 +423,29 @@ func (l *CloudEventProxy) Handler() http.Handler {
 		subPattern := apiBase + subscriptionsPath
 		mux.HandleFunc(subPattern, l.subscriptionHandler)
 		mux.HandleFunc(subPattern+"/", l.subscriptionHandler)
+		mux.HandleFunc(apiBase+streamPath, l.streamHandler)
 	}
 	mux.HandleFunc(apiBase, l.currentStateHandler)
-	return mux
+	return requireToken(mux, l.authenticators)
 }
 
-// ListenAndServe starts the HTTP API server on the given port.
+// ListenAndServe starts the API server on the given port, over TLS when configured.
 func (l *CloudEventProxy) ListenAndServe(port int) {
 	server := &http.Server{
 		Addr:              fmt.Sprintf(":%d", port),
 		ReadHeaderTimeout: 5 * time.Second,
 		Handler:           l.Handler(),
 	}
-	glog.Infof("API server listening on :%d", port)
-	if err := server.ListenAndServe(); err != nil {
+	var err error
+	if l.tls != nil {
+		server.TLSConfig = l.tls.serverConfig()
+		glog.Infof("API server listening on :%d with TLS", port)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		glog.Infof("API server listening on :%d", port)
+		err = server.ListenAndServe()
+	}
+	if err != nil {
 		glog.Errorf("API server error: %v", err)
 	}
 }
@@ -242,6 +477,9 @@ func (l *CloudEventProxy) currentStateHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if l.cache.Stale(resource) {
+		w.Header().Set(staleHeader, "true")
+	}
 	json.NewEncoder(w).Encode(ce)
 }
 
@@ -267,6 +505,24 @@ func NewHTTPWriterFunc(timeout time.Duration) WriterFunc {
 	}
 }
 
+// NewTLSHTTPWriterFunc returns a WriterFunc like NewHTTPWriterFunc whose writers verify HTTPS endpoints
+// against files.CAFile, or the system roots when unset, and present the client certificate of files
+// when set. The client certificate is reloaded when it changes until stopCh is closed.
+func NewTLSHTTPWriterFunc(timeout time.Duration, files TLSFiles, stopCh <-chan struct{}) (WriterFunc, error) {
+	r, err := newTLSReloader(files)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.watch(stopCh); err != nil {
+		glog.Errorf("failed to watch TLS files, certificates will not be reloaded: %v", err)
+	}
+	return func(endpoint string) io.Writer {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = r.clientConfig()
+		return NewHTTPWriter(endpoint, &http.Client{Timeout: timeout, Transport: transport})
+	}, nil
+}
+
 func (hw *httpWriter) Write(p []byte) (int, error) {
 	resp, err := hw.client.Post(hw.endpoint, "application/json", bytes.NewReader(p))
 	if err != nil {
@@ -283,12 +539,25 @@ func (hw *httpWriter) Write(p []byte) (int, error) {
 type subscriptionRequest struct {
 	EndpointURI string `json:"EndpointUri"`
 	Resource    string `json:"ResourceAddress"`
+	// Replay delivers the cached events matching ResourceAddress to the endpoint right after subscribing
+	Replay bool `json:"Replay,omitempty"`
 }
 
 type subscriptionResponse struct {
 	ID          string `json:"SubscriptionId"`
 	EndpointURI string `json:"EndpointUri"`
 	Resource    string `json:"ResourceAddress"`
+	// ResolvedResources lists the current resource addresses matching a wildcard or interface level ResourceAddress
+	ResolvedResources []string `json:"ResolvedResources,omitempty"`
+}
+
+func (l *CloudEventProxy) newSubscriptionResponse(id, endpoint, resource string) subscriptionResponse {
+	return subscriptionResponse{
+		ID:                id,
+		EndpointURI:       endpoint,
+		Resource:          resource,
+		ResolvedResources: l.cache.Resolve(resource),
+	}
 }
 
 func (l *CloudEventProxy) subscriptionHandler(w http.ResponseWriter, r *http.Request) {
@@ -340,27 +609,25 @@ func (l *CloudEventProxy) handleCreateSubscription(w http.ResponseWriter, r *htt
 		http.Error(w, "invalid EndpointUri", http.StatusBadRequest)
 		return
 	}
+	if err := l.cache.ValidateResource(req.Resource); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	id := l.pubSub.Subscribe(req.Resource, req.EndpointURI)
+	id := l.subscribe(req.Resource, req.Replay, func() string {
+		return l.pubSub.Subscribe(req.Resource, req.EndpointURI)
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(subscriptionResponse{
-		ID:          id,
-		EndpointURI: req.EndpointURI,
-		Resource:    req.Resource,
-	})
+	json.NewEncoder(w).Encode(l.newSubscriptionResponse(id, req.EndpointURI, req.Resource))
 }
 
 func (l *CloudEventProxy) handleGetSubscriptions(w http.ResponseWriter, _ *http.Request) {
 	subs := l.pubSub.List()
 	resp := make([]subscriptionResponse, 0, len(subs))
 	for _, sub := range subs {
-		resp = append(resp, subscriptionResponse{
-			ID:          sub.ID,
-			EndpointURI: sub.Endpoint,
-			Resource:    sub.Resource,
-		})
+		resp = append(resp, l.newSubscriptionResponse(sub.ID, sub.Endpoint, sub.Resource))
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
@@ -373,11 +640,7 @@ func (l *CloudEventProxy) handleGetSubscriptionByID(w http.ResponseWriter, id st
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(subscriptionResponse{
-		ID:          sub.ID,
-		EndpointURI: sub.Endpoint,
-		Resource:    sub.Resource,
-	})
+	json.NewEncoder(w).Encode(l.newSubscriptionResponse(sub.ID, sub.Endpoint, sub.Resource))
 }
 
 func (l *CloudEventProxy) handleDeleteSubscriptionByID(w http.ResponseWriter, id string) {
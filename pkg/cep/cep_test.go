@@ -14,6 +14,7 @@ import (
 	"time"
 
 	ce "github.com/cloudevents/sdk-go/v2/event"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/redhat-cne/sdk-go/pkg/event"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -29,6 +30,7 @@ const (
 
 func TestBuildDataValues(t *testing.T) {
 	resource := "/cluster/node/worker-0/ens2f0/master"
+	offset := int64(-12)
 
 	tests := []struct {
 		name     string
@@ -37,31 +39,31 @@ func TestBuildDataValues(t *testing.T) {
 		want     []event.DataValue
 	}{
 		{
-			name:     "ptp state: state + offset placeholder",
+			name:     "ptp state: state + offset",
 			resource: resource,
 			value:    ipc.StateValue{State: ipc.StateLocked},
 			want: []event.DataValue{
 				{Resource: resource, DataType: event.NOTIFICATION, ValueType: event.ENUMERATION, Value: ipc.StateLocked},
-				{Resource: resource, DataType: event.METRIC, ValueType: event.DECIMAL, Value: placeholderOffset},
+				{Resource: resource, DataType: event.METRIC, ValueType: event.DECIMAL, Value: offset},
 			},
 		},
 		{
-			name:     "os clock state: state + offset placeholder",
+			name:     "os clock state: state + offset",
 			resource: "/cluster/node/worker-0/CLOCK_REALTIME",
 			value:    ipc.StateValue{State: ipc.StateFreerun},
 			want: []event.DataValue{
 				{Resource: "/cluster/node/worker-0/CLOCK_REALTIME", DataType: event.NOTIFICATION, ValueType: event.ENUMERATION, Value: ipc.StateFreerun},
-				{Resource: "/cluster/node/worker-0/CLOCK_REALTIME", DataType: event.METRIC, ValueType: event.DECIMAL, Value: placeholderOffset},
+				{Resource: "/cluster/node/worker-0/CLOCK_REALTIME", DataType: event.METRIC, ValueType: event.DECIMAL, Value: offset},
 			},
 		},
 		{
-			name:     "gnss state: state + offset placeholder + gpsFix placeholder",
+			name:     "gnss state: state + offset + gpsFix placeholder",
 			resource: resource,
 			value:    ipc.GNSSStateValue{State: ipc.GNSSSynchronized},
 			want: []event.DataValue{
 				{Resource: resource, DataType: event.NOTIFICATION, ValueType: event.ENUMERATION, Value: ipc.GNSSSynchronized},
-				{Resource: resource, DataType: event.METRIC, ValueType: event.DECIMAL, Value: placeholderOffset},
-				{Resource: resource + "/gpsFix", DataType: event.METRIC, ValueType: event.DECIMAL, Value: placeholderOffset},
+				{Resource: resource, DataType: event.METRIC, ValueType: event.DECIMAL, Value: offset},
+				{Resource: resource + "/gpsFix", DataType: event.METRIC, ValueType: event.DECIMAL, Value: unknownGPSFix},
 			},
 		},
 		{
@@ -97,6 +99,33 @@ func TestBuildDataValues(t *testing.T) {
 				{Resource: "/cluster/node/worker-0/ens7f0/extQl", DataType: event.METRIC, ValueType: event.DECIMAL, Value: float64(0x20)},
 			},
 		},
+		{
+			name:     "offset summary: statistics under the source",
+			resource: "/cluster/node/worker-0/ens2f0",
+			value:    ipc.OffsetSummaryValue{Source: "ptp4l", Window: 60, Samples: 60, Min: -20, Max: 8, Mean: -3.5, StdDev: 6.25},
+			want: []event.DataValue{
+				{Resource: "/cluster/node/worker-0/ens2f0/ptp4l/offset/min", DataType: event.METRIC, ValueType: event.DECIMAL, Value: float64(-20)},
+				{Resource: "/cluster/node/worker-0/ens2f0/ptp4l/offset/max", DataType: event.METRIC, ValueType: event.DECIMAL, Value: float64(8)},
+				{Resource: "/cluster/node/worker-0/ens2f0/ptp4l/offset/mean", DataType: event.METRIC, ValueType: event.DECIMAL, Value: -3.5},
+				{Resource: "/cluster/node/worker-0/ens2f0/ptp4l/offset/stddev", DataType: event.METRIC, ValueType: event.DECIMAL, Value: 6.25},
+			},
+		},
+		{
+			name:     "port state: role",
+			resource: "/cluster/node/worker-0/ens1f0/port-state",
+			value:    ipc.PortStateValue{Role: "FAULTY"},
+			want: []event.DataValue{
+				{Resource: "/cluster/node/worker-0/ens1f0/port-state", DataType: event.NOTIFICATION, ValueType: event.ENUMERATION, Value: "FAULTY"},
+			},
+		},
+		{
+			name:     "time error mask: verdict under the source and statistic",
+			resource: "/cluster/node/worker-0/ens1f0/time-error",
+			value:    ipc.TimeErrorMaskValue{Source: "ts2phc", Statistic: "mtie", Mask: "G.8272-PRTC-A", Verdict: ipc.MaskFail},
+			want: []event.DataValue{
+				{Resource: "/cluster/node/worker-0/ens1f0/time-error/ts2phc/mtie", DataType: event.NOTIFICATION, ValueType: event.ENUMERATION, Value: "FAIL"},
+			},
+		},
 		{
 			name:     "nil value returns nil",
 			resource: resource,
@@ -107,7 +136,7 @@ func TestBuildDataValues(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := buildDataValues(tt.resource, tt.value)
+			got := buildDataValues(tt.resource, tt.value, offset)
 			require.Equal(t, len(tt.want), len(got))
 			for i, wantDV := range tt.want {
 				assert.Equal(t, wantDV.Resource, got[i].Resource, "dv[%d] resource", i)
@@ -185,6 +214,47 @@ func TestListenerResilience(t *testing.T) {
 			},
 			wantCached: false,
 		},
+		{
+			name: "version 1 accepted",
+			input: func() io.Reader {
+				var buf bytes.Buffer
+				msg := validMsg
+				msg.Version = ipc.MinVersion
+				ipc.Encode(&buf, []ipc.Message{msg})
+				return &buf
+			},
+			wantCached: true,
+		},
+		{
+			name: "legacy restart command wipes prior entries",
+			input: func() io.Reader {
+				var buf bytes.Buffer
+				ipc.Encode(&buf, []ipc.Message{validMsg})
+				buf.WriteString("CMD LIVE_START\nCMD RESTART\n")
+				return &buf
+			},
+			wantCached: false,
+		},
+		{
+			name: "restart control message wipes prior entries",
+			input: func() io.Reader {
+				var buf bytes.Buffer
+				ipc.Encode(&buf, []ipc.Message{ipc.NewHello(), validMsg,
+					{Version: ipc.Version, Type: ipc.TypeControl, Values: ipc.ControlValue{Command: ipc.CommandRestart}}})
+				return &buf
+			},
+			wantCached: false,
+		},
+		{
+			name: "control message without hello skipped",
+			input: func() io.Reader {
+				var buf bytes.Buffer
+				ipc.Encode(&buf, []ipc.Message{validMsg,
+					{Version: ipc.Version, Type: ipc.TypeControl, Values: ipc.ControlValue{Command: ipc.CommandRestart}}})
+				return &buf
+			},
+			wantCached: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -200,6 +270,56 @@ func TestListenerResilience(t *testing.T) {
 	}
 }
 
+// ipcConn is a daemon connection reading from r and writing to w
+type ipcConn struct {
+	io.Reader
+	io.Writer
+}
+
+func TestIPCHandshake(t *testing.T) {
+	t.Run("hello is sent and negotiated", func(t *testing.T) {
+		var sent bytes.Buffer
+		var daemon bytes.Buffer
+		ipc.Encode(&daemon, []ipc.Message{ipc.NewHello()})
+		before := testutil.ToFloat64(IPCHandshakes.WithLabelValues("ok"))
+
+		NewCloudEventProxy(NewEventCache("worker-0"), nil).Serve(ipcConn{Reader: &daemon, Writer: &sent})
+
+		var hello ipc.Message
+		require.NoError(t, json.Unmarshal(sent.Bytes(), &hello))
+		assert.Equal(t, ipc.NewHello(), hello)
+		assert.Equal(t, before+1, testutil.ToFloat64(IPCHandshakes.WithLabelValues("ok")))
+	})
+
+	t.Run("mismatches are counted", func(t *testing.T) {
+		downgraded := testutil.ToFloat64(IPCHandshakes.WithLabelValues("downgraded"))
+		failed := testutil.ToFloat64(IPCHandshakes.WithLabelValues("failed"))
+		unknownType := testutil.ToFloat64(IPCRejectedMessages.WithLabelValues("type"))
+		unknownCommand := testutil.ToFloat64(IPCRejectedMessages.WithLabelValues("command"))
+		unnegotiated := testutil.ToFloat64(IPCRejectedMessages.WithLabelValues("session"))
+
+		var daemon bytes.Buffer
+		ipc.Encode(&daemon, []ipc.Message{
+			{Version: 3, Type: ipc.TypeHello, Values: ipc.HelloValue{Versions: []int{3}}},
+			{Version: 3, Type: ipc.TypeHello, Values: ipc.HelloValue{Versions: []int{2, 3}, Types: []string{"dpll_state", ipc.TypeControl}}},
+			{Version: ipc.Version, Type: "dpll_state"},
+			{Version: ipc.Version, Type: ipc.TypeControl, Values: ipc.ControlValue{Command: "reboot"}},
+			// the daemon did not announce port state messages
+			{Version: ipc.Version, Type: ipc.TypePortState, IFace: testIFace, Values: ipc.PortStateValue{Role: "SLAVE"}},
+		})
+		cache := NewEventCache("worker-0")
+		NewCloudEventProxy(cache, nil).Listen(&daemon)
+
+		assert.Equal(t, failed+1, testutil.ToFloat64(IPCHandshakes.WithLabelValues("failed")))
+		assert.Equal(t, downgraded+1, testutil.ToFloat64(IPCHandshakes.WithLabelValues("downgraded")))
+		assert.Equal(t, unknownType+1, testutil.ToFloat64(IPCRejectedMessages.WithLabelValues("type")))
+		assert.Equal(t, unknownCommand+1, testutil.ToFloat64(IPCRejectedMessages.WithLabelValues("command")))
+		assert.Equal(t, unnegotiated+1, testutil.ToFloat64(IPCRejectedMessages.WithLabelValues("session")))
+		_, cached := cache.Get(ipc.TypePortState)
+		assert.False(t, cached)
+	})
+}
+
 func TestIntegrationEventDelivery(t *testing.T) {
 	t.Run("subscriber receives matching events", func(t *testing.T) {
 		var mu sync.Mutex
@@ -435,8 +555,28 @@ func TestSubscriptionValidation(t *testing.T) {
 			wantStatus: http.StatusBadRequest,
 		},
 		{
-			name:       "valid subscription",
+			name:       "unknown resource",
 			body:       `{"EndpointUri":"http://localhost:9999/event","ResourceAddress":"/resource/a"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "wildcard within segment",
+			body:       `{"EndpointUri":"http://localhost:9999/event","ResourceAddress":"/cluster/node/worker-*/sync/ptp-status/lock-state"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "valid subscription",
+			body:       `{"EndpointUri":"http://localhost:9999/event","ResourceAddress":"` + testPTPLockState + `"}`,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "valid wildcard subscription",
+			body:       `{"EndpointUri":"http://localhost:9999/event","ResourceAddress":"/cluster/node/*/sync/ptp-status/lock-state"}`,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "valid hierarchical subscription",
+			body:       `{"EndpointUri":"http://localhost:9999/event","ResourceAddress":"/cluster/node/worker-0/sync/**"}`,
 			wantStatus: http.StatusCreated,
 		},
 	}
@@ -451,6 +591,115 @@ func TestSubscriptionValidation(t *testing.T) {
 	}
 }
 
+func TestIntegrationSubscriptionReplay(t *testing.T) {
+	var mu sync.Mutex
+	received := map[string][]ce.Event{}
+	consumer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e ce.Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received[r.URL.Path] = append(received[r.URL.Path], e)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer consumer.Close()
+
+	cache := NewEventCache("worker-0")
+	cache.Update(ptpMsg("ens1f0", ipc.StateLocked))
+	cache.Update(ipc.Message{Version: ipc.Version, Type: ipc.TypeGNSSState, Profile: "ts2phc.0.config",
+		IFace: "ens1f0", Values: ipc.GNSSStateValue{State: ipc.GNSSSynchronized}})
+	ps := NewPubSub(filepath.Join(t.TempDir(), "subscriptions.json"), NewHTTPWriterFunc(2*time.Second))
+	proxy := NewCloudEventProxy(cache, ps)
+	apiServer := httptest.NewServer(proxy.Handler())
+	defer apiServer.Close()
+
+	subscribe := func(endpoint, body string) {
+		resp, err := http.Post(apiServer.URL+"/api/ocloudNotifications/v2/subscriptions", "application/json",
+			strings.NewReader(fmt.Sprintf(body, consumer.URL+endpoint)))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+	subscribe("/plain", `{"EndpointUri":"%s","ResourceAddress":"`+testPTPLockState+`"}`)
+	subscribe("/replay", `{"EndpointUri":"%s","ResourceAddress":"/cluster/node/*/sync/ptp-status/lock-state","Replay":true}`)
+	subscribe("/iface", `{"EndpointUri":"%s","ResourceAddress":"/cluster/node/worker-0/ens1f0/master","Replay":true}`)
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received["/replay"]) == 1 && len(received["/iface"]) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Never(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received["/plain"]) > 0
+	}, 100*time.Millisecond, 10*time.Millisecond, "subscription without replay must wait for the next event")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, testPTPLockState, received["/replay"][0].Source())
+	var data event.Data
+	require.NoError(t, received["/replay"][0].DataAs(&data))
+	assert.Equal(t, ipc.StateLocked, data.Values[0].Value)
+
+	// the interface matches data values of both the PTP and the GNSS state
+	var sources []string
+	for _, e := range received["/iface"] {
+		sources = append(sources, e.Source())
+	}
+	assert.ElementsMatch(t, []string{testPTPLockState, "/cluster/node/worker-0/sync/gnss-status/gnss-sync-status"}, sources)
+}
+
+func TestSubscriptionReplayDuringPublish(t *testing.T) {
+	writers := map[string]*testWriter{}
+	cache := NewEventCache("worker-0")
+	proxy := NewCloudEventProxy(cache, testPubSub(t, writers))
+	proxy.publish(ptpMsg(testIFace, ipc.StateFreerun))
+
+	states := []string{ipc.StateLocked, ipc.StateFreerun}
+	// fewer updates than the delivery queue holds, so none is dropped
+	const updates = 50
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < updates; i++ {
+			proxy.publish(ptpMsg(testIFace, states[i%2]))
+		}
+	}()
+	var endpoints []string
+	for i := 0; i < 20; i++ {
+		endpoint := fmt.Sprintf("http://consumer/%d", i)
+		body := fmt.Sprintf(`{"EndpointUri":%q,"ResourceAddress":%q,"Replay":true}`, endpoint, testPTPLockState)
+		rec := httptest.NewRecorder()
+		proxy.subscriptionHandler(rec, httptest.NewRequest(http.MethodPost, apiBase+subscriptionsPath, strings.NewReader(body)))
+		require.Equal(t, http.StatusCreated, rec.Code)
+		endpoints = append(endpoints, endpoint)
+	}
+	<-done
+
+	// every subscriber receives the state of its replay, then every change published after it, ending
+	// with the latest state whichever update its replay interleaved with
+	want := states[(updates-1)%2]
+	state := func(w *testWriter, i int) interface{} {
+		var data event.Data
+		require.NoError(t, w.event(t, i).DataAs(&data))
+		return data.Values[0].Value
+	}
+	for _, endpoint := range endpoints {
+		w := writers[endpoint]
+		require.Eventually(t, func() bool {
+			n := w.delivered()
+			return n > 0 && state(w, n-1) == want
+		}, 5*time.Second, time.Millisecond, "endpoint %s", endpoint)
+		for i := 1; i < w.delivered(); i++ {
+			require.NotEqual(t, state(w, i-1), state(w, i), "endpoint %s received event %d twice", endpoint, i)
+		}
+	}
+}
+
 func TestIntegrationCurrentState(t *testing.T) {
 	cache := NewEventCache("worker-0")
 	ps := NewPubSub(filepath.Join(t.TempDir(), "subscriptions.json"), NewHTTPWriterFunc(2*time.Second))
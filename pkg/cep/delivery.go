@@ -0,0 +1,202 @@
+package cep
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ExpireAction is what happens to a subscription whose endpoint keeps failing delivery.
+type ExpireAction string
+
+// Expire actions.
+const (
+	// ExpireEvict removes the subscription.
+	ExpireEvict ExpireAction = "evict"
+	// ExpireMarkInactive keeps the subscription but stops delivering events to it.
+	ExpireMarkInactive ExpireAction = "inactive"
+)
+
+// DeliveryConfig configures the asynchronous delivery of events to subscribers.
+type DeliveryConfig struct {
+	// QueueSize is the number of events queued per subscriber, the oldest event is dropped when full.
+	QueueSize int
+	// InitialBackoff is the wait before the first retry of a failed delivery, doubled on every retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between retries.
+	MaxBackoff time.Duration
+	// ExpireAfter is how long deliveries to a subscriber fail before it expires, 0 never expires.
+	ExpireAfter time.Duration
+	// ExpireAction is applied to expired subscriptions.
+	ExpireAction ExpireAction
+}
+
+// DefaultDeliveryConfig returns the delivery settings used by NewPubSub.
+func DefaultDeliveryConfig() DeliveryConfig {
+	return DeliveryConfig{
+		QueueSize:      100,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		ExpireAfter:    5 * time.Minute,
+		ExpireAction:   ExpireEvict,
+	}
+}
+
+// Validate checks the delivery settings.
+func (c DeliveryConfig) Validate() error {
+	if c.QueueSize < 1 {
+		return fmt.Errorf("delivery queue size must be positive, got %d", c.QueueSize)
+	}
+	if c.InitialBackoff <= 0 || c.MaxBackoff < c.InitialBackoff {
+		return fmt.Errorf("invalid delivery backoff %s..%s", c.InitialBackoff, c.MaxBackoff)
+	}
+	if c.ExpireAfter < 0 {
+		return fmt.Errorf("delivery expiry must not be negative, got %s", c.ExpireAfter)
+	}
+	if c.ExpireAction != ExpireEvict && c.ExpireAction != ExpireMarkInactive {
+		return fmt.Errorf("unknown expire action %q, expected %q or %q", c.ExpireAction, ExpireEvict, ExpireMarkInactive)
+	}
+	return nil
+}
+
+// deliveryQueue holds the events waiting for delivery to one subscriber.
+type deliveryQueue struct {
+	id       string
+	endpoint string // endpoint label of the delivery metrics
+	w        io.Writer
+	events   chan []byte
+	stop     chan struct{}
+}
+
+func newDeliveryQueue(id, endpoint string, w io.Writer, size int) *deliveryQueue {
+	return &deliveryQueue{
+		id:       id,
+		endpoint: endpoint,
+		w:        w,
+		events:   make(chan []byte, size),
+		stop:     make(chan struct{}),
+	}
+}
+
+// enqueue adds the event to the queue, dropping the oldest queued event when it is full
+func (q *deliveryQueue) enqueue(data []byte) {
+	for {
+		select {
+		case q.events <- data:
+			DeliveryQueueDepth.WithLabelValues(q.id).Set(float64(len(q.events)))
+			return
+		default:
+		}
+		select {
+		case <-q.events:
+			EventsDropped.WithLabelValues(q.id).Inc()
+			glog.Warningf("delivery queue of subscriber %s is full, dropped oldest event", q.id)
+		default:
+		}
+	}
+}
+
+// deliver writes queued events to the subscriber until the queue is stopped. A failed delivery is
+// retried with exponential backoff, and the subscription expires once deliveries failed for ExpireAfter.
+func (ps *PubSub) deliver(q *deliveryQueue) {
+	cfg := ps.delivery
+	var failingSince time.Time
+	for {
+		var data []byte
+		select {
+		case <-q.stop:
+			return
+		case data = <-q.events:
+			DeliveryQueueDepth.WithLabelValues(q.id).Set(float64(len(q.events)))
+		}
+		backoff := cfg.InitialBackoff
+		for {
+			start := time.Now()
+			_, err := q.w.Write(data)
+			if err == nil {
+				DeliveryDuration.WithLabelValues(q.endpoint, "success").Observe(time.Since(start).Seconds())
+				failingSince = time.Time{}
+				EventsDelivered.WithLabelValues(q.id).Inc()
+				break
+			}
+			DeliveryDuration.WithLabelValues(q.endpoint, "failure").Observe(time.Since(start).Seconds())
+			DeliveryFailures.WithLabelValues(q.id).Inc()
+			if failingSince.IsZero() {
+				failingSince = time.Now()
+			}
+			if cfg.ExpireAfter > 0 && time.Since(failingSince) >= cfg.ExpireAfter {
+				glog.Errorf("delivery to subscriber %s failing since %s: %v", q.id, failingSince.Format(time.RFC3339), err)
+				ps.expire(q.id)
+				return
+			}
+			glog.Errorf("failed to write event to subscriber %s, retrying in %s: %v", q.id, backoff, err)
+			select {
+			case <-q.stop:
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, cfg.MaxBackoff)
+		}
+	}
+}
+
+// startQueueLocked starts delivering events to the subscription. Caller must hold ps.mu.
+func (ps *PubSub) startQueueLocked(sub Subscription) {
+	if sub.W == nil || sub.Inactive {
+		return
+	}
+	endpoint := sub.Endpoint
+	if sub.stream {
+		endpoint = streamPath
+	}
+	q := newDeliveryQueue(sub.ID, endpoint, sub.W, ps.delivery.QueueSize)
+	ps.queues[sub.ID] = q
+	go ps.deliver(q)
+}
+
+// stopQueueLocked stops delivering events to the subscription. Caller must hold ps.mu.
+func (ps *PubSub) stopQueueLocked(id string) {
+	if q, ok := ps.queues[id]; ok {
+		close(q.stop)
+		delete(ps.queues, id)
+		if !ps.endpointQueuedLocked(q.endpoint) {
+			DeliveryDuration.DeletePartialMatch(prometheus.Labels{"endpoint": q.endpoint})
+		}
+	}
+	deleteSubscriptionMetrics(id)
+}
+
+// endpointQueuedLocked returns whether events are delivered to the endpoint. Caller must hold ps.mu.
+func (ps *PubSub) endpointQueuedLocked(endpoint string) bool {
+	for _, q := range ps.queues {
+		if q.endpoint == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// expire evicts the subscription or marks it inactive, as configured, and persists the change
+func (ps *PubSub) expire(id string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	idx := ps.findLocked(id)
+	if idx == -1 {
+		return
+	}
+	SubscriptionsExpired.WithLabelValues(string(ps.delivery.ExpireAction)).Inc()
+	if ps.delivery.ExpireAction == ExpireMarkInactive {
+		glog.Warningf("marking subscription %s to %s inactive", id, ps.subs[idx].Endpoint)
+		ps.subs[idx].Inactive = true
+		ps.stopQueueLocked(id)
+	} else {
+		glog.Warningf("evicting subscription %s to %s", id, ps.subs[idx].Endpoint)
+		ps.removeLocked(idx)
+	}
+	if err := ps.save(); err != nil {
+		glog.Errorf("failed to save subscriptions: %v", err)
+	}
+}
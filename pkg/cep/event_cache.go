@@ -1,8 +1,14 @@
 package cep
 
 import (
+	"encoding/json"
+	"math"
+	"os"
 	"path"
 	"sync"
+	"time"
+
+	"github.com/golang/glog"
 
 	"github.com/google/uuid"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
@@ -11,11 +17,38 @@ import (
 	"github.com/redhat-cne/sdk-go/pkg/types"
 )
 
+// eventCacheSaveDelay is how long the changes of the cached events are batched before they are persisted
+const eventCacheSaveDelay = time.Second
+
 // EventCache stores the latest cloud event for each IPC message type.
 type EventCache struct {
 	mu       sync.RWMutex
 	nodeName string
 	entries  map[string]*event.Event
+	offsets  map[offsetKey]int64 // latest mean offset by state message resource
+	// stale holds, by IPC type, the data value resources restored from disk that the daemon has not
+	// reported since. They are dropped by Reconcile once the daemon finished replaying its state.
+	stale map[string]map[string]bool
+	// storePath is the filepath the entries are persisted to, empty disables persistence
+	storePath string
+	// saveTimer persists the entries once the changes are batched, nil when none is pending
+	saveTimer *time.Timer
+	// saveMu serializes the writes of the store
+	saveMu sync.Mutex
+}
+
+// cacheRecord is the persisted form of a cached event
+type cacheRecord struct {
+	IPCType string            `json:"ipcType"`
+	ID      string            `json:"id"`
+	Type    string            `json:"type"`
+	Source  string            `json:"source"`
+	Values  []event.DataValue `json:"values"`
+}
+
+type offsetKey struct {
+	ipcType  string
+	resource string
 }
 
 // NewEventCache creates an EventCache for the given node.
@@ -23,9 +56,18 @@ func NewEventCache(nodeName string) *EventCache {
 	return &EventCache{
 		nodeName: nodeName,
 		entries:  make(map[string]*event.Event),
+		offsets:  make(map[offsetKey]int64),
+		stale:    make(map[string]map[string]bool),
 	}
 }
 
+// SetStorePath persists the cached events to the given file, the changes batched for eventCacheSaveDelay.
+func (c *EventCache) SetStorePath(storePath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.storePath = storePath
+}
+
 // Update modifies the cache based on the contents of the given ipc.Message and returns a copy of the resultant
 // event.Event. Will return nil in case of a duplicate event.
 func (c *EventCache) Update(msg ipc.Message) *event.Event {
@@ -35,15 +77,25 @@ func (c *EventCache) Update(msg ipc.Message) *event.Event {
 	}
 
 	resourceAddr := c.buildResourceAddress(msg.Type, msg.IFace)
-	dvs := buildDataValues(resourceAddr, msg.Values)
-	if len(dvs) == 0 {
-		return nil
-	}
 	contentType := "application/json"
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	var changed bool
+	if summary, isSummary := msg.Values.(ipc.OffsetSummaryValue); isSummary {
+		changed = c.updateStateOffsetLocked(msg.IFace, summary)
+	}
+	dvs := buildDataValues(resourceAddr, msg.Values, c.offsets[offsetKey{ipcType: msg.Type, resource: resourceAddr}])
+	if len(dvs) == 0 {
+		return nil
+	}
+	defer func() {
+		if changed {
+			c.scheduleSaveLocked()
+		}
+	}()
+
 	e, exists := c.entries[msg.Type]
 	if !exists {
 		e = &event.Event{
@@ -53,25 +105,32 @@ func (c *EventCache) Update(msg ipc.Message) *event.Event {
 			Data:            &event.Data{Version: event.APISchemaVersion},
 		}
 		c.entries[msg.Type] = e
+		CachedEvents.Set(float64(len(c.entries)))
 	}
 
-	var changed bool
-	if msg.IFace == "" {
+	publish := false
+	if msg.IFace == "" && msg.Type != ipc.TypeOffsetSummary && msg.Type != ipc.TypeTimeErrorMask {
+		delete(c.stale, msg.Type)
 		if !exists || !dataValuesEqual(e.Data.Values, dvs) {
 			e.Data.Values = dvs
-			changed = true
+			publish = true
 		}
 	} else {
 		for _, dv := range dvs {
+			delete(c.stale[msg.Type], dv.Resource)
 			if setDataValue(e, dv) {
-				changed = true
+				publish = true
 			}
 		}
+		if len(c.stale[msg.Type]) == 0 {
+			delete(c.stale, msg.Type)
+		}
 	}
 
-	if !changed {
+	if !publish {
 		return nil
 	}
+	changed = true
 
 	e.ID = uuid.New().String()
 	e.Time, _ = types.ParseTimestamp(msg.Timestamp)
@@ -108,6 +167,170 @@ func (c *EventCache) Clear() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.entries = make(map[string]*event.Event)
+	c.offsets = make(map[offsetKey]int64)
+	c.stale = make(map[string]map[string]bool)
+	CachedEvents.Set(0)
+	c.scheduleSaveLocked()
+}
+
+// Stale returns whether the cached event of the given source holds data restored from disk that the
+// daemon has not reported since.
+func (c *EventCache) Stale(source string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for ipcType, e := range c.entries {
+		if e.Source == source && len(c.stale[ipcType]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Reconcile drops the data restored from disk that the daemon did not report again in its replay,
+// and the events left without data.
+func (c *EventCache) Reconcile() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.stale) == 0 {
+		return
+	}
+	for ipcType, resources := range c.stale {
+		e, ok := c.entries[ipcType]
+		if !ok {
+			continue
+		}
+		values := e.Data.Values[:0]
+		for _, dv := range e.Data.Values {
+			if !resources[dv.Resource] {
+				values = append(values, dv)
+			}
+		}
+		e.Data.Values = values
+		if len(values) == 0 {
+			delete(c.entries, ipcType)
+		}
+		glog.Infof("dropped %d stale resources of %s not replayed by the daemon", len(resources), ipcType)
+	}
+	c.stale = make(map[string]map[string]bool)
+	CachedEvents.Set(float64(len(c.entries)))
+	c.scheduleSaveLocked()
+}
+
+// scheduleSaveLocked persists the entries after eventCacheSaveDelay, along with the changes made
+// until then. Caller must hold c.mu.
+func (c *EventCache) scheduleSaveLocked() {
+	if c.storePath == "" || c.saveTimer != nil {
+		return
+	}
+	c.saveTimer = time.AfterFunc(eventCacheSaveDelay, c.save)
+}
+
+// Flush persists the pending changes of the entries.
+func (c *EventCache) Flush() {
+	c.mu.Lock()
+	pending := c.saveTimer != nil
+	if pending {
+		c.saveTimer.Stop()
+	}
+	c.mu.Unlock()
+	if pending {
+		c.save()
+	}
+}
+
+// save persists the entries, logging failures as the cache stays usable. The file is written without
+// holding c.mu.
+func (c *EventCache) save() {
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+	c.mu.Lock()
+	c.saveTimer = nil
+	storePath := c.storePath
+	records := make([]cacheRecord, 0, len(c.entries))
+	for ipcType, e := range c.entries {
+		records = append(records, cacheRecord{IPCType: ipcType, ID: e.ID, Type: e.Type, Source: e.Source, Values: e.Data.Values})
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	c.mu.Unlock()
+	if err == nil {
+		// Write to a temp file and rename to avoid corrupting the store on a crash mid-write.
+		tmpPath := storePath + ".tmp"
+		if err = os.WriteFile(tmpPath, data, 0600); err == nil {
+			err = os.Rename(tmpPath, storePath)
+		}
+	}
+	if err != nil {
+		glog.Errorf("failed to save event cache: %v", err)
+	}
+}
+
+// LoadFromDisk restores the entries persisted to the store path, marking all their data stale.
+func (c *EventCache) LoadFromDisk() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, err := os.ReadFile(c.storePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var records []cacheRecord
+	if unmarshalErr := json.Unmarshal(data, &records); unmarshalErr != nil {
+		return unmarshalErr
+	}
+
+	c.entries = make(map[string]*event.Event)
+	c.offsets = make(map[offsetKey]int64)
+	c.stale = make(map[string]map[string]bool)
+	contentType := "application/json"
+	for _, r := range records {
+		if len(r.Values) == 0 {
+			continue
+		}
+		c.entries[r.IPCType] = &event.Event{
+			ID:              r.ID,
+			Type:            r.Type,
+			Source:          r.Source,
+			DataContentType: &contentType,
+			Data:            &event.Data{Version: event.APISchemaVersion, Values: r.Values},
+		}
+		c.stale[r.IPCType] = make(map[string]bool)
+		for _, dv := range r.Values {
+			c.stale[r.IPCType][dv.Resource] = true
+			// keep reporting the restored offsets of state events until the next summary
+			if offset, isNum := toFloat(dv.Value); isNum && dv.DataType == event.METRIC && r.IPCType != ipc.TypeOffsetSummary {
+				c.offsets[offsetKey{ipcType: r.IPCType, resource: dv.Resource}] = int64(math.Round(offset))
+			}
+		}
+	}
+	CachedEvents.Set(float64(len(c.entries)))
+	return nil
+}
+
+// updateStateOffsetLocked stores the mean offset of the summary as the offset of the state its source reports,
+// and updates the cached state event without publishing it. Returns whether the cached event changed.
+// Caller must hold c.mu.
+func (c *EventCache) updateStateOffsetLocked(iface string, summary ipc.OffsetSummaryValue) bool {
+	stateType, ok := offsetStateTypes[summary.Source]
+	if !ok {
+		return false
+	}
+	resourceAddr := c.buildResourceAddress(stateType, iface)
+	offset := int64(math.Round(summary.Mean))
+	c.offsets[offsetKey{ipcType: stateType, resource: resourceAddr}] = offset
+	e, exists := c.entries[stateType]
+	if !exists {
+		return false
+	}
+	changed := false
+	for i, dv := range e.Data.Values {
+		if dv.Resource == resourceAddr && dv.DataType == event.METRIC && !valuesEqual(dv.Value, offset) {
+			e.Data.Values[i].Value = offset
+			changed = true
+		}
+	}
+	return changed
 }
 
 func dataValuesEqual(a, b []event.DataValue) bool {
@@ -115,13 +338,35 @@ func dataValuesEqual(a, b []event.DataValue) bool {
 		return false
 	}
 	for i := range a {
-		if a[i].Resource != b[i].Resource || a[i].DataType != b[i].DataType || a[i].Value != b[i].Value {
+		if a[i].Resource != b[i].Resource || a[i].DataType != b[i].DataType || !valuesEqual(a[i].Value, b[i].Value) {
 			return false
 		}
 	}
 	return true
 }
 
+// valuesEqual compares data values, numbers by value as those restored from disk are float64
+func valuesEqual(a, b interface{}) bool {
+	fa, aNum := toFloat(a)
+	fb, bNum := toFloat(b)
+	if aNum && bNum {
+		return fa == fb
+	}
+	return a == b
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
 func (c *EventCache) buildResourceAddress(ipcType string, iface string) string {
 	prefix := path.Join("/cluster/node", c.nodeName)
 
@@ -136,6 +381,12 @@ func (c *EventCache) buildResourceAddress(ipcType string, iface string) string {
 		return path.Join(prefix, iface)
 	case ipc.TypeSyncState:
 		return path.Join(prefix, string(ptp.SyncStatusState))
+	case ipc.TypeOffsetSummary:
+		return path.Join(prefix, iface)
+	case ipc.TypePortState:
+		return path.Join(prefix, iface, "port-state")
+	case ipc.TypeTimeErrorMask:
+		return path.Join(prefix, iface, "time-error")
 	default:
 		return prefix
 	}
@@ -2,7 +2,12 @@ package cep
 
 import (
 	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -188,3 +193,210 @@ func TestEventCache_Update(t *testing.T) {
 		assert.False(t, ok)
 	})
 }
+
+func offsetSummaryMsg(iface, source string, mean float64) ipc.Message {
+	return ipc.Message{
+		Version: ipc.Version, Type: ipc.TypeOffsetSummary,
+		Profile: testProfile, IFace: iface,
+		Values: ipc.OffsetSummaryValue{Source: source, Window: 60, Samples: 60, Min: mean - 5, Max: mean + 5, Mean: mean, StdDev: 2},
+	}
+}
+
+func TestEventCache_OffsetSummary(t *testing.T) {
+	t.Run("summary is published on its own resource", func(t *testing.T) {
+		cache := NewEventCache("worker-0")
+		result := cache.Update(offsetSummaryMsg(testIFace, "ptp4l", -3))
+		require.NotNil(t, result)
+		assert.Equal(t, string(OffsetSummaryChange), result.Type)
+		assert.Equal(t, "/cluster/node/worker-0/sync/ptp-status/offset-summary", result.Source)
+		assert.Len(t, result.Data.Values, 4)
+
+		// summaries of other sources are merged into the same event
+		result = cache.Update(offsetSummaryMsg("CLOCK_REALTIME", "phc2sys", 1))
+		require.NotNil(t, result)
+		assert.Len(t, result.Data.Values, 8)
+		assert.Nil(t, cache.Update(offsetSummaryMsg("CLOCK_REALTIME", "phc2sys", 1)))
+	})
+
+	t.Run("state events report the mean offset of their source", func(t *testing.T) {
+		cache := NewEventCache("worker-0")
+		cache.Update(offsetSummaryMsg(testIFace, "ptp4l", -3.4))
+		first := cache.Update(ptpMsg(testIFace, ipc.StateLocked))
+		require.NotNil(t, first)
+		assert.Equal(t, metricDV("/cluster/node/worker-0/ens2f0/master", int64(-3)), first.Data.Values[1])
+
+		// a new summary updates the cached state without publishing a state change
+		cache.Update(offsetSummaryMsg(testIFace, "ptp4l", 7))
+		cached, ok := cache.Get(ipc.TypePTPState)
+		require.True(t, ok)
+		assert.Equal(t, int64(7), cached.Data.Values[1].Value)
+		assert.Nil(t, cache.Update(ptpMsg(testIFace, ipc.StateLocked)))
+
+		// DPLL and ts2phc offsets are only served on the offset summary resource
+		cache.Update(offsetSummaryMsg(testIFace, "dpll", 100))
+		cache.Update(offsetSummaryMsg(testIFace, "ts2phc", -50))
+		cached, _ = cache.Get(ipc.TypePTPState)
+		assert.Equal(t, int64(7), cached.Data.Values[1].Value)
+		summary, ok := cache.Get(ipc.TypeOffsetSummary)
+		require.True(t, ok)
+		assert.Contains(t, summary.Data.Values, metricDV("/cluster/node/worker-0/ens2f0/ts2phc/offset/mean", float64(-50)))
+
+		cache.Clear()
+		first = cache.Update(ptpMsg(testIFace, ipc.StateLocked))
+		require.NotNil(t, first)
+		assert.Equal(t, unknownOffset, first.Data.Values[1].Value)
+	})
+}
+
+func TestEventCache_Persistence(t *testing.T) {
+	// restoredCache persists the state of two interfaces and returns a new cache restored from it
+	restoredCache := func(t *testing.T) *EventCache {
+		storePath := filepath.Join(t.TempDir(), "events.json")
+		cache := NewEventCache("worker-0")
+		cache.SetStorePath(storePath)
+		cache.Update(offsetSummaryMsg("ens1f0", "ptp4l", -3))
+		cache.Update(ptpMsg("ens1f0", ipc.StateLocked))
+		cache.Update(ptpMsg("ens2f0", ipc.StateFreerun))
+		cache.Flush()
+
+		restored := NewEventCache("worker-0")
+		restored.SetStorePath(storePath)
+		require.NoError(t, restored.LoadFromDisk())
+		return restored
+	}
+
+	t.Run("missing store", func(t *testing.T) {
+		cache := NewEventCache("worker-0")
+		cache.SetStorePath(filepath.Join(t.TempDir(), "events.json"))
+		require.NoError(t, cache.LoadFromDisk())
+		_, ok := cache.GetBySource(testPTPLockState)
+		assert.False(t, ok)
+	})
+
+	t.Run("restored events are stale until reported again", func(t *testing.T) {
+		cache := restoredCache(t)
+		e, ok := cache.GetBySource(testPTPLockState)
+		require.True(t, ok)
+		require.Len(t, e.Data.Values, 4)
+		assert.Equal(t, ipc.StateLocked, e.Data.Values[0].Value)
+		assert.True(t, cache.Stale(testPTPLockState))
+
+		// an unchanged state is not published again
+		assert.Nil(t, cache.Update(ptpMsg("ens1f0", ipc.StateLocked)))
+		assert.True(t, cache.Stale(testPTPLockState), "ens2f0 was not reported")
+		require.NotNil(t, cache.Update(ptpMsg("ens2f0", ipc.StateLocked)))
+		assert.False(t, cache.Stale(testPTPLockState))
+	})
+
+	t.Run("reconcile drops the state not replayed", func(t *testing.T) {
+		cache := restoredCache(t)
+		cache.Update(ptpMsg("ens2f0", ipc.StateFreerun))
+		cache.Reconcile()
+		e, ok := cache.GetBySource(testPTPLockState)
+		require.True(t, ok)
+		require.Len(t, e.Data.Values, 2)
+		assert.Equal(t, "/cluster/node/worker-0/ens2f0/master", e.Data.Values[0].Resource)
+		assert.False(t, cache.Stale(testPTPLockState))
+
+		_, ok = cache.GetBySource("/cluster/node/worker-0/sync/ptp-status/offset-summary")
+		assert.False(t, ok, "events without replayed data are dropped")
+
+		// the reconciled state is persisted
+		cache.Flush()
+		reloaded := NewEventCache("worker-0")
+		reloaded.SetStorePath(cache.storePath)
+		require.NoError(t, reloaded.LoadFromDisk())
+		e, ok = reloaded.GetBySource(testPTPLockState)
+		require.True(t, ok)
+		assert.Len(t, e.Data.Values, 2)
+	})
+
+	t.Run("current state of restored events", func(t *testing.T) {
+		cache := restoredCache(t)
+		proxy := NewCloudEventProxy(cache, nil)
+		apiServer := httptest.NewServer(proxy.Handler())
+		defer apiServer.Close()
+		url := apiServer.URL + apiBase + testPTPLockState + "/CurrentState"
+
+		resp, err := http.Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "true", resp.Header.Get(staleHeader))
+
+		var buf strings.Builder
+		require.NoError(t, ipc.Encode(&buf, []ipc.Message{
+			ipc.NewHello(),
+			ptpMsg("ens1f0", ipc.StateHoldover),
+			{Version: ipc.Version, Type: ipc.TypeControl, Values: ipc.ControlValue{Command: ipc.CommandLiveStart}},
+		}))
+		proxy.Listen(strings.NewReader(buf.String()))
+		assert.True(t, cache.Stale(testPTPLockState), "a process live start does not end the replay")
+
+		buf.Reset()
+		require.NoError(t, ipc.Encode(&buf, []ipc.Message{{Version: ipc.Version, Type: ipc.TypeStatusResponse}}))
+		proxy.Listen(strings.NewReader(buf.String()))
+
+		resp, err = http.Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get(staleHeader))
+		e, _ := cache.GetBySource(testPTPLockState)
+		assert.Len(t, e.Data.Values, 2)
+	})
+
+	t.Run("changes are batched", func(t *testing.T) {
+		storePath := filepath.Join(t.TempDir(), "events.json")
+		cache := NewEventCache("worker-0")
+		cache.SetStorePath(storePath)
+		cache.Update(ptpMsg("ens1f0", ipc.StateLocked))
+		cache.Update(ptpMsg("ens2f0", ipc.StateFreerun))
+		assert.NoFileExists(t, storePath, "written once the changes are batched")
+
+		require.Eventually(t, func() bool {
+			restored := NewEventCache("worker-0")
+			restored.SetStorePath(storePath)
+			if restored.LoadFromDisk() != nil {
+				return false
+			}
+			e, ok := restored.GetBySource(testPTPLockState)
+			return ok && len(e.Data.Values) == 4
+		}, 3*eventCacheSaveDelay, 10*time.Millisecond)
+	})
+
+	t.Run("restart clears the store", func(t *testing.T) {
+		cache := restoredCache(t)
+		cache.Clear()
+		cache.Flush()
+		reloaded := NewEventCache("worker-0")
+		reloaded.SetStorePath(cache.storePath)
+		require.NoError(t, reloaded.LoadFromDisk())
+		_, ok := reloaded.GetBySource(testPTPLockState)
+		assert.False(t, ok)
+	})
+}
+
+func TestEventCache_PortState(t *testing.T) {
+	portMsg := func(iface, role string) ipc.Message {
+		return ipc.Message{Version: ipc.Version, Type: ipc.TypePortState, Profile: testProfile, IFace: iface,
+			Values: ipc.PortStateValue{Role: role}}
+	}
+	cache := NewEventCache("worker-0")
+	require.NoError(t, cache.ValidateResource("/cluster/node/worker-0/ens1f0/port-state"))
+
+	cache.Update(portMsg("ens1f0", "SLAVE"))
+	result := cache.Update(portMsg("ens2f0", "MASTER"))
+	require.NotNil(t, result)
+	assert.Equal(t, string(PortStateChange), result.Type)
+	assert.Equal(t, "/cluster/node/worker-0/sync/ptp-status/port-state", result.Source)
+	require.Len(t, result.Data.Values, 2)
+
+	// upstream failover
+	assert.Nil(t, cache.Update(portMsg("ens2f0", "MASTER")))
+	result = cache.Update(portMsg("ens1f0", "FAULTY"))
+	require.NotNil(t, result)
+	assert.Equal(t, "/cluster/node/worker-0/ens1f0/port-state", result.Data.Values[0].Resource)
+	assert.Equal(t, "FAULTY", result.Data.Values[0].Value)
+	assert.Equal(t, []string{"/cluster/node/worker-0/ens1f0/port-state"}, cache.Resolve("/cluster/node/worker-0/ens1f0/port-state"))
+}
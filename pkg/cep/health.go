@@ -0,0 +1,109 @@
+package cep
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultDaemonTimeout is how long the daemon may stay disconnected before /healthz fails.
+const DefaultDaemonTimeout = 5 * time.Minute
+
+// daemonHealth tracks the daemon connection and its state replay
+type daemonHealth struct {
+	mu        sync.Mutex
+	connected bool
+	// since is when the daemon connected or disconnected, the start of cloud-event-proxy before it first connected
+	since time.Time
+	// replayed is set once the daemon replayed its state, until its event connection closes or it restarts
+	replayed bool
+}
+
+func newDaemonHealth() *daemonHealth {
+	return &daemonHealth{since: time.Now()}
+}
+
+func (h *daemonHealth) setConnected(connected bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.connected = connected
+	h.since = time.Now()
+	if connected {
+		DaemonConnected.Set(1)
+	} else {
+		DaemonConnected.Set(0)
+	}
+}
+
+func (h *daemonHealth) setReplayed(replayed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.replayed = replayed
+}
+
+// healthy returns whether the daemon is connected or disconnected for less than the timeout, 0 never fails
+func (h *daemonHealth) healthy(timeout time.Duration) (bool, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.connected || timeout == 0 {
+		return true, ""
+	}
+	if down := time.Since(h.since); down >= timeout {
+		return false, fmt.Sprintf("daemon not connected for %s", down.Round(time.Second))
+	}
+	return true, ""
+}
+
+// ready returns whether the daemon is connected and replayed its state
+func (h *daemonHealth) ready() (bool, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.connected {
+		return false, "daemon not connected"
+	}
+	if !h.replayed {
+		return false, "daemon state replay not complete"
+	}
+	return true, ""
+}
+
+// probeHandler serves a probe, 200 when check passes and 503 with its reason otherwise
+func probeHandler(check func() (bool, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if ok, msg := check(); !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "503: %s\n", msg)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// HealthHandler returns the HTTP handler of /metrics and the /healthz and /readyz probes. /healthz fails
+// once the daemon has been disconnected for daemonTimeout, 0 disables it, and /readyz until the daemon
+// is connected and replayed its state.
+func (l *CloudEventProxy) HealthHandler(daemonTimeout time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/healthz", probeHandler(func() (bool, string) { return l.health.healthy(daemonTimeout) }))
+	mux.Handle("/readyz", probeHandler(l.health.ready))
+	return mux
+}
+
+// ListenAndServeHealth starts the metrics and probes server on the given port. It is served in plain
+// HTTP without authentication, like the daemon metrics.
+func (l *CloudEventProxy) ListenAndServeHealth(port int, daemonTimeout time.Duration) {
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		ReadHeaderTimeout: 5 * time.Second,
+		Handler:           l.HealthHandler(daemonTimeout),
+	}
+	glog.Infof("metrics and health server listening on :%d", port)
+	if err := server.ListenAndServe(); err != nil {
+		glog.Errorf("metrics and health server error: %v", err)
+	}
+}
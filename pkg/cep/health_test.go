@@ -0,0 +1,121 @@
+package cep
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
+)
+
+func TestHealth(t *testing.T) {
+	probe := func(t *testing.T, url string) int {
+		t.Helper()
+		resp, err := http.Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	proxy := NewCloudEventProxy(NewEventCache("worker-0"), nil)
+	server := httptest.NewServer(proxy.HealthHandler(50 * time.Millisecond))
+	defer server.Close()
+
+	assert.Equal(t, http.StatusServiceUnavailable, probe(t, server.URL+"/readyz"), "daemon not connected")
+	require.Eventually(t, func() bool {
+		return probe(t, server.URL+"/healthz") == http.StatusServiceUnavailable
+	}, 2*time.Second, 10*time.Millisecond, "daemon never connected")
+
+	daemonR, daemonW := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		proxy.Serve(ipcConn{Reader: daemonR, Writer: io.Discard})
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(DaemonConnected) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, http.StatusOK, probe(t, server.URL+"/healthz"))
+	assert.Equal(t, http.StatusServiceUnavailable, probe(t, server.URL+"/readyz"), "replay not complete")
+
+	require.NoError(t, ipc.Encode(daemonW, []ipc.Message{
+		ipc.NewHello(),
+		{Version: ipc.Version, Type: ipc.TypeControl, Values: ipc.ControlValue{Command: ipc.CommandLiveStart}},
+	}))
+	assert.Never(t, func() bool {
+		return probe(t, server.URL+"/readyz") == http.StatusOK
+	}, 100*time.Millisecond, 10*time.Millisecond, "a process live start does not end the replay")
+	require.NoError(t, ipc.Encode(daemonW, []ipc.Message{{Version: ipc.Version, Type: ipc.TypeStatusResponse}}))
+	require.Eventually(t, func() bool {
+		return probe(t, server.URL+"/readyz") == http.StatusOK
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// a restart of the daemon processes is followed by a new replay
+	_, err := io.WriteString(daemonW, "CMD RESTART\n")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return probe(t, server.URL+"/readyz") == http.StatusServiceUnavailable
+	}, 2*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, ipc.Encode(daemonW, []ipc.Message{{Version: ipc.Version, Type: ipc.TypeStatusResponse}}))
+	require.Eventually(t, func() bool {
+		return probe(t, server.URL+"/readyz") == http.StatusOK
+	}, 2*time.Second, 10*time.Millisecond)
+	daemonW.Close()
+	<-done
+	assert.Zero(t, testutil.ToFloat64(DaemonConnected))
+	assert.Equal(t, http.StatusServiceUnavailable, probe(t, server.URL+"/readyz"))
+	require.Eventually(t, func() bool {
+		return probe(t, server.URL+"/healthz") == http.StatusServiceUnavailable
+	}, 2*time.Second, 10*time.Millisecond, "daemon disconnected past the timeout")
+}
+
+func TestMetrics(t *testing.T) {
+	t.Run("ipc messages", func(t *testing.T) {
+		received := testutil.ToFloat64(IPCMessagesReceived.WithLabelValues(ipc.TypePTPState))
+		control := testutil.ToFloat64(IPCMessagesReceived.WithLabelValues(ipc.TypeControl))
+		parseErrors := testutil.ToFloat64(IPCParseErrors)
+
+		var daemon strings.Builder
+		require.NoError(t, ipc.Encode(&daemon, []ipc.Message{ptpMsg("ens1f0", ipc.StateLocked), ptpMsg("ens2f0", ipc.StateLocked)}))
+		daemon.WriteString("ptp4l[1.0]: not an IPC message\nCMD LIVE_START\n")
+		cache := NewEventCache("worker-0")
+		NewCloudEventProxy(cache, nil).Listen(strings.NewReader(daemon.String()))
+
+		assert.Equal(t, received+2, testutil.ToFloat64(IPCMessagesReceived.WithLabelValues(ipc.TypePTPState)))
+		assert.Equal(t, control+1, testutil.ToFloat64(IPCMessagesReceived.WithLabelValues(ipc.TypeControl)))
+		assert.Equal(t, parseErrors+1, testutil.ToFloat64(IPCParseErrors))
+		assert.Equal(t, 1.0, testutil.ToFloat64(CachedEvents))
+
+		cache.Clear()
+		assert.Zero(t, testutil.ToFloat64(CachedEvents))
+	})
+
+	t.Run("subscriptions and delivery", func(t *testing.T) {
+		series := testutil.CollectAndCount(DeliveryDuration)
+		ps := NewPubSub(filepath.Join(t.TempDir(), "subscriptions.json"), func(string) io.Writer { return &testWriter{} })
+		id := ps.Subscribe(testPTPLockState, "http://consumer:9090/event")
+		streamID := ps.SubscribeStream(testPTPLockState, io.Discard)
+		assert.Equal(t, 1.0, testutil.ToFloat64(Subscriptions.WithLabelValues("rest")))
+		assert.Equal(t, 1.0, testutil.ToFloat64(Subscriptions.WithLabelValues("stream")))
+
+		ps.Publish(NewEventCache("worker-0").Update(ptpMsg("ens1f0", ipc.StateLocked)))
+		require.Eventually(t, func() bool {
+			return testutil.CollectAndCount(DeliveryDuration) == series+2
+		}, 2*time.Second, 10*time.Millisecond, "one series per endpoint")
+
+		ps.Unsubscribe(streamID)
+		ps.Unsubscribe(id)
+		assert.Zero(t, testutil.ToFloat64(Subscriptions.WithLabelValues("rest")))
+		assert.Zero(t, testutil.ToFloat64(Subscriptions.WithLabelValues("stream")))
+		assert.Equal(t, series, testutil.CollectAndCount(DeliveryDuration))
+	})
+}
@@ -0,0 +1,162 @@
+package cep
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "openshift"
+	metricsSubsystem = "cloud_event_proxy"
+)
+
+var (
+	// EventsDelivered counts events delivered to a subscriber.
+	EventsDelivered = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "events_delivered_total",
+			Help:      "Number of events delivered to a subscriber.",
+		}, []string{"subscription"})
+
+	// DeliveryFailures counts failed delivery attempts to a subscriber, retries included.
+	DeliveryFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "delivery_failures_total",
+			Help:      "Number of failed delivery attempts to a subscriber.",
+		}, []string{"subscription"})
+
+	// EventsDropped counts events dropped because the delivery queue of a subscriber was full.
+	EventsDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "events_dropped_total",
+			Help:      "Number of events dropped from a full subscriber delivery queue.",
+		}, []string{"subscription"})
+
+	// DeliveryQueueDepth reports the events waiting in the delivery queue of a subscriber.
+	DeliveryQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "delivery_queue_depth",
+			Help:      "Number of events waiting in a subscriber delivery queue.",
+		}, []string{"subscription"})
+
+	// SubscriptionsExpired counts subscriptions evicted or marked inactive after failing delivery.
+	SubscriptionsExpired = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "subscriptions_expired_total",
+			Help:      "Number of subscriptions evicted or marked inactive after failing delivery.",
+		}, []string{"action"})
+
+	// IPCHandshakes counts IPC handshakes with the daemon by result: ok, downgraded or failed.
+	IPCHandshakes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "ipc_handshakes_total",
+			Help:      "Number of IPC handshakes with the daemon by result.",
+		}, []string{"result"})
+
+	// IPCRejectedMessages counts daemon messages skipped for an unsupported version, type or command, or a type
+	// not negotiated with the daemon.
+	IPCRejectedMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "ipc_rejected_messages_total",
+			Help:      "Number of daemon messages skipped for an unsupported version, type or command, or a type not negotiated.",
+		}, []string{"reason"})
+
+	// DeliveryDuration observes the duration of delivery attempts by subscriber endpoint and result.
+	DeliveryDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "delivery_duration_seconds",
+			Help:      "Duration of delivery attempts to a subscriber endpoint by result.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+		}, []string{"endpoint", "result"})
+
+	// Subscriptions reports the active subscriptions by kind: rest or stream.
+	Subscriptions = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "subscriptions",
+			Help:      "Number of subscriptions by kind.",
+		}, []string{"kind"})
+
+	// IPCMessagesReceived counts the messages received from the daemon by type.
+	IPCMessagesReceived = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "ipc_messages_received_total",
+			Help:      "Number of IPC messages received from the daemon by type.",
+		}, []string{"type"})
+
+	// IPCParseErrors counts the lines received from the daemon that are not IPC messages.
+	IPCParseErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "ipc_parse_errors_total",
+			Help:      "Number of lines received from the daemon that could not be parsed as IPC messages.",
+		})
+
+	// DaemonConnected reports whether the daemon is connected to the IPC socket.
+	DaemonConnected = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "daemon_connected",
+			Help:      "1 when the daemon is connected to the IPC socket, 0 otherwise.",
+		})
+
+	// CachedEvents reports the events held by the event cache.
+	CachedEvents = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "cached_events",
+			Help:      "Number of events held by the event cache.",
+		})
+
+	registerMetrics sync.Once
+)
+
+// RegisterMetrics registers the cloud-event-proxy metrics with the default prometheus registry.
+func RegisterMetrics() {
+	registerMetrics.Do(func() {
+		prometheus.MustRegister(EventsDelivered)
+		prometheus.MustRegister(DeliveryFailures)
+		prometheus.MustRegister(EventsDropped)
+		prometheus.MustRegister(DeliveryQueueDepth)
+		prometheus.MustRegister(SubscriptionsExpired)
+		prometheus.MustRegister(IPCHandshakes)
+		prometheus.MustRegister(IPCRejectedMessages)
+		prometheus.MustRegister(DeliveryDuration)
+		prometheus.MustRegister(Subscriptions)
+		prometheus.MustRegister(IPCMessagesReceived)
+		prometheus.MustRegister(IPCParseErrors)
+		prometheus.MustRegister(DaemonConnected)
+		prometheus.MustRegister(CachedEvents)
+	})
+}
+
+// deleteSubscriptionMetrics drops the delivery metrics of a removed subscription
+func deleteSubscriptionMetrics(id string) {
+	EventsDelivered.DeleteLabelValues(id)
+	DeliveryFailures.DeleteLabelValues(id)
+	EventsDropped.DeleteLabelValues(id)
+	DeliveryQueueDepth.DeleteLabelValues(id)
+}
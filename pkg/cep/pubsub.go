@@ -18,13 +18,18 @@ type Subscription struct {
 	Resource string
 	Endpoint string
 	W        io.Writer
+	// Inactive subscriptions failed delivery for longer than the configured expiry and receive no events
+	Inactive bool
 	active   bool
+	// stream subscriptions deliver to an open API stream, they are neither persisted nor listed
+	stream bool
 }
 
 type subscriptionRecord struct {
 	ID       string `json:"id"`
 	Resource string `json:"resource"`
 	Endpoint string `json:"endpoint,omitempty"`
+	Inactive bool   `json:"inactive,omitempty"`
 }
 
 // PubSub is a datastore for Subscription objects providing O(1) lookup for
@@ -40,11 +45,17 @@ type PubSub struct {
 	free []int
 	// resourceIndex maps the watched resource to subscriptions, allowing for O(1) lookup
 	resourceIndex map[string][]int
+	// wildcards holds the subscriptions whose resource address contains wildcards, matched on every publish
+	wildcards []int
 	// storePath is the filepath for persistent storage of subscriptionRecords
 	storePath string
 	// writerFunc creates an io.Writer for a given endpoint. Used when Subscribe() is called, or when
 	// SubscriptionRecords are loaded from disk.
 	writerFunc WriterFunc
+	// delivery configures the asynchronous delivery of events to subscribers
+	delivery DeliveryConfig
+	// queues holds the delivery queue of each subscription with a writer, by subscription ID
+	queues map[string]*deliveryQueue
 }
 
 // NewPubSub creates a PubSub with the given persistent store path and writer factory.
@@ -53,17 +64,41 @@ func NewPubSub(storePath string, writerFunc WriterFunc) *PubSub {
 		resourceIndex: make(map[string][]int),
 		storePath:     storePath,
 		writerFunc:    writerFunc,
+		delivery:      DefaultDeliveryConfig(),
+		queues:        make(map[string]*deliveryQueue),
 	}
 }
 
+// SetDeliveryConfig sets the delivery settings. It must be called before subscriptions are added or loaded.
+func (ps *PubSub) SetDeliveryConfig(cfg DeliveryConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.delivery = cfg
+	return nil
+}
+
 // Subscribe registers a new subscription for the given resource and returns its ID.
 func (ps *PubSub) Subscribe(resource, endpoint string) string {
-	id := uuid.New().String()
 	var w io.Writer
 	if endpoint != "" && ps.writerFunc != nil {
 		w = ps.writerFunc(endpoint)
 	}
-	sub := Subscription{ID: id, Resource: resource, Endpoint: endpoint, W: w, active: true}
+	return ps.add(Subscription{Resource: resource, Endpoint: endpoint, W: w})
+}
+
+// SubscribeStream registers a subscription for the given resource delivering events to w until it is
+// unsubscribed, and returns its ID.
+func (ps *PubSub) SubscribeStream(resource string, w io.Writer) string {
+	return ps.add(Subscription{Resource: resource, W: w, stream: true})
+}
+
+func (ps *PubSub) add(sub Subscription) string {
+	id := uuid.New().String()
+	sub.ID = id
+	sub.active = true
 
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
@@ -77,104 +112,230 @@ func (ps *PubSub) Subscribe(resource, endpoint string) string {
 		idx = len(ps.subs)
 		ps.subs = append(ps.subs, sub)
 	}
-	ps.resourceIndex[resource] = append(ps.resourceIndex[resource], idx)
+	ps.addIndexLocked(idx)
+	ps.startQueueLocked(sub)
+	ps.updateSubscriptionsMetricLocked()
 
+	if sub.stream {
+		return id
+	}
 	if err := ps.save(); err != nil {
 		glog.Errorf("failed to save subscriptions: %v", err)
 	}
 	return id
 }
 
+// Replay queues the given events for delivery to the subscription ahead of any event published later.
+// Events published after the subscription was added are queued ahead of them, so the caller must keep
+// events from being published between adding the subscription, reading the replayed events from the
+// cache and Replay.
+func (ps *PubSub) Replay(id string, events []event.Event) bool {
+	ps.mu.RLock()
+	q, ok := ps.queues[id]
+	ps.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	for i := range events {
+		if data := marshalEvent(&events[i]); data != nil {
+			q.enqueue(data)
+		}
+	}
+	return true
+}
+
 // Unsubscribe removes the subscription with the given ID, returning false if not found.
 func (ps *PubSub) Unsubscribe(id string) bool {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
 
-	idx := -1
+	idx := ps.findLocked(id)
+	if idx == -1 {
+		return false
+	}
+	ps.removeLocked(idx)
+
+	if err := ps.save(); err != nil {
+		glog.Errorf("failed to save subscriptions: %v", err)
+	}
+	return true
+}
+
+// findLocked returns the index of the subscription with the given ID, -1 if not found. Caller must hold ps.mu.
+func (ps *PubSub) findLocked(id string) int {
 	for i, s := range ps.subs {
 		if s.active && s.ID == id {
-			idx = i
-			break
+			return i
 		}
 	}
-	if idx == -1 {
-		return false
-	}
+	return -1
+}
 
+// removeLocked removes the subscription at the given index. Caller must hold ps.mu.
+func (ps *PubSub) removeLocked(idx int) {
 	resource := ps.subs[idx].Resource
+	ps.stopQueueLocked(ps.subs[idx].ID)
 	ps.subs[idx] = Subscription{}
 	ps.free = append(ps.free, idx)
+	ps.updateSubscriptionsMetricLocked()
 
-	indices := ps.resourceIndex[resource]
-	for i, si := range indices {
-		if si == idx {
-			indices[i] = indices[len(indices)-1]
-			indices = indices[:len(indices)-1]
-			break
-		}
+	if hasWildcard(resource) {
+		ps.wildcards = removeIndex(ps.wildcards, idx)
+		return
 	}
+	indices := removeIndex(ps.resourceIndex[resource], idx)
 	if len(indices) == 0 {
 		delete(ps.resourceIndex, resource)
 	} else {
 		ps.resourceIndex[resource] = indices
 	}
+}
 
-	if err := ps.save(); err != nil {
-		glog.Errorf("failed to save subscriptions: %v", err)
+// updateSubscriptionsMetricLocked reports the number of subscriptions. Caller must hold ps.mu.
+func (ps *PubSub) updateSubscriptionsMetricLocked() {
+	var rest, streams int
+	for _, sub := range ps.subs {
+		switch {
+		case sub.ID == "":
+		case sub.stream:
+			streams++
+		default:
+			rest++
+		}
+	}
+	Subscriptions.WithLabelValues("rest").Set(float64(rest))
+	Subscriptions.WithLabelValues("stream").Set(float64(streams))
+}
+
+// addIndexLocked indexes the subscription at the given index by its resource address. Caller must hold ps.mu.
+func (ps *PubSub) addIndexLocked(idx int) {
+	resource := ps.subs[idx].Resource
+	if hasWildcard(resource) {
+		ps.wildcards = append(ps.wildcards, idx)
+	} else {
+		ps.resourceIndex[resource] = append(ps.resourceIndex[resource], idx)
 	}
-	return true
 }
 
-// UnsubscribeAll removes all subscriptions.
+func removeIndex(indices []int, idx int) []int {
+	for i, si := range indices {
+		if si == idx {
+			indices[i] = indices[len(indices)-1]
+			return indices[:len(indices)-1]
+		}
+	}
+	return indices
+}
+
+// stopAllQueuesLocked stops delivering events to all subscriptions. Caller must hold ps.mu.
+func (ps *PubSub) stopAllQueuesLocked() {
+	for id := range ps.queues {
+		ps.stopQueueLocked(id)
+	}
+}
+
+// UnsubscribeAll removes all subscriptions but streams, which end with their API stream.
 func (ps *PubSub) UnsubscribeAll() {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
-	ps.subs = nil
-	ps.free = nil
-	ps.resourceIndex = make(map[string][]int)
+	for i := range ps.subs {
+		if ps.subs[i].active && !ps.subs[i].stream {
+			ps.removeLocked(i)
+		}
+	}
 	if err := ps.save(); err != nil {
 		glog.Errorf("failed to save subscriptions: %v", err)
 	}
 }
 
 type publishTarget struct {
-	id string
-	w  io.Writer
+	q *deliveryQueue
+	// filter is the resource address of the data values delivered, empty delivers the whole event
+	filter string
 }
 
-func (ps *PubSub) getTargets(source string) []publishTarget {
+// getTargets returns the subscribers of the event source, then those of its data value resources
+func (ps *PubSub) getTargets(e *event.Event) []publishTarget {
 	ps.mu.RLock()
 	defer ps.mu.RUnlock()
-	indices := ps.resourceIndex[source]
-	targets := make([]publishTarget, 0, len(indices))
-	for _, idx := range indices {
+	var targets []publishTarget
+	seen := map[string]bool{}
+	add := func(idx int, filter string) {
 		sub := ps.subs[idx]
-		if sub.W != nil {
-			targets = append(targets, publishTarget{id: sub.ID, w: sub.W})
+		if q, ok := ps.queues[sub.ID]; ok && !seen[sub.ID] {
+			seen[sub.ID] = true
+			targets = append(targets, publishTarget{q: q, filter: filter})
+		}
+	}
+	for _, idx := range ps.resourceIndex[e.Source] {
+		add(idx, "")
+	}
+	for _, idx := range ps.wildcards {
+		if matchResource(ps.subs[idx].Resource, e.Source) {
+			add(idx, "")
+		}
+	}
+	if e.Data == nil {
+		return targets
+	}
+	for _, dv := range e.Data.Values {
+		for _, idx := range ps.resourceIndex[dv.Resource] {
+			add(idx, ps.subs[idx].Resource)
+		}
+		for _, idx := range ps.wildcards {
+			if matchResource(ps.subs[idx].Resource, dv.Resource) {
+				add(idx, ps.subs[idx].Resource)
+			}
 		}
 	}
 	return targets
 }
 
-// Publish converts the event to a CloudEvent and delivers it to all matching subscribers.
+// Publish converts the event to a CloudEvent and queues it for delivery to all matching subscribers.
+// Subscribers of data value resources receive the event with the matching data values only.
 func (ps *PubSub) Publish(e *event.Event) {
+	payloads := map[string][]byte{}
+	for _, t := range ps.getTargets(e) {
+		data, ok := payloads[t.filter]
+		if !ok {
+			data = marshalEvent(filterEvent(e, t.filter))
+			payloads[t.filter] = data
+		}
+		if data != nil {
+			t.q.enqueue(data)
+		}
+	}
+}
+
+// filterEvent returns a copy of the event holding only the data values matching the resource address
+func filterEvent(e *event.Event, filter string) *event.Event {
+	if filter == "" {
+		return e
+	}
+	out := copyEvent(e)
+	values := out.Data.Values[:0]
+	for _, dv := range out.Data.Values {
+		if matchResource(filter, dv.Resource) {
+			values = append(values, dv)
+		}
+	}
+	out.Data.Values = values
+	return &out
+}
+
+// marshalEvent returns the event as a newline terminated CloudEvent, nil on error
+func marshalEvent(e *event.Event) []byte {
 	ce, err := e.NewCloudEventV2()
 	if err != nil {
 		glog.Errorf("failed to convert event to CloudEvent: %v", err)
-		return
+		return nil
 	}
 	data, err := json.Marshal(ce)
 	if err != nil {
 		glog.Errorf("failed to marshal CloudEvent: %v", err)
-		return
-	}
-	data = append(data, '\n')
-
-	for _, t := range ps.getTargets(e.Source) {
-		if _, writeErr := t.w.Write(data); writeErr != nil {
-			glog.Errorf("failed to write event to subscriber %s: %v", t.id, writeErr)
-		}
+		return nil
 	}
+	return append(data, '\n')
 }
 
 // Get returns the subscription with the given ID.
@@ -182,7 +343,7 @@ func (ps *PubSub) Get(id string) (*Subscription, bool) {
 	ps.mu.RLock()
 	defer ps.mu.RUnlock()
 	for i := range ps.subs {
-		if ps.subs[i].active && ps.subs[i].ID == id {
+		if ps.subs[i].active && !ps.subs[i].stream && ps.subs[i].ID == id {
 			return &ps.subs[i], true
 		}
 	}
@@ -195,7 +356,7 @@ func (ps *PubSub) List() []Subscription {
 	defer ps.mu.RUnlock()
 	out := make([]Subscription, 0, len(ps.subs)-len(ps.free))
 	for _, s := range ps.subs {
-		if s.active {
+		if s.active && !s.stream {
 			out = append(out, s)
 		}
 	}
@@ -206,8 +367,8 @@ func (ps *PubSub) List() []Subscription {
 func (ps *PubSub) save() error {
 	records := make([]subscriptionRecord, 0, len(ps.subs)-len(ps.free))
 	for _, sub := range ps.subs {
-		if sub.active {
-			records = append(records, subscriptionRecord{ID: sub.ID, Resource: sub.Resource, Endpoint: sub.Endpoint})
+		if sub.active && !sub.stream {
+			records = append(records, subscriptionRecord{ID: sub.ID, Resource: sub.Resource, Endpoint: sub.Endpoint, Inactive: sub.Inactive})
 		}
 	}
 	data, err := json.MarshalIndent(records, "", "  ")
@@ -238,16 +399,20 @@ func (ps *PubSub) LoadFromDisk() error {
 		return unmarshalErr
 	}
 
+	ps.stopAllQueuesLocked()
 	ps.subs = make([]Subscription, len(records))
 	ps.free = nil
 	ps.resourceIndex = make(map[string][]int)
+	ps.wildcards = nil
 	for i, r := range records {
 		var w io.Writer
 		if r.Endpoint != "" && ps.writerFunc != nil {
 			w = ps.writerFunc(r.Endpoint)
 		}
-		ps.subs[i] = Subscription{ID: r.ID, Resource: r.Resource, Endpoint: r.Endpoint, W: w, active: true}
-		ps.resourceIndex[r.Resource] = append(ps.resourceIndex[r.Resource], i)
+		ps.subs[i] = Subscription{ID: r.ID, Resource: r.Resource, Endpoint: r.Endpoint, W: w, Inactive: r.Inactive, active: true}
+		ps.addIndexLocked(i)
+		ps.startQueueLocked(ps.subs[i])
 	}
+	ps.updateSubscriptionsMetricLocked()
 	return nil
 }
@@ -3,14 +3,21 @@ package cep
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
+	"math"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	ce "github.com/cloudevents/sdk-go/v2/event"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/redhat-cne/sdk-go/pkg/event"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
 )
 
 const (
@@ -29,17 +36,24 @@ func makeTestEvent(source string) *event.Event {
 	}
 }
 
-func testPubSub(t *testing.T, writers map[string]*bytes.Buffer) *PubSub {
+func testPubSub(t *testing.T, writers map[string]*testWriter) *PubSub {
 	t.Helper()
 	return NewPubSub(filepath.Join(t.TempDir(), "subscriptions.json"), func(endpoint string) io.Writer {
-		buf := &bytes.Buffer{}
+		w := &testWriter{}
 		if writers != nil {
-			writers[endpoint] = buf
+			writers[endpoint] = w
 		}
-		return buf
+		return w
 	})
 }
 
+// assertDelivered waits until the writer received n events, and checks that no more follow
+func assertDelivered(t *testing.T, w *testWriter, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool { return w.delivered() >= n }, 5*time.Second, time.Millisecond)
+	assert.Never(t, func() bool { return w.delivered() > n }, 50*time.Millisecond, time.Millisecond)
+}
+
 func TestSubscribe(t *testing.T) {
 	t.Run("single subscription", func(t *testing.T) {
 		ps := testPubSub(t, nil)
@@ -155,7 +169,7 @@ func TestPublish(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			writers := map[string]*bytes.Buffer{}
+			writers := map[string]*testWriter{}
 			ps := testPubSub(t, writers)
 
 			for _, s := range tt.subs {
@@ -165,14 +179,12 @@ func TestPublish(t *testing.T) {
 			ps.Publish(makeTestEvent(tt.publishSource))
 
 			for _, ep := range tt.wantDelivered {
-				buf := writers[ep]
-				require.NotEmpty(t, buf.Bytes(), "endpoint %s should have received an event", ep)
-				var got ce.Event
-				require.NoError(t, json.NewDecoder(buf).Decode(&got), "endpoint %s", ep)
+				assertDelivered(t, writers[ep], 1)
+				got := writers[ep].event(t, 0)
 				assert.Equal(t, tt.publishSource, got.Source(), "endpoint %s source mismatch", ep)
 			}
 			for _, ep := range tt.wantEmpty {
-				assert.Empty(t, writers[ep].Bytes(), "endpoint %s should not have received an event", ep)
+				assertDelivered(t, writers[ep], 0)
 			}
 		})
 	}
@@ -240,7 +252,7 @@ func TestUnsubscribe(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			writers := map[string]*bytes.Buffer{}
+			writers := map[string]*testWriter{}
 			ps := testPubSub(t, writers)
 
 			ids := make([]string, len(tt.subs))
@@ -272,10 +284,10 @@ func TestUnsubscribe(t *testing.T) {
 			if tt.publishSource != "" {
 				ps.Publish(makeTestEvent(tt.publishSource))
 				for _, ep := range tt.wantDelivered {
-					require.NotEmpty(t, writers[ep].Bytes(), "endpoint %s should have received an event", ep)
+					assertDelivered(t, writers[ep], 1)
 				}
 				for _, ep := range tt.wantEmpty {
-					assert.Empty(t, writers[ep].Bytes(), "endpoint %s should not have received an event", ep)
+					assertDelivered(t, writers[ep], 0)
 				}
 			}
 		})
@@ -401,3 +413,197 @@ func TestPubSubPersistence(t *testing.T) {
 		})
 	}
 }
+
+// testWriter records the written events. It fails the first failures writes, and blocks writes while
+// block is open.
+type testWriter struct {
+	mu       sync.Mutex
+	failures int
+	block    chan struct{}
+	events   [][]byte
+}
+
+func (w *testWriter) Write(p []byte) (int, error) {
+	if w.block != nil {
+		<-w.block
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failures > 0 {
+		w.failures--
+		return 0, errors.New("subscriber unavailable")
+	}
+	w.events = append(w.events, bytes.Clone(p))
+	return len(p), nil
+}
+
+func (w *testWriter) delivered() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.events)
+}
+
+// event decodes the i-th written event
+func (w *testWriter) event(t *testing.T, i int) ce.Event {
+	t.Helper()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	require.Greater(t, len(w.events), i)
+	var e ce.Event
+	require.NoError(t, json.Unmarshal(w.events[i], &e))
+	return e
+}
+
+func testDeliveryPubSub(t *testing.T, cfg DeliveryConfig, writers map[string]*testWriter) *PubSub {
+	t.Helper()
+	ps := NewPubSub(filepath.Join(t.TempDir(), "subscriptions.json"), func(endpoint string) io.Writer {
+		return writers[endpoint]
+	})
+	require.NoError(t, ps.SetDeliveryConfig(cfg))
+	return ps
+}
+
+func TestDelivery(t *testing.T) {
+	cfg := DeliveryConfig{QueueSize: 2, InitialBackoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond, ExpireAction: ExpireEvict}
+
+	t.Run("slow subscriber does not delay others", func(t *testing.T) {
+		writers := map[string]*testWriter{testSub1: {block: make(chan struct{})}, testSub2: {}}
+		ps := testDeliveryPubSub(t, cfg, writers)
+		id1 := ps.Subscribe(testPTPLockState, testSub1)
+		ps.Subscribe(testPTPLockState, testSub2)
+
+		ps.Publish(makeTestEvent(testPTPLockState))
+		require.Eventually(t, func() bool { return writers[testSub2].delivered() == 1 }, 5*time.Second, time.Millisecond)
+		assert.Zero(t, writers[testSub1].delivered())
+
+		// the blocked subscriber keeps the newest events of its bounded queue
+		for i := 0; i < 3; i++ {
+			ps.Publish(makeTestEvent(testPTPLockState))
+		}
+		close(writers[testSub1].block)
+		assertDelivered(t, writers[testSub2], 4)
+		assertDelivered(t, writers[testSub1], 3)
+		assert.Equal(t, 1.0, testutil.ToFloat64(EventsDropped.WithLabelValues(id1)))
+	})
+
+	t.Run("failed delivery is retried", func(t *testing.T) {
+		writers := map[string]*testWriter{testSub1: {failures: 3}}
+		ps := testDeliveryPubSub(t, cfg, writers)
+		id := ps.Subscribe(testPTPLockState, testSub1)
+
+		ps.Publish(makeTestEvent(testPTPLockState))
+		assertDelivered(t, writers[testSub1], 1)
+		assert.Equal(t, 3.0, testutil.ToFloat64(DeliveryFailures.WithLabelValues(id)))
+		assert.Equal(t, 1.0, testutil.ToFloat64(EventsDelivered.WithLabelValues(id)))
+	})
+
+	for _, action := range []ExpireAction{ExpireEvict, ExpireMarkInactive} {
+		t.Run("failing subscriber expires: "+string(action), func(t *testing.T) {
+			expireCfg := cfg
+			expireCfg.ExpireAfter = 20 * time.Millisecond
+			expireCfg.ExpireAction = action
+			writers := map[string]*testWriter{testSub1: {failures: math.MaxInt}, testSub2: {}}
+			ps := testDeliveryPubSub(t, expireCfg, writers)
+			id := ps.Subscribe(testPTPLockState, testSub1)
+			ps.Subscribe(testPTPLockState, testSub2)
+
+			ps.Publish(makeTestEvent(testPTPLockState))
+			require.Eventually(t, func() bool {
+				ps.mu.RLock()
+				defer ps.mu.RUnlock()
+				_, delivering := ps.queues[id]
+				return !delivering
+			}, 5*time.Second, time.Millisecond)
+
+			// the expiry is persisted
+			reloaded := testDeliveryPubSub(t, expireCfg, writers)
+			reloaded.storePath = ps.storePath
+			require.NoError(t, reloaded.LoadFromDisk())
+			sub, found := reloaded.Get(id)
+			if action == ExpireEvict {
+				assert.False(t, found)
+				assert.Len(t, reloaded.List(), 1)
+			} else {
+				require.True(t, found)
+				assert.True(t, sub.Inactive)
+				assert.NotContains(t, reloaded.queues, id)
+				assert.Len(t, reloaded.List(), 2)
+			}
+			assertDelivered(t, writers[testSub2], 1)
+		})
+	}
+}
+
+func TestDeliveryConfigValidate(t *testing.T) {
+	assert.NoError(t, DefaultDeliveryConfig().Validate())
+	for _, cfg := range []DeliveryConfig{
+		{QueueSize: 0, InitialBackoff: time.Second, MaxBackoff: time.Second, ExpireAction: ExpireEvict},
+		{QueueSize: 1, InitialBackoff: time.Second, MaxBackoff: time.Millisecond, ExpireAction: ExpireEvict},
+		{QueueSize: 1, InitialBackoff: time.Second, MaxBackoff: time.Second, ExpireAfter: -time.Second, ExpireAction: ExpireEvict},
+		{QueueSize: 1, InitialBackoff: time.Second, MaxBackoff: time.Second, ExpireAction: "drop"},
+	} {
+		assert.Error(t, cfg.Validate(), cfg)
+	}
+}
+
+func TestPublishResourceMatching(t *testing.T) {
+	const (
+		ens1f0 = "/cluster/node/worker-0/ens1f0/master"
+		ens2f0 = "/cluster/node/worker-0/ens2f0/master"
+	)
+	e := makeTestEvent(testPTPLockState)
+	e.Data.Values = append(buildDataValues(ens1f0, ipc.StateValue{State: ipc.StateLocked}, 5),
+		buildDataValues(ens2f0, ipc.StateValue{State: ipc.StateFreerun}, 900)...)
+
+	tests := []struct {
+		name       string
+		resource   string
+		wantValues []string // resources of the delivered data values, nil if nothing is delivered
+	}{
+		{"wildcard node", "/cluster/node/*/sync/ptp-status/lock-state", []string{ens1f0, ens1f0, ens2f0, ens2f0}},
+		{"hierarchical node", "/cluster/node/worker-0/**", []string{ens1f0, ens1f0, ens2f0, ens2f0}},
+		{"single interface", ens2f0, []string{ens2f0, ens2f0}},
+		{"wildcard interface", "/cluster/node/worker-0/*/master", []string{ens1f0, ens1f0, ens2f0, ens2f0}},
+		{"other node", "/cluster/node/worker-1/**", nil},
+		{"other interface", "/cluster/node/worker-0/ens3f0/master", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writers := map[string]*testWriter{}
+			ps := testPubSub(t, writers)
+			ps.Subscribe(tt.resource, testSub1)
+
+			ps.Publish(e)
+
+			if tt.wantValues == nil {
+				assertDelivered(t, writers[testSub1], 0)
+				return
+			}
+			// the event is delivered once
+			assertDelivered(t, writers[testSub1], 1)
+			got := writers[testSub1].event(t, 0)
+			assert.Equal(t, testPTPLockState, got.Source())
+			var data event.Data
+			require.NoError(t, got.DataAs(&data))
+			var resources []string
+			for _, dv := range data.Values {
+				resources = append(resources, dv.Resource)
+			}
+			assert.Equal(t, tt.wantValues, resources)
+		})
+	}
+	assert.Len(t, e.Data.Values, 4, "published event must not be modified")
+}
+
+func TestUnsubscribeWildcard(t *testing.T) {
+	writers := map[string]*testWriter{}
+	ps := testPubSub(t, writers)
+	id := ps.Subscribe("/cluster/node/*/sync/ptp-status/lock-state", testSub1)
+	require.Len(t, ps.wildcards, 1)
+	require.True(t, ps.Unsubscribe(id))
+	assert.Empty(t, ps.wildcards)
+	assert.Empty(t, ps.resourceIndex)
+
+	ps.Publish(makeTestEvent(testPTPLockState))
+	assertDelivered(t, writers[testSub1], 0)
+}
@@ -0,0 +1,153 @@
+package cep
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/redhat-cne/sdk-go/pkg/event"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
+)
+
+// Subscription resource addresses may contain wildcards:
+//   - "*" matches a single path segment, e.g. /cluster/node/*/sync/ptp-status/lock-state
+//   - "**" as the last segment matches the rest of the address, e.g. /cluster/node/worker-0/ens2f0/**
+//
+// An address matches either the source of an event, which then is delivered whole, or the resource of
+// its data values, e.g. a single interface, in which case only the matching data values are delivered.
+const (
+	wildcardSegment    = "*"
+	wildcardHierarchic = "**"
+)
+
+// templateValues holds a value of every IPC type, used to build the resource addresses the cache produces
+var templateValues = map[string]ipc.Value{
+	ipc.TypePTPState:          ipc.StateValue{},
+	ipc.TypeOSClockState:      ipc.StateValue{},
+	ipc.TypeClockClass:        ipc.ClockClassValue{},
+	ipc.TypeGNSSState:         ipc.GNSSStateValue{},
+	ipc.TypeSyncEState:        ipc.SyncEStateValue{},
+	ipc.TypeSyncEClockQuality: ipc.SyncEClockQualityValue{},
+	ipc.TypeSyncState:         ipc.SyncStateValue{},
+	ipc.TypeOffsetSummary:     ipc.OffsetSummaryValue{Source: wildcardSegment},
+	ipc.TypePortState:         ipc.PortStateValue{},
+	ipc.TypeTimeErrorMask:     ipc.TimeErrorMaskValue{Source: wildcardSegment, Statistic: wildcardSegment},
+}
+
+// hasWildcard returns whether the resource address contains a wildcard segment
+func hasWildcard(resource string) bool {
+	for _, seg := range strings.Split(resource, "/") {
+		if seg == wildcardSegment || seg == wildcardHierarchic {
+			return true
+		}
+	}
+	return false
+}
+
+// matchResource returns whether the subscription resource address matches the given address.
+// Wildcards in the address itself, as in resource templates, match any segment of the pattern.
+func matchResource(pattern, resource string) bool {
+	p := strings.Split(strings.TrimSuffix(pattern, "/"), "/")
+	r := strings.Split(strings.TrimSuffix(resource, "/"), "/")
+	for i, seg := range p {
+		if seg == wildcardHierarchic && i == len(p)-1 {
+			return len(r) >= i
+		}
+		if i >= len(r) {
+			return false
+		}
+		if seg != wildcardSegment && r[i] != wildcardSegment && seg != r[i] {
+			return false
+		}
+	}
+	return len(p) == len(r)
+}
+
+// validateResourcePattern checks the placement of wildcards in a subscription resource address
+func validateResourcePattern(resource string) error {
+	if !strings.HasPrefix(resource, "/") {
+		return fmt.Errorf("resource address %q must be absolute", resource)
+	}
+	segs := strings.Split(resource, "/")
+	for i, seg := range segs {
+		if seg == wildcardHierarchic && i != len(segs)-1 {
+			return fmt.Errorf("resource address %q: %s is only allowed as the last segment", resource, wildcardHierarchic)
+		}
+		if seg != wildcardSegment && seg != wildcardHierarchic && strings.Contains(seg, "*") {
+			return fmt.Errorf("resource address %q: wildcards must span a whole segment", resource)
+		}
+	}
+	return nil
+}
+
+// resourceTemplates returns the event sources and data value resources the cache builds,
+// with a wildcard segment in place of the interface
+func (c *EventCache) resourceTemplates() []string {
+	var templates []string
+	for ipcType, v := range templateValues {
+		_, source, _ := oranMapping(ipcType)
+		templates = append(templates, path.Join("/cluster/node", c.nodeName, string(source)))
+		for _, dv := range buildDataValues(c.buildResourceAddress(ipcType, wildcardSegment), v, unknownOffset) {
+			templates = append(templates, dv.Resource)
+		}
+	}
+	return templates
+}
+
+// ValidateResource returns an error when the subscription resource address is malformed or
+// cannot match any resource address of this node.
+func (c *EventCache) ValidateResource(resource string) error {
+	if err := validateResourcePattern(resource); err != nil {
+		return err
+	}
+	for _, template := range c.resourceTemplates() {
+		if matchResource(resource, template) {
+			return nil
+		}
+	}
+	return fmt.Errorf("resource address %q does not match any resource of node %s", resource, c.nodeName)
+}
+
+// Resolve returns the cached event sources and data value resources matching the subscription resource address
+func (c *EventCache) Resolve(resource string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	seen := map[string]bool{}
+	for _, e := range c.entries {
+		if matchResource(resource, e.Source) {
+			seen[e.Source] = true
+		}
+		for _, dv := range e.Data.Values {
+			if matchResource(resource, dv.Resource) {
+				seen[dv.Resource] = true
+			}
+		}
+	}
+	resolved := make([]string, 0, len(seen))
+	for r := range seen {
+		resolved = append(resolved, r)
+	}
+	sort.Strings(resolved)
+	return resolved
+}
+
+// Matching returns the cached events for the subscription resource address, by source. Events are
+// returned whole when their source matches, and with the matching data values only otherwise.
+func (c *EventCache) Matching(resource string) []event.Event {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var events []event.Event
+	for _, e := range c.entries {
+		if matchResource(resource, e.Source) {
+			events = append(events, copyEvent(e))
+			continue
+		}
+		if f := filterEvent(e, resource); len(f.Data.Values) > 0 {
+			events = append(events, *f)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Source < events[j].Source })
+	return events
+}
@@ -0,0 +1,89 @@
+package cep
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
+)
+
+func TestMatchResource(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		resource string
+		want     bool
+	}{
+		{testPTPLockState, testPTPLockState, true},
+		{"/cluster/node/*/sync/ptp-status/lock-state", testPTPLockState, true},
+		{"/cluster/node/*/sync/ptp-status/lock-state", "/cluster/node/worker-0/sync/ptp-status/clock-class", false},
+		{"/cluster/node/*", testPTPLockState, false},
+		{"/cluster/node/worker-0/**", testPTPLockState, true},
+		{"/cluster/node/worker-0/**", "/cluster/node/worker-0", true},
+		{"/cluster/node/worker-1/**", testPTPLockState, false},
+		{"/cluster/node/worker-0/ens1f0/master", "/cluster/node/worker-0/*/master", true},
+		{"/cluster/node/worker-0/ens1f0/master", "/cluster/node/worker-0/ens1f0/master/gpsFix", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, matchResource(tt.pattern, tt.resource), "%s ~ %s", tt.pattern, tt.resource)
+	}
+}
+
+func TestEventCache_ValidateResource(t *testing.T) {
+	cache := NewEventCache("worker-0")
+	for _, resource := range []string{
+		testPTPLockState,
+		"/cluster/node/*/sync/ptp-status/lock-state",
+		"/cluster/node/worker-0/**",
+		"/cluster/node/worker-0/ens1f0/master",
+		"/cluster/node/worker-0/*/master/clock-class",
+		"/cluster/node/worker-0/ens1f0/ptp4l/offset/mean",
+	} {
+		assert.NoError(t, cache.ValidateResource(resource), resource)
+	}
+	for _, resource := range []string{
+		"cluster/node/worker-0/**",
+		"/cluster/node/**/sync/ptp-status/lock-state",
+		"/cluster/node/worker-*/sync/ptp-status/lock-state",
+		"/cluster/node/worker-1/sync/ptp-status/lock-state",
+		"/resource/a",
+	} {
+		assert.Error(t, cache.ValidateResource(resource), resource)
+	}
+}
+
+func TestEventCache_Resolve(t *testing.T) {
+	cache := NewEventCache("worker-0")
+	assert.Empty(t, cache.Resolve("/cluster/node/*/sync/ptp-status/lock-state"))
+
+	cache.Update(ptpMsg("ens1f0", ipc.StateLocked))
+	cache.Update(ptpMsg("ens2f0", ipc.StateFreerun))
+
+	assert.Equal(t, []string{testPTPLockState}, cache.Resolve("/cluster/node/*/sync/ptp-status/lock-state"))
+	assert.Equal(t, []string{"/cluster/node/worker-0/ens1f0/master", "/cluster/node/worker-0/ens2f0/master"},
+		cache.Resolve("/cluster/node/worker-0/*/master"))
+	assert.Equal(t, []string{"/cluster/node/worker-0/ens1f0/master", "/cluster/node/worker-0/ens2f0/master", testPTPLockState},
+		cache.Resolve("/cluster/node/worker-0/**"))
+	assert.Empty(t, cache.Resolve("/cluster/node/worker-0/ens3f0/master"))
+}
+
+func TestEventCache_Matching(t *testing.T) {
+	cache := NewEventCache("worker-0")
+	cache.Update(ptpMsg("ens1f0", ipc.StateLocked))
+	cache.Update(ptpMsg("ens2f0", ipc.StateFreerun))
+
+	events := cache.Matching("/cluster/node/*/sync/ptp-status/lock-state")
+	require.Len(t, events, 1)
+	assert.Len(t, events[0].Data.Values, 4)
+
+	events = cache.Matching("/cluster/node/worker-0/ens2f0/master")
+	require.Len(t, events, 1)
+	assert.Equal(t, testPTPLockState, events[0].Source)
+	require.Len(t, events[0].Data.Values, 2)
+	assert.Equal(t, ipc.StateFreerun, events[0].Data.Values[0].Value)
+
+	assert.Empty(t, cache.Matching("/cluster/node/worker-0/ens3f0/master"))
+	e, _ := cache.GetBySource(testPTPLockState)
+	assert.Len(t, e.Data.Values, 4, "cached event must not be modified")
+}
@@ -0,0 +1,129 @@
+package cep
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const streamPath = "stream"
+
+// streamKeepAlive is the interval of the comments keeping idle streams open through proxies
+const streamKeepAlive = 30 * time.Second
+
+// errStreamClosed is returned for events delivered to a stream whose client went away
+var errStreamClosed = errors.New("stream closed")
+
+// sseWriter writes the CloudEvents delivered to a stream subscription as server-sent events
+type sseWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	closed  bool
+	// failed is closed on the first failed write, the client is gone and the stream must end
+	failed chan struct{}
+}
+
+func newSSEWriter(w http.ResponseWriter, flusher http.Flusher) *sseWriter {
+	return &sseWriter{w: w, flusher: flusher, failed: make(chan struct{})}
+}
+
+// Write sends one newline terminated CloudEvent as an SSE message
+func (s *sseWriter) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	if err := s.write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *sseWriter) keepAlive() error {
+	return s.write([]byte(": keep-alive\n\n"))
+}
+
+// write sends p to the client, marking the stream failed on error
+func (s *sseWriter) write(p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errStreamClosed
+	}
+	if _, err := s.w.Write(p); err != nil {
+		s.closed = true
+		close(s.failed)
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// close stops writes to the response, which must not be used once the handler returned
+func (s *sseWriter) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+}
+
+// streamHandler streams the CloudEvents matching the resource query parameter as server-sent events,
+// starting with the cached events unless snapshot=false is given.
+func (l *CloudEventProxy) streamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	resource := r.URL.Query().Get("resource")
+	if resource == "" {
+		http.Error(w, "resource is required", http.StatusBadRequest)
+		return
+	}
+	if err := l.cache.ValidateResource(resource); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	sse := newSSEWriter(w, flusher)
+	defer sse.close()
+	id := l.subscribe(resource, r.URL.Query().Get("snapshot") != "false", func() string {
+		return l.pubSub.SubscribeStream(resource, sse)
+	})
+	defer l.pubSub.Unsubscribe(id)
+	glog.Infof("streaming %s to %s", resource, r.RemoteAddr)
+
+	ticker := time.NewTicker(streamKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			glog.Infof("stream of %s to %s closed", resource, r.RemoteAddr)
+			return
+		case <-sse.failed:
+			glog.Infof("stream of %s to %s failed, client gone", resource, r.RemoteAddr)
+			return
+		case <-ticker.C:
+			if err := sse.keepAlive(); err != nil {
+				glog.Infof("stream of %s to %s failed, client gone: %v", resource, r.RemoteAddr, err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package cep
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ce "github.com/cloudevents/sdk-go/v2/event"
+	"github.com/redhat-cne/sdk-go/pkg/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
+)
+
+// readSSE returns the CloudEvent of the next message of the stream
+func readSSE(t *testing.T, r *bufio.Reader) ce.Event {
+	t.Helper()
+	var data strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" && data.Len() > 0 {
+			break
+		}
+		if d, ok := strings.CutPrefix(line, "data: "); ok {
+			data.WriteString(d)
+		}
+	}
+	var e ce.Event
+	require.NoError(t, json.Unmarshal([]byte(data.String()), &e))
+	return e
+}
+
+// deadClient is a response writer whose writes fail once the response started, like those of a
+// client that went away without closing the connection
+type deadClient struct {
+	header http.Header
+}
+
+func (d *deadClient) Header() http.Header { return d.header }
+func (d *deadClient) WriteHeader(int)     {}
+func (d *deadClient) Flush()              {}
+func (d *deadClient) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+func TestStream(t *testing.T) {
+	cache := NewEventCache("worker-0")
+	cache.Update(ptpMsg("ens1f0", ipc.StateLocked))
+	cache.Update(ptpMsg("ens2f0", ipc.StateLocked))
+	ps := NewPubSub(filepath.Join(t.TempDir(), "subscriptions.json"), nil)
+	proxy := NewCloudEventProxy(cache, ps)
+	apiServer := httptest.NewServer(proxy.Handler())
+	defer apiServer.Close()
+	streamURL := apiServer.URL + apiBase + streamPath
+
+	t.Run("invalid resource", func(t *testing.T) {
+		for _, query := range []string{"", "?resource=/resource/a"} {
+			resp, err := http.Get(streamURL + query)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		}
+	})
+
+	t.Run("snapshot then live events", func(t *testing.T) {
+		resp, err := http.Get(streamURL + "?resource=/cluster/node/worker-0/ens1f0/master")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		r := bufio.NewReader(resp.Body)
+
+		var data event.Data
+		snapshot := readSSE(t, r)
+		assert.Equal(t, testPTPLockState, snapshot.Source())
+		require.NoError(t, snapshot.DataAs(&data))
+		assert.Equal(t, ipc.StateLocked, data.Values[0].Value)
+
+		// streams are not subscriptions of the REST API
+		assert.Empty(t, ps.List())
+		ps.UnsubscribeAll()
+
+		var buf strings.Builder
+		require.NoError(t, ipc.Encode(&buf, []ipc.Message{ptpMsg("ens1f0", ipc.StateFreerun)}))
+		proxy.Listen(strings.NewReader(buf.String()))
+
+		live := readSSE(t, r)
+		require.NoError(t, live.DataAs(&data))
+		require.Len(t, data.Values, 2, "only the data values of the streamed interface")
+		assert.Equal(t, "/cluster/node/worker-0/ens1f0/master", data.Values[0].Resource)
+		assert.Equal(t, ipc.StateFreerun, data.Values[0].Value)
+
+		resp.Body.Close()
+		require.Eventually(t, func() bool {
+			ps.mu.RLock()
+			defer ps.mu.RUnlock()
+			return len(ps.queues) == 0
+		}, 5*time.Second, 10*time.Millisecond, "stream subscription must end with the stream")
+	})
+
+	t.Run("without snapshot", func(t *testing.T) {
+		resp, err := http.Get(streamURL + "?snapshot=false&resource=/cluster/node/*/sync/ptp-status/lock-state")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		r := bufio.NewReader(resp.Body)
+		require.Eventually(t, func() bool {
+			ps.mu.RLock()
+			defer ps.mu.RUnlock()
+			return len(ps.queues) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+
+		var buf strings.Builder
+		require.NoError(t, ipc.Encode(&buf, []ipc.Message{ptpMsg("ens1f0", ipc.StateHoldover)}))
+		proxy.Listen(strings.NewReader(buf.String()))
+
+		var data event.Data
+		require.NoError(t, readSSE(t, r).DataAs(&data))
+		assert.Equal(t, ipc.StateHoldover, data.Values[0].Value)
+	})
+
+	t.Run("dead client", func(t *testing.T) {
+		sse := newSSEWriter(&deadClient{header: http.Header{}}, &deadClient{})
+		assert.Error(t, sse.keepAlive())
+		assert.ErrorIs(t, sse.keepAlive(), errStreamClosed)
+		select {
+		case <-sse.failed:
+		default:
+			t.Fatal("failed keep-alive must end the stream")
+		}
+
+		// a failed event write ends the stream, whose request context stays open
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		req := httptest.NewRequest(http.MethodGet, streamURL+"?resource=/cluster/node/worker-0/ens1f0/master", nil).WithContext(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			proxy.streamHandler(&deadClient{header: http.Header{}}, req)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("stream to a dead client did not end")
+		}
+		require.Eventually(t, func() bool {
+			ps.mu.RLock()
+			defer ps.mu.RUnlock()
+			return len(ps.queues) == 0
+		}, 5*time.Second, 10*time.Millisecond, "stream subscription must end with the stream")
+	})
+}
@@ -0,0 +1,192 @@
+package cep
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+)
+
+// TLSFiles names the PEM files of a TLS endpoint. The files are reloaded when they change, so that
+// certificates rotated by e.g. cert-manager take effect without restarting cloud-event-proxy.
+type TLSFiles struct {
+	// CertFile and KeyFile hold the certificate and private key presented to the peer
+	CertFile string
+	KeyFile  string
+	// CAFile holds the CA certificates the peer certificate is verified against
+	CAFile string
+}
+
+// tlsReloader holds the certificate and CA pool loaded from TLSFiles
+type tlsReloader struct {
+	files TLSFiles
+	mu    sync.RWMutex
+	cert  *tls.Certificate
+	pool  *x509.CertPool
+}
+
+func newTLSReloader(files TLSFiles) (*tlsReloader, error) {
+	if (files.CertFile == "") != (files.KeyFile == "") {
+		return nil, fmt.Errorf("certificate and key file must be set together")
+	}
+	r := &tlsReloader{files: files}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload reads the files, keeping the previously loaded certificates on error
+func (r *tlsReloader) reload() error {
+	var cert *tls.Certificate
+	if r.files.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate %s: %w", r.files.CertFile, err)
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if r.files.CAFile != "" {
+		pem, err := os.ReadFile(r.files.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no CA certificates found in %s", r.files.CAFile)
+		}
+	}
+	r.mu.Lock()
+	r.cert, r.pool = cert, pool
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *tlsReloader) certificate() (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.cert == nil {
+		return nil, fmt.Errorf("no certificate configured")
+	}
+	return r.cert, nil
+}
+
+func (r *tlsReloader) caPool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+// serverConfig returns a TLS configuration serving the current certificate, and requiring client
+// certificates signed by the current CA when a CA file is set
+func (r *tlsReloader) serverConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, err := r.certificate()
+			if err != nil {
+				return nil, err
+			}
+			cfg := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{*cert}}
+			if pool := r.caPool(); pool != nil {
+				cfg.ClientCAs = pool
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// clientConfig returns a TLS configuration verifying servers against the current CA when a CA file is
+// set, else against the system roots, and presenting the current client certificate when one is set
+func (r *tlsReloader) clientConfig() *tls.Config {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if r.files.CAFile != "" {
+		// the default verification is replaced by one against the pool loaded at handshake time,
+		// as RootCAs would keep the pool loaded when the configuration was created
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = r.verifyServer
+	}
+	if r.files.CertFile != "" {
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.certificate()
+		}
+	}
+	return cfg
+}
+
+// verifyServer verifies the server certificate chain and name against the current CA pool
+func (r *tlsReloader) verifyServer(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("server presented no certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         r.caPool(),
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// watch reloads the files when they change until stopCh is closed. The directories of the files are
+// watched, as Secret volumes replace files through a symlink swap.
+func (r *tlsReloader) watch(stopCh <-chan struct{}) error {
+	return watchFiles(stopCh, func() {
+		if err := r.reload(); err != nil {
+			glog.Errorf("failed to reload TLS files, keeping previous certificates: %v", err)
+			return
+		}
+		glog.Info("reloaded TLS files")
+	}, r.files.CertFile, r.files.KeyFile, r.files.CAFile)
+}
+
+// watchFiles calls onChange whenever the directory of one of the files changes, until stopCh is closed
+func watchFiles(stopCh <-chan struct{}, onChange func(), files ...string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	dirs := map[string]bool{}
+	for _, f := range files {
+		if f == "" || dirs[filepath.Dir(f)] {
+			continue
+		}
+		dirs[filepath.Dir(f)] = true
+		if err = watcher.Add(filepath.Dir(f)); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
+					onChange()
+				}
+			case watchErr, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				glog.Errorf("file watcher error: %v", watchErr)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,192 @@
+package cep
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert returns a certificate for 127.0.0.1 signed by parent, or a self-signed CA if parent is nil
+func newTestCert(t *testing.T, cn string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{cert: cert, key: key}
+}
+
+// write stores the certificate and key as PEM files in dir, returning their paths
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0o600))
+	der, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600))
+	return certFile, keyFile
+}
+
+func (c *testCert) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.cert)
+	return pool
+}
+
+func TestServerTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, "server", ca).write(t, dir, "server")
+	client := newTestCert(t, "client", ca)
+
+	proxy := NewCloudEventProxy(NewEventCache("worker-0"), nil)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	require.NoError(t, proxy.SetServerConfig(ServerConfig{TLS: TLSFiles{CertFile: certFile, KeyFile: keyFile, CAFile: caFile}}, stopCh))
+	server := httptest.NewUnstartedServer(proxy.Handler())
+	server.TLS = proxy.tls.serverConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(clientCert *testCert) (*http.Response, error) {
+		cfg := &tls.Config{RootCAs: ca.pool()}
+		if clientCert != nil {
+			cfg.Certificates = []tls.Certificate{{Certificate: [][]byte{clientCert.cert.Raw}, PrivateKey: clientCert.key}}
+		}
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		return c.Get(server.URL + apiBase + strings.TrimPrefix(testPTPLockState, "/") + "/CurrentState")
+	}
+
+	resp, err := get(client)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "server", resp.TLS.PeerCertificates[0].Subject.CommonName)
+
+	_, err = get(nil)
+	assert.Error(t, err, "client certificate is required")
+	_, err = get(newTestCert(t, "rogue", newTestCert(t, "rogue-ca", nil)))
+	assert.Error(t, err, "client certificate must be signed by the CA")
+
+	// a rotated certificate is served once its files change
+	newTestCert(t, "rotated", ca).write(t, dir, "server")
+	require.Eventually(t, func() bool {
+		resp, err = get(client)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].Subject.CommonName == "rotated"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// invalid files keep the previous certificate
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0o600))
+	assert.Error(t, proxy.tls.reload())
+	resp, err = get(client)
+	require.NoError(t, err)
+	resp.Body.Close()
+}
+
+func TestServerConfigValidation(t *testing.T) {
+	proxy := NewCloudEventProxy(NewEventCache("worker-0"), nil)
+	assert.NoError(t, proxy.SetServerConfig(ServerConfig{}, nil))
+	assert.Nil(t, proxy.tls)
+	assert.Error(t, proxy.SetServerConfig(ServerConfig{TLS: TLSFiles{CAFile: "/ca.crt"}}, nil))
+	assert.Error(t, proxy.SetServerConfig(ServerConfig{TLS: TLSFiles{CertFile: "/tls.crt"}}, nil))
+	assert.Error(t, proxy.SetServerConfig(ServerConfig{TLS: TLSFiles{CertFile: "/nonexistent.crt", KeyFile: "/nonexistent.key"}}, nil))
+}
+
+func TestTLSHTTPWriter(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, "cloud-event-proxy", ca).write(t, dir, "client")
+	server := newTestCert(t, "consumer", ca)
+
+	var mu sync.Mutex
+	var peer string
+	newConsumer := func(server *testCert) *httptest.Server {
+		consumer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			peer = r.TLS.PeerCertificates[0].Subject.CommonName
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		consumer.TLS = &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{server.cert.Raw}, PrivateKey: server.key}},
+			ClientCAs:    ca.pool(),
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		}
+		consumer.StartTLS()
+		t.Cleanup(consumer.Close)
+		return consumer
+	}
+	consumer := newConsumer(server)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	writerFunc, err := NewTLSHTTPWriterFunc(time.Second, TLSFiles{CertFile: certFile, KeyFile: keyFile, CAFile: caFile}, stopCh)
+	require.NoError(t, err)
+	_, err = writerFunc(consumer.URL + "/event").Write([]byte("{}"))
+	require.NoError(t, err)
+	mu.Lock()
+	assert.Equal(t, "cloud-event-proxy", peer)
+	mu.Unlock()
+
+	// without the CA the consumer certificate is not trusted
+	_, err = NewHTTPWriterFunc(time.Second)(consumer.URL + "/event").Write([]byte("{}"))
+	assert.Error(t, err)
+
+	// the writers of existing subscriptions verify consumers against the rotated CA
+	rotatedCA := newTestCert(t, "rotated-ca", nil)
+	rotatedConsumer := newConsumer(newTestCert(t, "consumer", rotatedCA))
+	w := writerFunc(rotatedConsumer.URL + "/event")
+	_, err = w.Write([]byte("{}"))
+	assert.Error(t, err)
+	rotatedCA.write(t, dir, "ca")
+	require.Eventually(t, func() bool {
+		_, writeErr := w.Write([]byte("{}"))
+		return writeErr == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
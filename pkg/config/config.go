@@ -116,8 +116,18 @@ type ProcessConfig struct {
 	ConfigName      string
 	EventChannel    chan<- event.Event
 	GMThreshold     Threshold
+	Thresholds      *event.OffsetThresholds // per source and interface thresholds, GMThreshold applies when nil
 	InitialPTPState event.PTPState
 }
+
+// OffsetThreshold returns the offset threshold of the source interface
+func (pc ProcessConfig) OffsetThreshold(source event.EventSource, iface string) event.OffsetThreshold {
+	if pc.Thresholds != nil {
+		return pc.Thresholds.Get(source, iface)
+	}
+	return event.NewOffsetThreshold(pc.GMThreshold.Min, pc.GMThreshold.Max)
+}
+
 type Threshold struct {
 	Max             int64
 	Min             int64
@@ -23,9 +23,11 @@ import (
 	"github.com/fsnotify/fsnotify"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/alias"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/hardwareconfig"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
 	ptpnetwork "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/network"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/parser"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/synce"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/timeerror"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ublox"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/utils"
 	ptpv2alpha1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v2alpha1"
@@ -44,6 +46,7 @@ import (
 
 	"github.com/golang/glog"
 	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -124,12 +127,7 @@ var ptpTmpFiles = []string{
 
 var vTbcHasHardwareConfig = false
 
-const (
-	socketDialTimeout = 5 * time.Second
-	// liveStartCommand is sent on each ptp4l process connection after the live
-	// gate opens. It tells CEP that all subsequent data is live (post-replay).
-	liveStartCommand = "CMD LIVE_START"
-)
+const socketDialTimeout = 5 * time.Second
 
 func dialSocket() (net.Conn, error) {
 	c, err := net.DialTimeout("unix", eventSocket, socketDialTimeout)
@@ -140,7 +138,15 @@ func dialSocket() (net.Conn, error) {
 	return c, err
 }
 
-// sendSidecarRestart sends the CMD RESTART control command to the cloud-event-proxy sidecar
+// controlLine returns the control command as understood by the cloud-event-proxy sidecar
+func (dn *Daemon) controlLine(command string) string {
+	if dn.processManager == nil || dn.processManager.ptpEventHandler == nil {
+		return ipc.ControlValue{Command: command}.Legacy()
+	}
+	return dn.processManager.ptpEventHandler.ControlLine(command)
+}
+
+// sendSidecarRestart sends the restart control command to the cloud-event-proxy sidecar
 // over a short-lived dedicated connection to the event socket. The sidecar will exec itself
 // for a clean restart, then re-read all configuration from disk (ConfigMap + ptp4l config files).
 //
@@ -153,10 +159,10 @@ func (dn *Daemon) sendSidecarRestart() error {
 	}
 	defer c.Close()
 
-	if _, err = fmt.Fprintf(c, "CMD RESTART\n"); err != nil {
+	if _, err = fmt.Fprintf(c, "%s\n", dn.controlLine(ipc.CommandRestart)); err != nil {
 		return err
 	}
-	glog.Infof("sendSidecarRestart: sent CMD RESTART to sidecar via %s", eventSocket)
+	glog.Infof("sendSidecarRestart: sent restart command to sidecar via %s", eventSocket)
 	return nil
 }
 
@@ -339,7 +345,9 @@ type ptpProcess struct {
 	logParser             parser.MetricsExtractor
 	clockType             event.ClockType
 	ptpClockThreshold     *ptpv1.PtpClockThreshold
-	haProfile             map[string][]string // stores list of interface name for each profile
+	offsetThresholds      *event.OffsetThresholds // per source thresholds, ptpClockThreshold applies when nil
+	offsetInRange         map[string]bool         // last offset check result per interface, for the threshold hysteresis
+	haProfile             map[string][]string     // stores list of interface name for each profile
 	syncERelations        *synce.Relations
 	c                     net.Conn
 	hasCollectedMetrics   bool
@@ -466,6 +474,9 @@ type Daemon struct {
 	// processes replay state before any live data arrives.
 	liveGate *liveGate
 
+	// timeErrorMonitor computes MTIE/TDEV of offset streams for profiles that enable it
+	timeErrorMonitor *timeerror.Monitor
+
 	delayedPhc2sys   atomic.Bool
 	delayedPhc2sysMu sync.Mutex // protects skipInitialStartup on phc2sys processes
 
@@ -650,6 +661,11 @@ func New(
 		liveGate:             &liveGate{},
 	}
 	dn.hardwareConfigManager = hardwareconfig.NewHardwareConfigManager(kubeClient, namespace, dn.interfaceResolver)
+	dn.hardwareConfigManager.SetPhaseCalibrationStore(hardwareconfig.NewPhaseCalibrationStore(kubeClient, namespace, nodeName))
+	dn.timeErrorMonitor = timeerror.NewMonitor(nodeName, MTIE, TDEV, TimeErrorMaskPass, TimeErrorMaskViolations, dn.handleTimeErrorMaskEvent)
+	pm.ptpEventHandler.SetOffsetObserver(dn.observeEventOffset)
+	pm.ptpEventHandler.SetSuppressedFlapsMetric(SuppressedStateFlaps)
+	pm.ptpEventHandler.SetIPCMismatchMetric(IPCMismatches)
 	pm.daemon = dn
 	return dn
 }
@@ -662,6 +678,8 @@ func New(
 func (dn *Daemon) Run() {
 	glog.Info("Daemon Run() started, waiting for configuration updates...")
 	go dn.processManager.ptpEventHandler.ProcessEvents()
+	go dn.timeErrorMonitor.Run(dn.stopCh, timeerror.DefaultEvaluationPeriod)
+	go dn.processManager.ptpEventHandler.RunOffsetSummary(dn.stopCh, event.DefaultOffsetSummaryInterval)
 
 	// Setup fsnotify channels (may be nil if watcher initialization failed)
 	var saFilesWatcherEventCh chan fsnotify.Event
@@ -792,6 +810,10 @@ func (dn *Daemon) applyNodePTPProfiles() error {
 	// collector (assuming there are no other
 	// references).
 	dn.processManager.process = nil
+	dn.timeErrorMonitor.Reset()
+	dn.processManager.ptpEventHandler.ClearOffsetThresholds()
+	dn.processManager.ptpEventHandler.ClearDebounceConfig()
+	dn.processManager.ptpEventHandler.ClearOffsetSummaries()
 
 	// Purge the alias store so stale interface→PHC mappings from a previous
 	// config application do not persist. All interfaces will be re-registered
@@ -909,6 +931,7 @@ func (dn *Daemon) applyNodePTPProfiles() error {
 							Min:             p.ptpClockThreshold.MinOffsetThreshold,
 							HoldOverTimeout: p.ptpClockThreshold.HoldOverTimeout,
 						},
+						Thresholds:      p.offsetThresholds,
 						InitialPTPState: event.PTP_FREERUN,
 					})
 					// Pre-populate event state immediately after the EventChannel
@@ -1029,7 +1052,23 @@ func (dn *Daemon) applyNodePtpProfile(runID int, nodeProfile *ptpv1.PtpProfile)
 	}
 
 	dn.reportPluginStatus(*nodeProfile.Name, pluginErrors)
-	var err error
+
+	timeErrorConfig, err := timeerror.ParseConfig(*nodeProfile.Name, nodeProfile.PtpSettings)
+	if err != nil {
+		glog.Errorf("time error statistics disabled for profile %s: %v", *nodeProfile.Name, err)
+	}
+	ptpClockThreshold := getPTPThreshold(nodeProfile)
+	offsetThresholds, err := event.ParseOffsetThresholds(
+		event.NewOffsetThreshold(ptpClockThreshold.MinOffsetThreshold, ptpClockThreshold.MaxOffsetThreshold),
+		nodeProfile.PtpSettings)
+	if err != nil {
+		return fmt.Errorf("failed to parse offset thresholds: %w", err)
+	}
+	debounceConfig, err := event.ParseDebounceConfig(nodeProfile.PtpSettings)
+	if err != nil {
+		return fmt.Errorf("failed to parse state debouncing: %w", err)
+	}
+
 	var cmdLine string
 	var configPath string
 	var socketPath string
@@ -1213,6 +1252,10 @@ func (dn *Daemon) applyNodePtpProfile(runID int, nodeProfile *ptpv1.PtpProfile)
 		args := strings.Split(cmdLine, " ")
 		cmd = exec.Command(args[0], args[1:]...)
 
+		dn.timeErrorMonitor.Configure(configFile, timeErrorConfig)
+		dn.processManager.ptpEventHandler.SetOffsetThresholds(configFile, offsetThresholds)
+		dn.processManager.ptpEventHandler.SetDebounceConfig(configFile, debounceConfig)
+
 		dprocess := ptpProcess{
 			name:              pProcess,
 			ifaces:            ifaces,
@@ -1227,7 +1270,8 @@ func (dn *Daemon) applyNodePtpProfile(runID int, nodeProfile *ptpv1.PtpProfile)
 			depProcess:        []process{},
 			nodeProfile:       *nodeProfile,
 			clockType:         clockType,
-			ptpClockThreshold: getPTPThreshold(nodeProfile),
+			ptpClockThreshold: ptpClockThreshold,
+			offsetThresholds:  offsetThresholds,
 			haProfile:         haProfile,
 			syncERelations:    relations,
 			logParser:         getParser(pProcess),
@@ -1380,6 +1424,7 @@ func (dn *Daemon) applyNodePtpProfile(runID int, nodeProfile *ptpv1.PtpProfile)
 			var localMaxHoldoverOffSet uint64 = dpll.LocalMaxHoldoverOffSet
 			var localHoldoverTimeout uint64 = dpll.LocalHoldoverTimeout
 			var maxInSpecOffset uint64 = dpll.MaxInSpecOffset
+			var maxFrequencyOffset uint64
 			var inSyncConditionTh uint64 = dpll.MaxInSpecOffset
 			var inSyncConditionTimes uint64 = 1
 			var flags dpll.Flag
@@ -1425,6 +1470,7 @@ func (dn *Daemon) applyNodePtpProfile(runID int, nodeProfile *ptpv1.PtpProfile)
 					}
 
 					flags = 0 // Default to 0 = no flags set
+					flagsConfigured := false
 
 					glog.Info("Init dpll: ptp settings ", (*nodeProfile).PtpSettings)
 					for k, v := range (*nodeProfile).PtpSettings {
@@ -1449,11 +1495,28 @@ func (dn *Daemon) applyNodePtpProfile(runID int, nodeProfile *ptpv1.PtpProfile)
 						if k == dpll.MaxInSpecOffsetStr {
 							maxInSpecOffset = i
 						}
+						if k == dpll.MaxFrequencyOffsetStr {
+							maxFrequencyOffset = i
+						}
 						if k == fmt.Sprintf("%s[%s]", dpll.ClockIdStr, iface.Name) {
 							clockId = i
 						}
 						if k == dpll.PtpSettingsDpllFlagsKey(iface.Name) {
 							flags = dpll.Flag(i)
+							flagsConfigured = true
+						}
+					}
+
+					// Without a vendor plugin providing the clock ID, follow the netdev DPLL pin link,
+					// which works for any driver exposing the DPLL netlink API
+					genericClockID := false
+					if _, found := nodeProfile.PtpSettings[fmt.Sprintf("%s[%s]", dpll.ClockIdStr, iface.Name)]; !found {
+						if id, idErr := hardwareconfig.GetClockIDFromNetdevPin(iface.Name); idErr != nil {
+							glog.Warningf("Init dpll: no clock ID provided for %s and netdev DPLL pin resolution failed: %v", iface.Name, idErr)
+						} else {
+							clockId = id
+							genericClockID = true
+							glog.Infof("Init dpll: resolved clock ID %#x for %s from its netdev DPLL pin", clockId, iface.Name)
 						}
 					}
 
@@ -1483,6 +1546,13 @@ func (dn *Daemon) applyNodePtpProfile(runID int, nodeProfile *ptpv1.PtpProfile)
 					if hwFlags != nil {
 						flags = *hwFlags
 						glog.Infof("Using DPLL flags from HardwareConfig for clock %#x: %d", clockId, flags)
+					} else if genericClockID && !flagsConfigured {
+						if detected, detectErr := hardwareconfig.DetectDPLLFlags(clockId); detectErr != nil {
+							glog.Warningf("Failed to detect DPLL flags for clock %#x: %v", clockId, detectErr)
+						} else {
+							flags = detected
+							glog.Infof("Using DPLL flags detected from the DPLL devices of clock %#x: %d", clockId, flags)
+						}
 					}
 
 					// Hardware-slaved DPLLs (e.g. E830 CF cards, identified by
@@ -1508,6 +1578,24 @@ func (dn *Daemon) applyNodePtpProfile(runID int, nodeProfile *ptpv1.PtpProfile)
 					dpllDaemon.SetHardwareConfigHandler(func(devices []*dpllnl.DoDeviceGetReply) error {
 						return dn.hardwareConfigManager.ProcessDPLLDeviceNotifications(devices)
 					})
+					dpllDaemon.SetMaxFrequencyOffset(maxFrequencyOffset)
+					dpllDaemon.SetDeviceMetrics(dpllDeviceMetrics())
+					dpllDaemon.SetResyncMetric(DpllNetlinkResyncs.MustCurryWith(prometheus.Labels{"node": NodeName}))
+					qualifier, drivePriorities, qualErr := dpll.NewPinQualifierFromSettings(clockId, iface.Name, nodeProfile.PtpSettings)
+					if qualErr != nil {
+						return fmt.Errorf("failed to configure the dpll reference qualification of %s: %w", iface.Name, qualErr)
+					}
+					if qualifier != nil {
+						qualifier.SetMetrics(dpllPinQualificationMetrics())
+						if drivePriorities {
+							source := hardwareconfig.PinCommandSource{Component: hardwareconfig.PinSourceDpllQualifier, Profile: profileName, Condition: iface.Name}
+							qualifier.SetPriorityApplier(func(commands []dpllnl.PinParentDeviceCtl) error {
+								return hardwareconfig.BatchPinSet(source, commands)
+							})
+						}
+						dpllDaemon.SetPinQualifier(qualifier)
+					}
+					dpllDaemon.SetPinFrequencyOffsetMetric(DpllPinFrequencyOffset.MustCurryWith(prometheus.Labels{"node": NodeName}))
 					dpllDaemon.CmdInit()
 					dprocess.depProcess = append(dprocess.depProcess, dpllDaemon)
 				}
@@ -1830,7 +1918,7 @@ connect:
 	glog.V(14).Infof("socket-writer[%s]: dial succeeded, waiting for liveGate", p.name)
 	p.dn.liveGate.Wait(liveGateTimeout)
 	glog.V(14).Infof("socket-writer[%s]: liveGate passed, sending LIVE_START", p.name)
-	if _, err2 := fmt.Fprintf(p.c, "%s\n", liveStartCommand); err2 != nil {
+	if _, err2 := fmt.Fprintf(p.c, "%s\n", p.dn.controlLine(ipc.CommandLiveStart)); err2 != nil {
 		glog.Errorf("failed to write LIVE_START marker: %v", err2)
 		goto connect
 	}
@@ -2158,7 +2246,7 @@ func (p *ptpProcess) ProcessTs2PhcEvents(ptpOffset float64, source string, iface
 	ptpOffsetInt64 := int64(ptpOffset)
 	// if state is HOLDOVER do not update the state
 	// transition to FREERUN if offset is outside configured thresholds
-	if shouldFreeRun(state, ptpOffset, p.ptpClockThreshold) {
+	if shouldFreeRun(state, p.isOffsetInRange(event.EventSource(source), iface, ptpOffset)) {
 		ptpState = event.PTP_FREERUN
 	}
 
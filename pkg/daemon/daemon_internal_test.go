@@ -22,6 +22,7 @@ import (
 	dpll "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/hardwareconfig"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/leap"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/network"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/utils"
@@ -39,6 +40,10 @@ import (
 const testPtp4lOffsetLine = "ptp4l[1.000]: [ptp4l.0.config] master offset 5 s2 freq -1000 path delay 100"
 const testSkipStartupReason = "delayed"
 
+// liveStartCommand is the live start control command sent on each ptp4l process connection after the
+// live gate opens, as understood by sidecars without a hello
+var liveStartCommand = (*ipc.Session)(nil).EncodeControl(ipc.CommandLiveStart)
+
 const (
 	testDUTLeadingIface = "ens2f0"
 	testDUTUpstream1    = "ens2f1"
@@ -3842,3 +3847,39 @@ func TestGetPTPThreshold(t *testing.T) {
 		})
 	}
 }
+
+func TestPtpProcessOffsetThresholds(t *testing.T) {
+	thresholds, err := event.ParseOffsetThresholds(event.NewOffsetThreshold(-100, 100), map[string]string{
+		"offsetThreshold.phc2sys": "enterMax=20,enterMin=-20",
+	})
+	assert.NoError(t, err)
+	p := &ptpProcess{name: phc2sysProcessName, offsetThresholds: thresholds}
+
+	assert.False(t, p.isOffsetInRange(event.PHC2SYS, clockRealTime, 50))
+	assert.True(t, p.isOffsetInRange(event.PHC2SYS, clockRealTime, 10))
+	assert.True(t, p.isOffsetInRange(event.PHC2SYS, clockRealTime, 90))
+	assert.False(t, shouldFreeRun(event.PTP_LOCKED, p.isOffsetInRange(event.PHC2SYS, clockRealTime, 90)))
+	assert.True(t, shouldFreeRun(event.PTP_LOCKED, p.isOffsetInRange(event.PHC2SYS, clockRealTime, 150)))
+	assert.False(t, shouldFreeRun(event.PTP_HOLDOVER, p.isOffsetInRange(event.PHC2SYS, clockRealTime, 150)))
+
+	// without per source thresholds the profile PtpClockThreshold applies
+	p = &ptpProcess{name: ts2phcProcessName, ptpClockThreshold: &ptpv1.PtpClockThreshold{MaxOffsetThreshold: 100, MinOffsetThreshold: -100}}
+	assert.True(t, p.isOffsetInRange(event.TS2PHC, "ens1f0", 50))
+	assert.False(t, p.isOffsetInRange(event.TS2PHC, "ens1f0", -150))
+	// offsets at the thresholds are out of range, as before per source thresholds
+	assert.False(t, p.isOffsetInRange(event.TS2PHC, "ens1f0", 100))
+	assert.True(t, p.isOffsetInRange(event.TS2PHC, "ens1f0", 99))
+	assert.False(t, p.isOffsetInRange(event.TS2PHC, "ens1f0", -100))
+	assert.True(t, shouldFreeRun(event.PTP_LOCKED, p.isOffsetInRange(event.TS2PHC, "ens1f0", 100)))
+	assert.True(t, shouldFreeRun(event.PTP_LOCKED, p.isOffsetInRange(event.TS2PHC, "ens1f0", -100)))
+
+	// a source threshold without band keeps the bounds out of range
+	thresholds, err = event.ParseOffsetThresholds(event.NewOffsetThreshold(-100, 100), map[string]string{
+		"offsetThreshold.ptp4l": "max=50,min=-50",
+	})
+	assert.NoError(t, err)
+	p = &ptpProcess{name: ptp4lProcessName, offsetThresholds: thresholds}
+	assert.True(t, p.isOffsetInRange(event.PTP4l, "ens2f0", 49))
+	assert.False(t, p.isOffsetInRange(event.PTP4l, "ens2f0", 50))
+	assert.False(t, p.isOffsetInRange(event.PTP4l, "ens2f0", -50))
+}
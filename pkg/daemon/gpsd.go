@@ -55,6 +55,7 @@ type GPSD struct {
 	gpsdSession          *gpsdlib.Session
 	gpsdDoneCh           chan bool
 	sourceLost           bool
+	offsetInRange        bool // result of the last offset check, for the threshold hysteresis
 	monitorCtx           context.Context
 	monitorCancel        context.CancelFunc
 	c                    net.Conn
@@ -306,6 +307,7 @@ func (g *GPSD) processGNSSLines(lines []string) {
 		}
 	default:
 		g.sourceLost = true
+		g.offsetInRange = false
 	}
 	if g.processConfig.EventChannel != nil {
 		select {
@@ -332,10 +334,8 @@ func (g *GPSD) processGNSSLines(lines []string) {
 	}
 }
 
-// isOffsetInRange ... check if offset is in range
+// isOffsetInRange ... check if offset is in range of the GNSS threshold
 func (g *GPSD) isOffsetInRange() bool {
-	if g.offset <= g.processConfig.GMThreshold.Max && g.offset >= g.processConfig.GMThreshold.Min {
-		return true
-	}
-	return false
+	g.offsetInRange = g.processConfig.OffsetThreshold(event.GNSS, g.gmInterface).InRange(g.offset, g.offsetInRange)
+	return g.offsetInRange
 }
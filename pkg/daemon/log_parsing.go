@@ -4,8 +4,6 @@ import (
 	"strings"
 	"time"
 
-	v1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
-
 	"github.com/golang/glog"
 
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/alias"
@@ -81,7 +79,8 @@ func processWithParser(process *ptpProcess, output string) {
 
 func processParsedMetrics(process *ptpProcess, ptpMetrics *parser.Metrics) {
 	// Convert interface from possible clock id
-	iface := process.ifaces.GetPhcID2IFace(ptpMetrics.Iface)
+	ifaceName := process.ifaces.GetPhcID2IFace(ptpMetrics.Iface)
+	iface := ifaceName
 	if iface != clockRealTime {
 		iface = alias.GetAlias(iface)
 	}
@@ -99,6 +98,13 @@ func processParsedMetrics(process *ptpProcess, ptpMetrics *parser.Metrics) {
 		configName = strings.Split(configName, MessageTagSuffixSeperator)[0]
 	}
 
+	if process.dn != nil && configName != "" {
+		process.dn.timeErrorMonitor.Observe(process.name, configName, iface, ptpMetrics.Offset, time.Now())
+	}
+	if process.handler != nil && configName != "" {
+		process.handler.ObserveOffset(process.name, configName, iface, ptpMetrics.Offset)
+	}
+
 	// Handle master offset source tracking
 	if ptpMetrics.Source == "master" && configName != "" {
 		masterOffsetSource.set(configName, process.name)
@@ -108,7 +114,7 @@ func processParsedMetrics(process *ptpProcess, ptpMetrics *parser.Metrics) {
 	state := convertParserClockStateEventPTPState(ptpMetrics.ClockState)
 
 	// transition to FREERUN if offset is outside configured thresholds
-	if shouldFreeRun(state, ptpMetrics.Offset, process.ptpClockThreshold) {
+	if shouldFreeRun(state, process.isOffsetInRange(event.EventSource(process.name), ifaceName, ptpMetrics.Offset)) {
 		state = event.PTP_FREERUN
 	}
 
@@ -167,6 +173,7 @@ func processParsedEvent(process *ptpProcess, ptpEvent *parser.PTPEvent) {
 		role := convertParserRoleToMetricsRole(ptpEvent.Role)
 		UpdateInterfaceRoleMetrics(process.name, interfaceName, role)
 		process.handler.SetPortRole(configName, interfaceName, ptpEvent)
+		process.handler.EmitPortState(configName, interfaceName, ptpEvent.Role)
 
 		if configName == "" {
 			return
@@ -193,16 +200,27 @@ func processParsedEvent(process *ptpProcess, ptpEvent *parser.PTPEvent) {
 }
 
 // shouldFreeRun returns true if we’re not already in HOLDOVER or FREERUN
-// and the current offset breaches either threshold.
-func shouldFreeRun(
-	currentState event.PTPState,
-	rawOffset float64,
-	th *v1.PtpClockThreshold,
-) bool {
+// and the current offset is out of range.
+func shouldFreeRun(currentState event.PTPState, inRange bool) bool {
 	if currentState == event.PTP_HOLDOVER || currentState == event.PTP_FREERUN {
 		return false
 	}
+	return !inRange
+}
 
-	ofs := int64(rawOffset)
-	return ofs >= th.MaxOffsetThreshold || ofs <= th.MinOffsetThreshold
+// isOffsetInRange checks the offset against the threshold of the source interface
+// and keeps the result for the hysteresis of the next check
+func (p *ptpProcess) isOffsetInRange(source event.EventSource, iface string, rawOffset float64) bool {
+	var threshold event.OffsetThreshold
+	if p.offsetThresholds != nil {
+		threshold = p.offsetThresholds.Get(source, iface)
+	} else {
+		threshold = event.NewOffsetThreshold(p.ptpClockThreshold.MinOffsetThreshold, p.ptpClockThreshold.MaxOffsetThreshold)
+	}
+	if p.offsetInRange == nil {
+		p.offsetInRange = map[string]bool{}
+	}
+	inRange := threshold.InOpenRange(int64(rawOffset), p.offsetInRange[iface])
+	p.offsetInRange[iface] = inRange
+	return inRange
 }
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/alias"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/synce"
 
@@ -198,10 +199,156 @@ var (
 			Help: "network_option1: ePRTC: {0, 0x2, 0x21}, PRTC:  {1, 0x2, 0x20}, PRC:   {2, 0x2, 0xFF}, SSUA:  {3, 0x4, 0xFF}, SSUB:  {4, 0x8, 0xFF}, EEC1:  {5, 0xB, 0xFF},QL-DNU: {6,0xF,0xFF}\n " +
 				"   network_option2 ePRTC: {0, 0x1, 0x21}, PRTC:  {1, 0x1, 0x20}, PRS:   {2, 0x1, 0xFF}, STU:   {3, 0x0, 0xFF}, ST2:   {4, 0x7, 0xFF}, TNC:   {5, 0x4, 0xFF}, ST3E:  {6, 0xD, 0xFF}, EEC2:  {7, 0xA, 0xFF}, PROV:  {8, 0xE, 0xFF}, QL-DUS: {9,0xF,0xFF}",
 		}, []string{"process", "node", "profile", "network_option", "iface", "device", "ql_type"})
+
+	// MTIE ... maximum time interval error per observation interval (tau in seconds)
+	MTIE = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "mtie_ns",
+			Help:      "maximum time interval error of the offset over the observation interval tau (seconds)",
+		}, []string{"process", "node", "profile", "iface", "tau"})
+
+	// TDEV ... time deviation per observation interval (tau in seconds)
+	TDEV = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "tdev_ns",
+			Help:      "time deviation of the offset for the observation interval tau (seconds)",
+		}, []string{"process", "node", "profile", "iface", "tau"})
+
+	// TimeErrorMaskPass ... 1 if the statistic meets the selected ITU-T mask, 0 otherwise
+	TimeErrorMaskPass = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "time_error_mask_pass",
+			Help:      "1 = statistic within the ITU-T mask for all configured intervals, 0 = mask exceeded",
+		}, []string{"process", "node", "profile", "iface", "mask", "statistic"})
+
+	// TimeErrorMaskViolations ... number of transitions from passing to failing the ITU-T mask
+	TimeErrorMaskViolations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "time_error_mask_violations_total",
+			Help:      "number of times the statistic started exceeding the ITU-T mask",
+		}, []string{"process", "node", "profile", "iface", "mask", "statistic"})
+
+	// SuppressedStateFlaps ... number of source state changes held back by debouncing
+	SuppressedStateFlaps = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "suppressed_state_flaps_total",
+			Help:      "number of source state changes that reverted before passing the profile debouncing",
+		}, []string{"process", "node", "iface", "profile"})
+
+	// DpllPinFrequencyOffset ... fractional frequency offset of the DPLL input pins
+	DpllPinFrequencyOffset = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "dpll_pin_frequency_offset_ppt",
+			Help:      "fractional frequency offset of the DPLL input pin against the DPLL, in parts per trillion",
+		}, []string{"node", "iface", "pin"})
+
+	// DpllPinQualified ... qualification of the DPLL input pins as reference candidates
+	DpllPinQualified = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "dpll_pin_qualified",
+			Help:      "1 = the DPLL input pin meets the reference qualification thresholds, 0 = not qualified",
+		}, []string{"node", "iface", "pin"})
+
+	// DpllPinRank ... rank of the DPLL input pins in the reference candidate list
+	DpllPinRank = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "dpll_pin_rank",
+			Help:      "rank of the DPLL input pin in the reference candidate list, 1 = best candidate",
+		}, []string{"node", "iface", "pin"})
+
+	// DpllPinPhaseOffset ... phase offset of the DPLL input pins
+	DpllPinPhaseOffset = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "dpll_pin_phase_offset_ns",
+			Help:      "phase offset of the DPLL input pin against the PPS DPLL, in nanoseconds",
+		}, []string{"node", "iface", "pin"})
+
+	// DpllDeviceLockStatus ... lock status of each DPLL device
+	DpllDeviceLockStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "dpll_device_lock_status",
+			Help:      "lock status of the DPLL device: 1 = unlocked, 2 = locked, 3 = locked with holdover acquired, 4 = holdover",
+		}, []string{"node", "iface", "type"})
+
+	// DpllDeviceHoldoverAcquired ... holdover readiness of each DPLL device
+	DpllDeviceHoldoverAcquired = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "dpll_device_holdover_acquired",
+			Help:      "1 = the DPLL device acquired holdover or is in holdover, 0 = it can't enter holdover",
+		}, []string{"node", "iface", "type"})
+
+	// DpllDeviceMode ... mode of each DPLL device
+	DpllDeviceMode = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "dpll_device_mode",
+			Help:      "mode of the DPLL device: 1 = manual, 2 = automatic",
+		}, []string{"node", "iface", "type"})
+
+	// DpllNetlinkResyncs ... number of DPLL netlink resubscriptions with a state resync
+	DpllNetlinkResyncs = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "dpll_netlink_resyncs_total",
+			Help:      "number of times the DPLL notifications were lost, on a socket overrun or error, and the DPLL state dumped again",
+		}, []string{"node", "iface", "reason"})
+
+	// IPCMismatches ... number of IPC protocol mismatches found in handshakes with cloud-event-proxy
+	IPCMismatches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "ipc_mismatches_total",
+			Help:      "number of IPC handshakes with cloud-event-proxy that downgraded the protocol version or dropped message types",
+		}, []string{"kind", "node"})
 )
 
 var registerMetrics sync.Once
 
+// dpllDeviceMetrics returns the DPLL device gauges of the node
+func dpllDeviceMetrics() *dpll.DeviceMetrics {
+	node := prometheus.Labels{"node": NodeName}
+	return &dpll.DeviceMetrics{
+		LockStatus:       DpllDeviceLockStatus.MustCurryWith(node),
+		HoldoverAcquired: DpllDeviceHoldoverAcquired.MustCurryWith(node),
+		Mode:             DpllDeviceMode.MustCurryWith(node),
+	}
+}
+
+// dpllPinQualificationMetrics returns the reference qualification gauges of the node
+func dpllPinQualificationMetrics() *dpll.PinQualificationMetrics {
+	node := prometheus.Labels{"node": NodeName}
+	return &dpll.PinQualificationMetrics{
+		Qualified:   DpllPinQualified.MustCurryWith(node),
+		Rank:        DpllPinRank.MustCurryWith(node),
+		PhaseOffset: DpllPinPhaseOffset.MustCurryWith(node),
+	}
+}
+
 func RegisterMetrics(nodeName string) {
 	registerMetrics.Do(func() {
 		prometheus.MustRegister(Offset)
@@ -216,6 +363,20 @@ func RegisterMetrics(nodeName string) {
 		prometheus.MustRegister(PTPHAMetrics)
 		prometheus.MustRegister(SynceQLInfo)
 		prometheus.MustRegister(SynceClockQL)
+		prometheus.MustRegister(MTIE)
+		prometheus.MustRegister(TDEV)
+		prometheus.MustRegister(TimeErrorMaskPass)
+		prometheus.MustRegister(TimeErrorMaskViolations)
+		prometheus.MustRegister(SuppressedStateFlaps)
+		prometheus.MustRegister(IPCMismatches)
+		prometheus.MustRegister(DpllNetlinkResyncs)
+		prometheus.MustRegister(DpllPinFrequencyOffset)
+		prometheus.MustRegister(DpllPinQualified)
+		prometheus.MustRegister(DpllPinRank)
+		prometheus.MustRegister(DpllPinPhaseOffset)
+		prometheus.MustRegister(DpllDeviceLockStatus)
+		prometheus.MustRegister(DpllDeviceHoldoverAcquired)
+		prometheus.MustRegister(DpllDeviceMode)
 
 		// Including these stats kills performance when Prometheus polls with multiple targets
 		prometheus.Unregister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
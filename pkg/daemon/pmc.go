@@ -140,8 +140,9 @@ func (pmc *PMCProcess) getMonitorSubcribeCommand() string {
 }
 
 // EmitClockClassLogs emits clock class change logs via the EventHandler's connection.
+// It returns once the clock class is written, so that it is part of the replay it is called for.
 func (pmc *PMCProcess) EmitClockClassLogs() {
-	go pmc.eventHandler.EmitClockClass(pmc.configFileName)
+	pmc.eventHandler.EmitClockClass(pmc.configFileName)
 }
 
 // CmdRun starts the PMC monitoring process.
@@ -289,7 +290,7 @@ func (pmc *PMCProcess) handleParentDS(parentDS protocol.ParentDataSet) {
 	pmc.parentDS = &parentDS
 
 	if pmc.clockType == TBC {
-		pmc.eventHandler.UpdateUpstreamParentDataSet(parentDS)
+		pmc.eventHandler.UpdateUpstreamParentDataSet(pmc.configFileName, parentDS)
 	} else if oldParentDS == nil || oldParentDS.GrandmasterClockClass != parentDS.GrandmasterClockClass {
 		pmc.eventHandler.AnnounceClockClass(
 			fbprotocol.ClockClass(parentDS.GrandmasterClockClass),
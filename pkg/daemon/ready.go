@@ -4,12 +4,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/alias"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/hardwareconfig"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	utilwait "k8s.io/apimachinery/pkg/util/wait"
 )
@@ -102,6 +105,9 @@ func (h metricHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
 		if dn := h.tracker.processManager.daemon; dn != nil {
 			dn.liveGate.Open()
 		}
+		if eventHandler := h.tracker.processManager.ptpEventHandler; eventHandler != nil {
+			go eventHandler.EmitReplayComplete()
+		}
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -119,11 +125,14 @@ func (h metricHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
 		dn.liveGate.Open()
 	}
 
-	// Non-critical emits can remain async.
+	// Non-critical emits can remain async, the end of the replay follows them.
 	glog.V(14).Info("/emit-logs: firing async emits (ProcessStatusLogs, ClockClassLogs)")
 	processManager := h.tracker.processManager
-	go processManager.EmitProcessStatusLogs()
-	go processManager.EmitClockClassLogs()
+	go func() {
+		processManager.EmitProcessStatusLogs()
+		processManager.EmitClockClassLogs()
+		eventHandler.EmitReplayComplete()
+	}()
 }
 
 type portAliasesHandler struct{}
@@ -136,12 +145,161 @@ func (h portAliasesHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
 	}
 }
 
+type decisionsHandler struct {
+	tracker *ReadyTracker
+}
+
+// ServeHTTP returns the latest clock state decision trace of every profile,
+// or of a single profile when the "config" query parameter is set (e.g. ts2phc.0.config).
+func (h decisionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.tracker.processManager == nil || h.tracker.processManager.ptpEventHandler == nil {
+		http.Error(w, "event handler is not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	eventHandler := h.tracker.processManager.ptpEventHandler
+	w.Header().Set("Content-Type", "application/json")
+	if cfgName := r.URL.Query().Get("config"); cfgName != "" {
+		trace, found := eventHandler.GetDecisionTrace(cfgName)
+		if !found {
+			http.Error(w, "no decision recorded for "+cfgName, http.StatusNotFound)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(trace); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := json.NewEncoder(w).Encode(eventHandler.GetDecisionTraces()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// dpllConfigs returns the DPLLs of the running processes, copied under the lock Ready uses
+func (rt *ReadyTracker) dpllConfigs() []*dpll.DpllConfig {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	var dplls []*dpll.DpllConfig
+	for _, p := range rt.processManager.process {
+		if p == nil {
+			continue
+		}
+		for _, dp := range p.depProcess {
+			if d, ok := dp.(*dpll.DpllConfig); ok {
+				dplls = append(dplls, d)
+			}
+		}
+	}
+	return dplls
+}
+
+type dpllCandidatesHandler struct {
+	tracker *ReadyTracker
+}
+
+// ServeHTTP returns the ranked reference candidates of every DPLL with a reference qualification, by interface
+func (h dpllCandidatesHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	if h.tracker.processManager == nil {
+		http.Error(w, "process manager is not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	candidates := map[string][]dpll.PinCandidate{}
+	for _, d := range h.tracker.dpllConfigs() {
+		if d.PinQualifier() != nil {
+			candidates[d.Iface()] = d.PinQualifier().Candidates()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(candidates); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type dpllDevicesHandler struct {
+	tracker *ReadyTracker
+}
+
+// ServeHTTP returns the state of the DPLL devices of every monitored clock, by interface
+func (h dpllDevicesHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	if h.tracker.processManager == nil {
+		http.Error(w, "process manager is not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	devices := map[string][]dpll.DeviceState{}
+	for _, d := range h.tracker.dpllConfigs() {
+		devices[d.Iface()] = d.Devices()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(devices); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type pinAuditHandler struct{}
+
+// ServeHTTP returns the audited DPLL pin commands, oldest first. The "component", "label",
+// "clockId" and "limit" query parameters select the records.
+func (h pinAuditHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := hardwareconfig.PinAuditFilter{
+		Component: query.Get("component"),
+		Label:     query.Get("label"),
+	}
+	if s := query.Get("clockId"); s != "" {
+		clockID, err := strconv.ParseUint(s, 0, 64)
+		if err != nil {
+			http.Error(w, "invalid clockId: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.ClockID = clockID
+	}
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 0 {
+			http.Error(w, "invalid limit: "+s, http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(hardwareconfig.PinAuditRecords(filter)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type phaseCalibrationHandler struct {
+	tracker *ReadyTracker
+}
+
+// ServeHTTP returns the phase calibrations of the output pins. Calibrations are requested with
+// the hardwareconfig.PhaseCalibrationAnnotation of a HardwareConfig.
+func (h phaseCalibrationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.tracker.processManager == nil || h.tracker.processManager.daemon == nil ||
+		h.tracker.processManager.daemon.hardwareConfigManager == nil {
+		http.Error(w, "hardware config manager is not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	hcm := h.tracker.processManager.daemon.hardwareConfigManager
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(hcm.PhaseCalibrations()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 // StartReadyServer ...
 func StartReadyServer(bindAddress string, tracker *ReadyTracker, serveInitMetrics bool) {
 	glog.Info("Starting Ready Server")
 	mux := http.NewServeMux()
 	mux.Handle("/ready", readyHandler{tracker: tracker})
 	mux.Handle("/port-aliases", portAliasesHandler{})
+	mux.Handle("/decisions", decisionsHandler{tracker: tracker})
+	mux.Handle("/dpll-candidates", dpllCandidatesHandler{tracker: tracker})
+	mux.Handle("/dpll-devices", dpllDevicesHandler{tracker: tracker})
+	mux.Handle("/dpll-pin-audit", pinAuditHandler{})
+	mux.Handle("/dpll-phase-calibration", phaseCalibrationHandler{tracker: tracker})
 	if serveInitMetrics {
 		mux.Handle("/emit-logs", metricHandler{tracker: tracker})
 	}
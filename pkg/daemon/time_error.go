@@ -0,0 +1,50 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/alias"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/timeerror"
+)
+
+// handleTimeErrorMaskEvent logs a change of the ITU-T mask verdict of a stream and sends it to
+// cloud-event-proxy
+func (dn *Daemon) handleTimeErrorMaskEvent(e timeerror.MaskEvent) {
+	value := ipc.TimeErrorMaskValue{
+		Source:    e.Process,
+		Statistic: string(e.Statistic),
+		Mask:      e.Mask,
+		Verdict:   ipc.MaskPass,
+	}
+	if e.Pass {
+		glog.Info(e.String())
+	} else {
+		glog.Warning(e.String())
+		value.Verdict = ipc.MaskFail
+	}
+	for _, r := range e.Failed {
+		value.Failed = append(value.Failed, ipc.TimeErrorResult{Tau: r.Tau, Value: r.Value, Limit: r.Limit})
+	}
+	dn.processManager.ptpEventHandler.EmitTimeErrorMask(e.CfgName, e.IFace, value)
+}
+
+// observeEventOffset feeds DPLL phase offsets received by the event handler to the time error monitor,
+// and DPLL and GNSS offsets to the offset summaries. Offsets of the other sources come from their logs.
+func (dn *Daemon) observeEventOffset(source event.EventSource, cfgName, iface string, offset int64) {
+	if offset == dpll.FaultyPhaseOffset {
+		return
+	}
+	iface = alias.GetAlias(iface)
+	switch source {
+	case event.DPLL:
+		dn.timeErrorMonitor.Observe(string(source), cfgName, iface, float64(offset), time.Now())
+		dn.processManager.ptpEventHandler.ObserveOffset(string(source), cfgName, iface, float64(offset))
+	case event.GNSS:
+		dn.processManager.ptpEventHandler.ObserveOffset(string(source), cfgName, iface, float64(offset))
+	}
+}
@@ -2,6 +2,7 @@ package debug
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -24,7 +25,9 @@ var (
 	ts2phcIcon     = "ts2phc"
 	iconClockClass = "ClockClass"
 	dpllIcon       = "dpll"
+	decisionIcon   = "Decision"
 	state          = map[string]StateDB{}
+	decisions      = map[string]StateDB{}
 	requiredKeys   = []string{GmKey, GnssKey, OverallTs2phcKey, OverallDpllKey, ClockClassKey}
 	resetGM        bool
 )
@@ -106,6 +109,30 @@ func UpdateClockClass(ClassTo uint8) {
 	}
 }
 
+// UpdateDecision updates the latest clock state decision of a profile and sets resetGM to true if the reason changed
+func UpdateDecision(cfgName, reason, summary string) {
+	if st, ok := decisions[cfgName]; !ok || st.State.(string) != reason {
+		resetGM = true
+	}
+	decisions[cfgName] = StateDB{State: reason, Offset: summary}
+}
+
+// printDecisions prints the latest decision of every profile below the tree
+func printDecisions() {
+	cfgNames := make([]string, 0, len(decisions))
+	for cfgName := range decisions {
+		cfgNames = append(cfgNames, cfgName)
+	}
+	sort.Strings(cfgNames)
+	for i, cfgName := range cfgNames {
+		connector := "├──"
+		if i == len(cfgNames)-1 {
+			connector = "└──"
+		}
+		fmt.Printf("*%s %s [%s]: %v\n", connector, decisionIcon, cfgName, decisions[cfgName].Offset)
+	}
+}
+
 // printTreeNode prints a tree node with its children
 func printTreeNode(parent Node, children []Node, indent string, isLast bool) {
 	// Print the parent node with the appropriate indent
@@ -195,6 +222,7 @@ func PrintTree() {
 
 	// Print the tree
 	printTree(root, rootChildren)
+	printDecisions()
 }
 
 // hasAllKeys checks if all required keys are present in the state
@@ -210,5 +238,6 @@ func hasAllKeys(keys []string) bool {
 // ClearState clears the state and resets resetGM
 func ClearState() {
 	state = map[string]StateDB{}
+	decisions = map[string]StateDB{}
 	resetGM = false
 }
@@ -1,10 +1,30 @@
 package debug_test
 
 import (
-	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/debug"
+	"io"
+	"os"
 	"testing"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/debug"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// captureStdout returns what f prints to the standard output
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	f()
+	require.NoError(t, w.Close())
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(out)
+}
+
 func TestPrintALLState_ChangesStateAndSetsResetGM(t *testing.T) {
 	debug.UpdateGMState("s2")
 	debug.UpdateDPLLState("s1", 100, "eth0")
@@ -17,3 +37,28 @@ func TestPrintALLState_ChangesStateAndSetsResetGM(t *testing.T) {
 	debug.UpdateClockClass(2)
 	debug.PrintTree()
 }
+
+func TestUpdateDecision_PrintedWithTree(t *testing.T) {
+	debug.ClearState()
+	defer debug.ClearState()
+	debug.UpdateDecision("ts2phc.0.config", "DPLL HOLDOVER within spec", "s1 class 7 leading ens1f0")
+	out := captureStdout(t, func() {
+		debug.UpdateGMState("s1")
+		debug.UpdateDPLLState("s1", 100, debug.OverallDpllKey)
+		debug.UpdateTs2phcState("s1", 0, debug.OverallTs2phcKey)
+		debug.UpdateGNSSState("s0", 100)
+		debug.UpdateClockClass(7)
+	})
+	assert.Empty(t, out, "the tree is printed once all the states are known")
+
+	out = captureStdout(t, debug.PrintTree)
+	assert.Contains(t, out, "GM (Grand Master Clock) (State:HoldOver)")
+	assert.Contains(t, out, "GNSS (State:FreeRun)")
+	assert.Contains(t, out, "ClockClass (State:7)")
+	assert.Contains(t, out, "*└── Decision [ts2phc.0.config]: s1 class 7 leading ens1f0\n")
+
+	// a new decision reason prints the tree again on the next GM state update
+	debug.UpdateDecision("ts2phc.0.config", "DPLL FREERUN", "s0 class 248 leading ens1f0")
+	out = captureStdout(t, func() { debug.UpdateGMState("s1") })
+	assert.Contains(t, out, "Decision [ts2phc.0.config]: s0 class 248 leading ens1f0")
+}
@@ -320,11 +320,19 @@ func GetDpllType(tp uint32) string {
 	return unknownStr
 }
 
+// Defines DPLL modes
+const (
+	// DpllModeManual means the input is selected by the user
+	DpllModeManual = 1
+	// DpllModeAutomatic means the DPLL selects the highest priority valid input
+	DpllModeAutomatic = 2
+)
+
 // GetMode returns DPLL mode as a string
 func GetMode(md uint32) string {
 	modeMap := map[int]string{
-		1: "manual",
-		2: "automatic",
+		DpllModeManual:    "manual",
+		DpllModeAutomatic: "automatic",
 	}
 	mode, found := modeMap[int(md)]
 	if found {
@@ -611,6 +619,20 @@ func GetPinCapabilities(c uint32) string {
 	return strings.Join(capList, ",")
 }
 
+// GetPinLabel returns the label identifying a pin: the board label when the driver
+// provides one, otherwise the panel or package label, otherwise the pin ID
+func GetPinLabel(p *PinInfo) string {
+	switch {
+	case p.BoardLabel != "":
+		return p.BoardLabel
+	case p.PanelLabel != "":
+		return p.PanelLabel
+	case p.PackageLabel != "":
+		return p.PackageLabel
+	}
+	return fmt.Sprintf("pin%d", p.ID)
+}
+
 // GetPinInfoHR returns human-readable pin status
 func GetPinInfoHR(reply *PinInfo, timestamp time.Time) ([]byte, error) {
 	hr := PinInfoHR{
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"syscall"
 
 	"github.com/mdlayher/genetlink"
 	"github.com/mdlayher/netlink"
@@ -53,6 +54,20 @@ func (c *Conn) GetMcastGroupID(mcGroup string) (id uint32, found bool) {
 	return 0, false
 }
 
+// IsOverrun reports whether a receive error is a socket overrun (ENOBUFS): the receive
+// buffer overflowed and notifications were dropped, the cached state must be dumped again
+func IsOverrun(err error) bool {
+	return errors.Is(err, syscall.ENOBUFS)
+}
+
+// Dialer opens a Conn for netlink family "dpll"
+type Dialer func() (*Conn, error)
+
+// DefaultDialer opens a Conn to the kernel with the default netlink options
+func DefaultDialer() (*Conn, error) {
+	return Dial(nil)
+}
+
 // Dial opens a Conn for netlink family "dpll". Any options are passed directly
 // to the underlying netlink package.
 func Dial(cfg *netlink.Config) (*Conn, error) {
@@ -69,6 +84,12 @@ func Dial(cfg *netlink.Config) (*Conn, error) {
 	return &Conn{c: c, f: f}, nil
 }
 
+// NewConn returns a Conn for the "dpll" family served on a genetlink connection,
+// like the family of a simulator.
+func NewConn(c *genetlink.Conn, f genetlink.Family) *Conn {
+	return &Conn{c: c, f: f}
+}
+
 // Close closes the Conn's underlying netlink connection.
 func (c *Conn) Close() error { return c.c.Close() }
 
@@ -195,9 +216,8 @@ func ParseDeviceReplies(msgs []genetlink.Message) ([]*DoDeviceGetReply, error) {
 // Get list of DPLL devices (dump) or attributes of a single dpll device
 func (c *Conn) DoDeviceGet(req DoDeviceGetRequest) (*DoDeviceGetReply, error) {
 	ae := netlink.NewAttributeEncoder()
-	if req.ID != 0 {
-		ae.Uint32(DpllID, req.ID)
-	}
+	// 0 is a valid device ID, the kernel requires it for a do request
+	ae.Uint32(DpllID, req.ID)
 	// TODO: field "req.ModuleName", type "string"
 
 	b, err := ae.Encode()
@@ -280,6 +300,51 @@ type DoDeviceGetReply struct {
 	FrequencyMonitor         uint32
 }
 
+// DoDeviceSetRequest is used with the DoDeviceSet method. Nil fields are left unchanged.
+type DoDeviceSetRequest struct {
+	ID                       uint32
+	Mode                     *uint32
+	PhaseOffsetMonitor       *uint32
+	PhaseOffsetAverageFactor *uint32
+}
+
+// DoDeviceSet wraps the "device-set" operation:
+// Set attributes for a DPLL device. The request is acknowledged, so the kernel errors are returned.
+func (c *Conn) DoDeviceSet(req DoDeviceSetRequest) error {
+	ae := netlink.NewAttributeEncoder()
+	ae.Uint32(DpllID, req.ID)
+	if req.Mode != nil {
+		ae.Uint32(DpllMode, *req.Mode)
+	}
+	if req.PhaseOffsetMonitor != nil {
+		ae.Uint32(DpllPhaseOffsetMonitor, *req.PhaseOffsetMonitor)
+	}
+	if req.PhaseOffsetAverageFactor != nil {
+		ae.Uint32(DpllPhaseOffsetAverageFactor, *req.PhaseOffsetAverageFactor)
+	}
+	b, err := ae.Encode()
+	if err != nil {
+		return err
+	}
+	return c.execAcknowledged(DpllCmdDeviceSet, b)
+}
+
+// SetDeviceMode switches a DPLL device between automatic and manual mode (DpllModeAutomatic,
+// DpllModeManual) and verifies the mode the device reports afterwards.
+func (c *Conn) SetDeviceMode(id uint32, mode uint32) error {
+	if err := c.DoDeviceSet(DoDeviceSetRequest{ID: id, Mode: &mode}); err != nil {
+		return fmt.Errorf("failed to set dpll %d mode to %s: %w", id, GetMode(mode), err)
+	}
+	device, err := c.DoDeviceGet(DoDeviceGetRequest{ID: id})
+	if err != nil {
+		return fmt.Errorf("failed to verify dpll %d mode: %w", id, err)
+	}
+	if device.Mode != mode {
+		return fmt.Errorf("dpll %d mode is %s after setting it to %s", id, GetMode(device.Mode), GetMode(mode))
+	}
+	return nil
+}
+
 func ParsePinReplies(msgs []genetlink.Message) ([]*PinInfo, error) {
 	replies := make([]*PinInfo, 0, len(msgs))
 
@@ -662,3 +727,60 @@ func (c *Conn) SendCommand(command uint8, data []byte) error {
 	_, err := c.c.Send(msg, c.f.ID, netlink.Request)
 	return err
 }
+
+// DoPinSet wraps the "pin-set" operation. Unlike SendCommand, the request is acknowledged,
+// so the kernel errors are returned.
+func (c *Conn) DoPinSet(req PinParentDeviceCtl) error {
+	b, err := EncodePinControl(req)
+	if err != nil {
+		return err
+	}
+	return c.execAcknowledged(DpllCmdPinSet, b)
+}
+
+// SelectInput selects an input pin as the reference of a DPLL device in manual mode,
+// and verifies that the device reports the pin as connected afterwards.
+func (c *Conn) SelectInput(deviceID uint32, pinID uint32) error {
+	device, err := c.DoDeviceGet(DoDeviceGetRequest{ID: deviceID})
+	if err != nil {
+		return fmt.Errorf("failed to get dpll %d: %w", deviceID, err)
+	}
+	if device.Mode != DpllModeManual {
+		return fmt.Errorf("dpll %d is in %s mode, inputs can only be selected in manual mode", deviceID, GetMode(device.Mode))
+	}
+	state := uint32(PinStateConnected)
+	err = c.DoPinSet(PinParentDeviceCtl{
+		ID:           pinID,
+		PinParentCtl: []PinControl{{PinParentID: deviceID, State: &state}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to select pin %d as the dpll %d input: %w", pinID, deviceID, err)
+	}
+	pin, err := c.DoPinGet(DoPinGetRequest{ID: pinID})
+	if err != nil {
+		return fmt.Errorf("failed to verify pin %d selection: %w", pinID, err)
+	}
+	for _, pd := range pin.ParentDevice {
+		if pd.ParentID != deviceID {
+			continue
+		}
+		if pd.State != PinStateConnected {
+			return fmt.Errorf("pin %d is %s on dpll %d after selecting it", pinID, GetPinState(pd.State), deviceID)
+		}
+		return nil
+	}
+	return fmt.Errorf("pin %d has no parent dpll %d", pinID, deviceID)
+}
+
+// execAcknowledged sends a DPLL command requesting an acknowledgement and waits for it
+func (c *Conn) execAcknowledged(command uint8, data []byte) error {
+	msg := genetlink.Message{
+		Header: genetlink.Header{
+			Command: command,
+			Version: c.f.Version,
+		},
+		Data: data,
+	}
+	_, err := c.c.Execute(msg, c.f.ID, netlink.Request|netlink.Acknowledge)
+	return err
+}
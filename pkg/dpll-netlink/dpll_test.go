@@ -193,6 +193,24 @@ func TestGetPinOperstate(t *testing.T) {
 	}
 }
 
+func TestGetPinLabel(t *testing.T) {
+	tests := []struct {
+		name string
+		pin  PinInfo
+		want string
+	}{
+		{"board label", PinInfo{ID: 1, BoardLabel: "GNSS-1PPS", PackageLabel: "REF0P"}, "GNSS-1PPS"},
+		{"panel label", PinInfo{ID: 2, PanelLabel: "SMA1", PackageLabel: "REF1P"}, "SMA1"},
+		{"package label", PinInfo{ID: 3, PackageLabel: "REF2P"}, "REF2P"},
+		{"no label", PinInfo{ID: 4}, "pin4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, GetPinLabel(&tt.pin))
+		})
+	}
+}
+
 // TestParseDeviceReplies_FrequencyMonitor verifies that ParseDeviceReplies
 // correctly decodes the DpllFrequencyMonitor attribute into DoDeviceGetReply.FrequencyMonitor.
 func TestParseDeviceReplies_FrequencyMonitor(t *testing.T) {
@@ -0,0 +1,1151 @@
+package dplltest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mdlayher/genetlink"
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+
+	nl "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+)
+
+const (
+	// simFamilyID and simMonitorGroupID are the IDs the simulator assigns to the
+	// "dpll" family and its monitor group, the kernel allocates them dynamically
+	simFamilyID       = 0x20
+	simMonitorGroupID = 0x10
+	simFamilyVersion  = 1
+)
+
+// errSimClosed is returned by Receive on a closed simulator socket. It reads
+// like the error of a closed netlink socket, which the monitors treat as the
+// normal end of the monitoring.
+var errSimClosed = errors.New("use of closed file")
+
+// Simulator is an in-process model of the kernel DPLL genetlink family. It
+// serves the requests of its connections like the kernel does, including
+// dumps and netlink errors, and multicasts change notifications to the
+// connections that joined the monitor group. Devices and pins are scripted
+// with AddDevice, AddPin and the Set methods, so the DPLL handling can be
+// exercised without DPLL hardware. The code under test connects to the
+// simulator through the Dialer it is given instead of dialing the kernel.
+//
+// Devices in automatic mode select the highest priority (lowest prio value)
+// input pin which has a signal and is not disconnected, the selected pin is
+// connected and the device locked-ho-acquired. A device that loses all its
+// inputs goes to holdover once it has been locked, and is unlocked otherwise.
+// Devices in manual mode lock on their connected input when it has a signal.
+type Simulator struct {
+	mu      sync.Mutex
+	devices []*nl.DoDeviceGetReply
+	pins    []*nl.PinInfo
+	// signal holds the input pins which receive a valid signal
+	signal map[uint32]bool
+	// holdoverAcquired holds the devices which have been locked, and go to holdover on reference loss
+	holdoverAcquired map[uint32]bool
+	// failures holds the errno returned to the next request of a command
+	failures map[uint8]syscall.Errno
+	sockets  map[*simSocket]bool
+	nextPID  uint32
+}
+
+// NewSimulator returns a simulator without devices and pins
+func NewSimulator() *Simulator {
+	return &Simulator{
+		signal:           map[uint32]bool{},
+		holdoverAcquired: map[uint32]bool{},
+		failures:         map[uint8]syscall.Errno{},
+		sockets:          map[*simSocket]bool{},
+	}
+}
+
+// Dial returns a new connection to the simulator
+func (s *Simulator) Dial() *nl.Conn {
+	s.mu.Lock()
+	s.nextPID++
+	sock := &simSocket{sim: s, pid: s.nextPID, groups: map[uint32]bool{}}
+	sock.cond = sync.NewCond(&sock.mu)
+	s.sockets[sock] = true
+	s.mu.Unlock()
+
+	family := genetlink.Family{
+		ID:      simFamilyID,
+		Version: simFamilyVersion,
+		Name:    "dpll",
+		Groups:  []genetlink.MulticastGroup{{ID: simMonitorGroupID, Name: nl.DpllMCGRPMonitor}},
+	}
+	return nl.NewConn(genetlink.NewConn(netlink.NewConn(sock, sock.pid)), family)
+}
+
+// Dialer returns the dialer of connections to the simulator, to inject where the kernel is dialed
+func (s *Simulator) Dialer() nl.Dialer {
+	return func() (*nl.Conn, error) { return s.Dial(), nil }
+}
+
+// AddDevice adds a DPLL device and returns the ID the simulator assigned to
+// it. The mode defaults to automatic and the supported modes to the mode.
+func (s *Simulator) AddDevice(dev nl.DoDeviceGetReply) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := copyDevice(&dev)
+	d.ID = uint32(len(s.devices))
+	if d.Mode == 0 {
+		d.Mode = nl.DpllModeAutomatic
+	}
+	if len(d.ModeSupported) == 0 {
+		d.ModeSupported = []uint32{d.Mode}
+	}
+	if d.LockStatus == 0 {
+		d.LockStatus = nl.DpllLockStatusUnlocked
+	}
+	s.devices = append(s.devices, d)
+	s.notifyDeviceLocked(nl.DpllCmdDeviceCreateNtf, d)
+	return d.ID
+}
+
+// AddPin adds a pin and returns the ID the simulator assigned to it. Its
+// ParentDevice entries must refer to added devices, inputs start without a
+// signal.
+func (s *Simulator) AddPin(pin nl.PinInfo) (uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, pd := range pin.ParentDevice {
+		if s.deviceLocked(pd.ParentID) == nil {
+			return 0, fmt.Errorf("pin parent device %d not found", pd.ParentID)
+		}
+	}
+	p := copyPin(&pin)
+	p.ID = uint32(len(s.pins))
+	for i := range p.ParentDevice {
+		if p.ParentDevice[i].State == 0 {
+			p.ParentDevice[i].State = nl.PinStateSelectable
+		}
+	}
+	s.pins = append(s.pins, p)
+	s.notifyPinLocked(nl.DpllCmdPinCreateNtf, p)
+	s.reselectLocked(parentIDs(p))
+	return p.ID, nil
+}
+
+// SetSignal sets whether an input pin receives a valid signal, the devices it
+// feeds reselect their reference
+func (s *Simulator) SetSignal(pinID uint32, present bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.pinLocked(pinID)
+	if p == nil {
+		return fmt.Errorf("pin %d not found", pinID)
+	}
+	s.signal[pinID] = present
+	s.reselectLocked(parentIDs(p))
+	return nil
+}
+
+// SetPhaseOffset sets the phase offset of an input pin measured by a device,
+// in the kernel unit of DpllPhaseOffsetDivider fractions of a picosecond
+func (s *Simulator) SetPhaseOffset(pinID, deviceID uint32, offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.pinLocked(pinID)
+	if p == nil {
+		return fmt.Errorf("pin %d not found", pinID)
+	}
+	pd := parentDevice(p, deviceID)
+	if pd == nil {
+		return fmt.Errorf("device %d is not a parent of pin %d", deviceID, pinID)
+	}
+	pd.PhaseOffset = offset
+	s.notifyPinLocked(nl.DpllCmdPinChangeNtf, p)
+	return nil
+}
+
+// SetFractionalFrequencyOffset sets the fractional frequency offset of a pin
+// in parts per trillion, it is reported in PPM as well
+func (s *Simulator) SetFractionalFrequencyOffset(pinID uint32, ppt int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.pinLocked(pinID)
+	if p == nil {
+		return fmt.Errorf("pin %d not found", pinID)
+	}
+	p.FractionalFrequencyOffsetPPT = ppt
+	p.FractionalFrequencyOffset = int(ppt / 1000000)
+	s.notifyPinLocked(nl.DpllCmdPinChangeNtf, p)
+	return nil
+}
+
+// SetLockStatus forces the lock status of a device, until its reference is
+// reselected
+func (s *Simulator) SetLockStatus(deviceID, status uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.deviceLocked(deviceID)
+	if d == nil {
+		return fmt.Errorf("device %d not found", deviceID)
+	}
+	if status == nl.DpllLockStatusLocked || status == nl.DpllLockStatusLockedHoldoverAcquired {
+		s.holdoverAcquired[deviceID] = true
+	}
+	if d.LockStatus != status {
+		d.LockStatus = status
+		s.notifyDeviceLocked(nl.DpllCmdDeviceChangeNtf, d)
+	}
+	return nil
+}
+
+// FailNext makes the next request of the command fail with errno
+func (s *Simulator) FailNext(command uint8, errno syscall.Errno) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[command] = errno
+}
+
+// Overrun overflows the receive buffer of the connections which joined the monitor
+// group: their queued notifications and the next ones are dropped, and their next
+// receive after a notification fails with ENOBUFS, as with the kernel
+func (s *Simulator) Overrun() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sock := range s.sockets {
+		if sock.joined(simMonitorGroupID) {
+			sock.mu.Lock()
+			sock.queued = nil
+			sock.overrun = true
+			sock.mu.Unlock()
+		}
+	}
+}
+
+// Subscribers returns the number of connections which joined the monitor group
+func (s *Simulator) Subscribers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for sock := range s.sockets {
+		if sock.joined(simMonitorGroupID) {
+			n++
+		}
+	}
+	return n
+}
+
+// Device returns a snapshot of a device
+func (s *Simulator) Device(id uint32) (nl.DoDeviceGetReply, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.deviceLocked(id)
+	if d == nil {
+		return nl.DoDeviceGetReply{}, false
+	}
+	return *copyDevice(d), true
+}
+
+// Pin returns a snapshot of a pin
+func (s *Simulator) Pin(id uint32) (nl.PinInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.pinLocked(id)
+	if p == nil {
+		return nl.PinInfo{}, false
+	}
+	return *copyPin(p), true
+}
+
+func (s *Simulator) deviceLocked(id uint32) *nl.DoDeviceGetReply {
+	if int(id) < len(s.devices) {
+		return s.devices[id]
+	}
+	return nil
+}
+
+func (s *Simulator) pinLocked(id uint32) *nl.PinInfo {
+	if int(id) < len(s.pins) {
+		return s.pins[id]
+	}
+	return nil
+}
+
+// handle serves a request sent on a socket, the replies and the errors are queued on the socket
+func (s *Simulator) handle(sock *simSocket, req netlink.Message) {
+	var gm genetlink.Message
+	if err := gm.UnmarshalBinary(req.Data); err != nil {
+		sock.queue([]netlink.Message{errorMessage(req, syscall.EINVAL)})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if errno, found := s.failures[gm.Header.Command]; found {
+		delete(s.failures, gm.Header.Command)
+		sock.queue([]netlink.Message{errorMessage(req, errno)})
+		return
+	}
+
+	var replies [][]byte
+	var err error
+	dump := req.Header.Flags&netlink.Dump == netlink.Dump
+	switch gm.Header.Command {
+	case nl.DpllCmdDeviceIDGet:
+		replies, err = s.deviceIDGetLocked(gm.Data)
+	case nl.DpllCmdDeviceGet:
+		if dump {
+			replies, err = s.deviceDumpLocked()
+		} else {
+			replies, err = s.deviceGetLocked(gm.Data)
+		}
+	case nl.DpllCmdDeviceSet:
+		err = s.deviceSetLocked(gm.Data)
+	case nl.DpllCmdPinIDGet:
+		replies, err = s.pinIDGetLocked(gm.Data)
+	case nl.DpllCmdPinGet:
+		if dump {
+			replies, err = s.pinDumpLocked()
+		} else {
+			replies, err = s.pinGetLocked(gm.Data)
+		}
+	case nl.DpllCmdPinSet:
+		err = s.pinSetLocked(gm.Data)
+	default:
+		err = syscall.EOPNOTSUPP
+	}
+
+	var errno syscall.Errno
+	if err != nil && !errors.As(err, &errno) {
+		errno = syscall.EINVAL
+	}
+	switch {
+	case errno != 0:
+		sock.queue([]netlink.Message{errorMessage(req, errno)})
+	case dump:
+		msgs := make([]netlink.Message, 0, len(replies)+1)
+		for _, b := range replies {
+			msgs = append(msgs, replyMessage(req, gm.Header.Command, netlink.Multi, b))
+		}
+		done := netlink.Message{
+			Header: netlink.Header{Type: netlink.Done, Flags: netlink.Multi, Sequence: req.Header.Sequence, PID: req.Header.PID},
+			Data:   nlenc.Int32Bytes(0),
+		}
+		sock.queue(append(msgs, done))
+	case len(replies) > 0:
+		sock.queue([]netlink.Message{replyMessage(req, gm.Header.Command, 0, replies[0])})
+	case req.Header.Flags&netlink.Acknowledge != 0:
+		sock.queue([]netlink.Message{errorMessage(req, 0)})
+	}
+}
+
+func (s *Simulator) deviceIDGetLocked(data []byte) ([][]byte, error) {
+	ad, err := netlink.NewAttributeDecoder(data)
+	if err != nil {
+		return nil, err
+	}
+	var clockID uint64
+	var moduleName string
+	var typ uint32
+	for ad.Next() {
+		switch ad.Type() {
+		case nl.DpllClockID:
+			clockID = ad.Uint64()
+		case nl.DpllModuleName:
+			moduleName = ad.String()
+		case nl.DpllType:
+			typ = uint32(ad.Uint8())
+		}
+	}
+	if err = ad.Err(); err != nil {
+		return nil, err
+	}
+	var found *nl.DoDeviceGetReply
+	for _, d := range s.devices {
+		if (clockID != 0 && d.ClockID != clockID) || (moduleName != "" && d.ModuleName != moduleName) ||
+			(typ != 0 && d.Type != typ) {
+			continue
+		}
+		if found != nil {
+			return nil, syscall.EINVAL
+		}
+		found = d
+	}
+	if found == nil {
+		return nil, syscall.ENODEV
+	}
+	ae := netlink.NewAttributeEncoder()
+	ae.Uint32(nl.DpllID, found.ID)
+	b, err := ae.Encode()
+	return [][]byte{b}, err
+}
+
+func (s *Simulator) deviceDumpLocked() ([][]byte, error) {
+	replies := make([][]byte, 0, len(s.devices))
+	for _, d := range s.devices {
+		b, err := encodeDevice(d)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, b)
+	}
+	return replies, nil
+}
+
+// requestedDeviceLocked returns the device of the DpllID attribute of a request
+func (s *Simulator) requestedDeviceLocked(ad *netlink.AttributeDecoder) (*nl.DoDeviceGetReply, error) {
+	for ad.Next() {
+		if ad.Type() == nl.DpllID {
+			if d := s.deviceLocked(ad.Uint32()); d != nil {
+				return d, nil
+			}
+			return nil, syscall.ENODEV
+		}
+	}
+	if err := ad.Err(); err != nil {
+		return nil, err
+	}
+	return nil, syscall.EINVAL
+}
+
+func (s *Simulator) deviceGetLocked(data []byte) ([][]byte, error) {
+	ad, err := netlink.NewAttributeDecoder(data)
+	if err != nil {
+		return nil, err
+	}
+	d, err := s.requestedDeviceLocked(ad)
+	if err != nil {
+		return nil, err
+	}
+	b, err := encodeDevice(d)
+	return [][]byte{b}, err
+}
+
+func (s *Simulator) deviceSetLocked(data []byte) error {
+	ad, err := netlink.NewAttributeDecoder(data)
+	if err != nil {
+		return err
+	}
+	d, err := s.requestedDeviceLocked(ad)
+	if err != nil {
+		return err
+	}
+	ad, err = netlink.NewAttributeDecoder(data)
+	if err != nil {
+		return err
+	}
+	update := *copyDevice(d)
+	for ad.Next() {
+		switch ad.Type() {
+		case nl.DpllMode:
+			update.Mode = ad.Uint32()
+			if !containsUint32(d.ModeSupported, update.Mode) {
+				return syscall.EOPNOTSUPP
+			}
+		case nl.DpllPhaseOffsetMonitor:
+			update.PhaseOffsetMonitor = ad.Uint32()
+		case nl.DpllPhaseOffsetAverageFactor:
+			update.PhaseOffsetAverageFactor = ad.Uint32()
+		case nl.DpllFrequencyMonitor:
+			update.FrequencyMonitor = ad.Uint32()
+		}
+	}
+	if err = ad.Err(); err != nil {
+		return err
+	}
+	*d = update
+	s.notifyDeviceLocked(nl.DpllCmdDeviceChangeNtf, d)
+	s.reselectLocked([]uint32{d.ID})
+	return nil
+}
+
+func (s *Simulator) pinIDGetLocked(data []byte) ([][]byte, error) {
+	ad, err := netlink.NewAttributeDecoder(data)
+	if err != nil {
+		return nil, err
+	}
+	var match nl.PinInfo
+	for ad.Next() {
+		switch ad.Type() {
+		case nl.DpllPinClockID:
+			match.ClockID = ad.Uint64()
+		case nl.DpllPinModuleName:
+			match.ModuleName = ad.String()
+		case nl.DpllPinBoardLabel:
+			match.BoardLabel = ad.String()
+		case nl.DpllPinPanelLabel:
+			match.PanelLabel = ad.String()
+		case nl.DpllPinPackageLabel:
+			match.PackageLabel = ad.String()
+		case nl.DpllPinType:
+			match.Type = ad.Uint32()
+		}
+	}
+	if err = ad.Err(); err != nil {
+		return nil, err
+	}
+	var found *nl.PinInfo
+	for _, p := range s.pins {
+		if (match.ClockID != 0 && p.ClockID != match.ClockID) ||
+			(match.ModuleName != "" && p.ModuleName != match.ModuleName) ||
+			(match.BoardLabel != "" && p.BoardLabel != match.BoardLabel) ||
+			(match.PanelLabel != "" && p.PanelLabel != match.PanelLabel) ||
+			(match.PackageLabel != "" && p.PackageLabel != match.PackageLabel) ||
+			(match.Type != 0 && p.Type != match.Type) {
+			continue
+		}
+		if found != nil {
+			return nil, syscall.EINVAL
+		}
+		found = p
+	}
+	if found == nil {
+		return nil, syscall.ENODEV
+	}
+	ae := netlink.NewAttributeEncoder()
+	ae.Uint32(nl.DpllPinID, found.ID)
+	b, err := ae.Encode()
+	return [][]byte{b}, err
+}
+
+func (s *Simulator) pinDumpLocked() ([][]byte, error) {
+	replies := make([][]byte, 0, len(s.pins))
+	for _, p := range s.pins {
+		b, err := encodePin(p)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, b)
+	}
+	return replies, nil
+}
+
+// requestedPinLocked returns the pin of the DpllPinID attribute of a request
+func (s *Simulator) requestedPinLocked(data []byte) (*nl.PinInfo, error) {
+	ad, err := netlink.NewAttributeDecoder(data)
+	if err != nil {
+		return nil, err
+	}
+	for ad.Next() {
+		if ad.Type() == nl.DpllPinID {
+			if p := s.pinLocked(ad.Uint32()); p != nil {
+				return p, nil
+			}
+			return nil, syscall.ENODEV
+		}
+	}
+	if err = ad.Err(); err != nil {
+		return nil, err
+	}
+	return nil, syscall.EINVAL
+}
+
+func (s *Simulator) pinGetLocked(data []byte) ([][]byte, error) {
+	p, err := s.requestedPinLocked(data)
+	if err != nil {
+		return nil, err
+	}
+	b, err := encodePin(p)
+	return [][]byte{b}, err
+}
+
+// pinSetLocked applies a pin-set request. The request is validated before
+// anything changes, like the capabilities of the pin for state, priority and
+// direction changes and the supported ranges for frequencies and phase adjust.
+func (s *Simulator) pinSetLocked(data []byte) error {
+	p, err := s.requestedPinLocked(data)
+	if err != nil {
+		return err
+	}
+	update := copyPin(p)
+	ad, err := netlink.NewAttributeDecoder(data)
+	if err != nil {
+		return err
+	}
+	for ad.Next() {
+		switch ad.Type() {
+		case nl.DpllPinFrequency:
+			update.Frequency = ad.Uint64()
+			if !inRanges(update.FrequencySupported, update.Frequency) {
+				return syscall.EINVAL
+			}
+		case nl.DpllPinEsyncFrequency:
+			v := ad.Uint64()
+			if !inRanges(update.EsyncFrequencySupported, v) {
+				return syscall.EINVAL
+			}
+			update.EsyncFrequency = int64(v)
+		case nl.DpllPinPhaseAdjust:
+			update.PhaseAdjust = ad.Int32()
+			if update.PhaseAdjust < update.PhaseAdjustMin || update.PhaseAdjust > update.PhaseAdjustMax {
+				return syscall.EINVAL
+			}
+			if update.PhaseAdjustGran > 1 && update.PhaseAdjust%int32(update.PhaseAdjustGran) != 0 {
+				return syscall.EINVAL
+			}
+		case nl.DpllPinParentDevice:
+			ad.Nested(func(ad *netlink.AttributeDecoder) error {
+				return s.setParentDeviceLocked(update, ad)
+			})
+		case nl.DpllPinParentPin:
+			ad.Nested(func(ad *netlink.AttributeDecoder) error {
+				return setParentPin(update, ad)
+			})
+		}
+	}
+	if err = ad.Err(); err != nil {
+		return err
+	}
+	*p = *update
+	s.notifyPinLocked(nl.DpllCmdPinChangeNtf, p)
+	s.disconnectOtherInputsLocked(p)
+	s.reselectLocked(parentIDs(p))
+	return nil
+}
+
+// disconnectOtherInputsLocked disconnects the other inputs of the manual mode devices the pin is
+// connected to as an input, a manual DPLL has a single selected reference
+func (s *Simulator) disconnectOtherInputsLocked(p *nl.PinInfo) {
+	for _, pd := range p.ParentDevice {
+		if pd.Direction != nl.PinDirectionInput || pd.State != nl.PinStateConnected ||
+			s.deviceLocked(pd.ParentID).Mode != nl.DpllModeManual {
+			continue
+		}
+		for _, other := range s.pins {
+			opd := parentDevice(other, pd.ParentID)
+			if other == p || opd == nil || opd.Direction != nl.PinDirectionInput || opd.State != nl.PinStateConnected {
+				continue
+			}
+			opd.State = nl.PinStateDisconnected
+			s.notifyPinLocked(nl.DpllCmdPinChangeNtf, other)
+		}
+	}
+}
+
+// setParentDeviceLocked applies a pin-parent-device nest of a pin-set request to the pin
+func (s *Simulator) setParentDeviceLocked(p *nl.PinInfo, ad *netlink.AttributeDecoder) error {
+	var pd *nl.PinParentDevice
+	for ad.Next() {
+		switch ad.Type() {
+		case nl.DpllPinParentID:
+			if pd = parentDevice(p, ad.Uint32()); pd == nil {
+				return syscall.EINVAL
+			}
+		case nl.DpllPinState, nl.DpllPinPrio, nl.DpllPinDirection:
+			if pd == nil {
+				return syscall.EINVAL
+			}
+			v := ad.Uint32()
+			switch ad.Type() {
+			case nl.DpllPinState:
+				if p.Capabilities&nl.PinCapState == 0 {
+					return syscall.EOPNOTSUPP
+				}
+				d := s.deviceLocked(pd.ParentID)
+				if v == nl.PinStateConnected && d.Mode == nl.DpllModeAutomatic && pd.Direction == nl.PinDirectionInput {
+					// inputs are connected by the reference selection of automatic mode
+					return syscall.EINVAL
+				}
+				if v != nl.PinStateConnected && v != nl.PinStateDisconnected && v != nl.PinStateSelectable {
+					return syscall.EINVAL
+				}
+				pd.State = v
+			case nl.DpllPinPrio:
+				if p.Capabilities&nl.PinCapPrio == 0 {
+					return syscall.EOPNOTSUPP
+				}
+				pd.Prio = &v
+			case nl.DpllPinDirection:
+				if p.Capabilities&nl.PinCapDir == 0 {
+					return syscall.EOPNOTSUPP
+				}
+				if v != nl.PinDirectionInput && v != nl.PinDirectionOutput {
+					return syscall.EINVAL
+				}
+				pd.Direction = v
+			}
+		}
+	}
+	if pd == nil {
+		return syscall.EINVAL
+	}
+	return nil
+}
+
+// setParentPin applies a pin-parent-pin nest of a pin-set request to the pin
+func setParentPin(p *nl.PinInfo, ad *netlink.AttributeDecoder) error {
+	var pp *nl.PinParentPin
+	for ad.Next() {
+		switch ad.Type() {
+		case nl.DpllPinParentID:
+			id := ad.Uint32()
+			for i := range p.ParentPin {
+				if p.ParentPin[i].ParentID == id {
+					pp = &p.ParentPin[i]
+				}
+			}
+			if pp == nil {
+				return syscall.EINVAL
+			}
+		case nl.DpllPinState:
+			if pp == nil {
+				return syscall.EINVAL
+			}
+			if p.Capabilities&nl.PinCapState == 0 {
+				return syscall.EOPNOTSUPP
+			}
+			pp.State = ad.Uint32()
+		}
+	}
+	if pp == nil {
+		return syscall.EINVAL
+	}
+	return nil
+}
+
+// reselectLocked reevaluates the reference and the lock status of the
+// devices, and notifies the pins and devices which changed
+func (s *Simulator) reselectLocked(deviceIDs []uint32) {
+	for _, id := range deviceIDs {
+		d := s.deviceLocked(id)
+		var selected *nl.PinInfo
+		selectedPrio := uint32(math.MaxUint32)
+		for _, p := range s.pins {
+			pd := parentDevice(p, id)
+			if pd == nil || pd.Direction != nl.PinDirectionInput || !s.signal[p.ID] {
+				continue
+			}
+			switch {
+			case d.Mode == nl.DpllModeManual:
+				if pd.State == nl.PinStateConnected {
+					selected = p
+				}
+			case pd.State != nl.PinStateDisconnected && pd.Prio != nil && *pd.Prio < selectedPrio:
+				selected, selectedPrio = p, *pd.Prio
+			}
+		}
+
+		for _, p := range s.pins {
+			pd := parentDevice(p, id)
+			if pd == nil || pd.Direction != nl.PinDirectionInput {
+				continue
+			}
+			state, operstate := pd.State, uint32(nl.PinOperstateStandby)
+			if d.Mode == nl.DpllModeAutomatic && state == nl.PinStateConnected && p != selected {
+				state = nl.PinStateSelectable
+			}
+			switch {
+			case p == selected:
+				state, operstate = nl.PinStateConnected, nl.PinOperstateActive
+			case !s.signal[p.ID]:
+				operstate = nl.PinOperstateNoSignal
+			}
+			if state != pd.State || operstate != pd.Operstate {
+				pd.State, pd.Operstate = state, operstate
+				s.notifyPinLocked(nl.DpllCmdPinChangeNtf, p)
+			}
+		}
+
+		lockStatus := uint32(nl.DpllLockStatusUnlocked)
+		switch {
+		case selected != nil:
+			lockStatus = nl.DpllLockStatusLockedHoldoverAcquired
+			s.holdoverAcquired[id] = true
+		case s.holdoverAcquired[id]:
+			lockStatus = nl.DpllLockStatusHoldover
+		}
+		if lockStatus != d.LockStatus {
+			d.LockStatus = lockStatus
+			s.notifyDeviceLocked(nl.DpllCmdDeviceChangeNtf, d)
+		}
+	}
+}
+
+func (s *Simulator) notifyDeviceLocked(command uint8, d *nl.DoDeviceGetReply) {
+	if b, err := encodeDevice(d); err == nil {
+		s.multicastLocked(command, b)
+	}
+}
+
+func (s *Simulator) notifyPinLocked(command uint8, p *nl.PinInfo) {
+	if b, err := encodePin(p); err == nil {
+		s.multicastLocked(command, b)
+	}
+}
+
+// multicastLocked sends a notification to the sockets which joined the monitor group
+func (s *Simulator) multicastLocked(command uint8, data []byte) {
+	gm := genetlink.Message{
+		Header: genetlink.Header{Command: command, Version: simFamilyVersion},
+		Data:   data,
+	}
+	b, err := gm.MarshalBinary()
+	if err != nil {
+		return
+	}
+	for sock := range s.sockets {
+		if sock.joined(simMonitorGroupID) {
+			sock.queue([]netlink.Message{{Header: netlink.Header{Type: simFamilyID}, Data: b}})
+		}
+	}
+}
+
+func (s *Simulator) removeSocket(sock *simSocket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sockets, sock)
+}
+
+// replyMessage returns a reply of the command to a request
+func replyMessage(req netlink.Message, command uint8, flags netlink.HeaderFlags, data []byte) netlink.Message {
+	gm := genetlink.Message{
+		Header: genetlink.Header{Command: command, Version: simFamilyVersion},
+		Data:   data,
+	}
+	b, _ := gm.MarshalBinary() //nolint:errcheck // marshaling a genetlink message does not fail
+	return netlink.Message{
+		Header: netlink.Header{Type: simFamilyID, Flags: flags, Sequence: req.Header.Sequence, PID: req.Header.PID},
+		Data:   b,
+	}
+}
+
+// errorMessage returns the netlink error message of a request, errno 0 acknowledges it
+func errorMessage(req netlink.Message, errno syscall.Errno) netlink.Message {
+	data := nlenc.Int32Bytes(-int32(errno))
+	// the error message repeats the header of the request
+	header := make([]byte, 16)
+	nlenc.PutUint32(header[0:4], req.Header.Length)
+	nlenc.PutUint16(header[4:6], uint16(req.Header.Type))
+	nlenc.PutUint16(header[6:8], uint16(req.Header.Flags))
+	nlenc.PutUint32(header[8:12], req.Header.Sequence)
+	nlenc.PutUint32(header[12:16], req.Header.PID)
+	return netlink.Message{
+		Header: netlink.Header{Type: netlink.Error, Sequence: req.Header.Sequence, PID: req.Header.PID},
+		Data:   append(data, header...),
+	}
+}
+
+// encodeDevice encodes the attributes of a device as the kernel replies them
+func encodeDevice(d *nl.DoDeviceGetReply) ([]byte, error) {
+	ae := netlink.NewAttributeEncoder()
+	ae.Uint32(nl.DpllID, d.ID)
+	if d.ModuleName != "" {
+		ae.String(nl.DpllModuleName, d.ModuleName)
+	}
+	ae.Uint64(nl.DpllClockID, d.ClockID)
+	ae.Uint32(nl.DpllMode, d.Mode)
+	for _, m := range d.ModeSupported {
+		ae.Uint32(nl.DpllModeSupported, m)
+	}
+	ae.Uint32(nl.DpllLockStatus, d.LockStatus)
+	if d.LockStatusError != 0 {
+		ae.Uint32(nl.DpllLockStatusError, d.LockStatusError)
+	}
+	ae.Int32(nl.DpllTemp, d.Temp)
+	ae.Uint32(nl.DpllType, d.Type)
+	for _, cq := range d.ClockQualityLevel {
+		ae.Uint32(nl.DpllClockQualityLevel, cq)
+	}
+	if d.PhaseOffsetMonitor != 0 {
+		ae.Uint32(nl.DpllPhaseOffsetMonitor, d.PhaseOffsetMonitor)
+	}
+	if d.PhaseOffsetAverageFactor != 0 {
+		ae.Uint32(nl.DpllPhaseOffsetAverageFactor, d.PhaseOffsetAverageFactor)
+	}
+	if d.FrequencyMonitor != 0 {
+		ae.Uint32(nl.DpllFrequencyMonitor, d.FrequencyMonitor)
+	}
+	return ae.Encode()
+}
+
+// encodeSint encodes a signed value in the smallest of 4 and 8 bytes, like the kernel nla_put_sint
+func encodeSint(ae *netlink.AttributeEncoder, typ uint16, v int64) {
+	if v >= math.MinInt32 && v <= math.MaxInt32 {
+		ae.Int32(typ, int32(v))
+		return
+	}
+	ae.Int64(typ, v)
+}
+
+func encodeFrequencyRanges(ae *netlink.AttributeEncoder, typ uint16, ranges []nl.FrequencyRange) {
+	for _, fr := range ranges {
+		ae.Nested(typ, func(ae *netlink.AttributeEncoder) error {
+			ae.Uint64(nl.DpllPinFrequencyMin, fr.FrequencyMin)
+			ae.Uint64(nl.DpllPinFrequencyMax, fr.FrequencyMax)
+			return nil
+		})
+	}
+}
+
+// encodePin encodes the attributes of a pin as the kernel replies them. The
+// phase and frequency offsets are reported for inputs only.
+func encodePin(p *nl.PinInfo) ([]byte, error) {
+	ae := netlink.NewAttributeEncoder()
+	ae.Uint32(nl.DpllPinID, p.ID)
+	if p.ModuleName != "" {
+		ae.String(nl.DpllPinModuleName, p.ModuleName)
+	}
+	ae.Uint64(nl.DpllPinClockID, p.ClockID)
+	if p.BoardLabel != "" {
+		ae.String(nl.DpllPinBoardLabel, p.BoardLabel)
+	}
+	if p.PanelLabel != "" {
+		ae.String(nl.DpllPinPanelLabel, p.PanelLabel)
+	}
+	if p.PackageLabel != "" {
+		ae.String(nl.DpllPinPackageLabel, p.PackageLabel)
+	}
+	ae.Uint32(nl.DpllPinType, p.Type)
+	if p.Frequency != 0 {
+		ae.Uint64(nl.DpllPinFrequency, p.Frequency)
+		encodeFrequencyRanges(ae, nl.DpllPinFrequencySupported, p.FrequencySupported)
+	}
+	ae.Uint32(nl.DpllPinCapabilities, p.Capabilities)
+	input := false
+	for _, pd := range p.ParentDevice {
+		input = input || pd.Direction == nl.PinDirectionInput
+		ae.Nested(nl.DpllPinParentDevice, func(ae *netlink.AttributeEncoder) error {
+			ae.Uint32(nl.DpllPinParentID, pd.ParentID)
+			ae.Uint32(nl.DpllPinDirection, pd.Direction)
+			if pd.Prio != nil {
+				ae.Uint32(nl.DpllPinPrio, *pd.Prio)
+			}
+			ae.Uint32(nl.DpllPinState, pd.State)
+			if pd.Direction == nl.PinDirectionInput {
+				ae.Int64(nl.DpllPinPhaseOffset, pd.PhaseOffset)
+			}
+			if pd.Operstate != 0 {
+				ae.Uint32(nl.DpllPinOperstate, pd.Operstate)
+			}
+			return nil
+		})
+	}
+	for _, pp := range p.ParentPin {
+		ae.Nested(nl.DpllPinParentPin, func(ae *netlink.AttributeEncoder) error {
+			ae.Uint32(nl.DpllPinParentID, pp.ParentID)
+			ae.Uint32(nl.DpllPinState, pp.State)
+			return nil
+		})
+	}
+	ae.Int32(nl.DpllPinPhaseAdjustMin, p.PhaseAdjustMin)
+	ae.Int32(nl.DpllPinPhaseAdjustMax, p.PhaseAdjustMax)
+	if p.PhaseAdjustGran != 0 {
+		ae.Uint32(nl.DpllPinPhaseAdjustGran, p.PhaseAdjustGran)
+	}
+	ae.Int32(nl.DpllPinPhaseAdjust, p.PhaseAdjust)
+	if input {
+		encodeSint(ae, nl.DpllPinFractionalFrequencyOffset, int64(p.FractionalFrequencyOffset))
+		encodeSint(ae, nl.DpllPinFractionalFrequencyOffsetPPT, p.FractionalFrequencyOffsetPPT)
+	}
+	if p.EsyncFrequency != 0 {
+		ae.Uint64(nl.DpllPinEsyncFrequency, uint64(p.EsyncFrequency))
+		encodeFrequencyRanges(ae, nl.DpllPinEsyncFrequencySupported, p.EsyncFrequencySupported)
+		ae.Uint32(nl.DpllPinEsyncPulse, p.EsyncPulse)
+	}
+	for _, rs := range p.ReferenceSync {
+		ae.Nested(nl.DpllPinReferenceSync, func(ae *netlink.AttributeEncoder) error {
+			ae.Uint32(nl.DpllPinID, rs.ID)
+			ae.Uint32(nl.DpllPinState, rs.State)
+			return nil
+		})
+	}
+	if p.MeasuredFrequency != 0 {
+		ae.Uint64(nl.DpllPinMeasuredFrequency, p.MeasuredFrequency)
+	}
+	return ae.Encode()
+}
+
+func copyDevice(d *nl.DoDeviceGetReply) *nl.DoDeviceGetReply {
+	c := *d
+	c.ModeSupported = append([]uint32(nil), d.ModeSupported...)
+	c.ClockQualityLevel = append([]uint32(nil), d.ClockQualityLevel...)
+	return &c
+}
+
+func copyPin(p *nl.PinInfo) *nl.PinInfo {
+	c := *p
+	c.FrequencySupported = append([]nl.FrequencyRange(nil), p.FrequencySupported...)
+	c.EsyncFrequencySupported = append([]nl.FrequencyRange(nil), p.EsyncFrequencySupported...)
+	c.ParentPin = append([]nl.PinParentPin(nil), p.ParentPin...)
+	c.ReferenceSync = append([]nl.ReferenceSync(nil), p.ReferenceSync...)
+	c.ParentDevice = append([]nl.PinParentDevice(nil), p.ParentDevice...)
+	for i := range c.ParentDevice {
+		if prio := c.ParentDevice[i].Prio; prio != nil {
+			v := *prio
+			c.ParentDevice[i].Prio = &v
+		}
+	}
+	return &c
+}
+
+func parentDevice(p *nl.PinInfo, deviceID uint32) *nl.PinParentDevice {
+	for i := range p.ParentDevice {
+		if p.ParentDevice[i].ParentID == deviceID {
+			return &p.ParentDevice[i]
+		}
+	}
+	return nil
+}
+
+func parentIDs(p *nl.PinInfo) []uint32 {
+	ids := make([]uint32, 0, len(p.ParentDevice))
+	for _, pd := range p.ParentDevice {
+		ids = append(ids, pd.ParentID)
+	}
+	return ids
+}
+
+func inRanges(ranges []nl.FrequencyRange, f uint64) bool {
+	for _, fr := range ranges {
+		if f >= fr.FrequencyMin && f <= fr.FrequencyMax {
+			return true
+		}
+	}
+	return false
+}
+
+func containsUint32(s []uint32, v uint32) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// simSocket is a netlink socket connected to a simulator
+type simSocket struct {
+	sim          *Simulator
+	pid          uint32
+	mu           sync.Mutex
+	cond         *sync.Cond
+	queued       [][]netlink.Message
+	groups       map[uint32]bool
+	readDeadline time.Time
+	closed       bool
+	// overrun drops the notifications, once one is dropped the next Receive fails with ENOBUFS
+	overrun bool
+	dropped bool
+}
+
+func (sock *simSocket) queue(msgs []netlink.Message) {
+	sock.mu.Lock()
+	defer sock.mu.Unlock()
+	if sock.closed {
+		return
+	}
+	if sock.overrun {
+		sock.dropped = true
+	} else {
+		sock.queued = append(sock.queued, msgs)
+	}
+	sock.cond.Broadcast()
+}
+
+func (sock *simSocket) joined(group uint32) bool {
+	sock.mu.Lock()
+	defer sock.mu.Unlock()
+	return sock.groups[group]
+}
+
+// Send implements netlink.Socket
+func (sock *simSocket) Send(m netlink.Message) error {
+	sock.mu.Lock()
+	closed := sock.closed
+	sock.mu.Unlock()
+	if closed {
+		return errSimClosed
+	}
+	sock.sim.handle(sock, m)
+	return nil
+}
+
+// SendMessages implements netlink.Socket
+func (sock *simSocket) SendMessages(msgs []netlink.Message) error {
+	for _, m := range msgs {
+		if err := sock.Send(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Receive implements netlink.Socket, it blocks until messages are queued,
+// the read deadline passes or the socket is closed
+func (sock *simSocket) Receive() ([]netlink.Message, error) {
+	sock.mu.Lock()
+	defer sock.mu.Unlock()
+	if !sock.readDeadline.IsZero() {
+		timer := time.AfterFunc(time.Until(sock.readDeadline), func() {
+			sock.mu.Lock()
+			defer sock.mu.Unlock()
+			sock.cond.Broadcast()
+		})
+		defer timer.Stop()
+	}
+	for len(sock.queued) == 0 && !sock.closed && !sock.dropped {
+		if !sock.readDeadline.IsZero() && !time.Now().Before(sock.readDeadline) {
+			return nil, os.ErrDeadlineExceeded
+		}
+		sock.cond.Wait()
+	}
+	if sock.closed {
+		return nil, errSimClosed
+	}
+	if sock.dropped {
+		sock.overrun, sock.dropped = false, false
+		return nil, syscall.ENOBUFS
+	}
+	msgs := sock.queued[0]
+	sock.queued = sock.queued[1:]
+	return msgs, nil
+}
+
+// Close implements netlink.Socket
+func (sock *simSocket) Close() error {
+	sock.mu.Lock()
+	sock.closed = true
+	sock.queued = nil
+	sock.cond.Broadcast()
+	sock.mu.Unlock()
+	sock.sim.removeSocket(sock)
+	return nil
+}
+
+// SetDeadline sets the read deadline, requests are served synchronously
+func (sock *simSocket) SetDeadline(t time.Time) error {
+	return sock.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the deadline of Receive
+func (sock *simSocket) SetReadDeadline(t time.Time) error {
+	sock.mu.Lock()
+	defer sock.mu.Unlock()
+	sock.readDeadline = t
+	return nil
+}
+
+// SetWriteDeadline does nothing, requests are served synchronously
+func (sock *simSocket) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// JoinGroup joins a multicast group of the simulator
+func (sock *simSocket) JoinGroup(group uint32) error {
+	if group != simMonitorGroupID {
+		return syscall.EINVAL
+	}
+	sock.mu.Lock()
+	defer sock.mu.Unlock()
+	sock.groups[group] = true
+	return nil
+}
+
+// LeaveGroup leaves a multicast group of the simulator
+func (sock *simSocket) LeaveGroup(group uint32) error {
+	sock.mu.Lock()
+	defer sock.mu.Unlock()
+	delete(sock.groups, group)
+	return nil
+}
@@ -0,0 +1,287 @@
+package dplltest
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/mdlayher/genetlink"
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	nl "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+)
+
+const simClockID uint64 = 0x507c6fffff1fb1b8
+
+func uint32Ptr(v uint32) *uint32 { return &v }
+
+// newTestSimulator returns a simulator of an E810 like board: EEC and PPS
+// DPLLs fed by a GNSS and an SMA input, and an SDP output
+func newTestSimulator(t *testing.T) (s *Simulator, eec, pps, gnss, sma, sdp uint32) {
+	t.Helper()
+	s = NewSimulator()
+	eec = s.AddDevice(nl.DoDeviceGetReply{ModuleName: "ice", ClockID: simClockID, Type: nl.DpllTypeEEC})
+	pps = s.AddDevice(nl.DoDeviceGetReply{ModuleName: "ice", ClockID: simClockID, Type: nl.DpllTypePPS})
+	input := func(label string, prio uint32) nl.PinInfo {
+		return nl.PinInfo{
+			ModuleName: "ice", ClockID: simClockID, BoardLabel: label, Type: nl.PinTypeEXT,
+			Capabilities:   nl.PinCapPrio | nl.PinCapState,
+			PhaseAdjustMin: -16723, PhaseAdjustMax: 16723,
+			ParentDevice: []nl.PinParentDevice{
+				{ParentID: eec, Direction: nl.PinDirectionInput, Prio: uint32Ptr(prio)},
+				{ParentID: pps, Direction: nl.PinDirectionInput, Prio: uint32Ptr(prio)},
+			},
+		}
+	}
+	var err error
+	gnss, err = s.AddPin(input("GNSS-1PPS", 0))
+	require.NoError(t, err)
+	sma, err = s.AddPin(input("SMA1", 3))
+	require.NoError(t, err)
+	sdp, err = s.AddPin(nl.PinInfo{
+		ModuleName: "ice", ClockID: simClockID, BoardLabel: "CVL-SDP22", Type: nl.PinTypeEXT,
+		Frequency: 1, FrequencySupported: []nl.FrequencyRange{{FrequencyMin: 1, FrequencyMax: 1}}, Capabilities: nl.PinCapState,
+		PhaseAdjustMin: -16723, PhaseAdjustMax: 16723, PhaseAdjustGran: 8,
+		ParentDevice: []nl.PinParentDevice{{ParentID: pps, Direction: nl.PinDirectionOutput, State: nl.PinStateConnected}},
+	})
+	require.NoError(t, err)
+	return
+}
+
+// receiveNotifications returns the devices and pins of the next notification received by a monitoring connection
+func receiveNotifications(t *testing.T, c *nl.Conn) ([]*nl.DoDeviceGetReply, []*nl.PinInfo) {
+	t.Helper()
+	msgs, _, err := c.GetGenetlinkConn().Receive()
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	switch msgs[0].Header.Command {
+	case nl.DpllCmdDeviceChangeNtf:
+		devices, err := nl.ParseDeviceReplies(msgs)
+		require.NoError(t, err)
+		return devices, nil
+	case nl.DpllCmdPinChangeNtf:
+		pins, err := nl.ParsePinReplies(msgs)
+		require.NoError(t, err)
+		return nil, pins
+	}
+	t.Fatalf("unexpected notification %d", msgs[0].Header.Command)
+	return nil, nil
+}
+
+func monitor(t *testing.T, s *Simulator) *nl.Conn {
+	t.Helper()
+	c := s.Dial()
+	t.Cleanup(func() { c.Close() })
+	id, found := c.GetMcastGroupID(nl.DpllMCGRPMonitor)
+	require.True(t, found)
+	require.NoError(t, c.GetGenetlinkConn().JoinGroup(id))
+	return c
+}
+
+func TestSimulator_Get(t *testing.T) {
+	s, eec, pps, gnss, _, sdp := newTestSimulator(t)
+	c := s.Dial()
+	defer c.Close()
+
+	devices, err := c.DumpDeviceGet()
+	require.NoError(t, err)
+	require.Len(t, devices, 2)
+	assert.Equal(t, eec, devices[0].ID)
+	assert.Equal(t, uint32(nl.DpllTypeEEC), devices[0].Type)
+	assert.Equal(t, pps, devices[1].ID)
+	assert.Equal(t, simClockID, devices[1].ClockID)
+	assert.Equal(t, uint32(nl.DpllModeAutomatic), devices[1].Mode)
+	assert.Equal(t, uint32(nl.DpllLockStatusUnlocked), devices[1].LockStatus)
+
+	pins, err := c.DumpPinGet()
+	require.NoError(t, err)
+	require.Len(t, pins, 3)
+	assert.Equal(t, "GNSS-1PPS", pins[gnss].BoardLabel)
+	require.Len(t, pins[gnss].ParentDevice, 2)
+	assert.Equal(t, uint32(0), *pins[gnss].ParentDevice[0].Prio)
+	assert.Equal(t, uint32(nl.PinStateSelectable), pins[gnss].ParentDevice[0].State)
+	assert.Equal(t, uint32(nl.PinOperstateNoSignal), pins[gnss].ParentDevice[0].Operstate)
+
+	pin, err := c.DoPinGet(nl.DoPinGetRequest{ID: sdp})
+	require.NoError(t, err)
+	assert.Equal(t, "CVL-SDP22", pin.BoardLabel)
+	assert.Equal(t, uint32(nl.PinStateConnected), pin.ParentDevice[0].State)
+
+	device, err := c.DoDeviceGet(nl.DoDeviceGetRequest{ID: pps})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(nl.DpllTypePPS), device.Type)
+
+	_, err = c.DoPinGet(nl.DoPinGetRequest{ID: 42})
+	assert.ErrorIs(t, err, syscall.ENODEV)
+
+	s.FailNext(nl.DpllCmdDeviceGet, syscall.EBUSY)
+	_, err = c.DumpDeviceGet()
+	assert.ErrorIs(t, err, syscall.EBUSY)
+	_, err = c.DumpDeviceGet()
+	assert.NoError(t, err, "only the next request fails")
+}
+
+func TestSimulator_ReferenceSelection(t *testing.T) {
+	s, eec, pps, gnss, sma, _ := newTestSimulator(t)
+	m := monitor(t, s)
+	c := s.Dial()
+	defer c.Close()
+
+	// GNSS is the highest priority input
+	require.NoError(t, s.SetSignal(sma, true))
+	_, pins := receiveNotifications(t, m)
+	assert.Equal(t, uint32(nl.PinStateConnected), pins[0].ParentDevice[0].State)
+	devices, _ := receiveNotifications(t, m)
+	assert.Equal(t, eec, devices[0].ID)
+	assert.Equal(t, uint32(nl.DpllLockStatusLockedHoldoverAcquired), devices[0].LockStatus)
+	receiveNotifications(t, m)
+	receiveNotifications(t, m)
+
+	require.NoError(t, s.SetSignal(gnss, true))
+	for _, id := range []uint32{eec, pps} {
+		_, pins = receiveNotifications(t, m)
+		assert.Equal(t, gnss, pins[0].ID)
+		assert.Equal(t, uint32(nl.PinStateConnected), pins[0].ParentDevice[id].State)
+		_, pins = receiveNotifications(t, m)
+		assert.Equal(t, sma, pins[0].ID)
+		assert.Equal(t, uint32(nl.PinStateSelectable), pins[0].ParentDevice[id].State)
+		assert.Equal(t, uint32(nl.PinOperstateStandby), pins[0].ParentDevice[id].Operstate)
+	}
+
+	// raising the priority of SMA over GNSS switches the reference
+	b, err := nl.EncodePinControl(nl.PinParentDeviceCtl{ID: sma, PinParentCtl: []nl.PinControl{{PinParentID: pps, Prio: uint32Ptr(0)}}})
+	require.NoError(t, err)
+	require.NoError(t, c.SendCommand(nl.DpllCmdPinSet, b))
+	b, err = nl.EncodePinControl(nl.PinParentDeviceCtl{ID: gnss, PinParentCtl: []nl.PinControl{{PinParentID: pps, Prio: uint32Ptr(4)}}})
+	require.NoError(t, err)
+	require.NoError(t, c.SendCommand(nl.DpllCmdPinSet, b))
+	pin, err := c.DoPinGet(nl.DoPinGetRequest{ID: sma})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(nl.PinStateSelectable), pin.ParentDevice[eec].State)
+	assert.Equal(t, uint32(nl.PinStateConnected), pin.ParentDevice[pps].State)
+
+	// both DPLLs were locked, they go to holdover when they lose their inputs
+	require.NoError(t, s.SetSignal(sma, false))
+	require.NoError(t, s.SetSignal(gnss, false))
+	device, found := s.Device(pps)
+	require.True(t, found)
+	assert.Equal(t, uint32(nl.DpllLockStatusHoldover), device.LockStatus)
+	device, _ = s.Device(eec)
+	assert.Equal(t, uint32(nl.DpllLockStatusHoldover), device.LockStatus)
+
+	// phase offsets are reported per parent device
+	require.NoError(t, s.SetPhaseOffset(gnss, pps, -1500))
+	require.NoError(t, s.SetFractionalFrequencyOffset(gnss, -3000000))
+	pin, err = c.DoPinGet(nl.DoPinGetRequest{ID: gnss})
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1500), pin.ParentDevice[pps].PhaseOffset)
+	assert.Equal(t, int64(0), pin.ParentDevice[eec].PhaseOffset)
+	assert.Equal(t, int64(-3000000), pin.FractionalFrequencyOffsetPPT)
+	assert.Equal(t, -3, pin.FractionalFrequencyOffset)
+}
+
+func TestSimulator_PinSet(t *testing.T) {
+	s, _, pps, gnss, _, sdp := newTestSimulator(t)
+	c := s.Dial()
+	defer c.Close()
+	pinSet := func(req nl.PinParentDeviceCtl) error {
+		b, err := nl.EncodePinControl(req)
+		require.NoError(t, err)
+		family := c.GetGenetlinkFamily()
+		msg := genetlink.Message{Header: genetlink.Header{Command: nl.DpllCmdPinSet, Version: family.Version}, Data: b}
+		_, err = c.GetGenetlinkConn().Execute(msg, family.ID, netlink.Request|netlink.Acknowledge)
+		return err
+	}
+	phaseAdjust := func(v int32) *int32 { return &v }
+
+	assert.NoError(t, pinSet(nl.PinParentDeviceCtl{ID: sdp, PhaseAdjust: phaseAdjust(-16720)}))
+	pin, _ := s.Pin(sdp)
+	assert.Equal(t, int32(-16720), pin.PhaseAdjust)
+	assert.ErrorIs(t, pinSet(nl.PinParentDeviceCtl{ID: sdp, PhaseAdjust: phaseAdjust(20000)}), syscall.EINVAL, "out of range")
+	assert.ErrorIs(t, pinSet(nl.PinParentDeviceCtl{ID: sdp, PhaseAdjust: phaseAdjust(12)}), syscall.EINVAL, "not a multiple of the granularity")
+
+	freq := uint64(10)
+	assert.ErrorIs(t, pinSet(nl.PinParentDeviceCtl{ID: sdp, Frequency: &freq}), syscall.EINVAL, "unsupported frequency")
+	assert.ErrorIs(t, pinSet(nl.PinParentDeviceCtl{ID: sdp, PinParentCtl: []nl.PinControl{{PinParentID: pps, Prio: uint32Ptr(1)}}}),
+		syscall.EOPNOTSUPP, "priority can't change")
+	assert.ErrorIs(t, pinSet(nl.PinParentDeviceCtl{ID: sdp, PinParentCtl: []nl.PinControl{{PinParentID: 7, State: uint32Ptr(nl.PinStateDisconnected)}}}),
+		syscall.EINVAL, "not a parent device")
+	assert.ErrorIs(t, pinSet(nl.PinParentDeviceCtl{ID: gnss, PinParentCtl: []nl.PinControl{{PinParentID: pps, State: uint32Ptr(nl.PinStateConnected)}}}),
+		syscall.EINVAL, "inputs of automatic devices are connected by the reference selection")
+
+	assert.NoError(t, pinSet(nl.PinParentDeviceCtl{ID: sdp, PinParentCtl: []nl.PinControl{{PinParentID: pps, State: uint32Ptr(nl.PinStateDisconnected)}}}))
+	pin, _ = s.Pin(sdp)
+	assert.Equal(t, uint32(nl.PinStateDisconnected), pin.ParentDevice[0].State)
+	assert.Equal(t, int32(-16720), pin.PhaseAdjust, "failed requests change nothing")
+}
+
+func TestSimulator_DeviceMode(t *testing.T) {
+	s, eec, pps, gnss, sma, _ := newTestSimulator(t)
+	c := s.Dial()
+	defer c.Close()
+	require.NoError(t, s.SetSignal(gnss, true))
+	require.NoError(t, s.SetSignal(sma, true))
+
+	err := c.SelectInput(pps, sma)
+	assert.EqualError(t, err, "dpll 1 is in automatic mode, inputs can only be selected in manual mode")
+	err = c.SetDeviceMode(pps, nl.DpllModeManual)
+	assert.ErrorIs(t, err, syscall.EOPNOTSUPP, "manual mode isn't supported")
+
+	s.devices[pps].ModeSupported = []uint32{nl.DpllModeManual, nl.DpllModeAutomatic}
+	require.NoError(t, c.SetDeviceMode(pps, nl.DpllModeManual))
+	device, err := c.DoDeviceGet(nl.DoDeviceGetRequest{ID: pps})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(nl.DpllModeManual), device.Mode)
+
+	// the manually selected input replaces the reference of the automatic selection
+	require.NoError(t, c.SelectInput(pps, sma))
+	pin, _ := s.Pin(sma)
+	assert.Equal(t, uint32(nl.PinStateConnected), pin.ParentDevice[pps].State)
+	assert.Equal(t, uint32(nl.PinOperstateActive), pin.ParentDevice[pps].Operstate)
+	pin, _ = s.Pin(gnss)
+	assert.Equal(t, uint32(nl.PinStateDisconnected), pin.ParentDevice[pps].State)
+	assert.Equal(t, uint32(nl.PinStateConnected), pin.ParentDevice[eec].State, "the EEC DPLL is not affected")
+
+	s.FailNext(nl.DpllCmdPinSet, syscall.EBUSY)
+	assert.ErrorIs(t, c.SelectInput(pps, gnss), syscall.EBUSY)
+	assert.Error(t, c.SelectInput(pps, 42))
+
+	// automatic mode selects the highest priority input again
+	require.NoError(t, c.SetDeviceMode(pps, nl.DpllModeAutomatic))
+	assert.NoError(t, c.DoPinSet(nl.PinParentDeviceCtl{ID: gnss, PinParentCtl: []nl.PinControl{{PinParentID: pps, State: uint32Ptr(nl.PinStateSelectable)}}}))
+	pin, _ = s.Pin(gnss)
+	assert.Equal(t, uint32(nl.PinStateConnected), pin.ParentDevice[pps].State)
+	pin, _ = s.Pin(sma)
+	assert.Equal(t, uint32(nl.PinStateSelectable), pin.ParentDevice[pps].State)
+}
+
+func TestSimulator_Overrun(t *testing.T) {
+	s, _, pps, gnss, sma, _ := newTestSimulator(t)
+	m := monitor(t, s)
+	require.NoError(t, s.SetSignal(sma, true))
+
+	s.Overrun()
+	require.NoError(t, s.SetSignal(gnss, true))
+	_, _, err := m.GetGenetlinkConn().Receive()
+	assert.True(t, nl.IsOverrun(err), "the notifications were dropped: %v", err)
+	assert.False(t, nl.IsOverrun(errors.New("netlink receive: use of closed file")))
+
+	// the socket receives the next notifications
+	require.NoError(t, s.SetPhaseOffset(gnss, pps, 1000))
+	_, pins := receiveNotifications(t, m)
+	assert.Equal(t, gnss, pins[0].ID)
+}
+
+func TestSimulator_Close(t *testing.T) {
+	s := NewSimulator()
+	m := monitor(t, s)
+	done := make(chan error)
+	go func() {
+		_, _, err := m.GetGenetlinkConn().Receive()
+		done <- err
+	}()
+	require.NoError(t, m.Close())
+	assert.EqualError(t, <-done, "netlink receive: use of closed file")
+}
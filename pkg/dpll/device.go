@@ -0,0 +1,162 @@
+package dpll
+
+import (
+	"slices"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	nl "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+)
+
+// DeviceMetrics are the per DPLL device metrics, with the iface and type labels
+type DeviceMetrics struct {
+	LockStatus       *prometheus.GaugeVec
+	HoldoverAcquired *prometheus.GaugeVec
+	Mode             *prometheus.GaugeVec
+}
+
+// DevicePin is an input pin feeding a DPLL device
+type DevicePin struct {
+	ID        uint32  `json:"id"`
+	Label     string  `json:"label"`
+	State     string  `json:"state"`
+	Operstate string  `json:"operstate,omitempty"`
+	Prio      *uint32 `json:"prio,omitempty"`
+	// PhaseOffset is the phase offset between the pin and the device, in ns
+	PhaseOffset int64 `json:"phaseOffset"`
+}
+
+// DeviceState is the state of one of the physical DPLL devices of a clock, e.g. its EEC or PPS DPLL
+type DeviceState struct {
+	ID         uint32 `json:"id"`
+	Type       string `json:"type"`
+	Mode       string `json:"mode"`
+	LockStatus string `json:"lockStatus"`
+	// HoldoverAcquired is set when the device can enter holdover, or is in holdover
+	HoldoverAcquired bool        `json:"holdoverAcquired"`
+	Pins             []DevicePin `json:"pins"`
+}
+
+// dpllDevice is the last state of a DPLL device seen in the DPLL notifications
+type dpllDevice struct {
+	id         uint32
+	dpllType   uint32
+	mode       uint32
+	lockStatus uint32
+	pins       map[uint32]DevicePin
+}
+
+func (dev *dpllDevice) holdoverAcquired() bool {
+	return dev.lockStatus == DPLL_LOCKED_HO_ACQ || dev.lockStatus == DPLL_HOLDOVER
+}
+
+// SetDeviceMetrics sets the metrics exporting the state of every DPLL device of the clock
+func (d *DpllConfig) SetDeviceMetrics(metrics *DeviceMetrics) {
+	d.deviceMetrics = metrics
+}
+
+// Devices returns the state of the DPLL devices of the clock, ordered by device ID
+func (d *DpllConfig) Devices() []DeviceState {
+	d.Lock()
+	defer d.Unlock()
+	states := make([]DeviceState, 0, len(d.dpllDevices))
+	for _, dev := range d.dpllDevices {
+		state := DeviceState{
+			ID:               dev.id,
+			Type:             nl.GetDpllType(dev.dpllType),
+			Mode:             nl.GetMode(dev.mode),
+			LockStatus:       nl.GetLockStatus(dev.lockStatus),
+			HoldoverAcquired: dev.holdoverAcquired(),
+			Pins:             make([]DevicePin, 0, len(dev.pins)),
+		}
+		for _, pin := range dev.pins {
+			state.Pins = append(state.Pins, pin)
+		}
+		slices.SortFunc(state.Pins, func(a, b DevicePin) int { return int(a.ID) - int(b.ID) })
+		states = append(states, state)
+	}
+	slices.SortFunc(states, func(a, b DeviceState) int { return int(a.ID) - int(b.ID) })
+	return states
+}
+
+// updateDevice records the state of a DPLL device of the clock. The mode is
+// not part of every notification, a missing mode keeps the previous one.
+func (d *DpllConfig) updateDevice(reply *nl.DoDeviceGetReply) {
+	if d.dpllDevices == nil {
+		d.dpllDevices = map[uint32]*dpllDevice{}
+	}
+	dev, found := d.dpllDevices[reply.ID]
+	if !found {
+		dev = &dpllDevice{id: reply.ID, pins: map[uint32]DevicePin{}}
+		d.dpllDevices[reply.ID] = dev
+	}
+	if found && dev.lockStatus != reply.LockStatus {
+		glog.Infof("%s (%#x) %s DPLL %d lock status %s -> %s", d.iface, d.clockId, nl.GetDpllType(reply.Type),
+			reply.ID, nl.GetLockStatus(dev.lockStatus), nl.GetLockStatus(reply.LockStatus))
+	}
+	dev.dpllType = reply.Type
+	dev.lockStatus = reply.LockStatus
+	if reply.Mode != 0 {
+		dev.mode = reply.Mode
+	}
+	d.exportDeviceMetrics(dev)
+}
+
+// updateDevicePins records an input pin of the clock in the devices it feeds,
+// and removes it from the devices it no longer feeds
+func (d *DpllConfig) updateDevicePins(pin *nl.PinInfo) {
+	for _, dev := range d.dpllDevices {
+		var parent *nl.PinParentDevice
+		for i := range pin.ParentDevice {
+			if pin.ParentDevice[i].ParentID == dev.id {
+				parent = &pin.ParentDevice[i]
+				break
+			}
+		}
+		if parent == nil || parent.Direction != nl.PinDirectionInput {
+			delete(dev.pins, pin.ID)
+			continue
+		}
+		devicePin := DevicePin{
+			ID:          pin.ID,
+			Label:       nl.GetPinLabel(pin),
+			State:       nl.GetPinState(parent.State),
+			PhaseOffset: parent.PhaseOffset / nl.DpllPhaseOffsetDivider / 1000,
+		}
+		if parent.Operstate != 0 {
+			devicePin.Operstate = nl.GetPinOperstate(parent.Operstate)
+		}
+		if parent.Prio != nil {
+			prio := *parent.Prio
+			devicePin.Prio = &prio
+		}
+		dev.pins[pin.ID] = devicePin
+	}
+}
+
+func (d *DpllConfig) exportDeviceMetrics(dev *dpllDevice) {
+	if d.deviceMetrics == nil {
+		return
+	}
+	labels := prometheus.Labels{"iface": d.iface, "type": nl.GetDpllType(dev.dpllType)}
+	d.deviceMetrics.LockStatus.With(labels).Set(float64(dev.lockStatus))
+	holdoverAcquired := 0.0
+	if dev.holdoverAcquired() {
+		holdoverAcquired = 1
+	}
+	d.deviceMetrics.HoldoverAcquired.With(labels).Set(holdoverAcquired)
+	if dev.mode != 0 {
+		d.deviceMetrics.Mode.With(labels).Set(float64(dev.mode))
+	}
+}
+
+func (d *DpllConfig) deleteDeviceMetrics() {
+	if d.deviceMetrics == nil {
+		return
+	}
+	labels := prometheus.Labels{"iface": d.iface}
+	d.deviceMetrics.LockStatus.DeletePartialMatch(labels)
+	d.deviceMetrics.HoldoverAcquired.DeletePartialMatch(labels)
+	d.deviceMetrics.Mode.DeletePartialMatch(labels)
+}
@@ -0,0 +1,90 @@
+package dpll
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	nl "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+)
+
+func TestDpllDevices(t *testing.T) {
+	const (
+		clockID uint64 = 0xAABBCCDD
+		eec     uint32 = 0
+		pps     uint32 = 1
+	)
+	metrics := &DeviceMetrics{
+		LockStatus:       prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_lock_status"}, []string{"iface", "type"}),
+		HoldoverAcquired: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_holdover_acquired"}, []string{"iface", "type"}),
+		Mode:             prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_mode"}, []string{"iface", "type"}),
+	}
+	d := &DpllConfig{
+		clockId: clockID,
+		iface:   "ens1f0",
+		exitCh:  make(chan struct{}),
+		ticker:  time.NewTicker(time.Hour),
+	}
+	d.SetDeviceMetrics(metrics)
+
+	devices := []*nl.DoDeviceGetReply{
+		{ID: eec, ClockID: clockID, Type: nl.DpllTypeEEC, Mode: nl.DpllModeAutomatic, LockStatus: DPLL_LOCKED_HO_ACQ},
+		{ID: pps, ClockID: clockID, Type: nl.DpllTypePPS, Mode: nl.DpllModeAutomatic, LockStatus: DPLL_LOCKED_HO_ACQ},
+		{ID: 2, ClockID: 0x11223344, Type: nl.DpllTypePPS, LockStatus: DPLL_FREERUN},
+	}
+	prio := uint32(0)
+	gnss := &nl.PinInfo{
+		ID: 10, ClockID: clockID, BoardLabel: "GNSS-1PPS",
+		ParentDevice: []nl.PinParentDevice{
+			{ParentID: eec, Direction: nl.PinDirectionInput, State: nl.PinStateConnected, Prio: &prio},
+			{ParentID: pps, Direction: nl.PinDirectionInput, State: nl.PinStateConnected, Prio: &prio, PhaseOffset: 3 * 1000 * nl.DpllPhaseOffsetDivider},
+		},
+	}
+	synce := &nl.PinInfo{
+		ID: 11, ClockID: clockID, PackageLabel: "RCLKA",
+		ParentDevice: []nl.PinParentDevice{{ParentID: eec, Direction: nl.PinDirectionInput, State: nl.PinStateSelectable, Operstate: nl.PinOperstateStandby}},
+	}
+	output := &nl.PinInfo{
+		ID: 12, ClockID: clockID, BoardLabel: "SMA2",
+		ParentDevice: []nl.PinParentDevice{{ParentID: pps, Direction: nl.PinDirectionOutput, State: nl.PinStateConnected}},
+	}
+
+	d.nlUpdateState(devices, nil)
+	d.nlUpdateState(nil, []*nl.PinInfo{gnss, synce, output})
+	states := d.Devices()
+	require.Len(t, states, 2, "only the devices of the clock")
+	assert.Equal(t, DeviceState{
+		ID: eec, Type: "eec", Mode: "automatic", LockStatus: "locked-ho-acquired", HoldoverAcquired: true,
+		Pins: []DevicePin{
+			{ID: 10, Label: "GNSS-1PPS", State: "connected", Prio: &prio},
+			{ID: 11, Label: "RCLKA", State: "selectable", Operstate: "standby"},
+		},
+	}, states[0])
+	assert.Equal(t, []DevicePin{{ID: 10, Label: "GNSS-1PPS", State: "connected", Prio: &prio, PhaseOffset: 3}}, states[1].Pins,
+		"outputs don't feed the device")
+
+	// the PPS DPLL loses lock while the EEC holds, the mode is not part of the notification
+	d.nlUpdateState([]*nl.DoDeviceGetReply{{ID: pps, ClockID: clockID, Type: nl.DpllTypePPS, LockStatus: DPLL_FREERUN}}, nil)
+	gnss.ParentDevice = gnss.ParentDevice[:1]
+	d.nlUpdateState(nil, []*nl.PinInfo{gnss})
+	states = d.Devices()
+	assert.Equal(t, "unlocked", states[1].LockStatus)
+	assert.False(t, states[1].HoldoverAcquired)
+	assert.Equal(t, "automatic", states[1].Mode)
+	assert.Empty(t, states[1].Pins, "GNSS no longer feeds the PPS DPLL")
+	assert.Len(t, states[0].Pins, 2)
+
+	assert.Equal(t, float64(DPLL_LOCKED_HO_ACQ), testutil.ToFloat64(metrics.LockStatus.WithLabelValues("ens1f0", "eec")))
+	assert.Equal(t, float64(DPLL_FREERUN), testutil.ToFloat64(metrics.LockStatus.WithLabelValues("ens1f0", "pps")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.HoldoverAcquired.WithLabelValues("ens1f0", "eec")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.HoldoverAcquired.WithLabelValues("ens1f0", "pps")))
+	assert.Equal(t, float64(nl.DpllModeAutomatic), testutil.ToFloat64(metrics.Mode.WithLabelValues("ens1f0", "pps")))
+
+	d.CmdStop()
+	assert.Zero(t, testutil.CollectAndCount(metrics.LockStatus))
+	assert.Zero(t, testutil.CollectAndCount(metrics.Mode))
+}
@@ -15,8 +15,9 @@ import (
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/config"
 	nl "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/utils"
 	"github.com/mdlayher/genetlink"
-	"golang.org/x/sync/semaphore"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
@@ -35,6 +36,7 @@ const (
 	LocalMaxHoldoverOffSetStr = "LocalMaxHoldoverOffSet"
 	LocalHoldoverTimeoutStr   = "LocalHoldoverTimeout"
 	MaxInSpecOffsetStr        = "MaxInSpecOffset"
+	MaxFrequencyOffsetStr     = "MaxFrequencyOffset" // ppb, maximum fractional frequency offset of a locked reference
 	ClockIdStr                = "clockId"
 	FaultyPhaseOffset         = 99999999999
 
@@ -42,6 +44,14 @@ const (
 	PPS_PIN_INDEX = 1
 )
 
+// Reasons of the netlink resubscriptions
+const (
+	// ResyncReasonOverrun is an overrun of the notification socket, notifications were dropped
+	ResyncReasonOverrun = "overrun"
+	// ResyncReasonError is any other failure of the notification socket
+	ResyncReasonError = "error"
+)
+
 // Flag is a bitmask which changes the default DPLL monitoeing  behavior
 type Flag uint64
 
@@ -99,6 +109,7 @@ type DpllConfig struct {
 	onHoldover             bool
 	closing                bool
 	sourceLost             bool
+	offsetInRange          bool // result of the last offset check, for the threshold hysteresis
 	processConfig          config.ProcessConfig
 	dependsOn              []event.EventSource
 	exitCh                 chan struct{}
@@ -112,6 +123,18 @@ type DpllConfig struct {
 	phaseStatus     int64
 	frequencyStatus int64
 	phaseOffset     int64
+	// frequencyOffset is the fractional frequency offset of the active reference, in ppt
+	frequencyOffset int64
+	// frequencyOffsetPinID is the ID of the active reference pin frequencyOffset was read from, if any
+	frequencyOffsetPinID *uint32
+	// pinFrequencyOffsets holds the last fractional frequency offset of the input pins, in ppt, by board label
+	pinFrequencyOffsets map[string]int64
+	// maxFrequencyOffset is the maximum frequency offset of a locked reference, in ppb. 0 disables the check
+	maxFrequencyOffset uint64
+	// pinFrequencyOffsetMetric exports pinFrequencyOffsets, with the iface and pin labels
+	pinFrequencyOffsetMetric *prometheus.GaugeVec
+	// qualifier ranks all the input pins as reference candidates, when configured
+	qualifier *PinQualifier
 
 	// clockId is needed to distinguish between DPLL associated with the particular
 	// iface from other DPLL units that might be present on the system. Clock ID implementation
@@ -131,6 +154,14 @@ type DpllConfig struct {
 
 	// devices holds the cache of DPLL device replies
 	devices []*nl.DoDeviceGetReply
+	// dpllDevices models each DPLL device of the clock, by device ID
+	dpllDevices map[uint32]*dpllDevice
+	// deviceMetrics exports dpllDevices, with the iface and type labels
+	deviceMetrics *DeviceMetrics
+	// resyncMetric counts the netlink resubscriptions, with the iface and reason labels
+	resyncMetric *prometheus.CounterVec
+	// dial opens the DPLL netlink connections
+	dial nl.Dialer
 }
 
 func (d *DpllConfig) InSpec() bool {
@@ -182,6 +213,56 @@ func (d *DpllConfig) PhaseOffset() int64 {
 	return d.phaseOffset
 }
 
+// FrequencyOffset returns the fractional frequency offset of the active reference, in ppt
+func (d *DpllConfig) FrequencyOffset() int64 {
+	d.Lock()
+	defer d.Unlock()
+	return d.frequencyOffset
+}
+
+// PinFrequencyOffsets returns the last fractional frequency offset of the DPLL input pins, in ppt, by board label
+func (d *DpllConfig) PinFrequencyOffsets() map[string]int64 {
+	d.Lock()
+	defer d.Unlock()
+	offsets := make(map[string]int64, len(d.pinFrequencyOffsets))
+	for label, ffo := range d.pinFrequencyOffsets {
+		offsets[label] = ffo
+	}
+	return offsets
+}
+
+// SetMaxFrequencyOffset sets the maximum fractional frequency offset of a locked reference, in ppb.
+// A reference drifting beyond it is out of spec even when the DPLL is locked. 0 disables the check.
+func (d *DpllConfig) SetMaxFrequencyOffset(ppb uint64) {
+	d.maxFrequencyOffset = ppb
+}
+
+// SetPinQualifier sets the qualifier evaluating all the input pins of the DPLL as reference candidates
+func (d *DpllConfig) SetPinQualifier(q *PinQualifier) {
+	d.qualifier = q
+}
+
+// PinQualifier returns the reference candidates qualifier of the DPLL, nil when not configured
+func (d *DpllConfig) PinQualifier() *PinQualifier {
+	return d.qualifier
+}
+
+// SetPinFrequencyOffsetMetric sets the gauge the input pins frequency offsets are exported to,
+// with the iface and pin labels
+func (d *DpllConfig) SetPinFrequencyOffsetMetric(metric *prometheus.GaugeVec) {
+	d.pinFrequencyOffsetMetric = metric
+}
+
+// SetResyncMetric sets the counter of the netlink resubscriptions, with the iface and reason labels
+func (d *DpllConfig) SetResyncMetric(metric *prometheus.CounterVec) {
+	d.resyncMetric = metric
+}
+
+// SetDialer sets the dialer of the DPLL netlink connections, the kernel by default
+func (d *DpllConfig) SetDialer(dial nl.Dialer) {
+	d.dial = dial
+}
+
 func (d *DpllConfig) hasFlag(flag Flag) bool {
 	return (d.flags & flag) == flag
 }
@@ -217,11 +298,36 @@ func (d *DpllConfig) PhaseStatus() int64 {
 	return d.phaseStatus
 }
 
+// Snapshot ... DPLL statuses, state and phase offset read together under the lock
+type Snapshot struct {
+	State           event.PTPState
+	PhaseStatus     int64
+	FrequencyStatus int64
+	PhaseOffset     int64
+}
+
+// Snapshot returns the DPLL statuses, state and phase offset, safe to call while the DPLL is monitored
+func (d *DpllConfig) Snapshot() Snapshot {
+	d.Lock()
+	defer d.Unlock()
+	return Snapshot{
+		State:           d.state,
+		PhaseStatus:     d.phaseStatus,
+		FrequencyStatus: d.frequencyStatus,
+		PhaseOffset:     d.phaseOffset,
+	}
+}
+
 // Name ... name of the process
 func (d *DpllConfig) Name() string {
 	return string(event.DPLL)
 }
 
+// Iface returns the interface the DPLL is monitored for
+func (d *DpllConfig) Iface() string {
+	return d.iface
+}
+
 // Stopped ... stopped
 func (d *DpllConfig) Stopped() bool {
 	// TODO implement me
@@ -262,6 +368,13 @@ func (d *DpllConfig) CmdStop() {
 	d.ticker.Stop()
 	glog.Infof("Ticker stopped %s", d.Name())
 	close(d.exitCh) // terminate loop
+	if d.pinFrequencyOffsetMetric != nil {
+		d.pinFrequencyOffsetMetric.DeletePartialMatch(prometheus.Labels{"iface": d.iface})
+	}
+	if d.qualifier != nil {
+		d.qualifier.deleteMetrics()
+	}
+	d.deleteDeviceMetrics()
 	glog.Infof("Process %s terminated", d.Name())
 }
 
@@ -289,7 +402,7 @@ func (d *DpllConfig) SyncInitialState() {
 		glog.Infof("SyncInitialState: EventChannel not set for %s, skipping", d.iface)
 		return
 	}
-	conn, err := nl.Dial(nil)
+	conn, err := d.dial()
 	if err != nil {
 		glog.Infof("SyncInitialState: failed to dial netlink for %s: %v", d.iface, err)
 		return
@@ -344,6 +457,7 @@ func NewDpll(clockId uint64, localMaxHoldoverOffSet, localHoldoverTimeout, maxIn
 		inSyncConditionThreshold: inSyncConditionTh,
 		inSyncConditionTimes:     inSyncConditionTimes,
 		flags:                    dpllFlags,
+		dial:                     nl.DefaultDialer,
 	}
 
 	if d.flags != 0 {
@@ -402,6 +516,7 @@ func (d *DpllConfig) nlUpdateState(devices []*nl.DoDeviceGetReply, pins []*nl.Pi
 				continue
 			}
 			glog.Info(string(replyHr), " ", d.iface)
+			d.updateDevice(reply)
 			dpllType := nl.GetDpllType(reply.Type)
 			lockChanged := false
 			switch dpllType {
@@ -428,38 +543,100 @@ func (d *DpllConfig) nlUpdateState(devices []*nl.DoDeviceGetReply, pins []*nl.Pi
 		}
 	}
 	for _, pin := range pins {
+		if pin.ClockID == d.clockId {
+			d.updateDevicePins(pin)
+			if isInputPin(pin) {
+				d.updatePinFrequencyOffset(pin)
+			}
+		}
 		if index, ok := d.ActivePhaseOffsetPin(pin); ok {
 			d.SetPhaseOffset(pin.ParentDevice[index].PhaseOffset)
 			glog.Info("setting phase offset to ", d.phaseOffset, " ns for clock id ", d.clockId, " iface ", d.iface)
+			d.frequencyOffset = pinFrequencyOffset(pin)
+			pinID := pin.ID
+			d.frequencyOffsetPinID = &pinID
 			valid = true
+		} else if d.frequencyOffsetPinID != nil && *d.frequencyOffsetPinID == pin.ID {
+			// the reference is no longer active, its offset no longer applies
+			glog.Infof("pin id %d is no longer the active reference of clock id %#x, resetting frequency offset", pin.ID, d.clockId)
+			d.frequencyOffset = 0
+			d.frequencyOffsetPinID = nil
 		}
 	}
+	if d.qualifier != nil && len(pins) > 0 {
+		d.qualifier.Update(pins, d.devices)
+	}
 	return valid
 }
 
+// isInputPin returns whether the pin is an input of any of its parent devices
+func isInputPin(pin *nl.PinInfo) bool {
+	for _, p := range pin.ParentDevice {
+		if p.Direction == nl.PinDirectionInput {
+			return true
+		}
+	}
+	return false
+}
+
+// pinFrequencyOffset returns the fractional frequency offset of an input pin in ppt. Older kernels
+// only report it in ppm.
+func pinFrequencyOffset(pin *nl.PinInfo) int64 {
+	if pin.FractionalFrequencyOffsetPPT != 0 {
+		return pin.FractionalFrequencyOffsetPPT
+	}
+	return int64(pin.FractionalFrequencyOffset) * 1000000
+}
+
+// updatePinFrequencyOffset records and exports the fractional frequency offset of an input pin
+func (d *DpllConfig) updatePinFrequencyOffset(pin *nl.PinInfo) {
+	ffo := pinFrequencyOffset(pin)
+	if d.pinFrequencyOffsets == nil {
+		d.pinFrequencyOffsets = map[string]int64{}
+	}
+	label := nl.GetPinLabel(pin)
+	d.pinFrequencyOffsets[label] = ffo
+	if d.pinFrequencyOffsetMetric != nil {
+		d.pinFrequencyOffsetMetric.With(prometheus.Labels{"iface": d.iface, "pin": label}).Set(float64(ffo))
+	}
+}
+
 // applyStateUpdate atomically updates DPLL state from device/pin data and
 // re-evaluates the state machine. The lock ensures no concurrent goroutine
 // can observe or mutate a partially-updated DpllConfig.
 func (d *DpllConfig) applyStateUpdate(devices []*nl.DoDeviceGetReply, pins []*nl.PinInfo) {
 	d.Lock()
-	defer d.Unlock()
 	if d.nlUpdateState(devices, pins) {
 		d.stateDecision()
 	}
+	d.Unlock()
+	d.applyReferencePriorities()
+}
+
+// applyReferencePriorities applies the reference priorities of the last ranking of the qualifier,
+// outside the DPLL lock since it does netlink I/O
+func (d *DpllConfig) applyReferencePriorities() {
+	if d.qualifier != nil {
+		d.qualifier.applyPendingPriorities()
+	}
 }
 
 // monitorNtf receives a multicast unsolicited notification and
 // calls dpll state updating function.
-func (d *DpllConfig) monitorNtf(c *genetlink.Conn) {
+// monitorNtf applies the DPLL notifications until the connection fails, and returns the failure
+func (d *DpllConfig) monitorNtf(c *genetlink.Conn) error {
 	for {
 		msgs, _, err := c.Receive()
 		if err != nil {
-			if err.Error() == "netlink receive: use of closed file" {
+			switch {
+			case err.Error() == "netlink receive: use of closed file":
 				glog.Infof("netlink connection has been closed - stop monitoring for %s", d.iface)
-			} else {
+			case nl.IsOverrun(err):
+				glog.Warningf("dpll notifications lost on %s: %v", d.iface, err)
+			default:
 				glog.Error(err)
 			}
-			return
+			return err
 		}
 		devices, pins := []*nl.DoDeviceGetReply{}, []*nl.PinInfo{}
 		for _, msg := range msgs {
@@ -469,13 +646,13 @@ func (d *DpllConfig) monitorNtf(c *genetlink.Conn) {
 				devices, err = nl.ParseDeviceReplies([]genetlink.Message{msg})
 				if err != nil {
 					glog.Error(err)
-					return
+					return err
 				}
 			case nl.DpllCmdPinChangeNtf:
 				pins, err = nl.ParsePinReplies([]genetlink.Message{msg})
 				if err != nil {
 					glog.Error(err)
-					return
+					return err
 				}
 			default:
 				glog.Info("unhandled dpll message", msg.Header.Command, msg.Data)
@@ -494,7 +671,7 @@ func (d *DpllConfig) monitorNtf(c *genetlink.Conn) {
 }
 
 func (d *DpllConfig) isNetLinkPresent() bool {
-	conn, err := nl.Dial(nil)
+	conn, err := d.dial()
 	if err != nil {
 		glog.Infof("failed to establish dpll netlink connection (%s): %s", d.iface, err)
 		return false
@@ -519,16 +696,19 @@ func (d *DpllConfig) MonitorDpllMock() {
 	glog.Infof("closing dpll mock ")
 }
 
-// MonitorDpllNetlink monitors DPLL through netlink
+// MonitorDpllNetlink monitors DPLL through netlink. When the notifications stop, on an overrun
+// of the socket or any other error, it subscribes again and resyncs the devices and pins state.
 func (d *DpllConfig) MonitorDpllNetlink() {
 	redial := true
-	var replies []*nl.DoDeviceGetReply
 	var err error
-	var sem *semaphore.Weighted
+	// ntfDone receives the error the notifications monitoring ended with
+	var ntfDone chan error
+	// resyncReason is why the monitoring subscribes again, empty for the first subscription
+	var resyncReason string
 	for {
 		if redial {
 			if d.conn == nil {
-				if conn, err2 := nl.Dial(nil); err2 != nil {
+				if conn, err2 := d.dialNetlink(); err2 != nil {
 					d.conn = nil
 					glog.Infof("failed to establish dpll netlink connection (%s): %s", d.iface, err2)
 					goto checkExit
@@ -544,29 +724,28 @@ func (d *DpllConfig) MonitorDpllNetlink() {
 				goto abort
 			}
 
-			replies, err = d.conn.DumpDeviceGet()
-			if err != nil {
-				goto abort
-			}
-
-			d.devices = replies
-
-			d.applyStateUpdate(replies, []*nl.PinInfo{})
-
+			// Subscribe before the dump, so no change is lost in between. The notifications
+			// queued during the dump are applied after it, the latest ones carry the current state.
 			err = c.JoinGroup(mcastID)
 			if err != nil {
 				goto abort
 			}
 
-			sem = semaphore.NewWeighted(1)
-			err = sem.Acquire(context.Background(), 1)
+			err = d.resync()
 			if err != nil {
+				glog.Errorf("failed to resync dpll state (%s): %v", d.iface, err)
 				goto abort
 			}
+			if resyncReason != "" {
+				glog.Infof("dpll state of %s resynced after %s", d.iface, resyncReason)
+				if d.resyncMetric != nil {
+					d.resyncMetric.With(prometheus.Labels{"iface": d.iface, "reason": resyncReason}).Inc()
+				}
+			}
 
+			ntfDone = make(chan error, 1)
 			go func() {
-				defer sem.Release(1)
-				d.monitorNtf(c)
+				ntfDone <- d.monitorNtf(c)
 			}()
 
 			goto checkExit
@@ -594,29 +773,35 @@ func (d *DpllConfig) MonitorDpllNetlink() {
 			d.stopDpll()
 			// Allow generated events some time to get processed
 			time.Sleep(time.Second)
+			d.Lock()
 			if d.onHoldover {
 				close(d.holdoverCloseCh)
 				glog.Infof("closing holdover for %s", d.iface)
 				d.onHoldover = false
 				d.closing = true
 			}
+			d.Unlock()
 
 			return
 
 		default:
 			redial = func() bool {
-				ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
-				defer cancel()
-
-				if sem == nil {
+				if ntfDone == nil {
 					return false
 				}
 
-				if err = sem.Acquire(ctx, 1); err != nil {
+				var ntfErr error
+				select {
+				case ntfErr = <-ntfDone:
+				case <-time.After(time.Second * 2):
 					return false
 				}
 
 				glog.Infof("dpll monitoring exited, initiating redial (%s)", d.iface)
+				resyncReason = ResyncReasonError
+				if nl.IsOverrun(ntfErr) {
+					resyncReason = ResyncReasonOverrun
+				}
 				d.stopDpll()
 				return true
 			}()
@@ -625,6 +810,52 @@ func (d *DpllConfig) MonitorDpllNetlink() {
 	}
 }
 
+// dialNetlink connects to the DPLL netlink family, retrying with backoff until the monitoring stops
+func (d *DpllConfig) dialNetlink() (*nl.Conn, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-d.exitCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return utils.RedialWithBackoff(ctx, fmt.Sprintf("dpll netlink (%s)", d.iface),
+		d.dial, utils.DefaultReconnectConfig())
+}
+
+// resync dumps the state of the devices and pins. The dump uses its own connection, the
+// monitoring connection receives the notifications.
+func (d *DpllConfig) resync() error {
+	conn, err := d.dial()
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck
+	defer conn.Close()
+	replies, err := conn.DumpDeviceGet()
+	if err != nil {
+		return err
+	}
+	d.devices = replies
+	d.applyStateUpdate(replies, []*nl.PinInfo{})
+	d.syncAllPins(conn)
+	return nil
+}
+
+// syncAllPins applies the current state of all the pins feeding the devices of the clock, as
+// the notifications only report the pins that change: their phase and frequency offsets, and
+// their evaluation as reference candidates
+func (d *DpllConfig) syncAllPins(conn *nl.Conn) {
+	pins, err := conn.DumpPinGet()
+	if err != nil {
+		glog.Errorf("failed to dump DPLL pins (%s): %v", d.iface, err)
+		return
+	}
+	d.applyStateUpdate(nil, pins)
+}
+
 // stopDpll stops DPLL monitoring
 func (d *DpllConfig) stopDpll() {
 	if d.conn != nil {
@@ -703,7 +934,8 @@ func (d *DpllConfig) stateDecision() {
 		}
 
 	case DPLL_LOCKED_HO_ACQ, DPLL_LOCKED:
-		if d.isOffsetInRange() {
+		// a locked reference drifting in frequency is out of spec, and so is the holdover it leads to
+		if d.isOffsetInRange() && d.isFrequencyOffsetInRange() {
 			glog.Infof("%s dpll is locked, source is not lost, offset is in range, state is DPLL_LOCKED_HO_ACQ", d.iface)
 			if d.hasLeadingSource() && d.onHoldover {
 				select {
@@ -716,7 +948,7 @@ func (d *DpllConfig) stateDecision() {
 			d.sourceLost = false
 			d.state = event.PTP_LOCKED
 		} else {
-			glog.Infof("%s dpll is not in spec, state is DPLL_LOCKED_HO_ACQ, offset is out of range, state is FREERUN", d.iface)
+			glog.Infof("%s dpll is not in spec, state is DPLL_LOCKED_HO_ACQ, offset or frequency offset is out of range, state is FREERUN", d.iface)
 			d.state = event.PTP_FREERUN
 			d.inSpec = false
 			d.phaseOffset = FaultyPhaseOffset
@@ -833,11 +1065,14 @@ func (d *DpllConfig) holdover() {
 		d.sendDpllEvent()
 		d.Unlock()
 	}()
+	d.Lock()
 	d.sendDpllEvent()
+	d.Unlock()
 	glog.Infof("setting dpll holdover for max holdover %v", d.LocalHoldoverTimeout)
 	for timeout := time.After(time.Duration(int64(d.LocalHoldoverTimeout) * int64(time.Second))); ; {
 		select {
 		case <-ticker.C:
+			d.Lock()
 			d.phaseOffset = int64(math.Round((d.slope) * time.Since(start).Seconds()))
 			glog.Infof("(%s) time since holdover start %f, offset %d nanosecond holdover %s", d.iface, time.Since(start).Seconds(), d.phaseOffset, strconv.FormatBool(d.onHoldover))
 			if d.hasGNSSAsSource() {
@@ -851,6 +1086,7 @@ func (d *DpllConfig) holdover() {
 					d.state = event.PTP_FREERUN
 					d.phaseOffset = FaultyPhaseOffset
 					d.sendDpllEvent()
+					d.Unlock()
 					return
 				}
 				d.sendDpllEvent()
@@ -861,16 +1097,21 @@ func (d *DpllConfig) holdover() {
 				d.state = event.PTP_HOLDOVER
 				d.sendDpllEvent()
 			}
+			d.Unlock()
 		case <-timeout: // since ts2phc has same timer , ts2phc should also move out of holdover
+			d.Lock()
 			d.inSpec = false // not in HO, Out of spec
 			d.state = event.PTP_FREERUN
 			d.phaseOffset = FaultyPhaseOffset
 			glog.Infof("holdover timer %d expired", d.timer)
 			d.sendDpllEvent()
+			d.Unlock()
 			return
 		case <-d.holdoverCloseCh:
 			glog.Info("holdover was closed")
+			d.Lock()
 			d.inSpec = true // if someone else is closing then it should be back in spec (if it was not in spec before)
+			d.Unlock()
 			return
 		}
 	}
@@ -907,11 +1148,31 @@ func (d *DpllConfig) isOffsetInRange() bool {
 		// Special case when the DPLL has no reported phase offset
 		return true
 	}
-	if d.phaseOffset <= d.processConfig.GMThreshold.Max && d.phaseOffset >= d.processConfig.GMThreshold.Min {
+	threshold := d.processConfig.OffsetThreshold(event.DPLL, d.iface)
+	wasInRange := d.offsetInRange
+	d.offsetInRange = threshold.InRange(d.phaseOffset, wasInRange)
+	if !d.offsetInRange {
+		if wasInRange {
+			glog.Infof("dpll offset out of range: min %d, max %d, current %d", threshold.Min, threshold.Max, d.phaseOffset)
+		} else {
+			glog.Infof("dpll offset not back in range: enter min %d, enter max %d, current %d",
+				threshold.EnterMin, threshold.EnterMax, d.phaseOffset)
+		}
+	}
+	return d.offsetInRange
+}
+
+// isFrequencyOffsetInRange returns whether the fractional frequency offset of the active reference
+// is within maxFrequencyOffset
+func (d *DpllConfig) isFrequencyOffsetInRange() bool {
+	if d.maxFrequencyOffset == 0 {
+		return true
+	}
+	// ppb to ppt
+	if math.Abs(float64(d.frequencyOffset)) <= float64(d.maxFrequencyOffset)*1000 {
 		return true
 	}
-	glog.Infof("dpll offset out of range: min %d, max %d, current %d",
-		d.processConfig.GMThreshold.Min, d.processConfig.GMThreshold.Max, d.phaseOffset)
+	glog.Infof("dpll frequency offset out of range: max %d ppb, current %d ppt", d.maxFrequencyOffset, d.frequencyOffset)
 	return false
 }
 
@@ -6,7 +6,10 @@ import (
 
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/config"
 	nl "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink/dplltest"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -298,3 +301,109 @@ func TestActivePhaseOffsetPin(t *testing.T) {
 		})
 	}
 }
+
+func TestDpllFrequencyOffset(t *testing.T) {
+	const clockID uint64 = 0xAABBCCDD
+	metric := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_dpll_pin_frequency_offset_ppt"}, []string{"iface", "pin"})
+	d := &DpllConfig{
+		clockId:            clockID,
+		iface:              "ens1f0",
+		phaseStatus:        DPLL_LOCKED_HO_ACQ,
+		frequencyStatus:    DPLL_LOCKED_HO_ACQ,
+		phaseOffset:        5,
+		maxFrequencyOffset: 10, // ppb
+		dependsOn:          []event.EventSource{event.GNSS},
+		exitCh:             make(chan struct{}),
+		ticker:             time.NewTicker(time.Hour),
+		processConfig:      config.ProcessConfig{GMThreshold: config.Threshold{Min: -100, Max: 100}},
+		devices:            []*nl.DoDeviceGetReply{{ID: 1, ClockID: clockID, Type: nl.DpllTypePPS}},
+	}
+	d.SetPinFrequencyOffsetMetric(metric)
+	gnss := &nl.PinInfo{
+		ID: 10, ClockID: clockID, BoardLabel: "GNSS-1PPS", FractionalFrequencyOffsetPPT: -4000,
+		ParentDevice: []nl.PinParentDevice{{ParentID: 1, Direction: nl.PinDirectionInput, State: nl.PinStateConnected}},
+	}
+	sma := &nl.PinInfo{
+		ID: 11, ClockID: clockID, BoardLabel: "SMA1", FractionalFrequencyOffset: -2, // ppm, older kernels
+		ParentDevice: []nl.PinParentDevice{{ParentID: 1, Direction: nl.PinDirectionInput, State: nl.PinStateSelectable}},
+	}
+	output := &nl.PinInfo{
+		ClockID: clockID, BoardLabel: "SMA2",
+		ParentDevice: []nl.PinParentDevice{{ParentID: 1, Direction: nl.PinDirectionOutput, State: nl.PinStateConnected}},
+	}
+	other := &nl.PinInfo{
+		ClockID: 0x11223344, BoardLabel: "GNSS-1PPS", FractionalFrequencyOffsetPPT: 7,
+		ParentDevice: []nl.PinParentDevice{{ParentID: 3, Direction: nl.PinDirectionInput}},
+	}
+
+	assert.True(t, d.nlUpdateState(nil, []*nl.PinInfo{gnss, sma, output, other}))
+	assert.Equal(t, map[string]int64{"GNSS-1PPS": -4000, "SMA1": -2000000}, d.PinFrequencyOffsets(), "only the inputs of the clock")
+	assert.Equal(t, int64(-4000), d.FrequencyOffset(), "frequency offset of the active reference")
+	assert.Equal(t, -2000000.0, testutil.ToFloat64(metric.WithLabelValues("ens1f0", "SMA1")))
+
+	d.stateDecision()
+	assert.Equal(t, event.PTP_LOCKED, d.state, "4 ppb is in range")
+
+	// the reference stays locked while drifting beyond 10 ppb
+	gnss.FractionalFrequencyOffsetPPT = 25000
+	d.nlUpdateState(nil, []*nl.PinInfo{gnss})
+	d.stateDecision()
+	assert.Equal(t, event.PTP_FREERUN, d.state)
+	assert.False(t, d.InSpec())
+
+	d.SetMaxFrequencyOffset(0)
+	d.phaseOffset = 5
+	d.stateDecision()
+	assert.Equal(t, event.PTP_LOCKED, d.state, "0 disables the frequency offset check")
+
+	// updates of other pins keep the offset of the active reference
+	d.nlUpdateState(nil, []*nl.PinInfo{sma})
+	assert.Equal(t, int64(25000), d.FrequencyOffset())
+
+	// the reference is no longer active
+	gnss.ParentDevice[0].State = nl.PinStateSelectable
+	d.nlUpdateState(nil, []*nl.PinInfo{gnss})
+	assert.Zero(t, d.FrequencyOffset(), "no active reference")
+
+	d.CmdStop()
+	assert.Zero(t, testutil.CollectAndCount(metric))
+}
+
+func TestDpllSyncAllPins(t *testing.T) {
+	const clockID uint64 = 0xAABBCCDD
+	sim := dplltest.NewSimulator()
+	pps := sim.AddDevice(nl.DoDeviceGetReply{ModuleName: "ice", ClockID: clockID, Type: nl.DpllTypePPS})
+	prio := uint32(0)
+	gnss, err := sim.AddPin(nl.PinInfo{
+		ModuleName: "ice", ClockID: clockID, BoardLabel: "GNSS-1PPS", Type: nl.PinTypeGNSS,
+		Capabilities: nl.PinCapPrio | nl.PinCapState,
+		ParentDevice: []nl.PinParentDevice{{ParentID: pps, Direction: nl.PinDirectionInput, Prio: &prio}},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, sim.SetSignal(gnss, true))
+	assert.NoError(t, sim.SetPhaseOffset(gnss, pps, 2*1000*nl.DpllPhaseOffsetDivider))
+	assert.NoError(t, sim.SetFractionalFrequencyOffset(gnss, -4000))
+
+	metric := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_dpll_pin_frequency_offset_ppt"}, []string{"iface", "pin"})
+	d := &DpllConfig{
+		clockId:         clockID,
+		iface:           "ens1f0",
+		state:           event.PTP_FREERUN,
+		phaseStatus:     DPLL_LOCKED_HO_ACQ,
+		frequencyStatus: DPLL_LOCKED_HO_ACQ,
+		phaseOffset:     FaultyPhaseOffset,
+		dependsOn:       []event.EventSource{event.GNSS},
+		processConfig:   config.ProcessConfig{GMThreshold: config.Threshold{Min: -100, Max: 100}},
+		devices:         []*nl.DoDeviceGetReply{{ID: pps, ClockID: clockID, Type: nl.DpllTypePPS}},
+	}
+	d.SetPinFrequencyOffsetMetric(metric)
+
+	// the dump refreshes the offsets of the active reference and re-evaluates the state
+	conn := sim.Dial()
+	defer conn.Close()
+	d.syncAllPins(conn)
+	assert.Equal(t, int64(2), d.Snapshot().PhaseOffset)
+	assert.Equal(t, int64(-4000), d.FrequencyOffset())
+	assert.Equal(t, -4000.0, testutil.ToFloat64(metric.WithLabelValues("ens1f0", "GNSS-1PPS")))
+	assert.Equal(t, event.PTP_LOCKED, d.Snapshot().State)
+}
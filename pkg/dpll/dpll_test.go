@@ -9,11 +9,14 @@ import (
 	"time"
 
 	nl "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink/dplltest"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/testhelpers"
 
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/config"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -303,6 +306,139 @@ func TestDpllConfig_MonitorProcessPartial(t *testing.T) {
 	closeChn <- true
 }
 
+func TestDpllConfig_MonitorSimulator(t *testing.T) {
+	sim := dplltest.NewSimulator()
+	eec := sim.AddDevice(nl.DoDeviceGetReply{ModuleName: "ice", ClockID: clockid, Type: nl.DpllTypeEEC})
+	pps := sim.AddDevice(nl.DoDeviceGetReply{ModuleName: "ice", ClockID: clockid, Type: nl.DpllTypePPS})
+	prio := uint32(0)
+	gnss, err := sim.AddPin(nl.PinInfo{
+		ModuleName: "ice", ClockID: clockid, BoardLabel: "GNSS-1PPS", Type: nl.PinTypeGNSS,
+		Capabilities: nl.PinCapPrio | nl.PinCapState,
+		ParentDevice: []nl.PinParentDevice{
+			{ParentID: eec, Direction: nl.PinDirectionInput, Prio: &prio},
+			{ParentID: pps, Direction: nl.PinDirectionInput, Prio: &prio},
+		},
+	})
+	assert.NoError(t, err)
+
+	eventChannel := make(chan event.Event, 100)
+	stopped := make(chan struct{})
+	go func() {
+		// the monitoring sends a reset event when it terminates
+		for e := range eventChannel {
+			if e.Reset {
+				close(stopped)
+				return
+			}
+		}
+	}()
+	d := dpll.NewDpll(clockid, 10, 2, 5, "ens01",
+		[]event.EventSource{event.GNSS}, dpll.NONE, map[string]map[string]string{}, 0, 0, 0)
+	d.SetDialer(sim.Dialer())
+	d.CmdInit()
+	d.MonitorProcess(config.ProcessConfig{
+		ClockType:       "GM",
+		ConfigName:      "test",
+		EventChannel:    eventChannel,
+		GMThreshold:     config.Threshold{Min: -100, Max: 100},
+		InitialPTPState: event.PTP_FREERUN,
+	})
+	assert.Eventually(t, func() bool { return sim.Subscribers() == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, sim.SetSignal(gnss, true))
+	assert.Eventually(t, func() bool {
+		status := d.Snapshot()
+		return status.PhaseStatus == nl.DpllLockStatusLockedHoldoverAcquired &&
+			status.FrequencyStatus == nl.DpllLockStatusLockedHoldoverAcquired
+	}, 2*time.Second, 10*time.Millisecond, "DPLLs lock on GNSS")
+
+	// the driver reports the phase offset of the active input, 2 ns are in spec
+	assert.NoError(t, sim.SetPhaseOffset(gnss, pps, 2*1000*nl.DpllPhaseOffsetDivider))
+	assert.Eventually(t, func() bool { return d.Snapshot().State == event.PTP_LOCKED }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(2), d.Snapshot().PhaseOffset)
+
+	assert.NoError(t, sim.SetSignal(gnss, false))
+	assert.Eventually(t, func() bool {
+		status := d.Snapshot()
+		return status.PhaseStatus == nl.DpllLockStatusHoldover && status.State == event.PTP_HOLDOVER
+	}, 2*time.Second, 10*time.Millisecond, "DPLLs go to holdover on GNSS loss")
+
+	d.CmdStop()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Error("DPLL monitoring did not terminate")
+	}
+}
+
+func TestDpllConfig_MonitorResync(t *testing.T) {
+	sim := dplltest.NewSimulator()
+	eec := sim.AddDevice(nl.DoDeviceGetReply{ModuleName: "ice", ClockID: clockid, Type: nl.DpllTypeEEC})
+	pps := sim.AddDevice(nl.DoDeviceGetReply{ModuleName: "ice", ClockID: clockid, Type: nl.DpllTypePPS})
+	prio := uint32(0)
+	gnss, err := sim.AddPin(nl.PinInfo{
+		ModuleName: "ice", ClockID: clockid, BoardLabel: "GNSS-1PPS", Type: nl.PinTypeGNSS,
+		Capabilities: nl.PinCapPrio | nl.PinCapState,
+		ParentDevice: []nl.PinParentDevice{
+			{ParentID: eec, Direction: nl.PinDirectionInput, Prio: &prio},
+			{ParentID: pps, Direction: nl.PinDirectionInput, Prio: &prio},
+		},
+	})
+	assert.NoError(t, err)
+
+	eventChannel := make(chan event.Event, 100)
+	stopped := make(chan struct{})
+	go func() {
+		for e := range eventChannel {
+			if e.Reset {
+				close(stopped)
+				return
+			}
+		}
+	}()
+	resyncs := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_resyncs"}, []string{"iface", "reason"})
+	d := dpll.NewDpll(clockid, 10, 2, 5, "ens01",
+		[]event.EventSource{event.GNSS}, dpll.NONE, map[string]map[string]string{}, 0, 0, 0)
+	d.SetResyncMetric(resyncs)
+	d.SetDialer(sim.Dialer())
+	d.CmdInit()
+	d.MonitorProcess(config.ProcessConfig{
+		ClockType:       "GM",
+		ConfigName:      "test",
+		EventChannel:    eventChannel,
+		GMThreshold:     config.Threshold{Min: -100, Max: 100},
+		InitialPTPState: event.PTP_FREERUN,
+	})
+	assert.Eventually(t, func() bool { return sim.Subscribers() == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.Zero(t, testutil.CollectAndCount(resyncs), "the first subscription is not a resync")
+
+	// the DPLLs lock while the notifications overflow the socket
+	sim.Overrun()
+	assert.NoError(t, sim.SetSignal(gnss, true))
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(resyncs.WithLabelValues("ens01", dpll.ResyncReasonOverrun)) == 1
+	}, 5*time.Second, 10*time.Millisecond, "the overrun is detected")
+	assert.Eventually(t, func() bool {
+		status := d.Snapshot()
+		return status.PhaseStatus == nl.DpllLockStatusLockedHoldoverAcquired &&
+			status.FrequencyStatus == nl.DpllLockStatusLockedHoldoverAcquired
+	}, 2*time.Second, 10*time.Millisecond, "the lost lock status is resynced")
+	assert.Equal(t, 1, sim.Subscribers())
+
+	// the notifications flow again on the new subscription
+	assert.NoError(t, sim.SetSignal(gnss, false))
+	assert.Eventually(t, func() bool { return d.Snapshot().PhaseStatus == nl.DpllLockStatusHoldover },
+		2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, testutil.CollectAndCount(resyncs))
+
+	d.CmdStop()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Error("DPLL monitoring did not terminate")
+	}
+}
+
 func TestSysfs(t *testing.T) {
 	//indexStr := fmt.Sprintf("/sys/class/net/%s/ifindex", "lo")
 	//fContent, err := os.ReadFile(indexStr)
@@ -0,0 +1,368 @@
+package dpll
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	nl "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// QualificationThresholds are the limits an input pin must meet to be a reference candidate.
+// 0 disables a check.
+type QualificationThresholds struct {
+	// MaxPhaseOffset is the maximum phase offset of the pin against the PPS DPLL, in ns
+	MaxPhaseOffset int64
+	// MaxFrequencyOffset is the maximum fractional frequency offset of the pin, in ppb
+	MaxFrequencyOffset uint64
+}
+
+// PinCandidate is the qualification of a DPLL input pin as a reference
+type PinCandidate struct {
+	// BoardLabel identifies the pin, falling back to the panel or package label
+	// for drivers without board labels
+	BoardLabel string `json:"boardLabel"`
+	ID         uint32 `json:"id"`
+	// Rank is the position of the pin in the candidate list, starting at 1
+	Rank    int  `json:"rank"`
+	Present bool `json:"present"`
+	Active  bool `json:"active"`
+	// PhaseOffset is in ns, only reported for the pins feeding the PPS DPLL
+	PhaseOffset    int64 `json:"phaseOffset"`
+	HasPhaseOffset bool  `json:"hasPhaseOffset"`
+	// FrequencyOffset is in ppt
+	FrequencyOffset int64  `json:"frequencyOffset"`
+	Qualified       bool   `json:"qualified"`
+	Reason          string `json:"reason,omitempty"`
+}
+
+// PinQualificationMetrics are the gauges the candidates are exported to, with the iface and pin labels
+type PinQualificationMetrics struct {
+	Qualified   *prometheus.GaugeVec
+	Rank        *prometheus.GaugeVec
+	PhaseOffset *prometheus.GaugeVec
+}
+
+// pinObservation is the last state of an input pin seen in the DPLL notifications
+type pinObservation struct {
+	pin PinCandidate
+	// basePrio is the priority of the pin when first seen, it orders the candidates without preference
+	basePrio uint32
+	// inputs are the parent devices the pin is an input of, with their priority when supported
+	inputs   []nl.PinParentDevice
+	prioCtrl bool
+}
+
+// PinQualifier evaluates every input pin of a DPLL clock for presence, phase offset and frequency
+// offset, and ranks them as reference candidates. Qualified pins rank first, in the configured
+// preference order of their board labels, then in the order of their initial priorities.
+type PinQualifier struct {
+	sync.Mutex
+	clockID    uint64
+	iface      string
+	thresholds QualificationThresholds
+	preference []string
+	pins       map[string]*pinObservation
+	ranking    []PinCandidate
+	metrics    *PinQualificationMetrics
+	// prioApplier, when set, applies the ranking as pin priorities
+	prioApplier func([]nl.PinParentDeviceCtl) error
+	// pendingPriorities are the priority commands of the last ranking change, not applied yet
+	pendingPriorities []nl.PinParentDeviceCtl
+	// applyMu serializes the priority applications, which run without the qualifier lock
+	applyMu sync.Mutex
+}
+
+// NewPinQualifier creates the qualifier of the input pins of a DPLL clock
+func NewPinQualifier(clockID uint64, iface string, thresholds QualificationThresholds, preference []string) *PinQualifier {
+	return &PinQualifier{
+		clockID:    clockID,
+		iface:      iface,
+		thresholds: thresholds,
+		preference: preference,
+		pins:       map[string]*pinObservation{},
+	}
+}
+
+// SetMetrics sets the gauges the candidates are exported to
+func (q *PinQualifier) SetMetrics(metrics *PinQualificationMetrics) {
+	q.metrics = metrics
+}
+
+// SetPriorityApplier makes the qualifier apply the ranking as pin priorities with the given
+// applier, e.g. hardwareconfig.BatchPinSet. The pin ranked first gets priority 0.
+func (q *PinQualifier) SetPriorityApplier(applier func([]nl.PinParentDeviceCtl) error) {
+	q.prioApplier = applier
+}
+
+// Candidates returns the last ranked candidate list
+func (q *PinQualifier) Candidates() []PinCandidate {
+	q.Lock()
+	defer q.Unlock()
+	return slices.Clone(q.ranking)
+}
+
+// Update evaluates the pins of a notification against the DPLL devices, and reranks the candidates.
+// It returns whether the order or the qualification of the candidates changed. The priorities of a
+// new ranking are only applied by applyPendingPriorities, since Update runs under the DPLL lock.
+func (q *PinQualifier) Update(pins []*nl.PinInfo, devices []*nl.DoDeviceGetReply) bool {
+	q.Lock()
+	defer q.Unlock()
+	updated := false
+	for _, pin := range pins {
+		if pin.ClockID != q.clockID || !isInputPin(pin) {
+			continue
+		}
+		q.observe(pin, devices)
+		updated = true
+	}
+	if !updated {
+		return false
+	}
+	ranking := q.rank()
+	changed := !slices.EqualFunc(ranking, q.ranking, func(a, b PinCandidate) bool {
+		return a.BoardLabel == b.BoardLabel && a.Qualified == b.Qualified
+	})
+	q.ranking = ranking
+	q.exportMetrics()
+	if changed {
+		glog.Infof("dpll %s (%#x) reference candidates: %s", q.iface, q.clockID, formatCandidates(ranking))
+		if q.prioApplier != nil {
+			q.pendingPriorities = q.priorityCommands()
+		}
+	}
+	return changed
+}
+
+// observe records the state of an input pin
+func (q *PinQualifier) observe(pin *nl.PinInfo, devices []*nl.DoDeviceGetReply) {
+	label := nl.GetPinLabel(pin)
+	obs, found := q.pins[label]
+	if !found {
+		obs = &pinObservation{basePrio: math.MaxUint32}
+		q.pins[label] = obs
+	}
+	obs.pin = PinCandidate{
+		BoardLabel:      label,
+		ID:              pin.ID,
+		FrequencyOffset: pinFrequencyOffset(pin),
+	}
+	obs.prioCtrl = pin.Capabilities&nl.PinCapPrio != 0
+	obs.inputs = obs.inputs[:0]
+	for _, p := range pin.ParentDevice {
+		if p.Direction != nl.PinDirectionInput {
+			continue
+		}
+		obs.inputs = append(obs.inputs, p)
+		if p.Prio != nil && !found && *p.Prio < obs.basePrio {
+			obs.basePrio = *p.Prio
+		}
+		// operstate is not reported by older kernels, the signal is then assumed present
+		switch p.Operstate {
+		case nl.PinOperstateActive:
+			obs.pin.Active, obs.pin.Present = true, true
+		case nl.PinOperstateStandby, 0:
+			obs.pin.Present = true
+			// a connected pin is only the active reference with a signal
+			if p.State == nl.PinStateConnected {
+				obs.pin.Active = true
+			}
+		}
+		for _, dev := range devices {
+			if dev.ID == p.ParentID && dev.ClockID == q.clockID && dev.Type == nl.DpllTypePPS {
+				obs.pin.PhaseOffset = int64(math.Round(float64(p.PhaseOffset) / nl.DpllPhaseOffsetDivider / 1000))
+				obs.pin.HasPhaseOffset = true
+			}
+		}
+	}
+	obs.pin.Qualified, obs.pin.Reason = q.qualify(obs.pin)
+}
+
+// qualify checks a pin against the thresholds, and returns the reason it is not qualified
+func (q *PinQualifier) qualify(pin PinCandidate) (bool, string) {
+	if !pin.Present {
+		return false, "no signal"
+	}
+	if q.thresholds.MaxPhaseOffset > 0 && pin.HasPhaseOffset && abs(pin.PhaseOffset) > q.thresholds.MaxPhaseOffset {
+		return false, fmt.Sprintf("phase offset %d ns out of range", pin.PhaseOffset)
+	}
+	if q.thresholds.MaxFrequencyOffset > 0 && abs(pin.FrequencyOffset) > int64(q.thresholds.MaxFrequencyOffset)*1000 {
+		return false, fmt.Sprintf("frequency offset %d ppt out of range", pin.FrequencyOffset)
+	}
+	return true, ""
+}
+
+// rank orders the observed pins as reference candidates
+func (q *PinQualifier) rank() []PinCandidate {
+	observations := make([]*pinObservation, 0, len(q.pins))
+	for _, obs := range q.pins {
+		observations = append(observations, obs)
+	}
+	preference := func(label string) int {
+		if i := slices.Index(q.preference, label); i >= 0 {
+			return i
+		}
+		return len(q.preference)
+	}
+	slices.SortFunc(observations, func(a, b *pinObservation) int {
+		switch {
+		case a.pin.Qualified != b.pin.Qualified:
+			if a.pin.Qualified {
+				return -1
+			}
+			return 1
+		case preference(a.pin.BoardLabel) != preference(b.pin.BoardLabel):
+			return preference(a.pin.BoardLabel) - preference(b.pin.BoardLabel)
+		case a.basePrio != b.basePrio:
+			if a.basePrio < b.basePrio {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.pin.BoardLabel, b.pin.BoardLabel)
+	})
+	ranking := make([]PinCandidate, 0, len(observations))
+	for i, obs := range observations {
+		obs.pin.Rank = i + 1
+		ranking = append(ranking, obs.pin)
+	}
+	return ranking
+}
+
+func (q *PinQualifier) exportMetrics() {
+	if q.metrics == nil {
+		return
+	}
+	for _, c := range q.ranking {
+		labels := prometheus.Labels{"iface": q.iface, "pin": c.BoardLabel}
+		qualified := 0.0
+		if c.Qualified {
+			qualified = 1
+		}
+		q.metrics.Qualified.With(labels).Set(qualified)
+		q.metrics.Rank.With(labels).Set(float64(c.Rank))
+		if c.HasPhaseOffset {
+			q.metrics.PhaseOffset.With(labels).Set(float64(c.PhaseOffset))
+		}
+	}
+}
+
+// deleteMetrics removes the series of the qualifier
+func (q *PinQualifier) deleteMetrics() {
+	if q.metrics == nil {
+		return
+	}
+	for _, m := range []*prometheus.GaugeVec{q.metrics.Qualified, q.metrics.Rank, q.metrics.PhaseOffset} {
+		m.DeletePartialMatch(prometheus.Labels{"iface": q.iface})
+	}
+}
+
+// priorityCommands returns the commands setting the priority of every prio capable candidate to
+// its rank, the first one getting priority 0, when they differ
+func (q *PinQualifier) priorityCommands() []nl.PinParentDeviceCtl {
+	commands := make([]nl.PinParentDeviceCtl, 0, len(q.ranking))
+	for _, c := range q.ranking {
+		obs := q.pins[c.BoardLabel]
+		if !obs.prioCtrl {
+			continue
+		}
+		prio := uint32(c.Rank - 1)
+		command := nl.PinParentDeviceCtl{ID: c.ID}
+		for _, p := range obs.inputs {
+			if p.Prio != nil && *p.Prio == prio {
+				continue
+			}
+			command.PinParentCtl = append(command.PinParentCtl, nl.PinControl{PinParentID: p.ParentID, Prio: &prio})
+		}
+		if len(command.PinParentCtl) > 0 {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+// applyPendingPriorities applies the priorities of the last ranking change with the priority
+// applier. It does netlink I/O, and must be called without holding the DPLL lock.
+func (q *PinQualifier) applyPendingPriorities() {
+	q.applyMu.Lock()
+	defer q.applyMu.Unlock()
+	q.Lock()
+	commands := q.pendingPriorities
+	q.pendingPriorities = nil
+	q.Unlock()
+	if len(commands) == 0 {
+		return
+	}
+	glog.Infof("dpll %s (%#x) applying %d reference priority changes", q.iface, q.clockID, len(commands))
+	if err := q.prioApplier(commands); err != nil {
+		glog.Errorf("dpll %s (%#x) failed to apply reference priorities: %v", q.iface, q.clockID, err)
+	}
+}
+
+func formatCandidates(ranking []PinCandidate) string {
+	parts := make([]string, 0, len(ranking))
+	for _, c := range ranking {
+		if c.Qualified {
+			parts = append(parts, fmt.Sprintf("%d:%s", c.Rank, c.BoardLabel))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d:%s (%s)", c.Rank, c.BoardLabel, c.Reason))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// PtpSettingsDpllQualificationKey returns the PtpSettings key of a reference qualification setting
+// for the given interface name: maxPhaseOffset (ns), maxFrequencyOffset (ppb), preference (comma
+// separated board labels) and drivePriorities (true/false)
+func PtpSettingsDpllQualificationKey(iface, setting string) string {
+	return fmt.Sprintf("dpll.%s.qualification.%s", iface, setting)
+}
+
+// NewPinQualifierFromSettings creates the pin qualifier configured in the PtpSettings of a profile
+// for the given interface, nil when no qualification setting is set. drivePriorities is returned
+// separately since the priority applier lives outside this package.
+func NewPinQualifierFromSettings(clockID uint64, iface string, settings map[string]string) (q *PinQualifier, drivePriorities bool, err error) {
+	var thresholds QualificationThresholds
+	var preference []string
+	configured := false
+	for _, setting := range []string{"maxPhaseOffset", "maxFrequencyOffset", "preference", "drivePriorities"} {
+		v, found := settings[PtpSettingsDpllQualificationKey(iface, setting)]
+		if !found {
+			continue
+		}
+		configured = true
+		switch setting {
+		case "maxPhaseOffset":
+			thresholds.MaxPhaseOffset, err = strconv.ParseInt(v, 10, 64)
+		case "maxFrequencyOffset":
+			thresholds.MaxFrequencyOffset, err = strconv.ParseUint(v, 10, 64)
+		case "preference":
+			for _, label := range strings.Split(v, ",") {
+				if label = strings.TrimSpace(label); label != "" {
+					preference = append(preference, label)
+				}
+			}
+		case "drivePriorities":
+			drivePriorities, err = strconv.ParseBool(v)
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid %s: %w", PtpSettingsDpllQualificationKey(iface, setting), err)
+		}
+	}
+	if !configured {
+		return nil, false, nil
+	}
+	return NewPinQualifier(clockID, iface, thresholds, preference), drivePriorities, nil
+}
@@ -0,0 +1,155 @@
+package dpll
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	nl "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+)
+
+func TestPinQualifier(t *testing.T) {
+	const (
+		clockID uint64 = 0xAABBCCDD
+		eec     uint32 = 0
+		pps     uint32 = 1
+	)
+	devices := []*nl.DoDeviceGetReply{
+		{ID: eec, ClockID: clockID, Type: nl.DpllTypeEEC},
+		{ID: pps, ClockID: clockID, Type: nl.DpllTypePPS},
+	}
+	prio := func(v uint32) *uint32 { return &v }
+	input := func(id uint32, label string, basePrio uint32, operstate uint32) *nl.PinInfo {
+		return &nl.PinInfo{
+			ID: id, ClockID: clockID, BoardLabel: label, Capabilities: nl.PinCapPrio | nl.PinCapState,
+			ParentDevice: []nl.PinParentDevice{
+				{ParentID: eec, Direction: nl.PinDirectionInput, Prio: prio(basePrio), Operstate: operstate},
+				{ParentID: pps, Direction: nl.PinDirectionInput, Prio: prio(basePrio), Operstate: operstate},
+			},
+		}
+	}
+	gnss := input(1, "GNSS-1PPS", 0, nl.PinOperstateActive)
+	sma := input(2, "SMA1", 3, nl.PinOperstateStandby)
+	synce := &nl.PinInfo{
+		ID: 3, ClockID: clockID, BoardLabel: "C827_0-RCLKA", Capabilities: nl.PinCapState,
+		ParentDevice: []nl.PinParentDevice{{ParentID: eec, Direction: nl.PinDirectionInput, Operstate: nl.PinOperstateNoSignal}},
+	}
+	output := &nl.PinInfo{
+		ID: 4, ClockID: clockID, BoardLabel: "SMA2",
+		ParentDevice: []nl.PinParentDevice{{ParentID: pps, Direction: nl.PinDirectionOutput}},
+	}
+
+	metrics := &PinQualificationMetrics{
+		Qualified:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_qualified"}, []string{"iface", "pin"}),
+		Rank:        prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_rank"}, []string{"iface", "pin"}),
+		PhaseOffset: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_phase_offset"}, []string{"iface", "pin"}),
+	}
+	var applied [][]nl.PinParentDeviceCtl
+	q := NewPinQualifier(clockID, "ens1f0", QualificationThresholds{MaxPhaseOffset: 100, MaxFrequencyOffset: 10}, nil)
+	q.SetMetrics(metrics)
+	q.SetPriorityApplier(func(commands []nl.PinParentDeviceCtl) error {
+		applied = append(applied, commands)
+		return nil
+	})
+
+	labels := func(candidates []PinCandidate) []string {
+		result := make([]string, 0, len(candidates))
+		for _, c := range candidates {
+			result = append(result, c.BoardLabel)
+		}
+		return result
+	}
+
+	// all qualified pins keep their priorities
+	assert.True(t, q.Update([]*nl.PinInfo{gnss, sma, synce, output}, devices))
+	assert.Empty(t, applied, "priorities are only applied outside the DPLL lock")
+	q.applyPendingPriorities()
+	candidates := q.Candidates()
+	assert.Equal(t, []string{"GNSS-1PPS", "SMA1", "C827_0-RCLKA"}, labels(candidates))
+	assert.True(t, candidates[0].Active)
+	assert.True(t, candidates[0].HasPhaseOffset)
+	assert.False(t, candidates[2].HasPhaseOffset, "SyncE recovered clocks only feed the EEC DPLL")
+	assert.Equal(t, "no signal", candidates[2].Reason)
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.Rank.WithLabelValues("ens1f0", "C827_0-RCLKA")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.Qualified.WithLabelValues("ens1f0", "C827_0-RCLKA")))
+	require.Len(t, applied, 1)
+	require.Len(t, applied[0], 1, "only SMA1 changes priority, the SyncE pin has no priority control")
+	assert.Equal(t, uint32(2), applied[0][0].ID)
+	assert.Equal(t, uint32(1), *applied[0][0].PinParentCtl[0].Prio)
+	sma.ParentDevice[0].Prio, sma.ParentDevice[1].Prio = prio(1), prio(1)
+	assert.False(t, q.Update([]*nl.PinInfo{sma}, devices), "the priority notification doesn't change the ranking")
+
+	// GNSS drifting in frequency is demoted
+	gnss.FractionalFrequencyOffsetPPT = 50000
+	assert.True(t, q.Update([]*nl.PinInfo{gnss}, devices))
+	q.applyPendingPriorities()
+	candidates = q.Candidates()
+	assert.Equal(t, []string{"SMA1", "GNSS-1PPS", "C827_0-RCLKA"}, labels(candidates))
+	assert.Equal(t, "frequency offset 50000 ppt out of range", candidates[1].Reason)
+	require.Len(t, applied, 2)
+	assert.Len(t, applied[1], 2, "SMA1 and GNSS swap priorities")
+	q.applyPendingPriorities()
+	assert.Len(t, applied, 2, "pending priorities are applied once")
+
+	// a phase offset out of range disqualifies SMA1
+	gnss.FractionalFrequencyOffsetPPT = 0
+	sma.ParentDevice[1].PhaseOffset = 250 * 1000 * nl.DpllPhaseOffsetDivider
+	assert.True(t, q.Update([]*nl.PinInfo{gnss, sma}, devices))
+	candidates = q.Candidates()
+	assert.Equal(t, []string{"GNSS-1PPS", "SMA1", "C827_0-RCLKA"}, labels(candidates))
+	assert.Equal(t, int64(250), candidates[1].PhaseOffset)
+	assert.Equal(t, 250.0, testutil.ToFloat64(metrics.PhaseOffset.WithLabelValues("ens1f0", "SMA1")))
+
+	// the phase offset is rounded from its sub-ns resolution
+	sma.ParentDevice[1].PhaseOffset = 1600 * nl.DpllPhaseOffsetDivider
+	assert.True(t, q.Update([]*nl.PinInfo{sma}, devices))
+	assert.Equal(t, int64(2), q.Candidates()[1].PhaseOffset, "1.6 ns")
+
+	// a connected pin without a signal is not the active reference
+	gnss.ParentDevice[0].State, gnss.ParentDevice[1].State = nl.PinStateConnected, nl.PinStateConnected
+	gnss.ParentDevice[0].Operstate, gnss.ParentDevice[1].Operstate = nl.PinOperstateNoSignal, nl.PinOperstateNoSignal
+	q.Update([]*nl.PinInfo{gnss}, devices)
+	candidates = q.Candidates()
+	assert.Equal(t, "GNSS-1PPS", candidates[1].BoardLabel)
+	assert.False(t, candidates[1].Active)
+	assert.Equal(t, "no signal", candidates[1].Reason)
+	gnss.ParentDevice[0].Operstate, gnss.ParentDevice[1].Operstate = nl.PinOperstateActive, nl.PinOperstateActive
+	q.Update([]*nl.PinInfo{gnss}, devices)
+	q.applyPendingPriorities()
+
+	// the preference overrides the initial priorities
+	q.preference = []string{"C827_0-RCLKA", "SMA1"}
+	sma.ParentDevice[1].PhaseOffset = 0
+	synce.ParentDevice[0].Operstate = nl.PinOperstateStandby
+	assert.True(t, q.Update([]*nl.PinInfo{sma, synce}, devices))
+	assert.Equal(t, []string{"C827_0-RCLKA", "SMA1", "GNSS-1PPS"}, labels(q.Candidates()))
+
+	assert.False(t, q.Update([]*nl.PinInfo{output}, devices), "outputs are not candidates")
+	q.deleteMetrics()
+	assert.Zero(t, testutil.CollectAndCount(metrics.Rank))
+}
+
+func TestNewPinQualifierFromSettings(t *testing.T) {
+	q, drive, err := NewPinQualifierFromSettings(1, "ens1f0", map[string]string{"dpll.ens1f0.flags": "0"})
+	assert.NoError(t, err)
+	assert.Nil(t, q, "not configured")
+	assert.False(t, drive)
+
+	q, drive, err = NewPinQualifierFromSettings(1, "ens1f0", map[string]string{
+		PtpSettingsDpllQualificationKey("ens1f0", "maxPhaseOffset"):     "100",
+		PtpSettingsDpllQualificationKey("ens1f0", "maxFrequencyOffset"): "10",
+		PtpSettingsDpllQualificationKey("ens1f0", "preference"):         "GNSS-1PPS, SMA1",
+		PtpSettingsDpllQualificationKey("ens1f0", "drivePriorities"):    "true",
+	})
+	require.NoError(t, err)
+	require.NotNil(t, q)
+	assert.True(t, drive)
+	assert.Equal(t, QualificationThresholds{MaxPhaseOffset: 100, MaxFrequencyOffset: 10}, q.thresholds)
+	assert.Equal(t, []string{"GNSS-1PPS", "SMA1"}, q.preference)
+
+	_, _, err = NewPinQualifierFromSettings(1, "ens1f0", map[string]string{"dpll.ens1f0.qualification.maxFrequencyOffset": "-1"})
+	assert.ErrorContains(t, err, "invalid dpll.ens1f0.qualification.maxFrequencyOffset")
+}
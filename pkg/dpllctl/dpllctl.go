@@ -0,0 +1,225 @@
+// Package dpllctl implements dpllctl, a command to inspect and control the kernel DPLL devices
+// and pins, e.g. from the daemon container for field debugging. Clocks are selected by network
+// interface and pins by board label, with the clock ID resolution and the board label mapping
+// of the daemon hardware configurations.
+package dpllctl
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	nl "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/hardwareconfig"
+)
+
+// Output formats
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+)
+
+const usage = `Usage: dpllctl <command> [flags]
+
+Commands:
+  devices   list the DPLL devices
+  pins      list the DPLL pins, one row per parent
+  watch     print the DPLL notifications as they come
+  set-pin   set the priority, state or phase adjustment of a pin, after confirmation
+
+Run "dpllctl <command> -h" for the flags of a command.
+`
+
+// errAborted is returned when the confirmation of a command is declined
+var errAborted = errors.New("aborted")
+
+// CLI runs the dpllctl commands
+type CLI struct {
+	Out io.Writer
+	Err io.Writer
+	In  io.Reader
+	// ResolveClockID resolves the DPLL clock ID of a network interface, with the method
+	// of a hardware definition
+	ResolveClockID func(iface, hwDefPath string) (uint64, error)
+	// LoadBoardLabelMap loads the board label map of a hardware definition, as the daemon
+	// does from the board-label-mapping ConfigMap. Nil disables the board label mapping.
+	LoadBoardLabelMap func(hwDefPath string) (hardwareconfig.BoardLabelMap, error)
+	// Dial opens the DPLL netlink connections
+	Dial nl.Dialer
+}
+
+// New returns a CLI on the standard streams
+func New() *CLI {
+	return &CLI{
+		Out:            os.Stdout,
+		Err:            os.Stderr,
+		In:             os.Stdin,
+		ResolveClockID: resolveClockID,
+		Dial:           nl.DefaultDialer,
+	}
+}
+
+// resolveClockID resolves the clock ID of a network interface like the hardware configurations
+func resolveClockID(iface, hwDefPath string) (uint64, error) {
+	pinCache, err := hardwareconfig.GetDpllPins()
+	if err != nil {
+		return 0, err
+	}
+	return hardwareconfig.GetClockIDFromInterfaceWithCache(iface, hwDefPath, pinCache)
+}
+
+// Run runs the command of the arguments, without the program name
+func (c *CLI) Run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(c.Err, usage)
+		return fmt.Errorf("no command")
+	}
+	switch args[0] {
+	case "devices":
+		return c.devices(args[1:])
+	case "pins":
+		return c.pins(args[1:])
+	case "watch":
+		return c.watch(ctx, args[1:])
+	case "set-pin":
+		return c.setPin(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(c.Out, usage)
+		return nil
+	}
+	fmt.Fprint(c.Err, usage)
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// selector holds the flags selecting a clock and the output format, shared by the commands
+type selector struct {
+	iface   string
+	clockID string
+	hwDef   string
+	output  string
+	// labelMap maps the hardware definition board labels to the pin board labels
+	labelMap hardwareconfig.BoardLabelMap
+}
+
+func (c *CLI) newFlagSet(name string, s *selector) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.Err)
+	fs.StringVar(&s.iface, "iface", "", "Network interface selecting the clock, resolved like the daemon does.")
+	fs.StringVar(&s.clockID, "clock-id", "", "Clock ID selecting the clock, all clocks if neither -iface nor -clock-id is set.")
+	fs.StringVar(&s.hwDef, "hwdef", "", "Hardware definition, e.g. intel/e810, for the clock ID resolution and the board label mapping.")
+	fs.StringVar(&s.output, "o", OutputTable, "Output format, table or json.")
+	return fs
+}
+
+// parse parses the flags of a command, and loads the board label map of the hardware definition
+func (c *CLI) parse(fs *flag.FlagSet, s *selector, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	if s.output != OutputTable && s.output != OutputJSON {
+		return fmt.Errorf("unknown output format %q", s.output)
+	}
+	if s.iface != "" && s.clockID != "" {
+		return fmt.Errorf("-iface and -clock-id are exclusive")
+	}
+	if s.hwDef != "" && c.LoadBoardLabelMap != nil {
+		labelMap, err := c.LoadBoardLabelMap(s.hwDef)
+		if err != nil {
+			fmt.Fprintf(c.Err, "Warning: failed to load the board label map of %s: %v\n", s.hwDef, err)
+		}
+		s.labelMap = labelMap
+	}
+	return nil
+}
+
+// clock returns the selected clock ID, 0 for all clocks
+func (c *CLI) clock(s *selector) (uint64, error) {
+	switch {
+	case s.clockID != "":
+		clockID, err := strconv.ParseUint(s.clockID, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid clock ID %q: %w", s.clockID, err)
+		}
+		return clockID, nil
+	case s.iface != "":
+		clockID, err := c.ResolveClockID(s.iface, s.hwDef)
+		if err != nil {
+			return 0, fmt.Errorf("failed to resolve the clock ID of %s: %w", s.iface, err)
+		}
+		return clockID, nil
+	}
+	return 0, nil
+}
+
+// pinLabel maps a hardware definition board label to the board label of the pin
+func (s *selector) pinLabel(label string) string {
+	if mapped, ok := s.labelMap[label]; ok {
+		return mapped
+	}
+	return label
+}
+
+// definitionLabel maps the board label of a pin back to its hardware definition board label,
+// empty when the label is not mapped
+func (s *selector) definitionLabel(label string) string {
+	for definition, mapped := range s.labelMap {
+		if mapped == label && definition != label {
+			return definition
+		}
+	}
+	return ""
+}
+
+// dump returns the devices and the pins of the selected clock
+func (c *CLI) dump(clockID uint64) ([]*nl.DoDeviceGetReply, []*nl.PinInfo, error) {
+	conn, err := c.Dial()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to dial DPLL netlink: %w", err)
+	}
+	//nolint:errcheck
+	defer conn.Close()
+	devices, err := conn.DumpDeviceGet()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to dump DPLL devices: %w", err)
+	}
+	pins, err := conn.DumpPinGet()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to dump DPLL pins: %w", err)
+	}
+	if clockID == 0 {
+		return devices, pins, nil
+	}
+	clockDevices := make([]*nl.DoDeviceGetReply, 0, len(devices))
+	for _, device := range devices {
+		if device.ClockID == clockID {
+			clockDevices = append(clockDevices, device)
+		}
+	}
+	clockPins := make([]*nl.PinInfo, 0, len(pins))
+	for _, pin := range pins {
+		if pin.ClockID == clockID {
+			clockPins = append(clockPins, pin)
+		}
+	}
+	return clockDevices, clockPins, nil
+}
+
+// confirm asks the user to confirm a command
+func (c *CLI) confirm(question string) (bool, error) {
+	fmt.Fprintf(c.Out, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(c.In).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
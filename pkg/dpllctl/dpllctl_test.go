@@ -0,0 +1,226 @@
+package dpllctl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	nl "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink/dplltest"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/hardwareconfig"
+)
+
+const (
+	testClockID  uint64 = 0x507c6fffff1fb1b8
+	otherClockID uint64 = 0x507c6fffff1fb1c0
+)
+
+type testPins struct {
+	eec, pps, gnss, sma, otherSma uint32
+}
+
+func newTestSimulator(t *testing.T) (*dplltest.Simulator, testPins) {
+	t.Helper()
+	sim := dplltest.NewSimulator()
+	var p testPins
+	p.eec = sim.AddDevice(nl.DoDeviceGetReply{ModuleName: "ice", ClockID: testClockID, Type: nl.DpllTypeEEC})
+	p.pps = sim.AddDevice(nl.DoDeviceGetReply{ModuleName: "ice", ClockID: testClockID, Type: nl.DpllTypePPS})
+	otherPps := sim.AddDevice(nl.DoDeviceGetReply{ModuleName: "ice", ClockID: otherClockID, Type: nl.DpllTypePPS})
+	addPin := func(pin nl.PinInfo) uint32 {
+		id, err := sim.AddPin(pin)
+		require.NoError(t, err)
+		return id
+	}
+	prio := func(v uint32) *uint32 { return &v }
+	p.gnss = addPin(nl.PinInfo{
+		ModuleName: "ice", ClockID: testClockID, BoardLabel: "GNSS-1PPS", Type: nl.PinTypeGNSS,
+		Capabilities: nl.PinCapPrio | nl.PinCapState,
+		ParentDevice: []nl.PinParentDevice{
+			{ParentID: p.eec, Direction: nl.PinDirectionInput, Prio: prio(0)},
+			{ParentID: p.pps, Direction: nl.PinDirectionInput, Prio: prio(0)},
+		},
+	})
+	p.sma = addPin(nl.PinInfo{
+		ModuleName: "ice", ClockID: testClockID, BoardLabel: "J5", Type: nl.PinTypeEXT,
+		Capabilities:   nl.PinCapPrio | nl.PinCapState,
+		PhaseAdjustMin: -16000, PhaseAdjustMax: 16000, PhaseAdjustGran: 8,
+		ParentDevice: []nl.PinParentDevice{
+			{ParentID: p.eec, Direction: nl.PinDirectionInput, Prio: prio(3)},
+			{ParentID: p.pps, Direction: nl.PinDirectionInput, Prio: prio(3)},
+		},
+	})
+	p.otherSma = addPin(nl.PinInfo{
+		ModuleName: "ice", ClockID: otherClockID, BoardLabel: "J5", Type: nl.PinTypeEXT,
+		Capabilities: nl.PinCapPrio | nl.PinCapState,
+		ParentDevice: []nl.PinParentDevice{{ParentID: otherPps, Direction: nl.PinDirectionInput, Prio: prio(3)}},
+	})
+	return sim, p
+}
+
+func newTestCLI(sim *dplltest.Simulator, in string) (*CLI, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	return &CLI{
+		Out: out,
+		Err: &bytes.Buffer{},
+		In:  strings.NewReader(in),
+		ResolveClockID: func(iface, _ string) (uint64, error) {
+			if iface == "ens1f0" {
+				return testClockID, nil
+			}
+			return 0, fmt.Errorf("no DPLL for %s", iface)
+		},
+		LoadBoardLabelMap: func(hwDefPath string) (hardwareconfig.BoardLabelMap, error) {
+			if hwDefPath == "intel/e810" {
+				return hardwareconfig.BoardLabelMap{"SMA1": "J5"}, nil
+			}
+			return nil, nil
+		},
+		Dial: sim.Dialer(),
+	}, out
+}
+
+func TestList(t *testing.T) {
+	sim, _ := newTestSimulator(t)
+
+	cli, out := newTestCLI(sim, "")
+	require.NoError(t, cli.Run(context.Background(), []string{"devices", "-iface", "ens1f0"}))
+	assert.Contains(t, out.String(), "eec")
+	assert.Contains(t, out.String(), "pps")
+	assert.NotContains(t, out.String(), fmt.Sprintf("%#x", otherClockID))
+
+	cli, out = newTestCLI(sim, "")
+	require.NoError(t, cli.Run(context.Background(), []string{"devices", "-o", "json"}))
+	var devices []nl.DpllStatusHR
+	require.NoError(t, json.Unmarshal(out.Bytes(), &devices))
+	assert.Len(t, devices, 3)
+
+	cli, out = newTestCLI(sim, "")
+	require.NoError(t, cli.Run(context.Background(), []string{"pins", "-clock-id", fmt.Sprintf("%#x", otherClockID), "-o", "json"}))
+	var pins []nl.PinInfoHR
+	require.NoError(t, json.Unmarshal(out.Bytes(), &pins))
+	require.Len(t, pins, 1)
+	assert.Equal(t, "J5", pins[0].BoardLabel)
+
+	cli, out = newTestCLI(sim, "")
+	require.NoError(t, cli.Run(context.Background(), []string{"pins", "-iface", "ens1f0", "-hwdef", "intel/e810"}))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 5, "a header and a row per parent device")
+	assert.Contains(t, lines[0], "alias")
+	assert.Regexp(t, `J5\s+SMA1\s+ext\s+eec 0\s+input\s+3\s+selectable`, out.String(), "the pins show their hardware definition label")
+
+	cli, _ = newTestCLI(sim, "")
+	assert.Error(t, cli.Run(context.Background(), []string{"pins", "-iface", "ens2f0"}))
+	assert.Error(t, cli.Run(context.Background(), []string{"pins", "-o", "yaml"}))
+	assert.Error(t, cli.Run(context.Background(), []string{"unknown"}))
+}
+
+func TestSetPin(t *testing.T) {
+	sim, p := newTestSimulator(t)
+	prio := func(pin, device uint32) uint32 {
+		info, found := sim.Pin(pin)
+		require.True(t, found)
+		for _, pd := range info.ParentDevice {
+			if pd.ParentID == device {
+				return *pd.Prio
+			}
+		}
+		t.Fatalf("pin %d has no parent %d", pin, device)
+		return 0
+	}
+
+	cli, _ := newTestCLI(sim, "")
+	err := cli.Run(context.Background(), []string{"set-pin", "-pin", "J5", "-device", "pps", "-prio", "1", "-yes"})
+	assert.ErrorContains(t, err, "select one with -iface or -clock-id", "J5 is on both clocks")
+	err = cli.Run(context.Background(), []string{"set-pin", "-iface", "ens1f0", "-pin", "J5", "-prio", "1", "-yes"})
+	assert.ErrorContains(t, err, "select one with -device")
+	err = cli.Run(context.Background(), []string{"set-pin", "-iface", "ens1f0", "-pin", "J5"})
+	assert.ErrorContains(t, err, "nothing to set")
+
+	// declined confirmation
+	cli, out := newTestCLI(sim, "n\n")
+	err = cli.Run(context.Background(), []string{"set-pin", "-iface", "ens1f0", "-pin", "J5", "-device", "pps", "-prio", "1"})
+	assert.ErrorIs(t, err, errAborted)
+	assert.Contains(t, out.String(), "Apply to pin J5 of clock 0x507c6fffff1fb1b8? [y/N]")
+	assert.Equal(t, uint32(3), prio(p.sma, p.pps))
+
+	// the hardware definition label is mapped to the pin board label, like in the daemon
+	cli, out = newTestCLI(sim, "y\n")
+	err = cli.Run(context.Background(), []string{"set-pin", "-iface", "ens1f0", "-hwdef", "intel/e810", "-pin", "SMA1",
+		"-device", "pps", "-prio", "1", "-phase-adjust", "1234"})
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "Phase adjustment 1234 ps fitted to 1232 ps")
+	assert.Contains(t, out.String(), "Applied:")
+	assert.Equal(t, uint32(1), prio(p.sma, p.pps))
+	assert.Equal(t, uint32(3), prio(p.sma, p.eec), "the other parent device is not changed")
+	assert.Equal(t, uint32(3), prio(p.otherSma, 2), "the other clock is not changed")
+	pin, _ := sim.Pin(p.sma)
+	assert.Equal(t, int32(1232), pin.PhaseAdjust)
+
+	cli, _ = newTestCLI(sim, "")
+	err = cli.Run(context.Background(), []string{"set-pin", "-pin", fmt.Sprint(p.gnss), "-device", "eec", "-state", "disconnected", "-yes"})
+	require.NoError(t, err)
+	pin, _ = sim.Pin(p.gnss)
+	assert.Equal(t, uint32(nl.PinStateDisconnected), pin.ParentDevice[0].State)
+	err = cli.Run(context.Background(), []string{"set-pin", "-pin", fmt.Sprint(p.gnss), "-device", "eec", "-state", "on", "-yes"})
+	assert.ErrorContains(t, err, "unknown pin state")
+}
+
+func TestWatch(t *testing.T) {
+	sim, p := newTestSimulator(t)
+	cli, out := newTestCLI(sim, "")
+	done := make(chan error)
+	go func() {
+		done <- cli.Run(context.Background(), []string{"watch", "-clock-id", fmt.Sprintf("%#x", testClockID), "-o", "json", "-count", "2"})
+	}()
+	require.Eventually(t, func() bool { return sim.Subscribers() == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	// the other clock notifications are filtered out
+	require.NoError(t, sim.SetPhaseOffset(p.otherSma, 2, 1000))
+	require.NoError(t, sim.SetPhaseOffset(p.sma, p.pps, 5*nl.DpllPhaseOffsetDivider))
+	require.NoError(t, sim.SetLockStatus(p.pps, nl.DpllLockStatusHoldover))
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not exit after 2 notifications")
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	var notification struct {
+		Device *nl.DpllStatusHR `json:"device"`
+		Pin    *nl.PinInfoHR    `json:"pin"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &notification))
+	require.NotNil(t, notification.Pin)
+	assert.Equal(t, p.sma, notification.Pin.ID)
+	notification.Pin = nil
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &notification))
+	require.NotNil(t, notification.Device)
+	assert.Equal(t, "holdover", notification.Device.LockStatus)
+
+	// table output until interrupted
+	ctx, cancel := context.WithCancel(context.Background())
+	cli, out = newTestCLI(sim, "")
+	go func() {
+		done <- cli.Run(ctx, []string{"watch", "-iface", "ens1f0"})
+	}()
+	require.Eventually(t, func() bool { return sim.Subscribers() == 1 }, 2*time.Second, 10*time.Millisecond)
+	require.NoError(t, sim.SetLockStatus(p.pps, nl.DpllLockStatusLockedHoldoverAcquired))
+	require.Eventually(t, func() bool { return sim.Subscribers() == 1 }, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not exit on interrupt")
+	}
+	assert.Contains(t, out.String(), "device pps 1: clock 0x507c6fffff1fb1b8 mode automatic lock status locked-ho-acquired")
+}
@@ -0,0 +1,134 @@
+package dpllctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/rodaine/table"
+
+	nl "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+)
+
+// devices lists the DPLL devices
+func (c *CLI) devices(args []string) error {
+	s := &selector{}
+	fs := c.newFlagSet("devices", s)
+	if err := c.parse(fs, s, args); err != nil {
+		return err
+	}
+	clockID, err := c.clock(s)
+	if err != nil {
+		return err
+	}
+	devices, _, err := c.dump(clockID)
+	if err != nil {
+		return err
+	}
+	if s.output == OutputJSON {
+		return writeJSON(c.Out, devices, nl.GetDpllStatusHR)
+	}
+	tbl := table.New("id", "clock id", "type", "module", "mode", "lock status").WithWriter(c.Out)
+	for _, device := range devices {
+		tbl.AddRow(device.ID, fmt.Sprintf("%#x", device.ClockID), nl.GetDpllType(device.Type), device.ModuleName,
+			nl.GetMode(device.Mode), nl.GetLockStatus(device.LockStatus))
+	}
+	tbl.Print()
+	return nil
+}
+
+// pins lists the DPLL pins, one row per parent device or pin
+func (c *CLI) pins(args []string) error {
+	s := &selector{}
+	fs := c.newFlagSet("pins", s)
+	if err := c.parse(fs, s, args); err != nil {
+		return err
+	}
+	clockID, err := c.clock(s)
+	if err != nil {
+		return err
+	}
+	devices, pins, err := c.dump(clockID)
+	if err != nil {
+		return err
+	}
+	if s.output == OutputJSON {
+		return writeJSON(c.Out, pins, nl.GetPinInfoHR)
+	}
+	c.printPins(s, devices, pins)
+	return nil
+}
+
+// printPins prints a table of pins, one row per parent device or pin. Pins mapped from a hardware
+// definition board label show it as their alias.
+func (c *CLI) printPins(s *selector, devices []*nl.DoDeviceGetReply, pins []*nl.PinInfo) {
+	parentNames := deviceNames(devices)
+	headers := []interface{}{"id", "clock id", "label", "type", "parent", "dir", "prio", "state", "oper.", "phase offset (ps)", "phase adj. (ps)"}
+	if len(s.labelMap) > 0 {
+		headers = slices.Insert(headers, 3, interface{}("alias"))
+	}
+	tbl := table.New(headers...).WithWriter(c.Out)
+	for _, pin := range pins {
+		label := nl.GetPinLabel(pin)
+		row := func(parent, dir, prio, state, oper, phaseOffset string) {
+			cols := []interface{}{pin.ID, fmt.Sprintf("%#x", pin.ClockID), label}
+			if len(s.labelMap) > 0 {
+				cols = append(cols, s.definitionLabel(label))
+			}
+			cols = append(cols, nl.GetPinType(pin.Type), parent, dir, prio, state, oper, phaseOffset, pin.PhaseAdjust)
+			tbl.AddRow(cols...)
+		}
+		for _, pd := range pin.ParentDevice {
+			prio := ""
+			if pd.Prio != nil {
+				prio = strconv.FormatUint(uint64(*pd.Prio), 10)
+			}
+			oper := ""
+			if pd.Operstate != 0 {
+				oper = nl.GetPinOperstate(pd.Operstate)
+			}
+			row(parentNames(pd.ParentID), nl.GetPinDirection(pd.Direction), prio, nl.GetPinState(pd.State), oper,
+				strconv.FormatFloat(float64(pd.PhaseOffset)/nl.DpllPhaseOffsetDivider, 'f', -1, 64))
+		}
+		for _, pp := range pin.ParentPin {
+			row(fmt.Sprintf("pin %d", pp.ParentID), "", "", nl.GetPinState(pp.State), "", "")
+		}
+		if len(pin.ParentDevice) == 0 && len(pin.ParentPin) == 0 {
+			row("", "", "", "", "", "")
+		}
+	}
+	tbl.Print()
+}
+
+// deviceNames returns a function naming the parent devices of the pins by type, e.g. "pps 1"
+func deviceNames(devices []*nl.DoDeviceGetReply) func(id uint32) string {
+	names := make(map[uint32]string, len(devices))
+	for _, device := range devices {
+		names[device.ID] = fmt.Sprintf("%s %d", nl.GetDpllType(device.Type), device.ID)
+	}
+	return func(id uint32) string {
+		if name, found := names[id]; found {
+			return name
+		}
+		return fmt.Sprintf("dpll %d", id)
+	}
+}
+
+// writeJSON writes the human-readable JSON of DPLL replies as an array
+func writeJSON[T any](w io.Writer, replies []T, hr func(T, time.Time) ([]byte, error)) error {
+	now := time.Now()
+	objects := make([]json.RawMessage, 0, len(replies))
+	for _, reply := range replies {
+		b, err := hr(reply, now)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, b)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(objects)
+}
@@ -0,0 +1,165 @@
+package dpllctl
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	nl "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/hardwareconfig"
+)
+
+// setPin sets the priority, state or phase adjustment of a pin, after confirmation
+func (c *CLI) setPin(args []string) error {
+	s := &selector{}
+	fs := c.newFlagSet("set-pin", s)
+	pinName := fs.String("pin", "", "Pin to set, by board label (mapped like the hardware definitions), panel or package label, or ID.")
+	deviceName := fs.String("device", "", "Parent device of the priority and state, eec, pps or a device ID. Optional when the pin has one parent device.")
+	prio := fs.Uint("prio", 0, "Priority of the pin for the parent device.")
+	state := fs.String("state", "", "State of the pin for the parent device: connected, disconnected or selectable.")
+	phaseAdjust := fs.Int64("phase-adjust", 0, "Phase adjustment of the pin in ps, rounded to the pin granularity.")
+	yes := fs.Bool("yes", false, "Apply without confirmation.")
+	if err := c.parse(fs, s, args); err != nil {
+		return err
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if *pinName == "" {
+		return fmt.Errorf("-pin is required")
+	}
+	if !set["prio"] && !set["state"] && !set["phase-adjust"] {
+		return fmt.Errorf("nothing to set, use -prio, -state or -phase-adjust")
+	}
+	clockID, err := c.clock(s)
+	if err != nil {
+		return err
+	}
+	devices, pins, err := c.dump(clockID)
+	if err != nil {
+		return err
+	}
+	pin, err := findPin(pins, s.pinLabel(*pinName))
+	if err != nil {
+		return err
+	}
+
+	command := nl.PinParentDeviceCtl{ID: pin.ID}
+	if set["phase-adjust"] {
+		if *phaseAdjust < math.MinInt32 || *phaseAdjust > math.MaxInt32 {
+			return fmt.Errorf("phase adjustment %d ps out of range", *phaseAdjust)
+		}
+		adjustment := hardwareconfig.FitPhaseAdjust(nl.GetPinLabel(pin), int32(*phaseAdjust), pin)
+		if int64(adjustment) != *phaseAdjust {
+			fmt.Fprintf(c.Out, "Phase adjustment %d ps fitted to %d ps (granularity %d ps, range [%d, %d] ps)\n",
+				*phaseAdjust, adjustment, pin.PhaseAdjustGran, pin.PhaseAdjustMin, pin.PhaseAdjustMax)
+		}
+		command.PhaseAdjust = &adjustment
+	}
+	if set["prio"] || set["state"] {
+		parentID, parentErr := parentDevice(devices, pin, *deviceName)
+		if parentErr != nil {
+			return parentErr
+		}
+		control := nl.PinControl{PinParentID: parentID}
+		if set["prio"] {
+			if *prio > math.MaxUint32 {
+				return fmt.Errorf("priority %d out of range", *prio)
+			}
+			p := uint32(*prio)
+			control.Prio = &p
+		}
+		if set["state"] {
+			st := nl.ParsePinState(*state)
+			if st == 0 {
+				return fmt.Errorf("unknown pin state %q", *state)
+			}
+			control.State = &st
+		}
+		command.PinParentCtl = []nl.PinControl{control}
+	}
+
+	c.printPins(s, devices, []*nl.PinInfo{pin})
+	fmt.Fprintf(c.Out, "Command: %s\n", command)
+	if !*yes {
+		confirmed, confirmErr := c.confirm(fmt.Sprintf("Apply to pin %s of clock %#x?", nl.GetPinLabel(pin), pin.ClockID))
+		if confirmErr != nil {
+			return confirmErr
+		}
+		if !confirmed {
+			return errAborted
+		}
+	}
+
+	conn, err := c.Dial()
+	if err != nil {
+		return fmt.Errorf("failed to dial DPLL netlink: %w", err)
+	}
+	//nolint:errcheck
+	defer conn.Close()
+	if err = conn.DoPinSet(command); err != nil {
+		return fmt.Errorf("failed to set pin %s: %w", nl.GetPinLabel(pin), err)
+	}
+	after, err := conn.DoPinGet(nl.DoPinGetRequest{ID: pin.ID})
+	if err != nil {
+		return fmt.Errorf("failed to read pin %s back: %w", nl.GetPinLabel(pin), err)
+	}
+	fmt.Fprintln(c.Out, "Applied:")
+	c.printPins(s, devices, []*nl.PinInfo{after})
+	return nil
+}
+
+// findPin finds a pin by board, panel or package label, or by ID. The label must select one pin,
+// the clock is selected with -iface or -clock-id when several clocks have it.
+func findPin(pins []*nl.PinInfo, name string) (*nl.PinInfo, error) {
+	id, idErr := strconv.ParseUint(name, 10, 32)
+	var found []*nl.PinInfo
+	for _, pin := range pins {
+		if pin.BoardLabel == name || pin.PanelLabel == name || pin.PackageLabel == name ||
+			(idErr == nil && pin.ID == uint32(id)) {
+			found = append(found, pin)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("pin %s not found", name)
+	case 1:
+		return found[0], nil
+	}
+	clocks := make([]string, 0, len(found))
+	for _, pin := range found {
+		clocks = append(clocks, fmt.Sprintf("%#x", pin.ClockID))
+	}
+	return nil, fmt.Errorf("pin %s found on clocks %s, select one with -iface or -clock-id", name, strings.Join(clocks, ", "))
+}
+
+// parentDevice returns the parent device of a pin named by type or ID, or its only parent device
+func parentDevice(devices []*nl.DoDeviceGetReply, pin *nl.PinInfo, name string) (uint32, error) {
+	isParent := func(id uint32) bool {
+		for _, pd := range pin.ParentDevice {
+			if pd.ParentID == id {
+				return true
+			}
+		}
+		return false
+	}
+	if name == "" {
+		if len(pin.ParentDevice) != 1 {
+			return 0, fmt.Errorf("pin %s has %d parent devices, select one with -device", nl.GetPinLabel(pin), len(pin.ParentDevice))
+		}
+		return pin.ParentDevice[0].ParentID, nil
+	}
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		if !isParent(uint32(id)) {
+			return 0, fmt.Errorf("device %d is not a parent of pin %s", id, nl.GetPinLabel(pin))
+		}
+		return uint32(id), nil
+	}
+	for _, device := range devices {
+		if device.ClockID == pin.ClockID && nl.GetDpllType(device.Type) == strings.ToLower(name) && isParent(device.ID) {
+			return device.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("no %s parent device for pin %s", name, nl.GetPinLabel(pin))
+}
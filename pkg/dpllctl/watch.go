@@ -0,0 +1,159 @@
+package dpllctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/genetlink"
+
+	nl "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+)
+
+// watchNotification is a notification printed by watch in JSON, one per line
+type watchNotification struct {
+	Device json.RawMessage `json:"device,omitempty"`
+	Pin    json.RawMessage `json:"pin,omitempty"`
+}
+
+// watch prints the DPLL notifications of the selected clock until the context is done
+func (c *CLI) watch(ctx context.Context, args []string) error {
+	s := &selector{}
+	fs := c.newFlagSet("watch", s)
+	count := fs.Int("count", 0, "Exit after this number of notifications, 0 to watch until interrupted.")
+	if err := c.parse(fs, s, args); err != nil {
+		return err
+	}
+	clockID, err := c.clock(s)
+	if err != nil {
+		return err
+	}
+	devices, _, err := c.dump(clockID)
+	if err != nil {
+		return err
+	}
+	parentNames := deviceNames(devices)
+
+	conn, err := c.Dial()
+	if err != nil {
+		return fmt.Errorf("failed to dial DPLL netlink: %w", err)
+	}
+	var closeOnce sync.Once
+	closeConn := func() { closeOnce.Do(func() { _ = conn.Close() }) }
+	defer closeConn()
+	mcastID, found := conn.GetMcastGroupID(nl.DpllMCGRPMonitor)
+	if !found {
+		return fmt.Errorf("multicast group %s not found", nl.DpllMCGRPMonitor)
+	}
+	gconn := conn.GetGenetlinkConn()
+	if err = gconn.JoinGroup(mcastID); err != nil {
+		return fmt.Errorf("failed to join the DPLL %s group: %w", nl.DpllMCGRPMonitor, err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		// unblocks Receive
+		select {
+		case <-ctx.Done():
+			closeConn()
+		case <-stop:
+		}
+	}()
+
+	for n := 0; *count == 0 || n < *count; {
+		msgs, _, receiveErr := gconn.Receive()
+		if receiveErr != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if nl.IsOverrun(receiveErr) {
+				fmt.Fprintf(c.Err, "Warning: notifications lost: %v\n", receiveErr)
+				continue
+			}
+			return receiveErr
+		}
+		for _, msg := range msgs {
+			printed, printErr := c.printNotification(s, msg, clockID, parentNames)
+			if printErr != nil {
+				return printErr
+			}
+			if printed {
+				n++
+			}
+		}
+	}
+	return nil
+}
+
+// printNotification prints a device or pin notification of the clock, 0 for all clocks
+func (c *CLI) printNotification(s *selector, msg genetlink.Message, clockID uint64, parentNames func(uint32) string) (bool, error) {
+	now := time.Now()
+	var notification watchNotification
+	var line string
+	switch msg.Header.Command {
+	case nl.DpllCmdDeviceCreateNtf, nl.DpllCmdDeviceChangeNtf, nl.DpllCmdDeviceDeleteNtf:
+		devices, err := nl.ParseDeviceReplies([]genetlink.Message{msg})
+		if err != nil || len(devices) == 0 || (clockID != 0 && devices[0].ClockID != clockID) {
+			return false, err
+		}
+		device := devices[0]
+		if notification.Device, err = nl.GetDpllStatusHR(device, now); err != nil {
+			return false, err
+		}
+		line = fmt.Sprintf("%s %s: clock %#x mode %s lock status %s", notificationName(msg.Header.Command, "device"),
+			parentNames(device.ID), device.ClockID, nl.GetMode(device.Mode), nl.GetLockStatus(device.LockStatus))
+	case nl.DpllCmdPinCreateNtf, nl.DpllCmdPinChangeNtf, nl.DpllCmdPinDeleteNtf:
+		pins, err := nl.ParsePinReplies([]genetlink.Message{msg})
+		if err != nil || len(pins) == 0 || (clockID != 0 && pins[0].ClockID != clockID) {
+			return false, err
+		}
+		pin := pins[0]
+		if notification.Pin, err = nl.GetPinInfoHR(pin, now); err != nil {
+			return false, err
+		}
+		label := nl.GetPinLabel(pin)
+		if definition := s.definitionLabel(label); definition != "" {
+			label = fmt.Sprintf("%s (%s)", label, definition)
+		}
+		parents := make([]string, 0, len(pin.ParentDevice))
+		for _, pd := range pin.ParentDevice {
+			parent := fmt.Sprintf("%s %s %s", parentNames(pd.ParentID), nl.GetPinDirection(pd.Direction), nl.GetPinState(pd.State))
+			if pd.Prio != nil {
+				parent += fmt.Sprintf(" prio %d", *pd.Prio)
+			}
+			if pd.Direction == nl.PinDirectionInput {
+				parent += fmt.Sprintf(" phase offset %g ps", float64(pd.PhaseOffset)/nl.DpllPhaseOffsetDivider)
+			}
+			parents = append(parents, parent)
+		}
+		line = fmt.Sprintf("%s %d %s: clock %#x phase adj. %d ps; %s", notificationName(msg.Header.Command, "pin"),
+			pin.ID, label, pin.ClockID, pin.PhaseAdjust, strings.Join(parents, "; "))
+	default:
+		return false, nil
+	}
+
+	if s.output == OutputJSON {
+		b, err := json.Marshal(notification)
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintln(c.Out, string(b))
+		return true, nil
+	}
+	fmt.Fprintf(c.Out, "%s %s\n", now.Format(time.RFC3339Nano), line)
+	return true, nil
+}
+
+// notificationName names a notification, e.g. "pin" for a change or "pin created"
+func notificationName(command uint8, object string) string {
+	switch command {
+	case nl.DpllCmdDeviceCreateNtf, nl.DpllCmdPinCreateNtf:
+		return object + " created"
+	case nl.DpllCmdDeviceDeleteNtf, nl.DpllCmdPinDeleteNtf:
+		return object + " deleted"
+	}
+	return object
+}
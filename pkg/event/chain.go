@@ -0,0 +1,82 @@
+package event
+
+import (
+	"strings"
+
+	fbprotocol "github.com/facebook/time/ptp/protocol"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/protocol"
+)
+
+// clockChain ... state of the clock chain driven by one profile. A node can host several
+// independent chains, e.g. two T-GMs on separate NIC groups or a T-GM and a T-BC,
+// each with its own GNSS, DPLL and leading clock.
+type clockChain struct {
+	clockClass         fbprotocol.ClockClass
+	clockAccuracy      fbprotocol.ClockAccuracy
+	outOfSpec          bool // is offset out of spec, used for Lost Source,In Spec and Out of Spec state transitions
+	frequencyTraceable bool // will be true if synce is traceable
+	leadingClockData   *LeadingClockParams
+}
+
+func newClockChain() *clockChain {
+	return &clockChain{
+		clockClass:       protocol.ClockClassUninitialized,
+		leadingClockData: newLeadingClockParams(),
+	}
+}
+
+// chainKey returns the clock chain of a process config name. The ptp4l, phc2sys and ts2phc
+// configs of a profile (ptp4l.0.config, ts2phc.0.config, ...) belong to the same chain.
+func chainKey(cfgName string) string {
+	if _, profile, found := strings.Cut(cfgName, "."); found {
+		return profile
+	}
+	return cfgName
+}
+
+// chain returns the clock chain of the process config name, creating it on first use
+func (e *EventHandler) chain(cfgName string) *clockChain {
+	e.chainsMu.Lock()
+	defer e.chainsMu.Unlock()
+	if e.chains == nil {
+		e.chains = map[string]*clockChain{}
+	}
+	key := chainKey(cfgName)
+	if upstream, ok := e.controlledChains[key]; ok {
+		key = upstream
+	}
+	c, ok := e.chains[key]
+	if !ok {
+		c = newClockChain()
+		e.chains[key] = c
+	}
+	return c
+}
+
+// linkControlledChain makes the T-BC controlled profile share the clock chain of its upstream profile,
+// the downstream ports follow the leading clock of the upstream ptp4l
+func (e *EventHandler) linkControlledChain(cfgName, controlledCfgName string) {
+	e.chainsMu.Lock()
+	defer e.chainsMu.Unlock()
+	key, controlledKey := chainKey(cfgName), chainKey(controlledCfgName)
+	if key == controlledKey || e.controlledChains[controlledKey] == key {
+		return
+	}
+	if e.controlledChains == nil {
+		e.controlledChains = map[string]string{}
+	}
+	e.controlledChains[controlledKey] = key
+	// the chain created for the controlled profile before the link is known is dropped
+	delete(e.chains, controlledKey)
+}
+
+// leadingClockData returns the leading clock parameters of the clock chain of the process config name
+func (e *EventHandler) leadingClockData(cfgName string) *LeadingClockParams {
+	return e.chain(cfgName).leadingClockData
+}
+
+// resetLeadingClockData drops the leading clock parameters of the chain of the process config name
+func (e *EventHandler) resetLeadingClockData(cfgName string) {
+	e.chain(cfgName).leadingClockData = newLeadingClockParams()
+}
@@ -0,0 +1,85 @@
+package event
+
+import (
+	"testing"
+	"time"
+
+	fbprotocol "github.com/facebook/time/ptp/protocol"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainKey(t *testing.T) {
+	assert.Equal(t, "0.config", chainKey("ts2phc.0.config"))
+	assert.Equal(t, chainKey("ts2phc.1.config"), chainKey("ptp4l.1.config"))
+	assert.NotEqual(t, chainKey("ts2phc.0.config"), chainKey("ts2phc.1.config"))
+	assert.Equal(t, "", chainKey(""))
+}
+
+func TestClockChains_Independent(t *testing.T) {
+	const gm0, gm1 = "ts2phc.0.config", "ts2phc.1.config"
+	e := &EventHandler{
+		data:         map[string][]*Data{},
+		clkSyncState: map[string]*clockSyncState{},
+	}
+	makeEvent := func(cfgName, iface string, process EventSource, state PTPState) Event {
+		ev := Event{Source: process, IFace: iface, CfgName: cfgName, ClockType: GM, Time: time.Now().UnixMilli()}
+		if process == GNSS {
+			ev.Data = &GNSSData{GPSStatus: 3}
+		} else {
+			// only the first T-GM reports a DPLL out of holdover specification
+			ev.Data = &PTPData{State: state, Values: map[ValueType]interface{}{OFFSET: int64(0)},
+				OutOfSpec: cfgName == gm0, FrequencyTraceable: true}
+		}
+		return ev
+	}
+
+	for cfgName, iface := range map[string]string{gm0: "ens1f0", gm1: "ens2f0"} {
+		e.addEvent(makeEvent(cfgName, iface, GNSS, PTP_LOCKED))
+		e.addEvent(makeEvent(cfgName, iface, DPLL, PTP_FREERUN))
+	}
+	assert.True(t, e.chain(gm0).outOfSpec)
+	assert.False(t, e.chain(gm1).outOfSpec)
+	assert.Equal(t, protocol.ClockClassOutOfSpec, e.updateGMState(gm0).clockClass)
+	assert.Equal(t, protocol.ClockClassFreerun, e.updateGMState(gm1).clockClass)
+
+	e.setClockClassLocked(gm0, fbprotocol.ClockClass6, fbprotocol.ClockAccuracyNanosecond100)
+	assert.Equal(t, fbprotocol.ClockClass6, e.chain(gm0).clockClass)
+	assert.Equal(t, protocol.ClockClassUninitialized, e.chain(gm1).clockClass)
+
+	// leading clock data of a T-BC profile stays with its own chain
+	e.updateLeadingClockData(Event{Source: DPLL, IFace: "ens3f0", CfgName: "ptp4l.2.config",
+		Data: &PTPData{Values: map[ValueType]interface{}{LeadingSource: true}}})
+	assert.Equal(t, "ens3f0", e.getLeadingInterfaceBC("ptp4l.2.config"))
+	assert.Equal(t, LEADING_INTERFACE_UNKNOWN, e.getLeadingInterfaceBC("ptp4l.0.config"))
+
+	e.resetLeadingClockData("ptp4l.2.config")
+	assert.Equal(t, LEADING_INTERFACE_UNKNOWN, e.getLeadingInterfaceBC("ptp4l.2.config"))
+}
+
+func TestClockChains_TBCControlledProfile(t *testing.T) {
+	const upstream, controlled = "ptp4l.0.config", "ptp4l.1.config"
+	e := &EventHandler{
+		data:         map[string][]*Data{},
+		clkSyncState: map[string]*clockSyncState{},
+	}
+	// the downstream profile may report before the upstream ptp4l names it
+	e.chain(controlled).outOfSpec = true
+
+	e.updateLeadingClockData(Event{Source: PTP4lProcessName, IFace: "ens1f0", CfgName: upstream,
+		Data: &PTPData{Values: map[ValueType]interface{}{ControlledPortsConfig: controlled, ClockIDKey: "aabbcc.fffe.ddeeff"}}})
+	e.updateLeadingClockData(Event{Source: DPLL, IFace: "ens1f0", CfgName: "ts2phc.0.config",
+		Data: &PTPData{Values: map[ValueType]interface{}{LeadingSource: true}}})
+
+	assert.Same(t, e.chain(upstream), e.chain(controlled))
+	assert.False(t, e.chain(controlled).outOfSpec)
+	lcd := e.leadingClockData(controlled)
+	assert.Equal(t, controlled, lcd.controlledPortsConfig)
+	assert.Equal(t, "aabbcc.fffe.ddeeff", lcd.clockID)
+	assert.Equal(t, "ens1f0", e.getLeadingInterfaceBC(controlled))
+
+	e.setClockClassLocked(upstream, fbprotocol.ClockClass6, fbprotocol.ClockAccuracyNanosecond100)
+	assert.Equal(t, fbprotocol.ClockClass6, e.chain(controlled).clockClass)
+	// an unrelated profile keeps its own chain
+	assert.NotSame(t, e.chain(upstream), e.chain("ptp4l.2.config"))
+}
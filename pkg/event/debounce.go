@@ -0,0 +1,259 @@
+package event
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PtpSettings keys of the per profile state debouncing
+const (
+	// DebounceMinDwellSetting is the minimum time a source stays in a state, e.g. "5s"
+	DebounceMinDwellSetting = "stateMinDwell"
+	// DebounceNSetting is the number of the last M events that must report a new source state
+	DebounceNSetting = "stateChangeN"
+	// DebounceMSetting is the number of events the N-of-M rule looks back on
+	DebounceMSetting = "stateChangeM"
+)
+
+// DebounceConfig holds the state debouncing settings of a profile
+type DebounceConfig struct {
+	MinDwell time.Duration
+	N        int
+	M        int
+}
+
+// ParseDebounceConfig reads the state debouncing settings of a profile.
+// Returns nil when debouncing is not enabled.
+func ParseDebounceConfig(settings map[string]string) (*DebounceConfig, error) {
+	cfg := &DebounceConfig{N: 1, M: 1}
+	var enabled bool
+	if s, ok := settings[DebounceMinDwellSetting]; ok {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid %s %q", DebounceMinDwellSetting, s)
+		}
+		cfg.MinDwell = d
+		enabled = true
+	}
+	sN, hasN := settings[DebounceNSetting]
+	sM, hasM := settings[DebounceMSetting]
+	if hasN != hasM {
+		return nil, fmt.Errorf("%s and %s must be set together", DebounceNSetting, DebounceMSetting)
+	}
+	if hasN {
+		n, errN := strconv.Atoi(sN)
+		m, errM := strconv.Atoi(sM)
+		if errN != nil || errM != nil || n < 1 || n > m {
+			return nil, fmt.Errorf("invalid %s/%s %q/%q, expected 1 <= N <= M", DebounceNSetting, DebounceMSetting, sN, sM)
+		}
+		cfg.N, cfg.M = n, m
+		enabled = true
+	}
+	if !enabled {
+		return nil, nil
+	}
+	return cfg, nil
+}
+
+type debounceKey struct {
+	cfgName string
+	source  EventSource
+	iface   string
+}
+
+// debounceState is the debounced state of a source interface
+type debounceState struct {
+	state    PTPState
+	since    int64      // time of the last accepted transition, ms
+	history  []PTPState // last M reported states, oldest first
+	pending  bool       // a reported state change is being held back
+	accepted EventData  // data of the last event reporting the accepted state
+	// timer commits the change reported by pendingEvent once the accepted state dwelled MinDwell,
+	// when only the dwell holds it back. generation tells the timers apart.
+	timer        *time.Timer
+	generation   int
+	pendingEvent Event
+}
+
+// stopTimer cancels the commit of the held back state change
+func (s *debounceState) stopTimer() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.pendingEvent = Event{}
+}
+
+// reportedState returns the source state reported by the event data, PTP_NOTSET when it reports none
+func reportedState(data EventData) PTPState {
+	switch d := data.(type) {
+	case *PTPData:
+		return d.State
+	case *GNSSData:
+		return d.State()
+	}
+	return PTP_NOTSET
+}
+
+// holdState returns a copy of the event data reporting the held state: the GNSS receiver status
+// of the accepted data, or the PTP state
+func holdState(data, accepted EventData, state PTPState) EventData {
+	switch d := data.(type) {
+	case *PTPData:
+		held := *d
+		held.State = state
+		return &held
+	case *GNSSData:
+		held := *d
+		if a, ok := accepted.(*GNSSData); ok {
+			held.GPSStatus, held.SourceLost = a.GPSStatus, a.SourceLost
+		}
+		return &held
+	}
+	return data
+}
+
+// SetDebounceConfig sets the state debouncing of the profile config, nil disables it
+func (e *EventHandler) SetDebounceConfig(cfgName string, cfg *DebounceConfig) {
+	e.Lock()
+	defer e.Unlock()
+	if e.debounceConfig == nil {
+		e.debounceConfig = map[string]*DebounceConfig{}
+	}
+	e.debounceConfig[cfgName] = cfg
+}
+
+// ClearDebounceConfig disables state debouncing of all profiles and drops the debounced states
+func (e *EventHandler) ClearDebounceConfig() {
+	e.Lock()
+	defer e.Unlock()
+	e.debounceConfig = nil
+	for _, s := range e.debounceStates {
+		s.stopTimer()
+	}
+	e.debounceStates = nil
+}
+
+// SetSuppressedFlapsMetric sets the counter of state changes held back by debouncing
+func (e *EventHandler) SetSuppressedFlapsMetric(metric *prometheus.CounterVec) {
+	e.suppressedFlapsMetric = metric
+}
+
+// debounce holds back source state changes that have not been reported by N of the last M
+// events of the source interface, or that come before the current state dwelled MinDwell.
+// A change held back by the dwell only is committed when the dwell ends, even if the source
+// reports nothing more. Returns the event with the debounced state. Caller must hold e.Lock().
+func (e *EventHandler) debounce(event Event) Event {
+	cfg := e.debounceConfig[event.CfgName]
+	state := reportedState(event.Data)
+	if cfg == nil || state == PTP_NOTSET {
+		return event
+	}
+	if e.debounceStates == nil {
+		e.debounceStates = map[debounceKey]*debounceState{}
+	}
+	key := debounceKey{cfgName: event.CfgName, source: event.Source, iface: event.IFace}
+	s, found := e.debounceStates[key]
+	if !found {
+		e.debounceStates[key] = &debounceState{state: state, since: event.Time, history: []PTPState{state}, accepted: event.Data}
+		return event
+	}
+	s.history = append(s.history, state)
+	if len(s.history) > cfg.M {
+		s.history = s.history[len(s.history)-cfg.M:]
+	}
+	if state == s.state {
+		s.accepted = event.Data
+		if s.pending {
+			s.pending = false
+			s.stopTimer()
+			glog.Infof("debounce: suppressed %s %s state flap on %s, staying %s", event.CfgName, event.Source, event.IFace, s.state)
+			if e.suppressedFlapsMetric != nil {
+				e.suppressedFlapsMetric.With(prometheus.Labels{
+					"process": string(event.Source), "node": e.nodeName, "iface": event.IFace, "profile": event.CfgName}).Inc()
+			}
+		}
+		return event
+	}
+	var reported int
+	for _, st := range s.history {
+		if st == state {
+			reported++
+		}
+	}
+	dwell := time.Duration(event.Time-s.since) * time.Millisecond
+	if reported >= cfg.N && dwell >= cfg.MinDwell {
+		s.state = state
+		s.since = event.Time
+		s.pending = false
+		s.accepted = event.Data
+		s.stopTimer()
+		return event
+	}
+	s.pending = true
+	if reported >= cfg.N {
+		s.pendingEvent = event
+		if s.timer == nil {
+			s.generation++
+			generation := s.generation
+			s.timer = time.AfterFunc(cfg.MinDwell-dwell, func() { e.commitDebounced(key, generation) })
+		}
+	} else {
+		s.stopTimer()
+	}
+	event.Data = holdState(event.Data, s.accepted, s.state)
+	return event
+}
+
+// commitDebounced accepts the state change held back by the dwell of a source interface, and sends
+// its last event to the event loop again to apply it. When the event loop is too busy to take it,
+// the change is rolled back, to be accepted again by the next event reporting it.
+func (e *EventHandler) commitDebounced(key debounceKey, generation int) {
+	e.Lock()
+	s, found := e.debounceStates[key]
+	if !found || s.timer == nil || s.generation != generation {
+		e.Unlock()
+		return
+	}
+	event := s.pendingEvent
+	prevState, prevSince, prevAccepted := s.state, s.since, s.accepted
+	s.timer = nil
+	s.pendingEvent = Event{}
+	now := time.Now().UnixMilli()
+	s.state = reportedState(event.Data)
+	s.since = now
+	s.pending = false
+	s.accepted = event.Data
+	state := s.state
+	e.Unlock()
+
+	glog.Infof("debounce: %s %s state %s on %s passed the dwell time", event.CfgName, event.Source, state, event.IFace)
+	event.Time = now
+	select {
+	case e.debounceCh <- event:
+		return
+	default:
+	}
+	glog.Warningf("debounce: dropped %s %s state change on %s, event loop busy", event.CfgName, event.Source, event.IFace)
+	e.Lock()
+	defer e.Unlock()
+	// roll back unless the state was changed or reset meanwhile
+	if current, ok := e.debounceStates[key]; ok && current == s && s.generation == generation && s.timer == nil && s.since == now {
+		s.state, s.since, s.accepted = prevState, prevSince, prevAccepted
+	}
+}
+
+// resetDebounce drops the debounced states of the profile source, or of all its sources
+// when source is empty. Caller must hold e.Lock().
+func (e *EventHandler) resetDebounce(cfgName string, source EventSource) {
+	for key, s := range e.debounceStates {
+		if key.cfgName == cfgName && (source == "" || key.source == source) {
+			s.stopTimer()
+			delete(e.debounceStates, key)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package event
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDebounceConfig(t *testing.T) {
+	cfg, err := ParseDebounceConfig(map[string]string{"clockType": "T-GM"})
+	assert.NoError(t, err)
+	assert.Nil(t, cfg)
+
+	cfg, err = ParseDebounceConfig(map[string]string{DebounceMinDwellSetting: "5s"})
+	assert.NoError(t, err)
+	assert.Equal(t, &DebounceConfig{MinDwell: 5 * time.Second, N: 1, M: 1}, cfg)
+
+	cfg, err = ParseDebounceConfig(map[string]string{DebounceNSetting: "3", DebounceMSetting: "5"})
+	assert.NoError(t, err)
+	assert.Equal(t, &DebounceConfig{N: 3, M: 5}, cfg)
+
+	for _, settings := range []map[string]string{
+		{DebounceMinDwellSetting: "soon"},
+		{DebounceNSetting: "3"},
+		{DebounceNSetting: "6", DebounceMSetting: "5"},
+		{DebounceNSetting: "0", DebounceMSetting: "5"},
+	} {
+		_, err = ParseDebounceConfig(settings)
+		assert.Error(t, err, settings)
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	const cfg = "ts2phc.0.config"
+	const iface = "ens1f0"
+	metric := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "flaps"}, []string{"process", "node", "iface", "profile"})
+	e := &EventHandler{nodeName: "node1"}
+	e.SetSuppressedFlapsMetric(metric)
+	e.SetDebounceConfig(cfg, &DebounceConfig{MinDwell: 2 * time.Second, N: 2, M: 3})
+
+	start := time.Now().UnixMilli()
+	send := func(state PTPState, afterMs int64) PTPState {
+		ev := e.debounce(Event{Source: DPLL, IFace: iface, CfgName: cfg, ClockType: GM, Time: start + afterMs,
+			Data: &PTPData{State: state}})
+		return ev.Data.(*PTPData).State
+	}
+	flaps := func() float64 {
+		return testutil.ToFloat64(metric.WithLabelValues(string(DPLL), "node1", iface, cfg))
+	}
+
+	assert.Equal(t, PTP_LOCKED, send(PTP_LOCKED, 0))
+	// a single FREERUN spike is held back and counted once it reverts
+	assert.Equal(t, PTP_LOCKED, send(PTP_FREERUN, 3000))
+	assert.Equal(t, PTP_LOCKED, send(PTP_LOCKED, 3100))
+	assert.Equal(t, 1.0, flaps())
+
+	// two of the last three events report FREERUN
+	assert.Equal(t, PTP_FREERUN, send(PTP_FREERUN, 3200))
+	assert.Equal(t, 1.0, flaps())
+
+	// reported by two of the last three events but FREERUN has not dwelled long enough
+	assert.Equal(t, PTP_FREERUN, send(PTP_LOCKED, 3300))
+	assert.Equal(t, PTP_FREERUN, send(PTP_LOCKED, 3400))
+	assert.Equal(t, PTP_LOCKED, send(PTP_LOCKED, 5200))
+	assert.Equal(t, 1.0, flaps())
+
+	// events of other profiles and sources without state pass untouched
+	ev := e.debounce(Event{Source: DPLL, IFace: iface, CfgName: "ts2phc.1.config", Data: &PTPData{State: PTP_FREERUN}})
+	assert.Equal(t, PTP_FREERUN, ev.Data.(*PTPData).State)
+	ev = e.debounce(Event{Source: PTP4l, IFace: iface, CfgName: cfg, Data: &PTPData{State: PTP_NOTSET}})
+	assert.Equal(t, PTP_NOTSET, ev.Data.(*PTPData).State)
+
+	e.resetDebounce(cfg, DPLL)
+	assert.Equal(t, PTP_FREERUN, send(PTP_FREERUN, 5400))
+	e.ClearDebounceConfig()
+	assert.Equal(t, PTP_LOCKED, send(PTP_LOCKED, 5500))
+}
+
+func TestDebounce_GNSS(t *testing.T) {
+	const cfg = "ts2phc.0.config"
+	const iface = "ens1f0"
+	e := &EventHandler{nodeName: "node1"}
+	e.SetDebounceConfig(cfg, &DebounceConfig{N: 2, M: 3})
+
+	start := time.Now().UnixMilli()
+	send := func(data *GNSSData, afterMs int64) *GNSSData {
+		ev := e.debounce(Event{Source: GNSS, IFace: iface, CfgName: cfg, ClockType: GM, Time: start + afterMs, Data: data})
+		return ev.Data.(*GNSSData)
+	}
+
+	assert.Equal(t, PTP_LOCKED, send(&GNSSData{GPSStatus: 3, Offset: 5}, 0).State())
+	// a lost fix spike keeps the fix of the accepted state, with the new offset
+	held := send(&GNSSData{GPSStatus: 0, Offset: 7, SourceLost: true}, 1000)
+	assert.Equal(t, PTP_LOCKED, held.State())
+	assert.Equal(t, int64(3), held.GPSStatus)
+	assert.False(t, held.SourceLost)
+	assert.Equal(t, int64(7), held.Offset)
+	assert.Equal(t, PTP_LOCKED, send(&GNSSData{GPSStatus: 3}, 2000).State())
+	assert.Equal(t, PTP_LOCKED, send(&GNSSData{GPSStatus: 3}, 3000).State())
+
+	// lost by two of the last three events
+	assert.Equal(t, PTP_LOCKED, send(&GNSSData{GPSStatus: 1}, 4000).State())
+	assert.Equal(t, PTP_FREERUN, send(&GNSSData{GPSStatus: 1}, 5000).State())
+}
+
+func TestDebounce_DwellTimer(t *testing.T) {
+	const cfg = "ts2phc.0.config"
+	const iface = "ens1f0"
+	e := &EventHandler{nodeName: "node1", debounceCh: make(chan Event, 1)}
+	e.SetDebounceConfig(cfg, &DebounceConfig{MinDwell: 100 * time.Millisecond, N: 1, M: 1})
+	send := func(state PTPState) PTPState {
+		e.Lock()
+		defer e.Unlock()
+		ev := e.debounce(Event{Source: DPLL, IFace: iface, CfgName: cfg, ClockType: GM, Time: time.Now().UnixMilli(),
+			Data: &PTPData{State: state}})
+		return ev.Data.(*PTPData).State
+	}
+
+	assert.Equal(t, PTP_LOCKED, send(PTP_LOCKED))
+	// held back by the dwell, the change is committed when the dwell ends without further events
+	assert.Equal(t, PTP_LOCKED, send(PTP_HOLDOVER))
+	select {
+	case ev := <-e.debounceCh:
+		assert.Equal(t, PTP_HOLDOVER, ev.Data.(*PTPData).State)
+		assert.Equal(t, iface, ev.IFace)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the held back state was not committed")
+	}
+	// the committed event passes the debouncing
+	assert.Equal(t, PTP_HOLDOVER, send(PTP_HOLDOVER))
+
+	// a change of a reset profile is never committed
+	assert.Equal(t, PTP_HOLDOVER, send(PTP_FREERUN))
+	e.Lock()
+	e.resetDebounce(cfg, "")
+	e.Unlock()
+	select {
+	case ev := <-e.debounceCh:
+		t.Fatalf("state change of a reset profile committed: %v", ev.Data)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// nor is a change that reverts before the dwell ends
+	assert.Equal(t, PTP_HOLDOVER, send(PTP_HOLDOVER))
+	assert.Equal(t, PTP_HOLDOVER, send(PTP_FREERUN))
+	assert.Equal(t, PTP_HOLDOVER, send(PTP_HOLDOVER))
+	select {
+	case ev := <-e.debounceCh:
+		t.Fatalf("reverted state change committed: %v", ev.Data)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// a change the busy event loop can't take is rolled back, and accepted by the next event
+	const iface2 = "ens2f0"
+	send2 := func(state PTPState) PTPState {
+		e.Lock()
+		defer e.Unlock()
+		ev := e.debounce(Event{Source: DPLL, IFace: iface2, CfgName: cfg, ClockType: GM, Time: time.Now().UnixMilli(),
+			Data: &PTPData{State: state}})
+		return ev.Data.(*PTPData).State
+	}
+	assert.Equal(t, PTP_LOCKED, send2(PTP_LOCKED))
+	e.debounceCh <- Event{}
+	assert.Equal(t, PTP_LOCKED, send2(PTP_FREERUN))
+	assert.Eventually(t, func() bool {
+		e.Lock()
+		defer e.Unlock()
+		s := e.debounceStates[debounceKey{cfgName: cfg, source: DPLL, iface: iface2}]
+		return s.timer == nil && !s.pending
+	}, 2*time.Second, 10*time.Millisecond)
+	<-e.debounceCh
+	assert.Empty(t, e.debounceCh, "the change was dropped")
+	e.Lock()
+	assert.Equal(t, PTP_LOCKED, e.debounceStates[debounceKey{cfgName: cfg, source: DPLL, iface: iface2}].state, "rolled back")
+	e.Unlock()
+	assert.Equal(t, PTP_FREERUN, send2(PTP_FREERUN), "the dropped change is accepted again")
+}
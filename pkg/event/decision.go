@@ -0,0 +1,217 @@
+package event
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	fbprotocol "github.com/facebook/time/ptp/protocol"
+)
+
+const (
+	// DeciderGM identifies traces recorded by updateGMState
+	DeciderGM = "updateGMState"
+	// DeciderBC identifies traces recorded by updateBCState
+	DeciderBC = "updateBCState"
+	// DeciderClockClass identifies traces recorded by UpdateClockClass
+	DeciderClockClass = "updateClockClass"
+	// OffsetThresholdCheck names the check of a GM source offset against its profile offset threshold
+	OffsetThresholdCheck = "offset-th"
+)
+
+// SourceInput is the state of a single source interface as seen by the state machine
+type SourceInput struct {
+	Source     EventSource `json:"source"`
+	IFace      string      `json:"iface"`
+	State      PTPState    `json:"state"`
+	Offset     int64       `json:"offset"`
+	SourceLost bool        `json:"sourceLost"`
+}
+
+// ThresholdCheck records an offset that was compared against a threshold
+type ThresholdCheck struct {
+	Name      string `json:"name"`
+	Source    string `json:"source,omitempty"`
+	Offset    int64  `json:"offset"`
+	Threshold int64  `json:"threshold"`
+	Passed    bool   `json:"passed"`
+}
+
+// ClockClassDecision records the outcome of the last GRANDMASTER_SETTINGS_NP update
+type ClockClassDecision struct {
+	Time      time.Time `json:"time"`
+	Requested uint8     `json:"requested"`
+	Applied   uint8     `json:"applied"`
+	Accuracy  uint8     `json:"accuracy"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// DecisionTrace holds the inputs and the outcome of the latest clock state decision for a profile
+type DecisionTrace struct {
+	CfgName          string              `json:"cfgName"`
+	ClockType        ClockType           `json:"clockType"`
+	Decider          string              `json:"decider"`
+	Time             time.Time           `json:"time"`
+	LeadingInterface string              `json:"leadingInterface"`
+	SourceLost       bool                `json:"sourceLost"`
+	OutOfSpec        bool                `json:"outOfSpec"`
+	Sources          []SourceInput       `json:"sources"`
+	Thresholds       []ThresholdCheck    `json:"thresholds,omitempty"`
+	State            PTPState            `json:"state"`
+	ClockClass       uint8               `json:"clockClass"`
+	Reason           string              `json:"reason"`
+	ClockClassUpdate *ClockClassDecision `json:"clockClassUpdate,omitempty"`
+}
+
+// Summary returns a single line description of the decision
+func (d *DecisionTrace) Summary() string {
+	inputs := make([]string, 0, len(d.Sources))
+	for _, s := range d.Sources {
+		inputs = append(inputs, fmt.Sprintf("%s/%s=%s(%d)", s.Source, s.IFace, s.State, s.Offset))
+	}
+	checks := make([]string, 0, len(d.Thresholds))
+	for _, c := range d.Thresholds {
+		checks = append(checks, fmt.Sprintf("%s |%d|<=%d:%t", c.Name, c.Offset, c.Threshold, c.Passed))
+	}
+	return fmt.Sprintf("%s class %d leading %s sourceLost %t: %s [%s] [%s]", d.State, d.ClockClass,
+		d.LeadingInterface, d.SourceLost, d.Reason, strings.Join(inputs, " "), strings.Join(checks, " "))
+}
+
+// collectSourceInputs snapshots per-interface states of all sources for the profile.
+// Caller must hold e.Lock().
+func (e *EventHandler) collectSourceInputs(cfgName string) []SourceInput {
+	var inputs []SourceInput
+	for _, d := range e.data[cfgName] {
+		for _, dd := range d.Details {
+			inputs = append(inputs, SourceInput{
+				Source:     d.ProcessName,
+				IFace:      dd.IFace,
+				State:      dd.State,
+				Offset:     dd.Offset,
+				SourceLost: dd.sourceLost,
+			})
+		}
+	}
+	return inputs
+}
+
+// recordThresholdCheck adds a threshold comparison to the decision being computed.
+// Caller must hold e.Lock().
+func (e *EventHandler) recordThresholdCheck(name string, source EventSource, offset, threshold int64, passed bool) {
+	e.pendingChecks = append(e.pendingChecks, ThresholdCheck{
+		Name:      name,
+		Source:    string(source),
+		Offset:    offset,
+		Threshold: threshold,
+		Passed:    passed,
+	})
+}
+
+// recordGMThresholdChecks records the checks of the DPLL, GNSS and ts2phc offsets of a GM profile
+// against their offset thresholds, with the enter band for sources that are not locked.
+// Caller must hold e.Lock().
+func (e *EventHandler) recordGMThresholdChecks(cfgName string) {
+	thresholds := e.offsetThresholds[cfgName]
+	if thresholds == nil {
+		return
+	}
+	for _, d := range e.data[cfgName] {
+		switch d.ProcessName {
+		case DPLL, GNSS, TS2PHCProcessName:
+		default:
+			continue
+		}
+		for _, dd := range d.Details {
+			th := thresholds.Get(d.ProcessName, dd.IFace)
+			locked := dd.State == PTP_LOCKED
+			threshold := th.Limit()
+			if !locked {
+				threshold = max(th.EnterMax, -th.EnterMin)
+			}
+			e.recordThresholdCheck(OffsetThresholdCheck, d.ProcessName, dd.Offset, threshold, th.InRange(dd.Offset, locked))
+		}
+	}
+}
+
+// recordDecision stores the trace for the decision just computed by decider.
+// Caller must hold e.Lock().
+func (e *EventHandler) recordDecision(cfgName string, clockType ClockType, decider, reason string, state *clockSyncState) {
+	if e.decisions == nil {
+		e.decisions = map[string]*DecisionTrace{}
+	}
+	trace := &DecisionTrace{
+		CfgName:    cfgName,
+		ClockType:  clockType,
+		Decider:    decider,
+		Time:       time.Now(),
+		OutOfSpec:  e.chain(cfgName).outOfSpec,
+		Sources:    e.collectSourceInputs(cfgName),
+		Thresholds: e.pendingChecks,
+		Reason:     reason,
+	}
+	e.pendingChecks = nil
+	if state != nil {
+		trace.LeadingInterface = state.leadingIFace
+		trace.SourceLost = state.sourceLost
+		trace.State = state.state
+		trace.ClockClass = uint8(state.clockClass)
+	}
+	if previous, ok := e.decisions[cfgName]; ok {
+		trace.ClockClassUpdate = previous.ClockClassUpdate
+	}
+	e.decisions[cfgName] = trace
+}
+
+// recordClockClassDecision attaches the result of a clock class update to the profile trace.
+// Caller must hold e.Lock().
+func (e *EventHandler) recordClockClassDecision(cfgName string, requested, applied fbprotocol.ClockClass,
+	accuracy fbprotocol.ClockAccuracy, err error) {
+	if e.decisions == nil {
+		e.decisions = map[string]*DecisionTrace{}
+	}
+	trace, ok := e.decisions[cfgName]
+	if !ok {
+		trace = &DecisionTrace{CfgName: cfgName, Decider: DeciderClockClass, Time: time.Now()}
+		e.decisions[cfgName] = trace
+	}
+	update := &ClockClassDecision{
+		Time:      time.Now(),
+		Requested: uint8(requested),
+		Applied:   uint8(applied),
+		Accuracy:  uint8(accuracy),
+	}
+	if err != nil {
+		update.Error = err.Error()
+	}
+	trace.ClockClassUpdate = update
+}
+
+// GetDecisionTraces returns a copy of the latest decision trace of every profile, sorted by profile name
+func (e *EventHandler) GetDecisionTraces() []DecisionTrace {
+	e.Lock()
+	defer e.Unlock()
+	traces := make([]DecisionTrace, 0, len(e.decisions))
+	for _, d := range e.decisions {
+		trace := *d
+		trace.Sources = append([]SourceInput(nil), d.Sources...)
+		trace.Thresholds = append([]ThresholdCheck(nil), d.Thresholds...)
+		if d.ClockClassUpdate != nil {
+			update := *d.ClockClassUpdate
+			trace.ClockClassUpdate = &update
+		}
+		traces = append(traces, trace)
+	}
+	sort.Slice(traces, func(i, j int) bool { return traces[i].CfgName < traces[j].CfgName })
+	return traces
+}
+
+// GetDecisionTrace returns the latest decision trace for the profile
+func (e *EventHandler) GetDecisionTrace(cfgName string) (DecisionTrace, bool) {
+	for _, t := range e.GetDecisionTraces() {
+		if t.CfgName == cfgName {
+			return t, true
+		}
+	}
+	return DecisionTrace{}, false
+}
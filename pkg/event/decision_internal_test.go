@@ -0,0 +1,117 @@
+package event
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	fbprotocol "github.com/facebook/time/ptp/protocol"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecisionTrace_GM(t *testing.T) {
+	const cfg = "ts2phc.0.config"
+	const iface = "ens1f0"
+	e := &EventHandler{
+		data:         map[string][]*Data{},
+		clkSyncState: map[string]*clockSyncState{},
+	}
+
+	e.updateGMState(cfg)
+	trace, ok := e.GetDecisionTrace(cfg)
+	assert.True(t, ok)
+	assert.Equal(t, LEADING_INTERFACE_UNKNOWN, trace.LeadingInterface)
+	assert.Equal(t, "leading interface not yet identified", trace.Reason)
+
+	e.SetOffsetThresholds(cfg, &OffsetThresholds{Default: NewOffsetThreshold(-100, 100)})
+	now := time.Now().UnixMilli()
+	e.addEvent(Event{Source: GNSS, IFace: iface, CfgName: cfg, ClockType: GM, Time: now,
+		Data: &GNSSData{GPSStatus: 3, Offset: 5}})
+	e.addEvent(Event{Source: DPLL, IFace: iface, CfgName: cfg, ClockType: GM, Time: now,
+		Data: &PTPData{State: PTP_LOCKED, Values: map[ValueType]interface{}{OFFSET: int64(7)}}})
+	// the first event of an interface creates its details, the second one records the offset
+	for i := 0; i < 2; i++ {
+		e.addEvent(Event{Source: TS2PHCProcessName, IFace: iface, CfgName: cfg, ClockType: GM, Time: now,
+			Data: &PTPData{State: PTP_FREERUN, Values: map[ValueType]interface{}{OFFSET: int64(900)}}})
+	}
+
+	state := e.updateGMState(cfg)
+	assert.Equal(t, PTP_FREERUN, state.state)
+
+	trace, ok = e.GetDecisionTrace(cfg)
+	assert.True(t, ok)
+	assert.Equal(t, DeciderGM, trace.Decider)
+	assert.Equal(t, GM, trace.ClockType)
+	assert.Equal(t, iface, trace.LeadingInterface)
+	assert.Equal(t, PTP_FREERUN, trace.State)
+	assert.Equal(t, uint8(protocol.ClockClassFreerun), trace.ClockClass)
+	assert.Equal(t, "GNSS LOCKED but ts2phc FREERUN", trace.Reason)
+	assert.Len(t, trace.Sources, 3)
+	assert.Contains(t, trace.Sources, SourceInput{Source: TS2PHCProcessName, IFace: iface, State: PTP_FREERUN, Offset: 900})
+	assert.Contains(t, trace.Summary(), "GNSS LOCKED but ts2phc FREERUN")
+	assert.Len(t, trace.Thresholds, 3, "a check per GM source interface")
+	assert.Contains(t, trace.Thresholds, ThresholdCheck{Name: OffsetThresholdCheck, Source: TS2PHCProcessName, Offset: 900, Threshold: 100, Passed: false})
+}
+
+func TestDecisionTrace_BCThresholds(t *testing.T) {
+	const cfg = "ptp4l.0.config"
+	const iface = "ens1f0"
+	e := &EventHandler{
+		data:         map[string][]*Data{},
+		clkSyncState: map[string]*clockSyncState{},
+		chains: testChains(cfg, &LeadingClockParams{
+			leadingInterface:         iface,
+			inSyncConditionThreshold: 100,
+			inSyncConditionTimes:     1,
+			toFreeRunThreshold:       1500,
+			MaxInSpecOffset:          500,
+			upstreamParentDataSet:    &protocol.ParentDataSet{},
+			upstreamTimeProperties:   &protocol.TimePropertiesDS{},
+			downstreamParentDataSet:  &protocol.ParentDataSet{},
+			downstreamTimeProperties: &protocol.TimePropertiesDS{},
+		}),
+	}
+	makeBCEvent := func(process EventSource, offset int64) Event {
+		return Event{Source: process, IFace: iface, CfgName: cfg, ClockType: BC, Time: time.Now().UnixMilli(),
+			Data: &PTPData{State: PTP_LOCKED, Values: map[ValueType]interface{}{OFFSET: offset}}}
+	}
+
+	e.addEvent(makeBCEvent(DPLL, 10))
+	e.addEvent(makeBCEvent(PTP4lProcessName, 10))
+	fillDataWindows(e, cfg, 10)
+	e.updateBCState(makeBCEvent(DPLL, 10))
+
+	trace, ok := e.GetDecisionTrace(cfg)
+	assert.True(t, ok)
+	assert.Equal(t, DeciderBC, trace.Decider)
+	assert.Equal(t, PTP_LOCKED, trace.State)
+	assert.Contains(t, trace.Reason, "FREERUN to LOCKED")
+	assert.Equal(t, []ThresholdCheck{{Name: string(InSyncConditionThreshold), Offset: 10, Threshold: 100, Passed: true}}, trace.Thresholds)
+
+	e.addEvent(makeBCEvent(DPLL, 2000))
+	e.updateBCState(makeBCEvent(DPLL, 2000))
+	trace, _ = e.GetDecisionTrace(cfg)
+	assert.Equal(t, PTP_FREERUN, trace.State)
+	assert.Contains(t, trace.Reason, "LOCKED to FREERUN")
+	assert.Contains(t, trace.Thresholds, ThresholdCheck{Name: string(ToFreeRunThreshold), Source: string(DPLL), Offset: 2000, Threshold: 1500, Passed: false})
+}
+
+func TestDecisionTrace_ClockClassUpdateKept(t *testing.T) {
+	const cfg = "ts2phc.0.config"
+	e := &EventHandler{data: map[string][]*Data{}, clkSyncState: map[string]*clockSyncState{}}
+
+	e.recordClockClassDecision(cfg, fbprotocol.ClockClass6, fbprotocol.ClockClass7, fbprotocol.ClockAccuracyUnknown, errors.New("pmc timeout"))
+	e.recordDecision(cfg, GM, DeciderGM, "DPLL HOLDOVER within spec", &clockSyncState{state: PTP_HOLDOVER, clockClass: fbprotocol.ClockClass7})
+
+	trace, ok := e.GetDecisionTrace(cfg)
+	assert.True(t, ok)
+	if assert.NotNil(t, trace.ClockClassUpdate) {
+		assert.Equal(t, uint8(6), trace.ClockClassUpdate.Requested)
+		assert.Equal(t, uint8(7), trace.ClockClassUpdate.Applied)
+		assert.Equal(t, "pmc timeout", trace.ClockClassUpdate.Error)
+	}
+
+	_, ok = e.GetDecisionTrace("unknown.config")
+	assert.False(t, ok)
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/alias"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/debug"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/parser"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/utils"
 
@@ -165,27 +166,62 @@ type clockSyncState struct {
 // EventHandler ... event handler to process events
 type EventHandler struct {
 	sync.Mutex
-	nodeName           string
-	stdoutSocket       string
-	stdoutToSocket     bool
-	processChannel     <-chan Event
-	closeCh            chan bool
-	conn               net.Conn   // event socket connection, guarded by connMu
-	connMu             sync.Mutex // separate mutex for conn to avoid deadlocks with embedded sync.Mutex
-	reconnectMu        sync.Mutex // serializes reconnection attempts to prevent leaked connections
-	data               map[string][]*Data
-	offsetMetric       *prometheus.GaugeVec
-	clockMetric        *prometheus.GaugeVec
-	clockClassMetric   *prometheus.GaugeVec
-	clockClass         fbprotocol.ClockClass
-	clockAccuracy      fbprotocol.ClockAccuracy
-	clkSyncState       map[string]*clockSyncState
-	downstreamCancel   map[string]context.CancelFunc // cancels in-flight downstream update goroutines per config
-	outOfSpec          bool                          // is offset out of spec, used for Lost Source,In Spec and OPut of Spec state transitions
-	frequencyTraceable bool                          // will be tru if synce is traceable
-	ReduceLog          bool                          // reduce logs for every announce
-	LeadingClockData   *LeadingClockParams
-	portRole           map[string]map[string]*parser.PTPEvent
+	nodeName              string
+	stdoutSocket          string
+	stdoutToSocket        bool
+	processChannel        <-chan Event
+	closeCh               chan bool
+	conn                  net.Conn      // event socket connection, guarded by connMu
+	ipcSession            *ipc.Session  // protocol negotiated on conn, nil until cloud-event-proxy sent its hello, guarded by connMu
+	pendingMessages       []ipc.Message // messages held back until cloud-event-proxy sent its hello, guarded by connMu
+	ipcMismatchMetric     *prometheus.CounterVec
+	connMu                sync.Mutex // separate mutex for conn to avoid deadlocks with embedded sync.Mutex
+	reconnectMu           sync.Mutex // serializes reconnection attempts to prevent leaked connections
+	data                  map[string][]*Data
+	offsetMetric          *prometheus.GaugeVec
+	clockMetric           *prometheus.GaugeVec
+	clockClassMetric      *prometheus.GaugeVec
+	clkSyncState          map[string]*clockSyncState
+	downstreamCancel      map[string]context.CancelFunc // cancels in-flight downstream update goroutines per config
+	ReduceLog             bool                          // reduce logs for every announce
+	chains                map[string]*clockChain        // independent clock chain state by profile, guarded by chainsMu
+	chainsMu              sync.Mutex
+	controlledChains      map[string]string // chain of the T-BC controlled profile to the chain of its upstream profile, guarded by chainsMu
+	portRole              map[string]map[string]*parser.PTPEvent
+	decisions             map[string]*DecisionTrace // latest state decision per profile, guarded by the embedded mutex
+	pendingChecks         []ThresholdCheck          // threshold checks of the decision being computed
+	offsetObserver        func(source EventSource, cfgName, iface string, offset int64)
+	offsetThresholds      map[string]*OffsetThresholds // per source thresholds by profile config name, guarded by the embedded mutex
+	debounceConfig        map[string]*DebounceConfig   // state debouncing by profile config name, guarded by the embedded mutex
+	debounceStates        map[debounceKey]*debounceState
+	debounceCh            chan Event // the process channel, held back state changes are sent to it when their dwell ended
+	suppressedFlapsMetric *prometheus.CounterVec
+	offsetWindows         map[offsetSummaryKey]*offsetWindow // offsets of the current summary window, guarded by offsetSummaryMu
+	offsetWindowStart     time.Time
+	offsetSummaryMu       sync.Mutex
+}
+
+// SetOffsetThresholds sets the per source offset thresholds of the profile config
+func (e *EventHandler) SetOffsetThresholds(cfgName string, thresholds *OffsetThresholds) {
+	e.Lock()
+	defer e.Unlock()
+	if e.offsetThresholds == nil {
+		e.offsetThresholds = map[string]*OffsetThresholds{}
+	}
+	e.offsetThresholds[cfgName] = thresholds
+}
+
+// ClearOffsetThresholds drops the offset thresholds of all profiles
+func (e *EventHandler) ClearOffsetThresholds() {
+	e.Lock()
+	defer e.Unlock()
+	e.offsetThresholds = nil
+}
+
+// SetOffsetObserver registers a function called with the offset of every event carrying one.
+// It must be set before ProcessEvents is started.
+func (e *EventHandler) SetOffsetObserver(observer func(source EventSource, cfgName, iface string, offset int64)) {
+	e.offsetObserver = observer
 }
 
 // getConn returns the current event socket connection under lock.
@@ -200,6 +236,9 @@ func (e *EventHandler) setConn(c net.Conn) {
 	e.connMu.Lock()
 	oldConn := e.conn
 	e.conn = c
+	if oldConn != c {
+		e.ipcSession = nil
+	}
 	e.connMu.Unlock()
 	if oldConn != nil && oldConn != c {
 		if err := oldConn.Close(); err != nil {
@@ -220,6 +259,14 @@ type GNSSData struct {
 
 func (*GNSSData) eventData() {}
 
+// State returns the state of the GNSS receiver, locked with a 3D fix and the source not lost
+func (d *GNSSData) State() PTPState {
+	if d.GPSStatus >= 3 && !d.SourceLost {
+		return PTP_LOCKED
+	}
+	return PTP_FREERUN
+}
+
 // PTPData carries PTP synchronization status (DPLL, ts2phc, ptp4l, SyncE).
 type PTPData struct {
 	State              PTPState
@@ -248,23 +295,22 @@ type Event struct {
 func Init(nodeName string, stdOutToSocket bool, socketName string, processChannel chan Event, closeCh chan bool,
 	offsetMetric *prometheus.GaugeVec, clockMetric *prometheus.GaugeVec, clockClassMetric *prometheus.GaugeVec) *EventHandler {
 	return &EventHandler{
-		nodeName:           nodeName,
-		stdoutSocket:       socketName,
-		stdoutToSocket:     stdOutToSocket,
-		closeCh:            closeCh,
-		processChannel:     processChannel,
-		data:               map[string][]*Data{},
-		clockMetric:        clockMetric,
-		offsetMetric:       offsetMetric,
-		clockClassMetric:   clockClassMetric,
-		clockClass:         protocol.ClockClassUninitialized,
-		clkSyncState:       map[string]*clockSyncState{},
-		downstreamCancel:   map[string]context.CancelFunc{},
-		outOfSpec:          false,
-		frequencyTraceable: false,
-		ReduceLog:          true,
-		LeadingClockData:   newLeadingClockParams(),
-		portRole:           map[string]map[string]*parser.PTPEvent{},
+		nodeName:         nodeName,
+		stdoutSocket:     socketName,
+		stdoutToSocket:   stdOutToSocket,
+		closeCh:          closeCh,
+		processChannel:   processChannel,
+		data:             map[string][]*Data{},
+		clockMetric:      clockMetric,
+		offsetMetric:     offsetMetric,
+		clockClassMetric: clockClassMetric,
+		clkSyncState:     map[string]*clockSyncState{},
+		downstreamCancel: map[string]context.CancelFunc{},
+		ReduceLog:        true,
+		chains:           map[string]*clockChain{},
+		portRole:         map[string]map[string]*parser.PTPEvent{},
+		decisions:        map[string]*DecisionTrace{},
+		debounceCh:       processChannel,
 	}
 }
 
@@ -272,13 +318,9 @@ func Init(nodeName string, stdOutToSocket bool, socketName string, processChanne
 func (e *Event) GetLogData() string {
 	switch d := e.Data.(type) {
 	case *GNSSData:
-		state := PTP_FREERUN
-		if d.GPSStatus >= 3 && !d.SourceLost {
-			state = PTP_LOCKED
-		}
 		return fmt.Sprintf("%s[%d]:[%s] %s %s %d %s %d %s\n", e.Source,
 			time.Now().Unix(), e.CfgName, e.IFace,
-			GPS_STATUS, d.GPSStatus, OFFSET, d.Offset, state)
+			GPS_STATUS, d.GPSStatus, OFFSET, d.Offset, d.State())
 	case *PTPData:
 		return formatPTPLogData(e.Source, e.CfgName, e.IFace, d.State, d.Values)
 	default:
@@ -377,10 +419,13 @@ func (e *EventHandler) updateGMState(cfgName string) clockSyncState {
 	dpllState := PTP_NOTSET
 	gnssState := PTP_FREERUN
 	ts2phcState := PTP_FREERUN
+	dpllFault := false
+	e.pendingChecks = nil
 	syncSrcLost := e.isSourceLost(cfgName)
 	leadingInterface := e.getLeadingInterface(cfgName)
 	if leadingInterface == LEADING_INTERFACE_UNKNOWN {
 		glog.Infof("Leading interface is not yet identified, clock state reporting delayed.")
+		e.recordDecision(cfgName, GM, DeciderGM, "leading interface not yet identified", &clockSyncState{leadingIFace: leadingInterface})
 		return clockSyncState{leadingIFace: leadingInterface}
 	}
 
@@ -403,6 +448,7 @@ func (e *EventHandler) updateGMState(cfgName string) clockSyncState {
 				dpllState = d.State
 				if e.hasNonLeadingDPLLFault(cfgName, leadingInterface) {
 					dpllState = PTP_FREERUN
+					dpllFault = true
 				}
 			case GNSS:
 				gnssState = d.State
@@ -421,26 +467,34 @@ func (e *EventHandler) updateGMState(cfgName string) clockSyncState {
 		e.clkSyncState[cfgName].lastLoggedTime = time.Now().Unix()
 		e.clkSyncState[cfgName].leadingIFace = leadingInterface
 		e.clkSyncState[cfgName].clkLog = fmt.Sprintf("%s[%d]:[%s] %s T-GM-STATUS %s\n", GM, e.clkSyncState[cfgName].lastLoggedTime, cfgName, leadingInterface, e.clkSyncState[cfgName].state)
+		e.recordDecision(cfgName, GM, DeciderGM, "no source data", e.clkSyncState[cfgName])
 		return *e.clkSyncState[cfgName]
 	}
 	e.clkSyncState[cfgName].leadingIFace = leadingInterface
+	var reason string
 	switch dpllState {
 	case PTP_FREERUN: // This is OVER ALL State with HOLDOVER having the highest priority
 		// add check so that clock class won't change if GM was in HOLDOVER state
 		e.clkSyncState[cfgName].state = dpllState
 		// T-GM or T-BC in free-run mode
-		if e.outOfSpec && e.frequencyTraceable {
+		if chain := e.chain(cfgName); chain.outOfSpec && chain.frequencyTraceable {
 			// T-GM in holdover, out of holdover specification
 			e.clkSyncState[cfgName].clockClass = protocol.ClockClassOutOfSpec
+			reason = "DPLL FREERUN after holdover, out of spec and frequency traceable"
 		} else { // from holdover it goes to out of spec to free run
 			// T-GM or T-BC in free-run mode
 			e.clkSyncState[cfgName].clockClass = protocol.ClockClassFreerun
+			reason = "DPLL FREERUN"
+		}
+		if dpllFault {
+			reason = "non-leading DPLL not locked while leading DPLL is locked"
 		}
 		e.clkSyncState[cfgName].clockAccuracy = fbprotocol.ClockAccuracyUnknown
 	case PTP_HOLDOVER:
 		e.clkSyncState[cfgName].state = dpllState
 		// T-GM in holdover, within holdover specification
 		e.clkSyncState[cfgName].clockClass = fbprotocol.ClockClass7
+		reason = "DPLL HOLDOVER within spec"
 	case PTP_LOCKED, PTP_NOTSET: // consider DPLL is locked if DPLL is not available
 		switch gnssState {
 		case PTP_LOCKED:
@@ -450,17 +504,21 @@ func (e *EventHandler) updateGMState(cfgName string) clockSyncState {
 				// T-GM or T-BC in free-run mode
 				e.clkSyncState[cfgName].clockClass = protocol.ClockClassFreerun
 				e.clkSyncState[cfgName].clockAccuracy = fbprotocol.ClockAccuracyUnknown
+				reason = "GNSS LOCKED but ts2phc FREERUN"
 			case PTP_LOCKED:
 				e.clkSyncState[cfgName].state = PTP_LOCKED
 				// T-GM connected to a PRTC in locked mode (e.g., PRTC traceable to GNSS)
 				e.clkSyncState[cfgName].clockClass = fbprotocol.ClockClass6
 				e.clkSyncState[cfgName].clockAccuracy = fbprotocol.ClockAccuracyNanosecond100
+				reason = "GNSS and ts2phc LOCKED, DPLL " + string(dpllState)
 			case PTP_HOLDOVER:
 				e.clkSyncState[cfgName].state = PTP_HOLDOVER
 				e.clkSyncState[cfgName].clockClass = fbprotocol.ClockClass7
+				reason = "ts2phc HOLDOVER"
 			}
 		case PTP_FREERUN:
 			if syncSrcLost {
+				reason = "GNSS source lost, waiting for DPLL to move to HOLDOVER"
 				switch ts2phcState {
 				case PTP_LOCKED:
 				case PTP_FREERUN:
@@ -469,6 +527,7 @@ func (e *EventHandler) updateGMState(cfgName string) clockSyncState {
 				case PTP_HOLDOVER:
 					e.clkSyncState[cfgName].state = PTP_HOLDOVER
 					e.clkSyncState[cfgName].clockClass = fbprotocol.ClockClass7
+					reason = "GNSS source lost, ts2phc HOLDOVER"
 				}
 			} else {
 				switch ts2phcState {
@@ -477,6 +536,7 @@ func (e *EventHandler) updateGMState(cfgName string) clockSyncState {
 					// T-GM or T-BC in free-run mode
 					e.clkSyncState[cfgName].clockClass = protocol.ClockClassFreerun
 					e.clkSyncState[cfgName].clockAccuracy = fbprotocol.ClockAccuracyUnknown
+					reason = "GNSS FREERUN, offset out of range"
 				}
 			}
 		}
@@ -489,14 +549,17 @@ func (e *EventHandler) updateGMState(cfgName string) clockSyncState {
 				// T-GM or T-BC in free-run mode
 				e.clkSyncState[cfgName].clockClass = protocol.ClockClassFreerun
 				e.clkSyncState[cfgName].clockAccuracy = fbprotocol.ClockAccuracyUnknown
+				reason = "DPLL not reporting, GNSS LOCKED but ts2phc not LOCKED"
 			case PTP_LOCKED:
 				e.clkSyncState[cfgName].state = PTP_LOCKED
 				// T-GM connected to a PRTC in locked mode (e.g., PRTC traceable to GNSS)
 				e.clkSyncState[cfgName].clockClass = fbprotocol.ClockClass6
 				e.clkSyncState[cfgName].clockAccuracy = fbprotocol.ClockAccuracyNanosecond100
+				reason = "DPLL not reporting, GNSS and ts2phc LOCKED"
 			case PTP_HOLDOVER:
 				e.clkSyncState[cfgName].state = PTP_HOLDOVER
 				e.clkSyncState[cfgName].clockClass = fbprotocol.ClockClass7 //TODO: check if this is correct
+				reason = "DPLL not reporting, ts2phc HOLDOVER"
 			}
 		case PTP_FREERUN:
 			switch ts2phcState {
@@ -504,11 +567,14 @@ func (e *EventHandler) updateGMState(cfgName string) clockSyncState {
 				e.clkSyncState[cfgName].state = PTP_FREERUN
 				e.clkSyncState[cfgName].clockClass = protocol.ClockClassFreerun
 				e.clkSyncState[cfgName].clockAccuracy = fbprotocol.ClockAccuracyUnknown
+				reason = "DPLL not reporting, GNSS FREERUN"
 			case PTP_HOLDOVER: // if holdover is detected then wait for ts2phc to move to HOLDOVER
 				e.clkSyncState[cfgName].state = PTP_HOLDOVER
 				e.clkSyncState[cfgName].clockClass = fbprotocol.ClockClass7 //TODO: check if this is correct
+				reason = "DPLL not reporting, GNSS FREERUN, ts2phc HOLDOVER"
 			}
 		default: // bad case
+			reason = "DPLL and GNSS not reporting, following ts2phc"
 			e.clkSyncState[cfgName].state = ts2phcState
 			switch ts2phcState {
 			case PTP_FREERUN:
@@ -520,6 +586,8 @@ func (e *EventHandler) updateGMState(cfgName string) clockSyncState {
 			}
 		}
 	}
+	e.recordGMThresholdChecks(cfgName)
+	e.recordDecision(cfgName, GM, DeciderGM, reason, e.clkSyncState[cfgName])
 	gSycState := e.clkSyncState[cfgName]
 	rclockSyncState := clockSyncState{
 		state:         gSycState.state,
@@ -605,8 +673,9 @@ func (e *EventHandler) getLeadingInterface(cfgName string) string {
 
 func (e *EventHandler) updateSpecState(event Event) {
 	if ptp, ok := event.Data.(*PTPData); ok && event.Source == DPLL {
-		e.outOfSpec = ptp.OutOfSpec
-		e.frequencyTraceable = ptp.FrequencyTraceable
+		chain := e.chain(event.CfgName)
+		chain.outOfSpec = ptp.OutOfSpec
+		chain.frequencyTraceable = ptp.FrequencyTraceable
 	}
 }
 func (e *EventHandler) toString() string {
@@ -674,18 +743,19 @@ func (e *EventHandler) AnnounceClockClass(clockClass fbprotocol.ClockClass, cloc
 
 func (e *EventHandler) announceClockClass(clockClass fbprotocol.ClockClass, clockAcc fbprotocol.ClockAccuracy, cfgName string) {
 	e.Lock()
-	e.setClockClassLocked(clockClass, clockAcc)
+	e.setClockClassLocked(cfgName, clockClass, clockAcc)
 	e.storeClockClassLocked(cfgName, clockClass, clockAcc)
 	e.Unlock()
 
 	e.emitClockClass(clockClass, cfgName)
 }
 
-// setClockClassLocked updates the clock class and accuracy fields.
+// setClockClassLocked updates the clock class and accuracy of the clock chain of the config.
 // Caller must hold e.Lock().
-func (e *EventHandler) setClockClassLocked(clockClass fbprotocol.ClockClass, clockAcc fbprotocol.ClockAccuracy) {
-	e.clockClass = clockClass
-	e.clockAccuracy = clockAcc
+func (e *EventHandler) setClockClassLocked(cfgName string, clockClass fbprotocol.ClockClass, clockAcc fbprotocol.ClockAccuracy) {
+	chain := e.chain(cfgName)
+	chain.clockClass = clockClass
+	chain.clockAccuracy = clockAcc
 }
 
 // storeClockClassLocked stores the clock class and accuracy in clkSyncState
@@ -742,6 +812,7 @@ func (e *EventHandler) reconnectEventSocket() bool {
 	)
 	if newConn != nil {
 		e.setConn(newConn)
+		go e.readPeer(newConn)
 		return true
 	}
 	return false
@@ -807,7 +878,7 @@ func (e *EventHandler) ProcessEvents() {
 			e.setConn(nil) // closes the connection if present
 		}
 	}()
-	var lastClockState PTPState
+	lastClockState := map[string]PTPState{} // by profile config name
 
 	// Establish initial connection to the event socket using exponential backoff.
 	// Retries indefinitely until connected or the handler is shutting down.
@@ -843,8 +914,7 @@ func (e *EventHandler) ProcessEvents() {
 						e.UpdateClockClass(clk)
 					} else {
 						e.Lock()
-						e.clockClass = clk.clockClass
-						e.clockAccuracy = clk.clockAccuracy
+						e.setClockClassLocked(clk.cfgName, clk.clockClass, clk.clockAccuracy)
 						e.storeClockClassLocked(clk.cfgName, clk.clockClass, clk.clockAccuracy)
 						e.Unlock()
 					}
@@ -883,7 +953,7 @@ func (e *EventHandler) ProcessEvents() {
 							cfgName = "ptp4l." + strings.Join(parts[1:], ".")
 						}
 						e.Lock()
-						currentClockClass := e.clockClass
+						currentClockClass := e.chain(cfgName).clockClass
 						e.Unlock()
 						logMsg := utils.GetClockClassLogMessage(PTP4lProcessName, cfgName, currentClockClass)
 						e.writeLogToSocket(logMsg)
@@ -901,12 +971,21 @@ func (e *EventHandler) ProcessEvents() {
 			// replace ts2phc logs here
 			if event.Reset { // clean up
 				debug.ClearState() // clear any state data used for debug
-				e.LeadingClockData = newLeadingClockParams()
+				e.resetLeadingClockData(event.CfgName)
+				e.Lock()
+				delete(e.decisions, event.CfgName)
+				if event.Source == TS2PHC {
+					e.resetDebounce(event.CfgName, "")
+				} else {
+					e.resetDebounce(event.CfgName, event.Source)
+				}
+				e.Unlock()
 				if event.Source == TS2PHC {
 					e.unregisterMetrics(event.CfgName, "")
 					delete(e.data, event.CfgName) // this will delete all index
-					e.clockClass = protocol.ClockClassUninitialized
-					e.clockAccuracy = fbprotocol.ClockAccuracyUnknown
+					e.Lock()
+					e.setClockClassLocked(event.CfgName, protocol.ClockClassUninitialized, fbprotocol.ClockAccuracyUnknown)
+					e.Unlock()
 				} else {
 					// Check if the index is within the slice bounds
 					for indexToRemove, d := range e.data[event.CfgName] {
@@ -920,8 +999,11 @@ func (e *EventHandler) ProcessEvents() {
 					e.Lock()
 					delete(e.clkSyncState, event.CfgName) // delete the clkSyncState
 					e.Unlock()
-					e.outOfSpec = false
-					e.frequencyTraceable = false
+					chain := e.chain(event.CfgName)
+					e.Lock()
+					chain.outOfSpec = false
+					chain.frequencyTraceable = false
+					e.Unlock()
 				}
 				continue
 			}
@@ -937,6 +1019,19 @@ func (e *EventHandler) ProcessEvents() {
 					e.UpdateClockStateMetrics(ptp.State, string(event.Source), event.IFace)
 				}
 			} else {
+				e.Lock()
+				event = e.debounce(event)
+				e.Unlock()
+				if e.offsetObserver != nil {
+					switch data := event.Data.(type) {
+					case *PTPData:
+						if offset, hasOffset := data.Values[OFFSET].(int64); hasOffset {
+							e.offsetObserver(event.Source, event.CfgName, event.IFace, offset)
+						}
+					case *GNSSData:
+						e.offsetObserver(event.Source, event.CfgName, event.IFace, data.Offset)
+					}
+				}
 
 				// Update the in MemData
 
@@ -989,6 +1084,9 @@ func (e *EventHandler) ProcessEvents() {
 						debug.UpdateTs2phcState(string(d.State), 0, debug.OverallTs2phcKey)
 					}
 				}
+				if trace, ok := e.GetDecisionTrace(event.CfgName); ok {
+					debug.UpdateDecision(event.CfgName, trace.Reason, trace.Summary())
+				}
 				debug.UpdateGMState(string(clockState.state))
 
 				if clockState.clkLog != "" && clockState.leadingIFace != LEADING_INTERFACE_UNKNOWN {
@@ -1014,7 +1112,8 @@ func (e *EventHandler) ProcessEvents() {
 					}
 				}
 				if event.ClockType == GM {
-					clockState.clockAccuracy = e.clockAccuracy
+					chain := e.chain(event.CfgName)
+					clockState.clockAccuracy = chain.clockAccuracy
 
 					if ptp, isPTP := event.Data.(*PTPData); isPTP && event.Source == DPLL {
 						if clockState.clockClass == fbprotocol.ClockClass7 || clockState.clockClass == protocol.ClockClassOutOfSpec {
@@ -1029,9 +1128,9 @@ func (e *EventHandler) ProcessEvents() {
 					}
 
 					if clockState.clockClass != protocol.ClockClassUninitialized &&
-						(clockState.clockClass != e.clockClass || clockState.clockAccuracy != e.clockAccuracy) {
-						glog.Infof("clock class change request from %d to %d with clock accuracy from %d to %d",
-							uint8(e.clockClass), uint8(clockState.clockClass), uint8(e.clockAccuracy), uint8(clockState.clockAccuracy))
+						(clockState.clockClass != chain.clockClass || clockState.clockAccuracy != chain.clockAccuracy) {
+						glog.Infof("%s clock class change request from %d to %d with clock accuracy from %d to %d", event.CfgName,
+							uint8(chain.clockClass), uint8(clockState.clockClass), uint8(chain.clockAccuracy), uint8(clockState.clockAccuracy))
 						debug.UpdateClockClass(uint8(clockState.clockClass))
 						go func() {
 							select {
@@ -1047,9 +1146,9 @@ func (e *EventHandler) ProcessEvents() {
 							}
 						}()
 					}
-					if lastClockState != clockState.state {
-						glog.Infof("PTP State: %v, Clock Class %d Time %s sourceLost %v", clockState.state, clockState.clockClass, time.Now(), clockState.sourceLost)
-						lastClockState = clockState.state
+					if lastClockState[event.CfgName] != clockState.state {
+						glog.Infof("%s PTP State: %v, Clock Class %d Time %s sourceLost %v", event.CfgName, clockState.state, clockState.clockClass, time.Now(), clockState.sourceLost)
+						lastClockState[event.CfgName] = clockState.state
 					}
 				} // T-GM
 			} // Not SYNC-E
@@ -1318,13 +1417,15 @@ func (e *EventHandler) UpdateClockClass(clk ClockClassRequest) {
 	classErr, clockClass, clockAccuracy := e.updateClockClass(clk.cfgName, clk.clockClass, clk.clockType, clk.clockAccuracy,
 		getter, setter)
 	glog.Infof("received %s,%v,%s,%v", clk.cfgName, clk.clockClass, clk.clockType, clk.clockAccuracy)
+	e.Lock()
+	e.recordClockClassDecision(clk.cfgName, clk.clockClass, clockClass, clockAccuracy, classErr)
+	e.Unlock()
 	if classErr != nil {
 		glog.Errorf("error updating clock class %s", classErr)
 	} else {
 		glog.Infof("updated clock class for last clock class %d to %d with clock accuracy %d", clk.clockClass, clockClass, clockAccuracy)
 		e.Lock()
-		e.clockClass = clockClass
-		e.clockAccuracy = clockAccuracy
+		e.setClockClassLocked(clk.cfgName, clockClass, clockAccuracy)
 		e.storeClockClassLocked(clk.cfgName, clockClass, clockAccuracy)
 		e.Unlock()
 		clockClassOut := utils.GetClockClassLogMessage(PTP4lProcessName, clk.cfgName, clockClass)
@@ -1405,10 +1506,13 @@ func (e *EventHandler) EmitPortRoleLogs() {
 		raw string
 	}
 	var entries []portRoleEntry
-	for _, ports := range e.portRole {
-		for _, portEvent := range ports {
+	var states []ipc.Message
+	now := time.Now()
+	for cfgName, ports := range e.portRole {
+		for portName, portEvent := range ports {
 			if portEvent != nil {
 				entries = append(entries, portRoleEntry{raw: portEvent.Raw})
+				states = append(states, portStateMessage(cfgName, portName, portEvent.Role, now))
 			}
 		}
 	}
@@ -1418,9 +1522,12 @@ func (e *EventHandler) EmitPortRoleLogs() {
 		glog.Infof("Port Event %s", entry.raw)
 		if !e.writeLogToSocket(entry.raw) {
 			glog.Warning("Broken pipe detected while emitting port role logs, stopping.")
-			break
+			return
 		}
 	}
+	if !e.writePortStates(states) {
+		glog.Warning("Broken pipe detected while emitting port states.")
+	}
 }
 
 // EmitProcessStatusLog writes a process status log entry to the event socket
@@ -110,12 +110,12 @@ func TestUpdateLeadingClockData_PTP4lProcessName(t *testing.T) {
 	}
 
 	e := EventHandler{
-		LeadingClockData: &LeadingClockParams{},
+		chains: testChains("", &LeadingClockParams{}),
 	}
 	e.updateLeadingClockData(event)
 
-	assert.Equal(t, expectedLeadingClockData.controlledPortsConfig, e.LeadingClockData.controlledPortsConfig)
-	assert.Equal(t, expectedLeadingClockData.clockID, e.LeadingClockData.clockID)
+	assert.Equal(t, expectedLeadingClockData.controlledPortsConfig, e.leadingClockData("").controlledPortsConfig)
+	assert.Equal(t, expectedLeadingClockData.clockID, e.leadingClockData("").clockID)
 }
 
 func TestUpdateLeadingClockData_DPLL(t *testing.T) {
@@ -142,15 +142,15 @@ func TestUpdateLeadingClockData_DPLL(t *testing.T) {
 	}
 
 	e := EventHandler{
-		LeadingClockData: &LeadingClockParams{},
+		chains: testChains("", &LeadingClockParams{}),
 	}
 	e.updateLeadingClockData(event)
 
-	assert.Equal(t, expectedLeadingClockData.leadingInterface, e.LeadingClockData.leadingInterface)
-	assert.Equal(t, expectedLeadingClockData.inSyncConditionThreshold, e.LeadingClockData.inSyncConditionThreshold)
-	assert.Equal(t, expectedLeadingClockData.inSyncConditionTimes, e.LeadingClockData.inSyncConditionTimes)
-	assert.Equal(t, expectedLeadingClockData.toFreeRunThreshold, e.LeadingClockData.toFreeRunThreshold)
-	assert.Equal(t, expectedLeadingClockData.MaxInSpecOffset, e.LeadingClockData.MaxInSpecOffset)
+	assert.Equal(t, expectedLeadingClockData.leadingInterface, e.leadingClockData("").leadingInterface)
+	assert.Equal(t, expectedLeadingClockData.inSyncConditionThreshold, e.leadingClockData("").inSyncConditionThreshold)
+	assert.Equal(t, expectedLeadingClockData.inSyncConditionTimes, e.leadingClockData("").inSyncConditionTimes)
+	assert.Equal(t, expectedLeadingClockData.toFreeRunThreshold, e.leadingClockData("").toFreeRunThreshold)
+	assert.Equal(t, expectedLeadingClockData.MaxInSpecOffset, e.leadingClockData("").MaxInSpecOffset)
 }
 
 func TestGetLeadingInterfaceBC(t *testing.T) {
@@ -162,16 +162,16 @@ func TestGetLeadingInterfaceBC(t *testing.T) {
 		{
 			name: "LeadingInterface is not empty",
 			input: &EventHandler{
-				LeadingClockData: &LeadingClockParams{
+				chains: testChains("ptp4l.0.config", &LeadingClockParams{
 					leadingInterface: "eth0",
-				},
+				}),
 			},
 			expected: "eth0",
 		},
 		{
 			name: "LeadingInterface is empty",
 			input: &EventHandler{
-				LeadingClockData: &LeadingClockParams{},
+				chains: testChains("ptp4l.0.config", &LeadingClockParams{}),
 			},
 			expected: LEADING_INTERFACE_UNKNOWN,
 		},
@@ -179,7 +179,7 @@ func TestGetLeadingInterfaceBC(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := tt.input.getLeadingInterfaceBC()
+			result := tt.input.getLeadingInterfaceBC("ptp4l.0.config")
 			assert.Equal(t, tt.expected, result)
 		})
 	}
@@ -188,7 +188,7 @@ func TestGetLeadingInterfaceBC(t *testing.T) {
 func TestInSpecCondition(t *testing.T) {
 	t.Run("returns false when MaxInSpecOffset is 0", func(t *testing.T) {
 		e := EventHandler{
-			LeadingClockData: &LeadingClockParams{MaxInSpecOffset: 0},
+			chains: testChains("testConfig", &LeadingClockParams{MaxInSpecOffset: 0}),
 		}
 		result := e.inSpecCondition("testConfig")
 		assert.False(t, result)
@@ -204,7 +204,7 @@ func TestInSpecCondition(t *testing.T) {
 			clkSyncState: map[string]*clockSyncState{
 				"testConfig": {leadingIFace: "iface1"},
 			},
-			LeadingClockData: &LeadingClockParams{MaxInSpecOffset: 5},
+			chains: testChains("testConfig", &LeadingClockParams{MaxInSpecOffset: 5}),
 		}
 		result := e.inSpecCondition("testConfig")
 		assert.False(t, result)
@@ -220,7 +220,7 @@ func TestInSpecCondition(t *testing.T) {
 			clkSyncState: map[string]*clockSyncState{
 				"testConfig": {leadingIFace: "iface1"},
 			},
-			LeadingClockData: &LeadingClockParams{MaxInSpecOffset: 5},
+			chains: testChains("testConfig", &LeadingClockParams{MaxInSpecOffset: 5}),
 		}
 		result := e.inSpecCondition("testConfig")
 		assert.True(t, result)
@@ -330,9 +330,9 @@ func TestFreeRunCondition(t *testing.T) {
 				}
 			}
 			e := &EventHandler{
-				data:             tt.data,
-				clkSyncState:     tt.syncState,
-				LeadingClockData: &LeadingClockParams{toFreeRunThreshold: tt.threshold},
+				data:         tt.data,
+				clkSyncState: tt.syncState,
+				chains:       testChains(tt.cfgName, &LeadingClockParams{toFreeRunThreshold: tt.threshold}),
 			}
 
 			result := e.freeRunCondition(tt.cfgName)
@@ -1084,17 +1084,17 @@ func TestUpdateGMState(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.desc, func(t *testing.T) {
 			e := &EventHandler{
-				data:             map[string][]*Data{},
-				clkSyncState:     map[string]*clockSyncState{},
-				LeadingClockData: newLeadingClockParams(),
+				data:         map[string][]*Data{},
+				clkSyncState: map[string]*clockSyncState{},
+				chains:       testChains(cfg, newLeadingClockParams()),
 			}
 
 			for i, s := range tt.steps {
 				for _, ev := range s.events {
 					e.addEvent(ev)
 				}
-				e.outOfSpec = s.outOfSpec
-				e.frequencyTraceable = s.frequencyTrace
+				e.chain(cfg).outOfSpec = s.outOfSpec
+				e.chain(cfg).frequencyTraceable = s.frequencyTrace
 
 				result := e.updateGMState(cfg)
 				assert.Equal(t, s.wantState, result.state, "step %d: state", i)
@@ -1128,7 +1128,7 @@ func TestUpdateBCState(t *testing.T) {
 		return &EventHandler{
 			data:         map[string][]*Data{},
 			clkSyncState: map[string]*clockSyncState{},
-			LeadingClockData: &LeadingClockParams{
+			chains: testChains(cfg, &LeadingClockParams{
 				leadingInterface:         iface,
 				inSyncConditionThreshold: 100,
 				inSyncConditionTimes:     1,
@@ -1138,7 +1138,7 @@ func TestUpdateBCState(t *testing.T) {
 				upstreamTimeProperties:   &protocol.TimePropertiesDS{},
 				downstreamParentDataSet:  &protocol.ParentDataSet{},
 				downstreamTimeProperties: &protocol.TimePropertiesDS{},
-			},
+			}),
 		}
 	}
 
@@ -1212,7 +1212,7 @@ func TestUpdateBCState(t *testing.T) {
 		e.addEvent(makeBCEvent(PTP4lProcessName, PTP_LOCKED, 5, false))
 		e.addEvent(makeBCEvent(DPLL, PTP_LOCKED, 5, false))
 		fillDataWindows(e, cfg, 5)
-		e.LeadingClockData.inSyncThresholdCounter = 0
+		e.leadingClockData(cfg).inSyncThresholdCounter = 0
 		result, needsTTSCAnnounce, needsDownstreamUpdate := e.updateBCState(makeBCEvent(DPLL, PTP_LOCKED, 5, false))
 		assert.Equal(t, PTP_LOCKED, result.state, "should transition back to LOCKED")
 		assert.False(t, needsTTSCAnnounce, "not a TTSC")
@@ -1278,35 +1278,35 @@ func TestUpdateSpecState(t *testing.T) {
 
 	t.Run("DPLL event sets outOfSpec", func(t *testing.T) {
 		e := newHandler()
-		assert.False(t, e.outOfSpec, "initial outOfSpec should be false")
+		assert.False(t, e.chain("").outOfSpec, "initial outOfSpec should be false")
 
 		e.updateSpecState(Event{Source: DPLL, Data: &PTPData{OutOfSpec: true}})
-		assert.True(t, e.outOfSpec, "DPLL event with OutOfSpec=true should set outOfSpec")
+		assert.True(t, e.chain("").outOfSpec, "DPLL event with OutOfSpec=true should set outOfSpec")
 
 		e.updateSpecState(Event{Source: DPLL, Data: &PTPData{OutOfSpec: false}})
-		assert.False(t, e.outOfSpec, "DPLL event with OutOfSpec=false should clear outOfSpec")
+		assert.False(t, e.chain("").outOfSpec, "DPLL event with OutOfSpec=false should clear outOfSpec")
 	})
 
 	t.Run("DPLL event sets frequencyTraceable", func(t *testing.T) {
 		e := newHandler()
-		assert.False(t, e.frequencyTraceable, "initial frequencyTraceable should be false")
+		assert.False(t, e.chain("").frequencyTraceable, "initial frequencyTraceable should be false")
 
 		e.updateSpecState(Event{Source: DPLL, Data: &PTPData{FrequencyTraceable: true}})
-		assert.True(t, e.frequencyTraceable, "DPLL event should set frequencyTraceable")
+		assert.True(t, e.chain("").frequencyTraceable, "DPLL event should set frequencyTraceable")
 
 		e.updateSpecState(Event{Source: DPLL, Data: &PTPData{FrequencyTraceable: false}})
-		assert.False(t, e.frequencyTraceable, "DPLL event should clear frequencyTraceable")
+		assert.False(t, e.chain("").frequencyTraceable, "DPLL event should clear frequencyTraceable")
 	})
 
 	t.Run("non-DPLL event does not change spec state", func(t *testing.T) {
 		e := newHandler()
 
 		e.updateSpecState(Event{Source: DPLL, Data: &PTPData{OutOfSpec: true, FrequencyTraceable: true}})
-		assert.True(t, e.outOfSpec)
-		assert.True(t, e.frequencyTraceable)
+		assert.True(t, e.chain("").outOfSpec)
+		assert.True(t, e.chain("").frequencyTraceable)
 
 		e.updateSpecState(Event{Source: TS2PHC, Data: &PTPData{OutOfSpec: false, FrequencyTraceable: false}})
-		assert.True(t, e.outOfSpec, "non-DPLL event should not change outOfSpec")
-		assert.True(t, e.frequencyTraceable, "non-DPLL event should not change frequencyTraceable")
+		assert.True(t, e.chain("").outOfSpec, "non-DPLL event should not change outOfSpec")
+		assert.True(t, e.chain("").frequencyTraceable, "non-DPLL event should not change frequencyTraceable")
 	})
 }
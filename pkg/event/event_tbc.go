@@ -83,8 +83,10 @@ func newLeadingClockParams() *LeadingClockParams {
 // The caller must perform all I/O after releasing the lock.
 func (e *EventHandler) updateBCState(event Event) (clockSyncState, bool, bool) {
 	cfgName := event.CfgName
+	lcd := e.leadingClockData(cfgName)
 	dpllState := PTP_NOTSET
 	ts2phcState := PTP_FREERUN
+	e.pendingChecks = nil
 
 	// For internal data announces, only update the downstream data on class change
 	// For External GM data announces in the locked state, update whenever any of the
@@ -93,9 +95,10 @@ func (e *EventHandler) updateBCState(event Event) (clockSyncState, bool, bool) {
 	if event.Source == PTP4lProcessName {
 		glog.Infof("PTP4l event: %+v", event)
 	}
-	leadingInterface := e.getLeadingInterfaceBC()
+	leadingInterface := e.getLeadingInterfaceBC(cfgName)
 	if leadingInterface == LEADING_INTERFACE_UNKNOWN {
 		glog.Infof("Leading interface is not yet identified, clock state reporting delayed.")
+		e.recordDecision(cfgName, event.ClockType, DeciderBC, "leading interface not yet identified", &clockSyncState{leadingIFace: leadingInterface})
 		return clockSyncState{leadingIFace: leadingInterface}, false, false
 	}
 
@@ -131,18 +134,21 @@ func (e *EventHandler) updateBCState(event Event) (clockSyncState, bool, bool) {
 		e.clkSyncState[cfgName].clkLog = fmt.Sprintf("T-BC[%d]:[%s] %s offset %d T-BC-STATUS %s\n",
 			e.clkSyncState[cfgName].lastLoggedTime, cfgName, leadingInterface, e.clkSyncState[cfgName].clockOffset,
 			e.clkSyncState[cfgName].state)
+		e.recordDecision(cfgName, event.ClockType, DeciderBC, "no source data", e.clkSyncState[cfgName])
 		return *e.clkSyncState[cfgName], false, false
 	}
 
-	isTTSC := (e.LeadingClockData.clockID != "" && e.LeadingClockData.controlledPortsConfig == "")
+	isTTSC := (lcd.clockID != "" && lcd.controlledPortsConfig == "")
 
 	glog.V(14).Info("current BC state: ", e.clkSyncState[cfgName].state)
+	reason := "no transition from " + string(e.clkSyncState[cfgName].state)
 	switch e.clkSyncState[cfgName].state {
 	case PTP_NOTSET, PTP_FREERUN:
 		if !e.isSourceLostBC(cfgName) && e.inSyncCondition(cfgName) {
 			e.clkSyncState[cfgName].state = PTP_LOCKED
 			glog.Info("BC FSM: FREERUN to LOCKED")
-			e.LeadingClockData.lastInSpec = true
+			reason = "FREERUN to LOCKED: source present and in-sync condition met"
+			lcd.lastInSpec = true
 			updateDownstreamData = true
 		}
 	case PTP_LOCKED:
@@ -150,27 +156,29 @@ func (e *EventHandler) updateBCState(event Event) (clockSyncState, bool, bool) {
 			e.clkSyncState[cfgName].state = PTP_FREERUN
 			e.clkSyncState[cfgName].clockClass = protocol.ClockClassFreerun
 			glog.Info("BC FSM: LOCKED to FREERUN")
+			reason = "LOCKED to FREERUN: free-run condition or non-leading DPLL fault"
 			updateDownstreamData = true
 		} else if e.isSourceLostBC(cfgName) {
 			e.clkSyncState[cfgName].state = PTP_HOLDOVER
 			e.clkSyncState[cfgName].clockClass = fbprotocol.ClockClass(135)
 			glog.Info("BC FSM: LOCKED to HOLDOVER")
-			e.LeadingClockData.lastInSpec = true
+			reason = "LOCKED to HOLDOVER: source lost"
+			lcd.lastInSpec = true
 			updateDownstreamData = true
 		} else {
-			if *e.LeadingClockData.upstreamTimeProperties != *e.LeadingClockData.downstreamTimeProperties {
-				e.LeadingClockData.downstreamTimeProperties = e.LeadingClockData.upstreamTimeProperties
+			if *lcd.upstreamTimeProperties != *lcd.downstreamTimeProperties {
+				lcd.downstreamTimeProperties = lcd.upstreamTimeProperties
 				updateDownstreamData = true
 			}
-			if *e.LeadingClockData.upstreamParentDataSet != *e.LeadingClockData.downstreamParentDataSet {
-				e.LeadingClockData.downstreamParentDataSet = e.LeadingClockData.upstreamParentDataSet
+			if *lcd.upstreamParentDataSet != *lcd.downstreamParentDataSet {
+				lcd.downstreamParentDataSet = lcd.upstreamParentDataSet
 				updateDownstreamData = true
 			}
-			if e.LeadingClockData.upstreamParentDataSet.GrandmasterClockClass == uint8(protocol.ClockClassFreerun) {
+			if lcd.upstreamParentDataSet.GrandmasterClockClass == uint8(protocol.ClockClassFreerun) {
 				updateDownstreamData = false // Don't propagate uptream free run and instead let future call move to holdover/freerun
-			} else if e.clkSyncState[cfgName].clockClass != fbprotocol.ClockClass(e.LeadingClockData.upstreamParentDataSet.GrandmasterClockClass) && !isTTSC {
-				e.clkSyncState[cfgName].clockClass = fbprotocol.ClockClass(e.LeadingClockData.upstreamParentDataSet.GrandmasterClockClass)
-				e.clkSyncState[cfgName].clockAccuracy = fbprotocol.ClockAccuracy(e.LeadingClockData.upstreamParentDataSet.GrandmasterClockAccuracy)
+			} else if e.clkSyncState[cfgName].clockClass != fbprotocol.ClockClass(lcd.upstreamParentDataSet.GrandmasterClockClass) && !isTTSC {
+				e.clkSyncState[cfgName].clockClass = fbprotocol.ClockClass(lcd.upstreamParentDataSet.GrandmasterClockClass)
+				e.clkSyncState[cfgName].clockAccuracy = fbprotocol.ClockAccuracy(lcd.upstreamParentDataSet.GrandmasterClockAccuracy)
 			}
 		}
 	case PTP_HOLDOVER:
@@ -180,29 +188,33 @@ func (e *EventHandler) updateBCState(event Event) (clockSyncState, bool, bool) {
 			e.clkSyncState[cfgName].state = PTP_FREERUN
 			e.clkSyncState[cfgName].clockClass = protocol.ClockClassFreerun
 			glog.Info("BC FSM: HOLDOVER to FREERUN")
+			reason = "HOLDOVER to FREERUN: free-run condition or non-leading DPLL fault"
 			updateDownstreamData = true
 		case e.inSyncCondition(cfgName) && !e.isSourceLostBC(cfgName):
 			e.clkSyncState[cfgName].state = PTP_LOCKED
 			glog.Info("BC FSM: HOLDOVER to LOCKED")
+			reason = "HOLDOVER to LOCKED: source recovered and in-sync condition met"
 			updateDownstreamData = true
 		default:
 			if event.IFace == leadingInterface {
 				inSpec := false
-				if e.LeadingClockData.lastInSpec {
+				if lcd.lastInSpec {
 					inSpec = e.inSpecCondition(cfgName)
 				}
-				if e.LeadingClockData.lastInSpec != inSpec {
-					e.LeadingClockData.lastInSpec = inSpec
+				if lcd.lastInSpec != inSpec {
+					lcd.lastInSpec = inSpec
 					if !inSpec {
 						if e.clkSyncState[cfgName].clockClass != fbprotocol.ClockClass(165) {
 							e.clkSyncState[cfgName].clockClass = fbprotocol.ClockClass(165)
 							glog.Info("BC FSM: HOLDOVER sub-state Out Of Spec")
+							reason = "HOLDOVER out of spec"
 							updateDownstreamData = true
 						}
 					} else {
 						if e.clkSyncState[cfgName].clockClass != fbprotocol.ClockClass(135) {
 							e.clkSyncState[cfgName].clockClass = fbprotocol.ClockClass(135)
 							glog.Info("BC FSM: HOLDOVER sub-state In Spec")
+							reason = "HOLDOVER in spec"
 							updateDownstreamData = true
 						}
 					}
@@ -234,12 +246,13 @@ func (e *EventHandler) updateBCState(event Event) (clockSyncState, bool, bool) {
 	if isTTSC && e.clkSyncState[cfgName].clockClass != fbprotocol.ClockClassSlaveOnly {
 		e.clkSyncState[cfgName].clockClass = fbprotocol.ClockClassSlaveOnly
 	}
+	e.recordDecision(cfgName, event.ClockType, DeciderBC, reason, e.clkSyncState[cfgName])
 	needsTTSCAnnounce := false
 	needsDownstreamUpdate := false
 	if updateDownstreamData && e.clkSyncState[cfgName].clockClass != protocol.ClockClassUninitialized {
 		if isTTSC {
 			// Set clock class fields under lock; the caller will emit after releasing the lock
-			e.setClockClassLocked(e.clkSyncState[cfgName].clockClass, e.clkSyncState[cfgName].clockAccuracy)
+			e.setClockClassLocked(cfgName, e.clkSyncState[cfgName].clockClass, e.clkSyncState[cfgName].clockAccuracy)
 			needsTTSCAnnounce = true
 		} else {
 			needsDownstreamUpdate = true
@@ -261,9 +274,10 @@ func (e *EventHandler) updateBCState(event Event) (clockSyncState, bool, bool) {
 
 // UpdateUpstreamParentDataSet updates the upstream time properties, parent data set, and current data set
 // for the leading clock and triggers downstream data updates when changes are detected.
-func (e *EventHandler) UpdateUpstreamParentDataSet(parentDS protocol.ParentDataSet) {
-	if !parentDS.Equal(e.LeadingClockData.upstreamParentDataSet) {
-		e.LeadingClockData.upstreamParentDataSet = &parentDS
+func (e *EventHandler) UpdateUpstreamParentDataSet(cfgName string, parentDS protocol.ParentDataSet) {
+	lcd := e.leadingClockData(cfgName)
+	if !parentDS.Equal(lcd.upstreamParentDataSet) {
+		lcd.upstreamParentDataSet = &parentDS
 	}
 }
 
@@ -314,9 +328,9 @@ func (e *EventHandler) EmitClockClass(cfgName string) {
 func (e *EventHandler) announceLocalData(cfgName string) {
 	// Snapshot shared data under lock to prevent data races with updateBCState
 	e.Lock()
-	clockID := e.LeadingClockData.clockID
-	controlledPortsConfig := e.LeadingClockData.controlledPortsConfig
-	downstreamTimeProperties := e.LeadingClockData.downstreamTimeProperties
+	clockID := e.leadingClockData(cfgName).clockID
+	controlledPortsConfig := e.leadingClockData(cfgName).controlledPortsConfig
+	downstreamTimeProperties := e.leadingClockData(cfgName).downstreamTimeProperties
 	state, ok := e.clkSyncState[cfgName]
 	if !ok {
 		e.Unlock()
@@ -415,7 +429,7 @@ func (e *EventHandler) downstreamAnnounceIWF(ctx context.Context, cfgName string
 
 	// Snapshot controlledPortsConfig under lock
 	e.Lock()
-	controlledPortsConfig := e.LeadingClockData.controlledPortsConfig
+	controlledPortsConfig := e.leadingClockData(cfgName).controlledPortsConfig
 	e.Unlock()
 
 	upsteamData, fetchErr := pmc.GetParentTimeAndCurrentDS(cfgName)
@@ -430,9 +444,9 @@ func (e *EventHandler) downstreamAnnounceIWF(ctx context.Context, cfgName string
 	}
 
 	if !e.applyIfLockedBC(cfgName, "after PMC fetch", func() {
-		e.LeadingClockData.upstreamParentDataSet = &upsteamData.ParentDataSet
-		e.LeadingClockData.upstreamTimeProperties = &upsteamData.TimePropertiesDS
-		e.LeadingClockData.upstreamCurrentDSStepsRemoved = upsteamData.CurrentDS.StepsRemoved
+		e.leadingClockData(cfgName).upstreamParentDataSet = &upsteamData.ParentDataSet
+		e.leadingClockData(cfgName).upstreamTimeProperties = &upsteamData.TimePropertiesDS
+		e.leadingClockData(cfgName).upstreamCurrentDSStepsRemoved = upsteamData.CurrentDS.StepsRemoved
 	}) {
 		return
 	}
@@ -482,29 +496,35 @@ func (e *EventHandler) downstreamAnnounceIWF(ctx context.Context, cfgName string
 	}
 
 	e.applyIfLockedBC(cfgName, "after downstream announce", func() {
-		e.LeadingClockData.downstreamParentDataSet = &upsteamData.ParentDataSet
-		e.LeadingClockData.downstreamTimeProperties = &upsteamData.TimePropertiesDS
+		e.leadingClockData(cfgName).downstreamParentDataSet = &upsteamData.ParentDataSet
+		e.leadingClockData(cfgName).downstreamTimeProperties = &upsteamData.TimePropertiesDS
 	})
 }
 
 func (e *EventHandler) inSyncCondition(cfgName string) bool {
-	if e.LeadingClockData.inSyncConditionThreshold == 0 {
+	lcd := e.leadingClockData(cfgName)
+	if lcd.inSyncConditionThreshold == 0 {
 		glog.Info("Leading clock in-sync condition is pending initialization")
 		return false
 	}
 
 	worstOffset := e.getLargestOffset(cfgName)
-	if math.Abs(float64(worstOffset)) < float64(e.LeadingClockData.inSyncConditionThreshold) {
-		e.LeadingClockData.inSyncThresholdCounter++
-		if e.LeadingClockData.inSyncThresholdCounter >= e.LeadingClockData.inSyncConditionTimes {
+	inSync := math.Abs(float64(worstOffset)) < float64(lcd.inSyncConditionThreshold)
+	e.recordThresholdCheck(string(InSyncConditionThreshold), "", worstOffset, int64(lcd.inSyncConditionThreshold), inSync)
+	if inSync {
+		inSync = e.inSourceEnterBands(cfgName)
+	}
+	if inSync {
+		lcd.inSyncThresholdCounter++
+		if lcd.inSyncThresholdCounter >= lcd.inSyncConditionTimes {
 			return true
 		}
 	} else {
-		e.LeadingClockData.inSyncThresholdCounter = 0
+		lcd.inSyncThresholdCounter = 0
 	}
 
 	glog.Info("sync condition not reached: worst offset ", worstOffset, " count ",
-		e.LeadingClockData.inSyncThresholdCounter, " out of ", e.LeadingClockData.inSyncConditionTimes)
+		lcd.inSyncThresholdCounter, " out of ", lcd.inSyncConditionTimes)
 
 	return false
 }
@@ -591,7 +611,8 @@ func (e *EventHandler) getCalculatedHoldoverOffset(cfgName string) int64 {
 }
 
 func (e *EventHandler) freeRunCondition(cfgName string) bool {
-	if e.LeadingClockData.toFreeRunThreshold == 0 {
+	lcd := e.leadingClockData(cfgName)
+	if lcd.toFreeRunThreshold == 0 {
 		glog.Info("Leading clock free-run condition is pending initialization")
 		return true
 	}
@@ -601,7 +622,8 @@ func (e *EventHandler) freeRunCondition(cfgName string) bool {
 			case DPLL:
 				for _, dd := range d.Details {
 					if dd.IFace == e.clkSyncState[cfgName].leadingIFace {
-						if math.Abs(float64(dd.Offset)) > float64(e.LeadingClockData.toFreeRunThreshold) {
+						exceeded := e.exceedsFreeRunThreshold(cfgName, DPLL, dd.IFace, dd.Offset)
+						if exceeded {
 							glog.Infof("free-run condition on DPLL %s", dd.IFace)
 							return true
 						}
@@ -615,7 +637,8 @@ func (e *EventHandler) freeRunCondition(cfgName string) bool {
 				// (which feeds the window via sendPtp4lOffsetEvent) may have a different
 				// interface name than the DPLL leading interface on the same NIC.
 				ptp4lAvgOffset := int64(d.window.Mean())
-				if math.Abs(float64(ptp4lAvgOffset)) > float64(e.LeadingClockData.toFreeRunThreshold) {
+				exceeded := e.exceedsFreeRunThreshold(cfgName, PTP4l, "", ptp4lAvgOffset)
+				if exceeded {
 					glog.Infof("free-run condition on PTP4l, avg offset %d", ptp4lAvgOffset)
 					return true
 				}
@@ -625,8 +648,56 @@ func (e *EventHandler) freeRunCondition(cfgName string) bool {
 	return false
 }
 
+// exceedsFreeRunThreshold checks the source offset against its configured exit band,
+// or the leading clock free-run threshold when the source has none
+func (e *EventHandler) exceedsFreeRunThreshold(cfgName string, source EventSource, iface string, offset int64) bool {
+	lcd := e.leadingClockData(cfgName)
+	threshold := int64(lcd.toFreeRunThreshold)
+	exceeded := math.Abs(float64(offset)) > float64(threshold)
+	if th, ok := e.offsetThresholds[cfgName].Override(source, iface); ok {
+		threshold = th.Limit()
+		exceeded = !th.InRange(offset, true)
+	}
+	e.recordThresholdCheck(string(ToFreeRunThreshold), source, offset, threshold, !exceeded)
+	return exceeded
+}
+
+// inSourceEnterBands checks that the sources with configured thresholds are back within their enter band
+func (e *EventHandler) inSourceEnterBands(cfgName string) bool {
+	thresholds := e.offsetThresholds[cfgName]
+	if thresholds == nil {
+		return true
+	}
+	inBand := true
+	check := func(source EventSource, iface string, offset int64) {
+		th, ok := thresholds.Override(source, iface)
+		if !ok {
+			return
+		}
+		in := th.InRange(offset, false)
+		e.recordThresholdCheck(string(InSyncConditionThreshold), source, offset, max(th.EnterMax, -th.EnterMin), in)
+		inBand = inBand && in
+	}
+	for _, d := range e.data[cfgName] {
+		switch d.ProcessName {
+		case DPLL:
+			for _, dd := range d.Details {
+				if dd.IFace == e.clkSyncState[cfgName].leadingIFace {
+					check(DPLL, dd.IFace, dd.Offset)
+				}
+			}
+		case PTP4l:
+			if !d.window.IsEmpty() {
+				check(PTP4l, "", int64(d.window.Mean()))
+			}
+		}
+	}
+	return inBand
+}
+
 func (e *EventHandler) inSpecCondition(cfgName string) bool {
-	if e.LeadingClockData.MaxInSpecOffset == 0 {
+	lcd := e.leadingClockData(cfgName)
+	if lcd.MaxInSpecOffset == 0 {
 		glog.Info("Leading clock in-spec condition is pending initialization")
 		return false
 	}
@@ -635,7 +706,9 @@ func (e *EventHandler) inSpecCondition(cfgName string) bool {
 			if d.ProcessName == DPLL {
 				for _, dd := range d.Details {
 					if dd.IFace == e.clkSyncState[cfgName].leadingIFace {
-						if math.Abs(float64(dd.Offset)) > float64(e.LeadingClockData.MaxInSpecOffset) {
+						outOfSpec := math.Abs(float64(dd.Offset)) > float64(lcd.MaxInSpecOffset)
+						e.recordThresholdCheck(string(MaxInSpecOffset), DPLL, dd.Offset, int64(lcd.MaxInSpecOffset), !outOfSpec)
+						if outOfSpec {
 							glog.Infof("out-of-spec condition on DPLL ", dd.IFace)
 							return false
 						}
@@ -647,9 +720,9 @@ func (e *EventHandler) inSpecCondition(cfgName string) bool {
 	return true
 }
 
-func (e *EventHandler) getLeadingInterfaceBC() string {
-	if e.LeadingClockData.leadingInterface != "" {
-		return e.LeadingClockData.leadingInterface
+func (e *EventHandler) getLeadingInterfaceBC(cfgName string) string {
+	if lcd := e.leadingClockData(cfgName); lcd.leadingInterface != "" {
+		return lcd.leadingInterface
 	}
 	return LEADING_INTERFACE_UNKNOWN
 }
@@ -690,36 +763,38 @@ func (e *EventHandler) updateLeadingClockData(event Event) {
 	if !ok {
 		return
 	}
+	lcd := e.leadingClockData(event.CfgName)
 	switch event.Source {
 	case PTP4lProcessName:
 		cpc, found := ptp.Values[ControlledPortsConfig].(string)
 		if found {
-			e.LeadingClockData.controlledPortsConfig = cpc
+			lcd.controlledPortsConfig = cpc
+			e.linkControlledChain(event.CfgName, cpc)
 		}
 		id, found := ptp.Values[ClockIDKey].(string)
 		if found {
-			e.LeadingClockData.clockID = id
+			lcd.clockID = id
 		}
 	case DPLL:
 		ls, found := ptp.Values[LeadingSource].(bool)
 		if found && ls {
-			e.LeadingClockData.leadingInterface = event.IFace
+			lcd.leadingInterface = event.IFace
 		}
 		inSyncTh, found := ptp.Values[InSyncConditionThreshold].(uint64)
 		if found {
-			e.LeadingClockData.inSyncConditionThreshold = int(inSyncTh)
+			lcd.inSyncConditionThreshold = int(inSyncTh)
 		}
 		inSyncTimes, found := ptp.Values[InSyncConditionTimes].(uint64)
 		if found {
-			e.LeadingClockData.inSyncConditionTimes = int(inSyncTimes)
+			lcd.inSyncConditionTimes = int(inSyncTimes)
 		}
 		toFreeRunTh, found := ptp.Values[ToFreeRunThreshold].(uint64)
 		if found {
-			e.LeadingClockData.toFreeRunThreshold = int(toFreeRunTh)
+			lcd.toFreeRunThreshold = int(toFreeRunTh)
 		}
 		maxInSpec, found := ptp.Values[MaxInSpecOffset].(uint64)
 		if found {
-			e.LeadingClockData.MaxInSpecOffset = maxInSpec
+			lcd.MaxInSpecOffset = maxInSpec
 		}
 	}
 }
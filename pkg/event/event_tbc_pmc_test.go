@@ -34,7 +34,6 @@ func newPMCTestEventHandler() *EventHandler {
 		data:             map[string][]*Data{},
 		clkSyncState:     map[string]*clockSyncState{},
 		downstreamCancel: map[string]context.CancelFunc{},
-		LeadingClockData: newLeadingClockParams(),
 	}
 }
 
@@ -93,8 +92,8 @@ func TestAnnounceLocalData_Freerun_SetsGMSettingsAndEGP(t *testing.T) {
 	defer pmc.ResetMock()
 	e := newPMCTestEventHandler()
 	cfgName := testCfgName
-	e.LeadingClockData.clockID = "001122.fffe.334455"
-	e.LeadingClockData.controlledPortsConfig = testControlledCfg
+	e.leadingClockData(cfgName).clockID = "001122.fffe.334455"
+	e.leadingClockData(cfgName).controlledPortsConfig = testControlledCfg
 	e.clkSyncState[cfgName] = &clockSyncState{
 		state:      PTP_FREERUN,
 		clockClass: protocol.ClockClassFreerun,
@@ -145,9 +144,9 @@ func TestAnnounceLocalData_Holdover135_SetsTimeProperties(t *testing.T) {
 	defer pmc.ResetMock()
 	e := newPMCTestEventHandler()
 	cfgName := testCfgName
-	e.LeadingClockData.clockID = "aabbcc.fffe.ddeeff"
-	e.LeadingClockData.controlledPortsConfig = testControlledCfg
-	e.LeadingClockData.downstreamTimeProperties = &protocol.TimePropertiesDS{
+	e.leadingClockData(cfgName).clockID = "aabbcc.fffe.ddeeff"
+	e.leadingClockData(cfgName).controlledPortsConfig = testControlledCfg
+	e.leadingClockData(cfgName).downstreamTimeProperties = &protocol.TimePropertiesDS{
 		CurrentUtcOffset:      37,
 		CurrentUtcOffsetValid: true,
 		Leap61:                true,
@@ -190,9 +189,9 @@ func TestAnnounceLocalData_Holdover165_NotTimeTraceable(t *testing.T) {
 	defer pmc.ResetMock()
 	e := newPMCTestEventHandler()
 	cfgName := testCfgName
-	e.LeadingClockData.clockID = "aabbcc.fffe.ddeeff"
-	e.LeadingClockData.controlledPortsConfig = testControlledCfg
-	e.LeadingClockData.downstreamTimeProperties = &protocol.TimePropertiesDS{
+	e.leadingClockData(cfgName).clockID = "aabbcc.fffe.ddeeff"
+	e.leadingClockData(cfgName).controlledPortsConfig = testControlledCfg
+	e.leadingClockData(cfgName).downstreamTimeProperties = &protocol.TimePropertiesDS{
 		CurrentUtcOffset: 37,
 	}
 	e.clkSyncState[cfgName] = &clockSyncState{
@@ -230,9 +229,9 @@ func TestAnnounceLocalData_NilDownstreamTimeProperties_SkipsGMSettings(t *testin
 	e := newPMCTestEventHandler()
 	cfgName := testCfgName
 
-	e.LeadingClockData.clockID = "aabbcc.fffe.ddeeff"
-	e.LeadingClockData.controlledPortsConfig = testControlledCfg
-	e.LeadingClockData.downstreamTimeProperties = nil
+	e.leadingClockData(cfgName).clockID = "aabbcc.fffe.ddeeff"
+	e.leadingClockData(cfgName).controlledPortsConfig = testControlledCfg
+	e.leadingClockData(cfgName).downstreamTimeProperties = nil
 	e.clkSyncState[cfgName] = &clockSyncState{
 		state:      PTP_HOLDOVER,
 		clockClass: fbprotocol.ClockClass(135),
@@ -294,7 +293,7 @@ func TestDownstreamAnnounceIWF_Locked_FetchesAndSetsDownstream(t *testing.T) {
 	defer pmc.ResetMock()
 
 	e := newPMCTestEventHandler()
-	e.LeadingClockData.controlledPortsConfig = testControlledCfg
+	e.leadingClockData(cfgName).controlledPortsConfig = testControlledCfg
 	e.clkSyncState[cfgName] = &clockSyncState{state: PTP_LOCKED}
 
 	ctx := context.Background()
@@ -330,9 +329,9 @@ func TestDownstreamAnnounceIWF_Locked_FetchesAndSetsDownstream(t *testing.T) {
 	assert.True(t, gs.TimePropertiesDS.PtpTimescale)
 	assert.True(t, gs.TimePropertiesDS.TimeTraceable)
 
-	assert.Equal(t, uint8(6), e.LeadingClockData.upstreamParentDataSet.GrandmasterClockClass)
-	assert.Equal(t, uint8(6), e.LeadingClockData.downstreamParentDataSet.GrandmasterClockClass)
-	assert.Equal(t, uint16(1), e.LeadingClockData.upstreamCurrentDSStepsRemoved)
+	assert.Equal(t, uint8(6), e.leadingClockData(cfgName).upstreamParentDataSet.GrandmasterClockClass)
+	assert.Equal(t, uint8(6), e.leadingClockData(cfgName).downstreamParentDataSet.GrandmasterClockClass)
+	assert.Equal(t, uint16(1), e.leadingClockData(cfgName).upstreamCurrentDSStepsRemoved)
 }
 
 func TestDownstreamAnnounceIWF_FetchError_ReturnsEarly(t *testing.T) {
@@ -414,7 +413,7 @@ func TestUpdateDownstreamData_Locked_CallsDownstreamAnnounceIWF(t *testing.T) {
 	defer pmc.ResetMock()
 	e := newPMCTestEventHandler()
 	cfgName := testCfgName
-	e.LeadingClockData.controlledPortsConfig = testControlledCfg
+	e.leadingClockData(cfgName).controlledPortsConfig = testControlledCfg
 	e.clkSyncState[cfgName] = &clockSyncState{state: PTP_LOCKED}
 
 	e.updateDownstreamData(cfgName)
@@ -436,8 +435,8 @@ func TestUpdateDownstreamData_Freerun_CallsAnnounceLocalData(t *testing.T) {
 	defer pmc.ResetMock()
 	e := newPMCTestEventHandler()
 	cfgName := testCfgName
-	e.LeadingClockData.clockID = "001122.fffe.334455"
-	e.LeadingClockData.controlledPortsConfig = testControlledCfg
+	e.leadingClockData(cfgName).clockID = "001122.fffe.334455"
+	e.leadingClockData(cfgName).controlledPortsConfig = testControlledCfg
 	e.clkSyncState[cfgName] = &clockSyncState{
 		state:      PTP_FREERUN,
 		clockClass: protocol.ClockClassFreerun,
@@ -26,3 +26,10 @@ func (t *EventHandlerForTests) SetClockClass(cfgName string, clockClass uint8) {
 		clockClass: fbprotocol.ClockClass(clockClass),
 	}
 }
+
+// testChains returns the clock chains of a handler holding the leading clock data of one profile
+func testChains(cfgName string, lcd *LeadingClockParams) map[string]*clockChain {
+	chain := newClockChain()
+	chain.leadingClockData = lcd
+	return map[string]*clockChain{chainKey(cfgName): chain}
+}
@@ -0,0 +1,166 @@
+package event
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
+)
+
+// SetIPCMismatchMetric sets the counter of IPC protocol mismatches with cloud-event-proxy
+func (e *EventHandler) SetIPCMismatchMetric(metric *prometheus.CounterVec) {
+	e.ipcMismatchMetric = metric
+}
+
+// IPCSession returns the protocol negotiated with cloud-event-proxy on the event socket, nil when
+// the sidecar did not announce one and only protocol version 1 is spoken
+func (e *EventHandler) IPCSession() *ipc.Session {
+	e.connMu.Lock()
+	defer e.connMu.Unlock()
+	return e.ipcSession
+}
+
+// ControlLine returns the control command as understood by the connected cloud-event-proxy
+func (e *EventHandler) ControlLine(command string) string {
+	return e.IPCSession().EncodeControl(command)
+}
+
+// readPeer handles the messages cloud-event-proxy sends on the event socket connection until it is
+// closed. Sidecars speaking protocol version 2 or later open the connection with a hello, which is
+// answered with ours; older sidecars send nothing.
+func (e *EventHandler) readPeer(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var msg ipc.Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			glog.Warningf("ignoring unreadable message from cloud-event-proxy: %v", err)
+			continue
+		}
+		hello, ok := msg.Values.(ipc.HelloValue)
+		if !ok {
+			glog.Warningf("ignoring %s message from cloud-event-proxy", msg.Type)
+			continue
+		}
+		e.handleHello(conn, hello)
+	}
+}
+
+// handleHello negotiates the protocol with the peer hello and answers with ours
+func (e *EventHandler) handleHello(conn net.Conn, peer ipc.HelloValue) {
+	session, err := ipc.Negotiate(peer)
+	if err != nil {
+		glog.Errorf("IPC handshake with cloud-event-proxy failed, speaking protocol version %d: %v", ipc.MinVersion, err)
+		e.countIPCMismatch("version")
+	} else {
+		glog.Infof("negotiated IPC protocol version %d with cloud-event-proxy", session.Version)
+		if session.Version < ipc.Version {
+			e.countIPCMismatch("version")
+		}
+		if unsupported := session.Unsupported(); len(unsupported) > 0 {
+			glog.Warningf("cloud-event-proxy does not support IPC message types %s, they will not be sent", strings.Join(unsupported, ", "))
+			e.countIPCMismatch("type")
+		}
+		if unknown := peer.UnknownTypes(); len(unknown) > 0 {
+			glog.Warningf("cloud-event-proxy supports IPC message types %s unknown to the daemon", strings.Join(unknown, ", "))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = ipc.Encode(&buf, []ipc.Message{ipc.NewHello()}); err == nil {
+		if err = conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout)); err != nil {
+			glog.Warningf("Failed to set write deadline: %v", err)
+		}
+		_, err = conn.Write(buf.Bytes())
+	}
+	if err != nil {
+		glog.Errorf("failed to send IPC hello to cloud-event-proxy: %v", err)
+	}
+
+	// the messages held back until the hello are written before the session lets new messages through,
+	// so that they keep their order
+	for {
+		e.connMu.Lock()
+		if e.conn != conn {
+			e.connMu.Unlock()
+			return
+		}
+		pending := e.pendingMessages
+		e.pendingMessages = nil
+		if len(pending) == 0 {
+			e.ipcSession = session
+			e.connMu.Unlock()
+			return
+		}
+		e.connMu.Unlock()
+		e.sendMessages(session, pending)
+	}
+}
+
+// pendingIPCMessages bounds the messages held back until cloud-event-proxy sends its hello
+const pendingIPCMessages = 256
+
+// writeMessages writes messages of a type to the event socket, stamped with the negotiated version,
+// when cloud-event-proxy supports the type. Messages of types introduced after protocol version 1 are
+// held back until cloud-event-proxy sent its hello, and so is the end of the replay when it would
+// overtake them. Returns false when the write failed.
+func (e *EventHandler) writeMessages(msgType string, msgs []ipc.Message) bool {
+	if !e.stdoutToSocket || len(msgs) == 0 {
+		return true
+	}
+	e.connMu.Lock()
+	session := e.ipcSession
+	if session == nil && (!session.Supports(msgType) || msgType == ipc.TypeStatusResponse && len(e.pendingMessages) > 0) {
+		e.pendingMessages = append(e.pendingMessages, msgs...)
+		if dropped := len(e.pendingMessages) - pendingIPCMessages; dropped > 0 {
+			glog.V(2).Infof("no hello from cloud-event-proxy, dropping %d held back IPC messages", dropped)
+			e.pendingMessages = e.pendingMessages[dropped:]
+		}
+		e.connMu.Unlock()
+		return true
+	}
+	e.connMu.Unlock()
+	return e.sendMessages(session, msgs)
+}
+
+// sendMessages writes the messages of the types supported by the session to the event socket, stamped
+// with its version. Returns false when the write failed.
+func (e *EventHandler) sendMessages(session *ipc.Session, msgs []ipc.Message) bool {
+	supported := make([]ipc.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		if session.Supports(msg.Type) {
+			msg.Version = session.MessageVersion()
+			supported = append(supported, msg)
+		}
+	}
+	if len(supported) == 0 {
+		return true
+	}
+	var buf bytes.Buffer
+	if err := ipc.Encode(&buf, supported); err != nil {
+		glog.Errorf("failed to encode %s messages: %v", supported[0].Type, err)
+		return true
+	}
+	return e.writeLogToSocket(buf.String())
+}
+
+func (e *EventHandler) countIPCMismatch(kind string) {
+	if e.ipcMismatchMetric != nil {
+		e.ipcMismatchMetric.With(prometheus.Labels{"kind": kind, "node": e.nodeName}).Inc()
+	}
+}
+
+// EmitReplayComplete tells cloud-event-proxy with a status_response that the state replay is complete,
+// the state it restored and that was not replayed is gone
+func (e *EventHandler) EmitReplayComplete() {
+	msg := ipc.Message{Version: ipc.Version, Type: ipc.TypeStatusResponse, Timestamp: time.Now().UTC().Format(time.RFC3339Nano)}
+	if !e.writeMessages(ipc.TypeStatusResponse, []ipc.Message{msg}) {
+		glog.Warning("Broken pipe detected while emitting the end of the replay.")
+	}
+}
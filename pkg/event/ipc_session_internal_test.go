@@ -0,0 +1,168 @@
+package event
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/cep"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/parser"
+	parserconstants "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/parser/constants"
+)
+
+// acceptWithHello accepts one connection, opens it with the given hello and returns the daemon reply
+func acceptWithHello(listener net.Listener, hello ipc.Message) <-chan ipc.Message {
+	replies := make(chan ipc.Message, 1)
+	go func() {
+		c, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if err = ipc.Encode(c, []ipc.Message{hello}); err != nil {
+			return
+		}
+		scanner := bufio.NewScanner(c)
+		for scanner.Scan() {
+			var msg ipc.Message
+			if json.Unmarshal(scanner.Bytes(), &msg) == nil && msg.Type == ipc.TypeHello {
+				replies <- msg
+			}
+		}
+	}()
+	return replies
+}
+
+func TestIPCHandshake(t *testing.T) {
+	newMetric := func() *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{Name: "ipc_mismatches_total"}, []string{"kind", "node"})
+	}
+
+	t.Run("sidecar without hello speaks version 1", func(t *testing.T) {
+		socketPath := shortSocketPath(t)
+		listener, err := net.Listen("unix", socketPath)
+		require.NoError(t, err)
+		defer listener.Close()
+		go acceptAndHold(listener)
+
+		e := newTestEventHandler(socketPath)
+		require.True(t, e.reconnectEventSocket())
+		defer e.setConn(nil)
+		assert.Nil(t, e.IPCSession())
+		assert.Equal(t, "CMD LIVE_START", e.ControlLine(ipc.CommandLiveStart))
+	})
+
+	t.Run("current sidecar", func(t *testing.T) {
+		socketPath := shortSocketPath(t)
+		listener, err := net.Listen("unix", socketPath)
+		require.NoError(t, err)
+		defer listener.Close()
+		replies := acceptWithHello(listener, ipc.NewHello())
+
+		e := newTestEventHandler(socketPath)
+		metric := newMetric()
+		e.SetIPCMismatchMetric(metric)
+		require.True(t, e.reconnectEventSocket())
+		defer e.setConn(nil)
+
+		select {
+		case reply := <-replies:
+			assert.Equal(t, ipc.NewHello(), reply)
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for the daemon hello")
+		}
+		require.Eventually(t, func() bool { return e.IPCSession() != nil }, 2*time.Second, 10*time.Millisecond)
+		assert.Equal(t, ipc.Version, e.IPCSession().Version)
+		assert.JSONEq(t, `{"version":2,"type":"control","values":{"command":"restart"}}`, e.ControlLine(ipc.CommandRestart))
+		assert.Zero(t, testutil.CollectAndCount(metric))
+
+		// a new connection starts without session until its sidecar announces one
+		e.setConn(nil)
+		assert.Nil(t, e.IPCSession())
+	})
+
+	t.Run("older sidecar", func(t *testing.T) {
+		socketPath := shortSocketPath(t)
+		listener, err := net.Listen("unix", socketPath)
+		require.NoError(t, err)
+		defer listener.Close()
+		hello := ipc.Message{Version: 1, Type: ipc.TypeHello,
+			Values: ipc.HelloValue{Versions: []int{1}, Types: []string{ipc.TypePTPState, ipc.TypeCacheClear}}}
+		replies := acceptWithHello(listener, hello)
+
+		e := newTestEventHandler(socketPath)
+		e.nodeName = "node1"
+		metric := newMetric()
+		e.SetIPCMismatchMetric(metric)
+		require.True(t, e.reconnectEventSocket())
+		defer e.setConn(nil)
+
+		select {
+		case <-replies:
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for the daemon hello")
+		}
+		require.Eventually(t, func() bool { return e.IPCSession() != nil }, 2*time.Second, 10*time.Millisecond)
+		session := e.IPCSession()
+		assert.Equal(t, 1, session.Version)
+		assert.False(t, session.Supports(ipc.TypeOffsetSummary))
+		assert.Equal(t, "CMD RESTART", e.ControlLine(ipc.CommandRestart))
+		assert.Equal(t, 1.0, testutil.ToFloat64(metric.WithLabelValues("version", "node1")))
+		assert.Equal(t, 1.0, testutil.ToFloat64(metric.WithLabelValues("type", "node1")))
+	})
+}
+
+func TestReplayComplete(t *testing.T) {
+	socketPath := shortSocketPath(t)
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	defer listener.Close()
+	cache := cep.NewEventCache("node1")
+	proxy := cep.NewCloudEventProxy(cache, nil)
+	go func() {
+		for {
+			c, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			proxy.Serve(c)
+			c.Close()
+		}
+	}()
+	health := httptest.NewServer(proxy.HealthHandler(0))
+	defer health.Close()
+	ready := func() bool {
+		resp, getErr := http.Get(health.URL + "/readyz")
+		require.NoError(t, getErr)
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}
+
+	e := newTestEventHandler(socketPath)
+	e.SetPortRole("ptp4l.0.config", "ens1f0", &parser.PTPEvent{PortID: 1, Role: parserconstants.PortRoleSlave, Raw: "ptp4l[1.0]: port 1: LISTENING to SLAVE"})
+	require.True(t, e.reconnectEventSocket())
+	defer e.setConn(nil)
+
+	// the replay of /emit-logs
+	e.EmitClockSyncLogs()
+	e.EmitPortRoleLogs()
+	assert.Never(t, ready, 100*time.Millisecond, 10*time.Millisecond, "replay not complete")
+	e.EmitReplayComplete()
+	require.Eventually(t, ready, 2*time.Second, 10*time.Millisecond)
+	_, ok := cache.Get(ipc.TypePortState)
+	assert.True(t, ok, "the replayed state precedes the end of the replay")
+
+	// a new event connection is replayed again
+	e.setConn(nil)
+	require.Eventually(t, func() bool { return !ready() }, 2*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,128 @@
+package event
+
+import (
+	"bytes"
+	"math"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
+)
+
+// DefaultOffsetSummaryInterval is the window of the offset summaries sent to cloud-event-proxy
+const DefaultOffsetSummaryInterval = 60 * time.Second
+
+type offsetSummaryKey struct {
+	cfgName string
+	source  string
+	iface   string
+}
+
+// offsetWindow accumulates the offsets of a source interface over a summary window
+type offsetWindow struct {
+	count    int
+	min, max float64
+	mean     float64
+	m2       float64 // sum of squared differences from the mean
+}
+
+func (w *offsetWindow) add(offset float64) {
+	if w.count == 0 || offset < w.min {
+		w.min = offset
+	}
+	if w.count == 0 || offset > w.max {
+		w.max = offset
+	}
+	w.count++
+	delta := offset - w.mean
+	w.mean += delta / float64(w.count)
+	w.m2 += delta * (offset - w.mean)
+}
+
+func (w *offsetWindow) summary(source string, window time.Duration) ipc.OffsetSummaryValue {
+	return ipc.OffsetSummaryValue{
+		Source:  source,
+		Window:  window.Seconds(),
+		Samples: w.count,
+		Min:     w.min,
+		Max:     w.max,
+		Mean:    w.mean,
+		StdDev:  math.Sqrt(w.m2 / float64(w.count)),
+	}
+}
+
+// ObserveOffset adds the offset of a profile source interface to the current summary window
+func (e *EventHandler) ObserveOffset(source, cfgName, iface string, offset float64) {
+	e.offsetSummaryMu.Lock()
+	defer e.offsetSummaryMu.Unlock()
+	if e.offsetWindows == nil {
+		e.offsetWindows = map[offsetSummaryKey]*offsetWindow{}
+		e.offsetWindowStart = time.Now()
+	}
+	key := offsetSummaryKey{cfgName: cfgName, source: source, iface: iface}
+	w, ok := e.offsetWindows[key]
+	if !ok {
+		w = &offsetWindow{}
+		e.offsetWindows[key] = w
+	}
+	w.add(offset)
+}
+
+// ClearOffsetSummaries drops the offsets of the current summary window
+func (e *EventHandler) ClearOffsetSummaries() {
+	e.offsetSummaryMu.Lock()
+	defer e.offsetSummaryMu.Unlock()
+	e.offsetWindows = nil
+}
+
+// offsetSummaries returns the summaries of the current window as IPC messages and starts a new window
+func (e *EventHandler) offsetSummaries(now time.Time) []ipc.Message {
+	e.offsetSummaryMu.Lock()
+	windows, start := e.offsetWindows, e.offsetWindowStart
+	e.offsetWindows = nil
+	e.offsetSummaryMu.Unlock()
+
+	msgs := make([]ipc.Message, 0, len(windows))
+	for key, w := range windows {
+		msgs = append(msgs, ipc.Message{
+			Version:   ipc.Version,
+			Type:      ipc.TypeOffsetSummary,
+			Timestamp: now.UTC().Format(time.RFC3339Nano),
+			Profile:   key.cfgName,
+			IFace:     key.iface,
+			Values:    w.summary(key.source, now.Sub(start)),
+		})
+	}
+	return msgs
+}
+
+// RunOffsetSummary sends the offset summaries to the event socket every period until stopCh is closed
+func (e *EventHandler) RunOffsetSummary(stopCh <-chan struct{}, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case now := <-ticker.C:
+			msgs := e.offsetSummaries(now)
+			if !e.stdoutToSocket || len(msgs) == 0 {
+				continue
+			}
+			session := e.IPCSession()
+			if !session.Supports(ipc.TypeOffsetSummary) {
+				continue
+			}
+			for i := range msgs {
+				msgs[i].Version = session.MessageVersion()
+			}
+			var buf bytes.Buffer
+			if err := ipc.Encode(&buf, msgs); err != nil {
+				glog.Errorf("failed to encode offset summaries: %v", err)
+				continue
+			}
+			e.writeLogToSocket(buf.String())
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package event
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
+)
+
+func TestOffsetSummaries(t *testing.T) {
+	e := &EventHandler{}
+	for _, offset := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		e.ObserveOffset(PTP4lProcessName, "ptp4l.0.config", "ens1f0", offset)
+	}
+	e.ObserveOffset(string(GNSS), "ts2phc.1.config", "ens2f0", -3)
+
+	now := time.Now().Add(30 * time.Second)
+	msgs := e.offsetSummaries(now)
+	require.Len(t, msgs, 2)
+	byProfile := map[string]ipc.Message{}
+	for _, msg := range msgs {
+		assert.Equal(t, ipc.TypeOffsetSummary, msg.Type)
+		assert.Equal(t, ipc.Version, msg.Version)
+		byProfile[msg.Profile] = msg
+	}
+
+	ptp4l := byProfile["ptp4l.0.config"].Values.(ipc.OffsetSummaryValue)
+	assert.Equal(t, "ens1f0", byProfile["ptp4l.0.config"].IFace)
+	assert.Equal(t, PTP4lProcessName, ptp4l.Source)
+	assert.Equal(t, 8, ptp4l.Samples)
+	assert.Equal(t, 2.0, ptp4l.Min)
+	assert.Equal(t, 9.0, ptp4l.Max)
+	assert.Equal(t, 5.0, ptp4l.Mean)
+	assert.InDelta(t, 2.0, ptp4l.StdDev, 1e-9)
+	assert.InDelta(t, 30, ptp4l.Window, 1)
+
+	gnss := byProfile["ts2phc.1.config"].Values.(ipc.OffsetSummaryValue)
+	assert.Equal(t, ipc.OffsetSummaryValue{Source: string(GNSS), Window: gnss.Window, Samples: 1, Min: -3, Max: -3, Mean: -3}, gnss)
+
+	// each summary starts a new window
+	assert.Empty(t, e.offsetSummaries(now))
+	e.ObserveOffset(PTP4lProcessName, "ptp4l.0.config", "ens1f0", 1)
+	e.ClearOffsetSummaries()
+	assert.Empty(t, e.offsetSummaries(now))
+}
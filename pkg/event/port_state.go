@@ -0,0 +1,30 @@
+package event
+
+import (
+	"time"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
+	parserconstants "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/parser/constants"
+)
+
+// portStateMessage returns the IPC message announcing the role of a profile port
+func portStateMessage(cfgName, iface string, role parserconstants.PTPPortRole, now time.Time) ipc.Message {
+	return ipc.Message{
+		Version:   ipc.Version,
+		Type:      ipc.TypePortState,
+		Timestamp: now.UTC().Format(time.RFC3339Nano),
+		Profile:   cfgName,
+		IFace:     iface,
+		Values:    ipc.PortStateValue{Role: role.String()},
+	}
+}
+
+// EmitPortState sends the role of a profile port to cloud-event-proxy, when it supports port state messages
+func (e *EventHandler) EmitPortState(cfgName, iface string, role parserconstants.PTPPortRole) {
+	e.writePortStates([]ipc.Message{portStateMessage(cfgName, iface, role, time.Now())})
+}
+
+// writePortStates writes the port state messages to the event socket, stamped with the negotiated version
+func (e *EventHandler) writePortStates(msgs []ipc.Message) bool {
+	return e.writeMessages(ipc.TypePortState, msgs)
+}
@@ -0,0 +1,156 @@
+package event
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/parser"
+	parserconstants "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/parser/constants"
+)
+
+// acceptMessages accepts one connection, opens it with the given hello and returns the messages
+// of the type received
+func acceptMessages(listener net.Listener, hello ipc.Message, msgType string) <-chan ipc.Message {
+	states := make(chan ipc.Message, 10)
+	go func() {
+		c, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if err = ipc.Encode(c, []ipc.Message{hello}); err != nil {
+			return
+		}
+		scanner := bufio.NewScanner(c)
+		for scanner.Scan() {
+			var msg ipc.Message
+			if json.Unmarshal(scanner.Bytes(), &msg) == nil && msg.Type == msgType {
+				states <- msg
+			}
+		}
+	}()
+	return states
+}
+
+func TestEmitPortState(t *testing.T) {
+	socketPath := shortSocketPath(t)
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	defer listener.Close()
+	states := acceptMessages(listener, ipc.NewHello(), ipc.TypePortState)
+
+	e := newTestEventHandler(socketPath)
+	require.True(t, e.reconnectEventSocket())
+	defer e.setConn(nil)
+	require.Eventually(t, func() bool { return e.IPCSession() != nil }, 2*time.Second, 10*time.Millisecond)
+
+	e.EmitPortState("ptp4l.0.config", "ens1f0", parserconstants.PortRoleFaulty)
+	select {
+	case msg := <-states:
+		assert.Equal(t, "ptp4l.0.config", msg.Profile)
+		assert.Equal(t, "ens1f0", msg.IFace)
+		assert.Equal(t, ipc.PortStateValue{Role: "FAULTY"}, msg.Values)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the port state")
+	}
+
+	// the port states are replayed with the port role logs
+	e.SetPortRole("ptp4l.0.config", "ens2f0", &parser.PTPEvent{PortID: 2, Role: parserconstants.PortRoleSlave, Raw: "ptp4l[1.0]: port 2: LISTENING to SLAVE"})
+	e.EmitPortRoleLogs()
+	select {
+	case msg := <-states:
+		assert.Equal(t, "ens2f0", msg.IFace)
+		assert.Equal(t, ipc.PortStateValue{Role: "SLAVE"}, msg.Values)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the replayed port state")
+	}
+}
+
+func TestEmitPortState_LegacySidecar(t *testing.T) {
+	socketPath := shortSocketPath(t)
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	defer listener.Close()
+	received := make(chan string, 10)
+	go acceptAndRead(listener, received)
+
+	e := newTestEventHandler(socketPath)
+	require.True(t, e.reconnectEventSocket())
+	defer e.setConn(nil)
+
+	e.EmitPortState("ptp4l.0.config", "ens1f0", parserconstants.PortRoleFaulty)
+	select {
+	case data := <-received:
+		t.Fatalf("port state sent to a sidecar without hello: %q", data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEmitPortState_BeforeHello(t *testing.T) {
+	socketPath := shortSocketPath(t)
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	defer listener.Close()
+	sendHello := make(chan struct{})
+	states := make(chan ipc.Message, 10)
+	go func() {
+		c, acceptErr := listener.Accept()
+		if acceptErr != nil {
+			return
+		}
+		defer c.Close()
+		<-sendHello
+		if ipc.Encode(c, []ipc.Message{ipc.NewHello()}) != nil {
+			return
+		}
+		scanner := bufio.NewScanner(c)
+		for scanner.Scan() {
+			var msg ipc.Message
+			if json.Unmarshal(scanner.Bytes(), &msg) == nil && (msg.Type == ipc.TypePortState || msg.Type == ipc.TypeStatusResponse) {
+				states <- msg
+			}
+		}
+	}()
+
+	e := newTestEventHandler(socketPath)
+	require.True(t, e.reconnectEventSocket())
+	defer e.setConn(nil)
+
+	// the port states of the start-up are sent once the sidecar said hello, in order, and the end of
+	// the replay does not overtake them
+	e.EmitPortState("ptp4l.0.config", "ens1f0", parserconstants.PortRoleListening)
+	e.EmitPortState("ptp4l.0.config", "ens1f0", parserconstants.PortRoleSlave)
+	e.EmitReplayComplete()
+	close(sendHello)
+	for _, role := range []string{"LISTENING", "SLAVE"} {
+		select {
+		case msg := <-states:
+			assert.Equal(t, ipc.PortStateValue{Role: role}, msg.Values)
+			assert.Equal(t, ipc.Version, msg.Version)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for the held back %s port state", role)
+		}
+	}
+	select {
+	case msg := <-states:
+		assert.Equal(t, ipc.TypeStatusResponse, msg.Type)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the end of the replay")
+	}
+	require.Eventually(t, func() bool { return e.IPCSession() != nil }, 2*time.Second, 10*time.Millisecond)
+
+	e.EmitPortState("ptp4l.0.config", "ens1f0", parserconstants.PortRoleFaulty)
+	select {
+	case msg := <-states:
+		assert.Equal(t, ipc.PortStateValue{Role: "FAULTY"}, msg.Values)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the port state")
+	}
+}
@@ -94,11 +94,7 @@ func (d *Data) AddEvent(event Event) {
 		sourceLost = data.SourceLost
 		offset = data.Offset
 		hasOffset = true
-		if data.GPSStatus >= 3 && !data.SourceLost {
-			state = PTP_LOCKED
-		} else {
-			state = PTP_FREERUN
-		}
+		state = data.State()
 	case *PTPData:
 		state = data.State
 		sourceLost = data.SourceLost
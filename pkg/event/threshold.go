@@ -0,0 +1,172 @@
+package event
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// OffsetThresholdSettingPrefix is the PtpSettings key prefix of per source offset thresholds.
+// Keys are offsetThreshold.<source> or offsetThreshold.<source>.<iface>, values a comma separated
+// list of max=<ns>, min=<ns>, enterMax=<ns> and enterMin=<ns>. Omitted fields are inherited from
+// the source threshold, then from the profile PtpClockThreshold; an omitted enter bound keeps the
+// inherited distance to its exit bound.
+const OffsetThresholdSettingPrefix = "offsetThreshold."
+
+// thresholdSources are the sources that accept their own offset threshold
+var thresholdSources = map[EventSource]bool{GNSS: true, DPLL: true, TS2PHC: true, PTP4l: true, PHC2SYS: true}
+
+// OffsetThreshold is the offset range of a source with a hysteresis band.
+// An in range offset leaves the range when it crosses Min or Max, and must return
+// within EnterMin and EnterMax before it is in range again.
+type OffsetThreshold struct {
+	Max      int64 `json:"max"`
+	Min      int64 `json:"min"`
+	EnterMax int64 `json:"enterMax"`
+	EnterMin int64 `json:"enterMin"`
+}
+
+// NewOffsetThreshold returns a threshold without hysteresis
+func NewOffsetThreshold(minOffset, maxOffset int64) OffsetThreshold {
+	return OffsetThreshold{Max: maxOffset, Min: minOffset, EnterMax: maxOffset, EnterMin: minOffset}
+}
+
+// InRange returns whether the offset is in range given the result of the previous check
+func (t OffsetThreshold) InRange(offset int64, wasInRange bool) bool {
+	if wasInRange {
+		return offset <= t.Max && offset >= t.Min
+	}
+	return offset <= t.EnterMax && offset >= t.EnterMin
+}
+
+// InOpenRange is InRange with the bounds out of range when the threshold has no hysteresis band,
+// as ptp4l, phc2sys and ts2phc offsets have always been checked
+func (t OffsetThreshold) InOpenRange(offset int64, wasInRange bool) bool {
+	if t.EnterMax == t.Max && t.EnterMin == t.Min {
+		return offset < t.Max && offset > t.Min
+	}
+	return t.InRange(offset, wasInRange)
+}
+
+// Limit returns the largest absolute offset that keeps an in range source in range
+func (t OffsetThreshold) Limit() int64 {
+	if -t.Min > t.Max {
+		return -t.Min
+	}
+	return t.Max
+}
+
+// OffsetThresholds holds the offset thresholds of a profile by source and interface
+type OffsetThresholds struct {
+	Default    OffsetThreshold
+	Sources    map[EventSource]OffsetThreshold
+	Interfaces map[EventSource]map[string]OffsetThreshold
+}
+
+// Get returns the threshold that applies to the source interface
+func (t *OffsetThresholds) Get(source EventSource, iface string) OffsetThreshold {
+	if th, ok := t.Override(source, iface); ok {
+		return th
+	}
+	return t.Default
+}
+
+// Override returns the threshold configured for the source interface or for the source,
+// false when the profile default applies
+func (t *OffsetThresholds) Override(source EventSource, iface string) (OffsetThreshold, bool) {
+	if t == nil {
+		return OffsetThreshold{}, false
+	}
+	if th, ok := t.Interfaces[source][iface]; ok {
+		return th, true
+	}
+	th, ok := t.Sources[source]
+	return th, ok
+}
+
+// ParseOffsetThresholds reads per source and per interface offset thresholds from PtpSettings
+func ParseOffsetThresholds(defaults OffsetThreshold, settings map[string]string) (*OffsetThresholds, error) {
+	t := &OffsetThresholds{
+		Default:    defaults,
+		Sources:    map[EventSource]OffsetThreshold{},
+		Interfaces: map[EventSource]map[string]OffsetThreshold{},
+	}
+	ifaceSettings := map[EventSource]map[string]string{}
+	// source thresholds first, interface thresholds inherit from them
+	for key, value := range settings {
+		name, found := strings.CutPrefix(key, OffsetThresholdSettingPrefix)
+		if !found {
+			continue
+		}
+		sourceName, iface, _ := strings.Cut(name, ".")
+		source := EventSource(sourceName)
+		if !thresholdSources[source] {
+			return nil, fmt.Errorf("%s: unsupported source %q", key, sourceName)
+		}
+		if iface != "" {
+			if ifaceSettings[source] == nil {
+				ifaceSettings[source] = map[string]string{}
+			}
+			ifaceSettings[source][iface] = value
+			continue
+		}
+		th, err := parseOffsetThreshold(defaults, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		t.Sources[source] = th
+	}
+	for source, ifaces := range ifaceSettings {
+		t.Interfaces[source] = map[string]OffsetThreshold{}
+		for iface, value := range ifaces {
+			th, err := parseOffsetThreshold(t.Get(source, ""), value)
+			if err != nil {
+				return nil, fmt.Errorf("%s%s.%s: %w", OffsetThresholdSettingPrefix, source, iface, err)
+			}
+			t.Interfaces[source][iface] = th
+		}
+	}
+	return t, nil
+}
+
+func parseOffsetThreshold(parent OffsetThreshold, value string) (OffsetThreshold, error) {
+	th := parent
+	var enterMaxSet, enterMinSet bool
+	for _, field := range strings.Split(value, ",") {
+		name, sValue, found := strings.Cut(strings.TrimSpace(field), "=")
+		if !found {
+			return th, fmt.Errorf("invalid field %q, expected <name>=<ns>", field)
+		}
+		v, err := strconv.ParseInt(strings.TrimSpace(sValue), 10, 64)
+		if err != nil {
+			return th, fmt.Errorf("invalid value of %s: %w", name, err)
+		}
+		switch strings.TrimSpace(name) {
+		case "max":
+			th.Max = v
+		case "min":
+			th.Min = v
+		case "enterMax":
+			th.EnterMax = v
+			enterMaxSet = true
+		case "enterMin":
+			th.EnterMin = v
+			enterMinSet = true
+		default:
+			return th, fmt.Errorf("unknown field %q", name)
+		}
+	}
+	if th.Min > th.Max {
+		return th, fmt.Errorf("min %d is greater than max %d", th.Min, th.Max)
+	}
+	if !enterMaxSet {
+		th.EnterMax = th.Max - (parent.Max - parent.EnterMax)
+	}
+	if !enterMinSet {
+		th.EnterMin = th.Min + (parent.EnterMin - parent.Min)
+	}
+	if th.EnterMin < th.Min || th.EnterMax > th.Max || th.EnterMin > th.EnterMax {
+		return th, fmt.Errorf("enter band [%d, %d] must lie within [%d, %d]", th.EnterMin, th.EnterMax, th.Min, th.Max)
+	}
+	return th, nil
+}
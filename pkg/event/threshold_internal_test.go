@@ -0,0 +1,131 @@
+package event
+
+import (
+	"testing"
+	"time"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOffsetThresholds(t *testing.T) {
+	defaults := NewOffsetThreshold(-100, 100)
+	thresholds, err := ParseOffsetThresholds(defaults, map[string]string{
+		"clockType":                   "T-GM",
+		"offsetThreshold.dpll":        "max=1500,min=-1500,enterMax=1000,enterMin=-1000",
+		"offsetThreshold.dpll.ens2":   "max=2000",
+		"offsetThreshold.gnss":        "max=50, min=-50",
+		"offsetThreshold.ts2phc.ens1": "enterMax=80",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, OffsetThreshold{Max: 1500, Min: -1500, EnterMax: 1000, EnterMin: -1000}, thresholds.Get(DPLL, "ens1"))
+	// the interface threshold keeps the enter margins of the source threshold
+	assert.Equal(t, OffsetThreshold{Max: 2000, Min: -1500, EnterMax: 1500, EnterMin: -1000}, thresholds.Get(DPLL, "ens2"))
+	assert.Equal(t, NewOffsetThreshold(-50, 50), thresholds.Get(GNSS, "ens1"))
+	assert.Equal(t, OffsetThreshold{Max: 100, Min: -100, EnterMax: 80, EnterMin: -100}, thresholds.Get(TS2PHC, "ens1"))
+	assert.Equal(t, defaults, thresholds.Get(TS2PHC, "ens2"))
+	assert.Equal(t, defaults, thresholds.Get(PTP4l, ""))
+	_, ok := thresholds.Override(PTP4l, "")
+	assert.False(t, ok)
+
+	for _, settings := range []map[string]string{
+		{"offsetThreshold.synce4l": "max=10"},
+		{"offsetThreshold.dpll": "max=10,min=20"},
+		{"offsetThreshold.dpll": "enterMax=200"},
+		{"offsetThreshold.dpll": "max"},
+		{"offsetThreshold.dpll": "limit=5"},
+	} {
+		_, err = ParseOffsetThresholds(defaults, settings)
+		assert.Error(t, err, settings)
+	}
+}
+
+func TestOffsetThreshold_Hysteresis(t *testing.T) {
+	th := OffsetThreshold{Max: 100, Min: -100, EnterMax: 50, EnterMin: -50}
+	inRange := false
+	for _, step := range []struct {
+		offset int64
+		want   bool
+	}{
+		{80, false}, // not yet within the enter band
+		{40, true},
+		{90, true}, // within the exit band
+		{-101, false},
+		{-60, false},
+		{-50, true},
+	} {
+		inRange = th.InRange(step.offset, inRange)
+		assert.Equal(t, step.want, inRange, "offset %d", step.offset)
+	}
+	assert.Equal(t, int64(100), th.Limit())
+}
+
+func TestOffsetThreshold_Bounds(t *testing.T) {
+	th := NewOffsetThreshold(-100, 100)
+	for _, wasInRange := range []bool{true, false} {
+		assert.True(t, th.InRange(100, wasInRange))
+		assert.True(t, th.InRange(-100, wasInRange))
+		assert.False(t, th.InRange(101, wasInRange))
+		assert.False(t, th.InRange(-101, wasInRange))
+		// without hysteresis band the bounds are out of the open range
+		assert.False(t, th.InOpenRange(100, wasInRange))
+		assert.False(t, th.InOpenRange(-100, wasInRange))
+		assert.True(t, th.InOpenRange(99, wasInRange))
+		assert.True(t, th.InOpenRange(-99, wasInRange))
+	}
+
+	th = OffsetThreshold{Max: 100, Min: -100, EnterMax: 50, EnterMin: -50}
+	assert.True(t, th.InOpenRange(100, true), "the bounds of a configured band are in range")
+	assert.True(t, th.InOpenRange(-100, true))
+	assert.True(t, th.InOpenRange(50, false))
+	assert.False(t, th.InOpenRange(51, false))
+}
+
+func TestFreeRunCondition_SourceThreshold(t *testing.T) {
+	const cfg = "ptp4l.0.config"
+	const iface = "ens1f0"
+	e := &EventHandler{
+		data:         map[string][]*Data{},
+		clkSyncState: map[string]*clockSyncState{cfg: {leadingIFace: iface}},
+		chains: testChains(cfg, &LeadingClockParams{
+			leadingInterface:         iface,
+			inSyncConditionThreshold: 100,
+			inSyncConditionTimes:     1,
+			toFreeRunThreshold:       500,
+			upstreamParentDataSet:    &protocol.ParentDataSet{},
+			upstreamTimeProperties:   &protocol.TimePropertiesDS{},
+			downstreamParentDataSet:  &protocol.ParentDataSet{},
+			downstreamTimeProperties: &protocol.TimePropertiesDS{},
+		}),
+	}
+	thresholds, err := ParseOffsetThresholds(NewOffsetThreshold(-500, 500), map[string]string{
+		"offsetThreshold.dpll": "max=1500,min=-1500,enterMax=20,enterMin=-20",
+	})
+	assert.NoError(t, err)
+	e.SetOffsetThresholds(cfg, thresholds)
+
+	addDPLL := func(offset int64) {
+		for i := 0; i < 2; i++ {
+			e.addEvent(Event{Source: DPLL, IFace: iface, CfgName: cfg, ClockType: BC, Time: time.Now().UnixMilli(),
+				Data: &PTPData{State: PTP_LOCKED, Values: map[ValueType]interface{}{OFFSET: offset}}})
+		}
+	}
+
+	// beyond the leading clock free-run threshold but within the DPLL exit band
+	addDPLL(1000)
+	assert.False(t, e.freeRunCondition(cfg))
+	addDPLL(1600)
+	assert.True(t, e.freeRunCondition(cfg))
+
+	// within the in-sync threshold but not yet back in the DPLL enter band
+	addDPLL(50)
+	fillDataWindows(e, cfg, 50)
+	assert.False(t, e.inSyncCondition(cfg))
+	addDPLL(10)
+	fillDataWindows(e, cfg, 10)
+	assert.True(t, e.inSyncCondition(cfg))
+
+	e.ClearOffsetThresholds()
+	addDPLL(1000)
+	assert.True(t, e.freeRunCondition(cfg))
+}
@@ -0,0 +1,20 @@
+package event
+
+import (
+	"time"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
+)
+
+// EmitTimeErrorMask sends a change of the ITU-T mask verdict of a profile time error stream to
+// cloud-event-proxy, when it supports time error mask messages
+func (e *EventHandler) EmitTimeErrorMask(cfgName, iface string, value ipc.TimeErrorMaskValue) {
+	e.writeMessages(ipc.TypeTimeErrorMask, []ipc.Message{{
+		Version:   ipc.Version,
+		Type:      ipc.TypeTimeErrorMask,
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Profile:   cfgName,
+		IFace:     iface,
+		Values:    value,
+	}})
+}
@@ -0,0 +1,38 @@
+package event
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ipc"
+)
+
+func TestEmitTimeErrorMask(t *testing.T) {
+	socketPath := shortSocketPath(t)
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	defer listener.Close()
+	masks := acceptMessages(listener, ipc.NewHello(), ipc.TypeTimeErrorMask)
+
+	e := newTestEventHandler(socketPath)
+	require.True(t, e.reconnectEventSocket())
+	defer e.setConn(nil)
+	require.Eventually(t, func() bool { return e.IPCSession() != nil }, 2*time.Second, 10*time.Millisecond)
+
+	value := ipc.TimeErrorMaskValue{Source: "ts2phc", Statistic: "mtie", Mask: "G.8272-PRTC-A", Verdict: ipc.MaskFail,
+		Failed: []ipc.TimeErrorResult{{Tau: 10, Value: 120, Limit: 100}}}
+	e.EmitTimeErrorMask("ts2phc.0.config", "ens1f0", value)
+	select {
+	case msg := <-masks:
+		assert.Equal(t, ipc.Version, msg.Version)
+		assert.Equal(t, "ts2phc.0.config", msg.Profile)
+		assert.Equal(t, "ens1f0", msg.IFace)
+		assert.Equal(t, value, msg.Values)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the time error mask event")
+	}
+}
@@ -0,0 +1,295 @@
+package hardwareconfig
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	dpll "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+	ptpv2alpha1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v2alpha1"
+	"sigs.k8s.io/yaml"
+)
+
+// DPLLModeAnnotation is the HardwareConfig annotation holding a YAML (or JSON) list of DPLLModeDesiredState,
+// applied with the hardware config's PTP profile, e.g.
+//
+//	ptp.openshift.io/dpll-mode: |
+//	  - subsystem: leader
+//	    pps:
+//	      mode: manual
+//	      reference: SMA1
+//
+// Unknown fields are rejected. The DPLLs the annotation sets to manual mode are set back to automatic
+// mode when the annotation no longer sets them, or their hardware config is removed.
+const DPLLModeAnnotation = "ptp.openshift.io/dpll-mode"
+
+// DPLL modes of a DPLLDeviceModeState
+const (
+	DPLLModeAutomatic = "automatic"
+	DPLLModeManual    = "manual"
+)
+
+// DPLLModeDesiredState is the desired mode of the EEC and PPS DPLLs of a clock chain subsystem,
+// e.g. to select the reference of a board manually during maintenance.
+type DPLLModeDesiredState struct {
+	Subsystem string               `json:"subsystem" yaml:"subsystem"`
+	EEC       *DPLLDeviceModeState `json:"eec,omitempty" yaml:"eec,omitempty"`
+	PPS       *DPLLDeviceModeState `json:"pps,omitempty" yaml:"pps,omitempty"`
+}
+
+// DPLLDeviceModeState is the desired mode of a DPLL device. Reference is the board label
+// of the input pin to select, only allowed in manual mode.
+type DPLLDeviceModeState struct {
+	Mode      string `json:"mode" yaml:"mode"`
+	Reference string `json:"reference,omitempty" yaml:"reference,omitempty"`
+}
+
+// DPLLModeCommand sets the mode of the DPLL device of a clock ID and type, and selects
+// its reference when ReferencePinID is set
+type DPLLModeCommand struct {
+	ClockID        uint64
+	Type           uint32
+	Mode           uint32
+	ReferencePinID *uint32
+	Description    string
+}
+
+// GetDPLLModeUint32 converts a DPLL mode name to its netlink value
+func GetDPLLModeUint32(mode string) (uint32, error) {
+	switch strings.ToLower(mode) {
+	case DPLLModeAutomatic:
+		return dpll.DpllModeAutomatic, nil
+	case DPLLModeManual:
+		return dpll.DpllModeManual, nil
+	}
+	return 0, fmt.Errorf("invalid DPLL mode: %s", mode)
+}
+
+// ApplyDPLLModeCommands applies a batch of DPLL mode commands issued by source. The mode changes
+// and reference selections are recorded in the audit log with the state before and after them.
+// A command fails when the device doesn't report the requested mode or reference afterwards.
+func ApplyDPLLModeCommands(source PinCommandSource, commands []DPLLModeCommand) error {
+	conn, err := dpllDialer()
+	if err != nil {
+		return fmt.Errorf("failed to dial DPLL: %v", err)
+	}
+	//nolint:errcheck
+	defer conn.Close()
+	devices, err := conn.DumpDeviceGet()
+	if err != nil {
+		return fmt.Errorf("failed to dump DPLL devices: %w", err)
+	}
+	for _, command := range commands {
+		var device *dpll.DoDeviceGetReply
+		for _, d := range devices {
+			if d.ClockID == command.ClockID && d.Type == command.Type {
+				device = d
+				break
+			}
+		}
+		if device == nil {
+			return fmt.Errorf("%s: no %s DPLL device with clock ID %#x", command.Description, dpll.GetDpllType(command.Type), command.ClockID)
+		}
+		if device.Mode != command.Mode {
+			glog.Infof("%s: setting DPLL %d mode %s -> %s", command.Description, device.ID, dpll.GetMode(device.Mode), dpll.GetMode(command.Mode))
+			if err = setDeviceModeAudited(conn, source, device, command.Mode); err != nil {
+				return fmt.Errorf("%s: %w", command.Description, err)
+			}
+		}
+		if command.ReferencePinID != nil {
+			glog.Infof("%s: selecting pin %d as DPLL %d reference", command.Description, *command.ReferencePinID, device.ID)
+			if err = selectInputAudited(conn, source, device.ID, *command.ReferencePinID); err != nil {
+				return fmt.Errorf("%s: %w", command.Description, err)
+			}
+		}
+	}
+	return nil
+}
+
+// setDeviceModeAudited sets the mode of a DPLL device with dpll.Conn.SetDeviceMode, and records
+// it in the audit log
+func setDeviceModeAudited(conn *dpll.Conn, source PinCommandSource, device *dpll.DoDeviceGetReply, mode uint32) error {
+	deviceID := device.ID
+	record := PinAuditRecord{
+		Time:      time.Now(),
+		Source:    source,
+		DeviceID:  &deviceID,
+		ClockID:   device.ClockID,
+		Label:     fmt.Sprintf("%s-dpll", dpll.GetDpllType(device.Type)),
+		Before:    &PinAuditState{Mode: dpll.GetMode(device.Mode)},
+		Requested: PinAuditState{Mode: dpll.GetMode(mode)},
+	}
+	defer func() { pinAuditLog.Add(record) }()
+
+	if err := conn.SetDeviceMode(device.ID, mode); err != nil {
+		record.Error = err.Error()
+		return err
+	}
+	// SetDeviceMode read the requested mode back
+	record.After = &PinAuditState{Mode: dpll.GetMode(mode)}
+	record.Confirmed = true
+	return nil
+}
+
+// selectInputAudited selects an input pin as the reference of a DPLL device in manual mode with
+// dpll.Conn.SelectInput, and records it in the audit log with the pin state before and after it
+func selectInputAudited(conn *dpll.Conn, source PinCommandSource, deviceID, pinID uint32) error {
+	state := uint32(dpll.PinStateConnected)
+	command := dpll.PinParentDeviceCtl{
+		ID:           pinID,
+		PinParentCtl: []dpll.PinControl{{PinParentID: deviceID, State: &state}},
+	}
+	before, err := conn.DoPinGet(dpll.DoPinGetRequest{ID: pinID})
+	if err != nil {
+		glog.Warningf("failed to get pin %d before selecting it: %v", pinID, err)
+	}
+	record := newPinAuditRecord(source, command, before)
+	defer func() { pinAuditLog.Add(record) }()
+
+	if err = conn.SelectInput(deviceID, pinID); err != nil {
+		record.Error = err.Error()
+		return err
+	}
+	after, err := conn.DoPinGet(dpll.DoPinGetRequest{ID: pinID})
+	if err != nil {
+		// SelectInput verified the selection, only the audited state is missing
+		record.Error = fmt.Sprintf("failed to read the pin back: %v", err)
+		return nil
+	}
+	completePinAuditRecord(&record, command, after)
+	return nil
+}
+
+// resolveDPLLModeCommands resolves the DPLL mode commands of a desired state against the clock chain
+func (hcm *HardwareConfigManager) resolveDPLLModeCommands(state DPLLModeDesiredState, clockChain *ptpv2alpha1.ClockChain) ([]DPLLModeCommand, error) {
+	if state.Subsystem == "" {
+		return nil, fmt.Errorf("subsystem not specified in DPLL mode desired state")
+	}
+	clockID, err := hcm.resolveSubsystemClockID(state.Subsystem, clockChain)
+	if err != nil {
+		return nil, err
+	}
+
+	commands := make([]DPLLModeCommand, 0, 2)
+	for _, device := range []struct {
+		name  string
+		typ   uint32
+		state *DPLLDeviceModeState
+	}{
+		{"EEC", dpll.DpllTypeEEC, state.EEC},
+		{"PPS", dpll.DpllTypePPS, state.PPS},
+	} {
+		if device.state == nil {
+			continue
+		}
+		mode, modeErr := GetDPLLModeUint32(device.state.Mode)
+		if modeErr != nil {
+			return nil, fmt.Errorf("invalid %s mode: %w", device.name, modeErr)
+		}
+		command := DPLLModeCommand{
+			ClockID:     clockID,
+			Type:        device.typ,
+			Mode:        mode,
+			Description: fmt.Sprintf("subsystem %s %s DPLL", state.Subsystem, device.name),
+		}
+		if device.state.Reference != "" {
+			if mode != dpll.DpllModeManual {
+				return nil, fmt.Errorf("%s reference %s requires manual mode", device.name, device.state.Reference)
+			}
+			pin, found := hcm.pinCache.GetPin(clockID, device.state.Reference)
+			if !found {
+				return nil, fmt.Errorf("DPLL pin not found in cache (subsystem=%s clock=%#x label=%s)", state.Subsystem, clockID, device.state.Reference)
+			}
+			command.ReferencePinID = &pin.ID
+		}
+		commands = append(commands, command)
+	}
+	return commands, nil
+}
+
+// dpllModeDesiredStates returns the DPLL mode desired states of the DPLLModeAnnotation of a hardware config
+func dpllModeDesiredStates(hwConfig ptpv2alpha1.HardwareConfig) ([]DPLLModeDesiredState, error) {
+	value, ok := hwConfig.Annotations[DPLLModeAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var states []DPLLModeDesiredState
+	if err := yaml.UnmarshalStrict([]byte(value), &states); err != nil {
+		return nil, fmt.Errorf("failed to parse %s annotation: %w", DPLLModeAnnotation, err)
+	}
+	for _, state := range states {
+		if state.EEC == nil && state.PPS == nil {
+			return nil, fmt.Errorf("%s annotation: no eec or pps mode for subsystem %s", DPLLModeAnnotation, state.Subsystem)
+		}
+	}
+	return states, nil
+}
+
+// dpllModeReverts returns the commands setting back to automatic mode the DPLLs that previous commands
+// set to manual mode and the current commands no longer set
+func dpllModeReverts(previous, current []DPLLModeCommand) []DPLLModeCommand {
+	var reverts []DPLLModeCommand
+	for _, prev := range previous {
+		if prev.Mode != dpll.DpllModeManual {
+			continue
+		}
+		if slices.ContainsFunc(current, func(c DPLLModeCommand) bool {
+			return c.ClockID == prev.ClockID && c.Type == prev.Type
+		}) {
+			continue
+		}
+		reverts = append(reverts, DPLLModeCommand{
+			ClockID:     prev.ClockID,
+			Type:        prev.Type,
+			Mode:        dpll.DpllModeAutomatic,
+			Description: prev.Description + " (no longer annotated)",
+		})
+	}
+	return reverts
+}
+
+// resolveHardwareConfigDPLLModes resolves the DPLL mode commands of the desired states annotated on a hardware config
+func (hcm *HardwareConfigManager) resolveHardwareConfigDPLLModes(hwConfig ptpv2alpha1.HardwareConfig) ([]DPLLModeCommand, error) {
+	states, err := dpllModeDesiredStates(hwConfig)
+	if err != nil || len(states) == 0 {
+		return nil, err
+	}
+	clockChain := hwConfig.Spec.Profile.ClockChain
+	if clockChain == nil {
+		return nil, fmt.Errorf("%s annotation requires a clock chain", DPLLModeAnnotation)
+	}
+	var commands []DPLLModeCommand
+	for _, state := range states {
+		found := false
+		for _, subsystem := range clockChain.Structure {
+			if subsystem.Name == state.Subsystem {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("subsystem %s not found in the clock chain structure", state.Subsystem)
+		}
+		stateCommands, resolveErr := hcm.resolveDPLLModeCommands(state, clockChain)
+		if resolveErr != nil {
+			return nil, resolveErr
+		}
+		commands = append(commands, stateCommands...)
+	}
+	return commands, nil
+}
+
+// applyDPLLModes applies the resolved DPLL mode commands of a hardware config
+func (hcm *HardwareConfigManager) applyDPLLModes(enrichedConfig *enrichedHardwareConfig, profileName string) error {
+	if len(enrichedConfig.dpllModeCommands) == 0 {
+		return nil
+	}
+	glog.Infof("Applying %d DPLL mode commands for hardware profile %s", len(enrichedConfig.dpllModeCommands), profileName)
+	source := PinCommandSource{Component: PinSourceHardwareConfig, Profile: profileName, Condition: "dpll-mode"}
+	if err := hcm.modeApplier(source, enrichedConfig.dpllModeCommands); err != nil {
+		return fmt.Errorf("failed to apply DPLL modes for hardware profile %s: %w", profileName, err)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package hardwareconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dpll "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink/dplltest"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	ptpv2alpha1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v2alpha1"
+)
+
+func TestApplyDPLLModeAnnotation(t *testing.T) {
+	const clockID uint64 = 0x507c6fffff1fb1b8
+	sim := dplltest.NewSimulator()
+	modes := []uint32{dpll.DpllModeManual, dpll.DpllModeAutomatic}
+	eec := sim.AddDevice(dpll.DoDeviceGetReply{ModuleName: "ice", ClockID: clockID, Type: dpll.DpllTypeEEC, ModeSupported: modes})
+	pps := sim.AddDevice(dpll.DoDeviceGetReply{ModuleName: "ice", ClockID: clockID, Type: dpll.DpllTypePPS, ModeSupported: modes})
+	input := func(label string, prio uint32) uint32 {
+		id, err := sim.AddPin(dpll.PinInfo{
+			ModuleName: "ice", ClockID: clockID, BoardLabel: label, Type: dpll.PinTypeEXT,
+			Capabilities: dpll.PinCapPrio | dpll.PinCapState,
+			ParentDevice: []dpll.PinParentDevice{
+				{ParentID: eec, Direction: dpll.PinDirectionInput, Prio: &prio},
+				{ParentID: pps, Direction: dpll.PinDirectionInput, Prio: &prio},
+			},
+		})
+		require.NoError(t, err)
+		require.NoError(t, sim.SetSignal(id, true))
+		return id
+	}
+	gnss := input("GNSS-1PPS", 0)
+	sma := input("SMA1", 3)
+	SetDpllDialer(sim.Dialer())
+	defer ResetDpllDialer()
+
+	conn := sim.Dial()
+	pins, err := conn.DumpPinGet()
+	require.NoError(t, err)
+	conn.Close()
+
+	origLog := pinAuditLog
+	pinAuditLog = NewPinAuditLog(DefaultPinAuditLogSize)
+	defer func() { pinAuditLog = origLog }()
+
+	hcm := newHardwareConfigManagerForTests()
+	hcm.pinCache = buildPinCacheFromPins(pins)
+	hcm.clockIDCache = map[string]uint64{"ens1f0:": clockID}
+	hwConfig := func(annotation string) ptpv2alpha1.HardwareConfig {
+		hw := ptpv2alpha1.HardwareConfig{
+			Spec: ptpv2alpha1.HardwareConfigSpec{
+				RelatedPtpProfileName: "test-profile",
+				Profile: ptpv2alpha1.HardwareProfile{
+					ClockChain: &ptpv2alpha1.ClockChain{
+						Structure: []ptpv2alpha1.Subsystem{{
+							Name:     "leader",
+							Ethernet: []ptpv2alpha1.Ethernet{{Ports: []string{"ens1f0"}}},
+						}},
+					},
+				},
+			},
+		}
+		hw.Name = "test-hwconfig"
+		if annotation != "" {
+			hw.Annotations = map[string]string{DPLLModeAnnotation: annotation}
+		}
+		return hw
+	}
+	// apply resolves the annotated desired states of a hardware config and applies its PTP profile
+	apply := func(annotation string) error {
+		hw := hwConfig(annotation)
+		commands, err := hcm.resolveHardwareConfigDPLLModes(hw)
+		if err != nil {
+			return err
+		}
+		// as UpdateHardwareConfig, revert the DPLLs no longer annotated
+		if len(hcm.hardwareConfigs) > 0 {
+			commands = append(commands, dpllModeReverts(hcm.hardwareConfigs[0].dpllModeCommands, commands)...)
+		}
+		hcm.hardwareConfigs = []enrichedHardwareConfig{{HardwareConfig: hw, dpllModeCommands: commands}}
+		profileName := "ptpconfig_test-profile"
+		return hcm.ApplyHardwareConfigsForProfile(&ptpv1.PtpProfile{Name: &profileName})
+	}
+
+	pinState := func(pinID, deviceID uint32) uint32 {
+		pin, found := sim.Pin(pinID)
+		require.True(t, found)
+		for _, pd := range pin.ParentDevice {
+			if pd.ParentID == deviceID {
+				return pd.State
+			}
+		}
+		t.Fatalf("pin %d has no parent %d", pinID, deviceID)
+		return 0
+	}
+
+	// no annotation, no DPLL mode commands
+	commands, err := hcm.resolveHardwareConfigDPLLModes(hwConfig(""))
+	require.NoError(t, err)
+	assert.Empty(t, commands)
+
+	// manual selection of SMA1 on the PPS DPLL only
+	require.NoError(t, apply(`
+- subsystem: leader
+  pps:
+    mode: manual
+    reference: SMA1
+`))
+	device, _ := sim.Device(pps)
+	assert.Equal(t, uint32(dpll.DpllModeManual), device.Mode)
+	device, _ = sim.Device(eec)
+	assert.Equal(t, uint32(dpll.DpllModeAutomatic), device.Mode)
+	assert.Equal(t, uint32(dpll.PinStateConnected), pinState(sma, pps))
+	assert.Equal(t, uint32(dpll.PinStateDisconnected), pinState(gnss, pps))
+	assert.Equal(t, uint32(dpll.PinStateConnected), pinState(gnss, eec))
+
+	// the mode change and the selection are audited
+	records := PinAuditRecords(PinAuditFilter{})
+	require.Len(t, records, 2)
+	source := PinCommandSource{Component: PinSourceHardwareConfig, Profile: defaultProfileName, Condition: "dpll-mode"}
+	assert.Equal(t, source, records[0].Source)
+	require.NotNil(t, records[0].DeviceID)
+	assert.Equal(t, pps, *records[0].DeviceID)
+	assert.Equal(t, "automatic", records[0].Before.Mode)
+	assert.Equal(t, "manual", records[0].After.Mode)
+	assert.True(t, records[0].Confirmed)
+	assert.Equal(t, source, records[1].Source)
+	assert.Equal(t, sma, records[1].PinID)
+	assert.Equal(t, "SMA1", records[1].Label)
+	assert.True(t, records[1].Confirmed)
+
+	// back to automatic mode
+	require.NoError(t, apply(`[{"subsystem": "leader", "pps": {"mode": "automatic"}}]`))
+	device, _ = sim.Device(pps)
+	assert.Equal(t, uint32(dpll.DpllModeAutomatic), device.Mode)
+
+	// removing the annotation sets the DPLLs it set to manual mode back to automatic mode
+	manual := `[{"subsystem": "leader", "pps": {"mode": "manual", "reference": "SMA1"}}]`
+	require.NoError(t, apply(manual))
+	device, _ = sim.Device(pps)
+	require.Equal(t, uint32(dpll.DpllModeManual), device.Mode)
+	require.NoError(t, apply(""))
+	device, _ = sim.Device(pps)
+	assert.Equal(t, uint32(dpll.DpllModeAutomatic), device.Mode)
+
+	// and so does removing the hardware config
+	require.NoError(t, apply(manual))
+	removed := hcm.hardwareConfigs
+	hcm.hardwareConfigs = nil
+	require.NoError(t, hcm.applyVendorDefaultsForRemovedConfigs(removed))
+	device, _ = sim.Device(pps)
+	assert.Equal(t, uint32(dpll.DpllModeAutomatic), device.Mode)
+
+	tests := []struct {
+		name       string
+		annotation string
+		wantErr    string
+	}{
+		{
+			name:       "reference in automatic mode",
+			annotation: `[{"subsystem": "leader", "eec": {"mode": "automatic", "reference": "SMA1"}}]`,
+			wantErr:    "EEC reference SMA1 requires manual mode",
+		},
+		{
+			name:       "unknown reference",
+			annotation: `[{"subsystem": "leader", "eec": {"mode": "manual", "reference": "SMA2"}}]`,
+			wantErr:    "DPLL pin not found in cache",
+		},
+		{
+			name:       "invalid mode",
+			annotation: `[{"subsystem": "leader", "eec": {"mode": "freerun"}}]`,
+			wantErr:    "invalid DPLL mode: freerun",
+		},
+		{
+			name:       "unknown subsystem",
+			annotation: `[{"subsystem": "follower", "eec": {"mode": "manual"}}]`,
+			wantErr:    "subsystem follower not found",
+		},
+		{
+			name:       "invalid annotation",
+			annotation: `subsystem: leader`,
+			wantErr:    "failed to parse " + DPLLModeAnnotation + " annotation",
+		},
+		{
+			name:       "unknown field",
+			annotation: `[{"subsystem": "leader", "pps": {"mode": "manual", "refrence": "SMA1"}}]`,
+			wantErr:    "failed to parse " + DPLLModeAnnotation + " annotation",
+		},
+		{
+			name:       "no device mode",
+			annotation: `[{"subsystem": "leader"}]`,
+			wantErr:    "no eec or pps mode for subsystem leader",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorContains(t, apply(tt.annotation), tt.wantErr)
+		})
+	}
+	device, _ = sim.Device(eec)
+	assert.Equal(t, uint32(dpll.DpllModeAutomatic), device.Mode, "failed desired states change nothing")
+}
@@ -0,0 +1,95 @@
+package hardwareconfig
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	dpllcfg "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll"
+	dpll "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+)
+
+// GetClockIDFromNetdevPin resolves the clock ID of the DPLL an interface is linked to, without
+// any vendor specific knowledge. The netdev DPLL pin is followed up its parent pins until a pin
+// registered with a DPLL device is reached, e.g. the ice recovered clock pin (direct DPLL parent),
+// or the E825 NIC pin feeding a zl3073x input (parent pin chain).
+func GetClockIDFromNetdevPin(ifname string) (uint64, error) {
+	pinID, found, err := netdevDpllPinGetter(ifname)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get DPLL pin for %s: %w", ifname, err)
+	}
+	if !found {
+		return 0, fmt.Errorf("no DPLL pin found for interface %s", ifname)
+	}
+
+	visited := map[uint32]bool{}
+	queue := []uint32{pinID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		pin, queryErr := dpllPinQuerier(id)
+		if queryErr != nil {
+			if id == pinID {
+				return 0, fmt.Errorf("failed to query DPLL pin %d: %w", id, queryErr)
+			}
+			glog.Warningf("Failed to query parent pin %d: %v, trying next", id, queryErr)
+			continue
+		}
+		if len(pin.ParentDevice) > 0 {
+			glog.Infof("Interface %s DPLL pin %d resolved to DPLL pin %d (module=%s) with clock ID %#x",
+				ifname, pinID, pin.ID, pin.ModuleName, pin.ClockID)
+			return pin.ClockID, nil
+		}
+		for _, parent := range pin.ParentPin {
+			queue = append(queue, parent.ParentID)
+		}
+	}
+	return 0, fmt.Errorf("DPLL pin %d of %s is not connected to a DPLL device", pinID, ifname)
+}
+
+// DetectDPLLFlags detects the DPLL monitoring flags of a clock from the DPLL devices
+// registered for it, for hardware without defaults describing them
+func DetectDPLLFlags(clockID uint64) (dpllcfg.Flag, error) {
+	conn, err := dpllDialer()
+	if err != nil {
+		return 0, fmt.Errorf("failed to dial DPLL: %w", err)
+	}
+	//nolint:errcheck
+	defer conn.Close()
+	devices, err := conn.DumpDeviceGet()
+	if err != nil {
+		return 0, fmt.Errorf("failed to dump DPLL devices: %w", err)
+	}
+	return dpllFlagsFromDevices(devices, clockID)
+}
+
+// dpllFlagsFromDevices disables the phase monitoring when the clock has no PPS DPLL,
+// and the frequency monitoring when it has no EEC DPLL
+func dpllFlagsFromDevices(devices []*dpll.DoDeviceGetReply, clockID uint64) (dpllcfg.Flag, error) {
+	var hasEEC, hasPPS bool
+	for _, device := range devices {
+		if device.ClockID != clockID {
+			continue
+		}
+		switch device.Type {
+		case dpll.DpllTypeEEC:
+			hasEEC = true
+		case dpll.DpllTypePPS:
+			hasPPS = true
+		}
+	}
+	if !hasEEC && !hasPPS {
+		return 0, fmt.Errorf("no DPLL device with clock ID %#x", clockID)
+	}
+	var flags dpllcfg.Flag
+	if !hasPPS {
+		flags |= dpllcfg.FlagNoPhaseStatus | dpllcfg.FlagNoPhaseOffset
+	}
+	if !hasEEC {
+		flags |= dpllcfg.FlagNoFreqencyStatus
+	}
+	return flags, nil
+}
@@ -0,0 +1,115 @@
+package hardwareconfig
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dpllcfg "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll"
+	dpll "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+)
+
+func TestGetClockIDFromNetdevPin(t *testing.T) {
+	const clockID = uint64(0x1070fdffff0e5f2c)
+
+	origNetdev := netdevDpllPinGetter
+	origQuerier := dpllPinQuerier
+	origCmd := commandExecutor
+	defer func() {
+		netdevDpllPinGetter = origNetdev
+		dpllPinQuerier = origQuerier
+		commandExecutor = origCmd
+	}()
+
+	pins := map[uint32]*dpll.PinInfo{
+		// mlx5 style: the netdev pin is registered with the NIC DPLLs
+		1: {ID: 1, ModuleName: "mlx5_dpll", ClockID: clockID, ParentDevice: []dpll.PinParentDevice{{ParentID: 0}, {ParentID: 1}}},
+		// E825 style: the netdev pin feeds the inputs of an external DPLL
+		5:  {ID: 5, ModuleName: testModuleIce, ParentPin: []dpll.PinParentPin{{ParentID: 17}, {ParentID: 18}}},
+		18: {ID: 18, ModuleName: testModuleZl3073x, ClockID: clockID, ParentDevice: []dpll.PinParentDevice{{ParentID: 2}}},
+		// parent pin loop without DPLL device
+		7: {ID: 7, ModuleName: testModuleIce, ParentPin: []dpll.PinParentPin{{ParentID: 8}}},
+		8: {ID: 8, ModuleName: testModuleIce, ParentPin: []dpll.PinParentPin{{ParentID: 7}}},
+	}
+	dpllPinQuerier = func(pinID uint32) (*dpll.PinInfo, error) {
+		if pin, ok := pins[pinID]; ok {
+			return pin, nil
+		}
+		return nil, fmt.Errorf("pin %d not found", pinID)
+	}
+
+	tests := []struct {
+		name    string
+		pinID   uint32
+		found   bool
+		wantErr string
+	}{
+		{name: "direct DPLL device parent", pinID: 1, found: true},
+		{name: "parent pin chain", pinID: 5, found: true},
+		{name: "no DPLL device", pinID: 7, found: true, wantErr: "is not connected to a DPLL device"},
+		{name: "unknown pin", pinID: 9, found: true, wantErr: "failed to query DPLL pin 9"},
+		{name: "no netdev pin", found: false, wantErr: "no DPLL pin found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			netdevDpllPinGetter = func(_ string) (uint32, bool, error) {
+				return tt.pinID, tt.found, nil
+			}
+			got, err := GetClockIDFromNetdevPin("enp1s0f0np0")
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, clockID, got)
+		})
+	}
+
+	t.Run("generic hardware defaults skip ethtool", func(t *testing.T) {
+		commandExecutor = NewMockCommandExecutor()
+		netdevDpllPinGetter = func(_ string) (uint32, bool, error) { return 1, true, nil }
+		got, err := GetClockIDFromInterfaceWithCache("enp1s0f0np0", HwDefGenericDPLL, nil)
+		require.NoError(t, err)
+		assert.Equal(t, clockID, got)
+	})
+
+	t.Run("fallback when the serial number is missing", func(t *testing.T) {
+		mockCmd := NewMockCommandExecutor()
+		mockCmd.SetResponse("ethtool", []string{"-i", "enp1s0f0np0"}, "driver: mlx5_core\nbus-info: 0000:01:00.0")
+		mockCmd.SetResponse("devlink", []string{"dev", "info", "pci/0000:01:00.0"}, "pci/0000:01:00.0:\n  driver mlx5_core")
+		commandExecutor = mockCmd
+		netdevDpllPinGetter = func(_ string) (uint32, bool, error) { return 1, true, nil }
+		got, err := GetClockIDFromInterfaceWithCache("enp1s0f0np0", HwDefIntelE810, nil)
+		require.NoError(t, err)
+		assert.Equal(t, clockID, got)
+	})
+}
+
+func TestDpllFlagsFromDevices(t *testing.T) {
+	const clockID = uint64(0x1070fdffff0e5f2c)
+	eec := &dpll.DoDeviceGetReply{ID: 0, ClockID: clockID, Type: dpll.DpllTypeEEC}
+	pps := &dpll.DoDeviceGetReply{ID: 1, ClockID: clockID, Type: dpll.DpllTypePPS}
+	other := &dpll.DoDeviceGetReply{ID: 2, ClockID: clockID + 1, Type: dpll.DpllTypePPS}
+
+	flags, err := dpllFlagsFromDevices([]*dpll.DoDeviceGetReply{eec, pps, other}, clockID)
+	require.NoError(t, err)
+	assert.Zero(t, flags)
+
+	flags, err = dpllFlagsFromDevices([]*dpll.DoDeviceGetReply{eec, other}, clockID)
+	require.NoError(t, err)
+	assert.Equal(t, dpllcfg.FlagNoPhaseStatus|dpllcfg.FlagNoPhaseOffset, flags)
+
+	flags, err = dpllFlagsFromDevices([]*dpll.DoDeviceGetReply{pps}, clockID)
+	require.NoError(t, err)
+	assert.Equal(t, dpllcfg.FlagNoFreqencyStatus, flags)
+
+	_, err = dpllFlagsFromDevices([]*dpll.DoDeviceGetReply{other}, clockID)
+	assert.ErrorContains(t, err, "no DPLL device")
+
+	defaults, err := LoadHardwareDefaults(HwDefGenericDPLL, nil)
+	require.NoError(t, err)
+	require.NotNil(t, defaults.ClockIDTransformation)
+	assert.Equal(t, ClockIDMethodNetdevPin, defaults.ClockIDTransformation.Method)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -201,6 +202,10 @@ type enrichedHardwareConfig struct {
 	holdoverParams map[uint64]*ptpv2alpha1.HoldoverParameters
 	// DPLL monitoring flags mapped by clock ID (from hardware vendor defaults)
 	dpllFlags map[uint64]dpllcfg.Flag
+	// DPLL mode commands resolved from the DPLLModeAnnotation
+	dpllModeCommands []DPLLModeCommand
+	// phase calibrations requested by the PhaseCalibrationAnnotation
+	phaseCalibrationRequests []PhaseCalibrationRequest
 }
 
 // HardwareConfigManager manages hardware configurations and their application
@@ -209,7 +214,8 @@ type enrichedHardwareConfig struct {
 type HardwareConfigManager struct {
 	hardwareConfigs []enrichedHardwareConfig
 	pinCache        *PinCache
-	pinApplier      func([]dpll.PinParentDeviceCtl) error
+	pinApplier      func(PinCommandSource, []dpll.PinParentDeviceCtl) error
+	modeApplier     func(PinCommandSource, []DPLLModeCommand) error
 	sysfsWriter     func(string, string) error
 	// cache of hardware defaults keyed by hwDefPath to avoid repeated loads
 	hwDefaultsCache map[string]*HardwareDefaults
@@ -217,6 +223,13 @@ type HardwareConfigManager struct {
 	clockIDCache map[string]uint64
 	// current PtpConfig used for resolving clock chains (optional)
 	ptpConfig *ptpv1.PtpConfig
+	// phase calibrations of the output pins, persisted by calibrationStore (optional, can be nil)
+	calibrationMu      sync.Mutex
+	calibrationStore   *PhaseCalibrationStore
+	phaseCalibrations  []PhaseCalibration
+	calibrationsLoaded bool
+	// PhaseCalibrationAnnotation of each hardware config when its requests were last calibrated
+	calibratedAnnotations map[string]string
 	// ConfigMap loader for board label remapping (optional, can be nil)
 	configMapLoader   *BoardLabelMapLoader
 	interfaceResolver *network.InterfaceResolver
@@ -229,7 +242,8 @@ type HardwareConfigManager struct {
 func NewHardwareConfigManager(kubeClient kubernetes.Interface, namespace string, resolver *network.InterfaceResolver) *HardwareConfigManager {
 	hcm := &HardwareConfigManager{
 		hardwareConfigs: make([]enrichedHardwareConfig, 0),
-		pinApplier:      func(cmds []dpll.PinParentDeviceCtl) error { return BatchPinSet(cmds) },
+		pinApplier:      BatchPinSet,
+		modeApplier:     ApplyDPLLModeCommands,
 		hwDefaultsCache: make(map[string]*HardwareDefaults),
 		clockIDCache:    make(map[string]uint64),
 		sysfsWriter: func(path, value string) error {
@@ -281,6 +295,28 @@ func (hcm *HardwareConfigManager) getClockIDCached(iface string, hwDefPath strin
 	return clockID, nil
 }
 
+// resolveSubsystemClockID returns the DPLL clock ID of a clock chain subsystem, resolved from its network interface
+func (hcm *HardwareConfigManager) resolveSubsystemClockID(subsystem string, clockChain *ptpv2alpha1.ClockChain) (uint64, error) {
+	networkInterface, err := GetSubsystemNetworkInterface(clockChain, subsystem)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get network interface for subsystem %s: %w", subsystem, err)
+	}
+
+	hwDefPath, hasHwDef, hwDefErr := getSubsystemHardwareDefinition(clockChain, subsystem)
+	if hwDefErr != nil {
+		return 0, fmt.Errorf("failed to resolve hardware definition for subsystem %s: %w", subsystem, hwDefErr)
+	}
+	if !hasHwDef {
+		glog.V(3).Infof("Subsystem %s has no hardware-specific definitions; using fallback clock ID transformer", subsystem)
+	}
+
+	clockID, err := hcm.getClockIDCached(networkInterface, hwDefPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve clock ID for subsystem %s interface %s: %w", subsystem, networkInterface, err)
+	}
+	return clockID, nil
+}
+
 // UpdateHardwareConfig implements HardwareConfigUpdateHandler interface
 // This method updates the hardware configuration stored in the manager
 func (hcm *HardwareConfigManager) UpdateHardwareConfig(hwConfigs []ptpv2alpha1.HardwareConfig) error {
@@ -324,6 +360,14 @@ func (hcm *HardwareConfigManager) UpdateHardwareConfig(hwConfigs []ptpv2alpha1.H
 		glog.Infof("Pin cache initialized: %d total pins", hcm.pinCache.Count())
 	}
 
+	// DPLL modes applied by the current hardware configs, to revert those no longer annotated
+	previousModes := map[string][]DPLLModeCommand{}
+	hcm.mu.RLock()
+	for _, hwConfig := range hcm.hardwareConfigs {
+		previousModes[hwConfig.Name] = hwConfig.dpllModeCommands
+	}
+	hcm.mu.RUnlock()
+
 	prepared := make([]enrichedHardwareConfig, len(hwConfigs))
 	for i, hwConfig := range hwConfigs {
 		// Resolve clock chain if clockType is specified
@@ -366,6 +410,22 @@ func (hcm *HardwareConfigManager) UpdateHardwareConfig(hwConfigs []ptpv2alpha1.H
 		prepared[i].structurePinCommands = structPins
 		prepared[i].structureSysFSCommands = structSysfs
 
+		modeCommands, modeErr := hcm.resolveHardwareConfigDPLLModes(*resolvedConfig)
+		if modeErr != nil {
+			return fmt.Errorf("failed to resolve DPLL modes for hardware config %s: %w", resolvedConfig.Name, modeErr)
+		}
+		modeCommands = append(modeCommands, dpllModeReverts(previousModes[resolvedConfig.Name], modeCommands)...)
+		if len(modeCommands) > 0 {
+			glog.Infof("  dpll modes: %d DPLL mode commands", len(modeCommands))
+		}
+		prepared[i].dpllModeCommands = modeCommands
+
+		calibrationRequests, calibrationErr := phaseCalibrationRequests(*resolvedConfig)
+		if calibrationErr != nil {
+			return fmt.Errorf("failed to resolve phase calibrations for hardware config %s: %w", resolvedConfig.Name, calibrationErr)
+		}
+		prepared[i].phaseCalibrationRequests = calibrationRequests
+
 		// Update stored config with populated phase adjustments
 		prepared[i].HardwareConfig = *resolvedConfig
 
@@ -470,10 +530,14 @@ func (hcm *HardwareConfigManager) ApplyHardwareConfigsForProfile(nodeProfile *pt
 		if err := hcm.applyStructureDefaults(&enrichedConfig, profileName); err != nil {
 			return err
 		}
+		if err := hcm.applyDPLLModes(&enrichedConfig, profileName); err != nil {
+			return err
+		}
 		if err := hcm.applyBehaviorConditions(&enrichedConfig, profileName); err != nil {
 			return err
 		}
 	}
+	hcm.startPhaseCalibrations(*nodeProfile.Name, relevantConfigs)
 
 	// NOTE: Structure application currently resolves and caches commands, but execution relies on future netlink/sysfs writers.
 	// Until full support lands, we simply cache the resolved data and return success so the daemon remains stable.
@@ -885,26 +949,7 @@ func (hcm *HardwareConfigManager) buildPhaseAdjustmentCommands(subsystem ptpv2al
 			totalAdjustment := *pinCfg.PhaseAdjustment
 
 			// Convert to int32 and validate against pin limits
-			adjustmentInt32 := int32(totalAdjustment)
-
-			// Round to granularity first (before clamping) to ensure we work with granularity-aligned values
-			originalAdjustment := adjustmentInt32
-
-			adjustmentInt32 = roundToGranularity(adjustmentInt32, pin.PhaseAdjustGran)
-			if adjustmentInt32 != originalAdjustment {
-				glog.V(3).Infof("Pin %s phase adjustment rounded from %d to %d ps (granularity: %d ps)",
-					boardLabel, originalAdjustment, adjustmentInt32, pin.PhaseAdjustGran)
-			}
-
-			// Clamp to pin's min/max range
-			if pin.PhaseAdjustMin != 0 && adjustmentInt32 < pin.PhaseAdjustMin {
-				glog.Warningf("Pin %s phase adjustment %d ps clamped to minimum %d ps", boardLabel, adjustmentInt32, pin.PhaseAdjustMin)
-				adjustmentInt32 = pin.PhaseAdjustMin
-			}
-			if pin.PhaseAdjustMax != 0 && adjustmentInt32 > pin.PhaseAdjustMax {
-				glog.Warningf("Pin %s phase adjustment %d ps clamped to maximum %d ps", boardLabel, adjustmentInt32, pin.PhaseAdjustMax)
-				adjustmentInt32 = pin.PhaseAdjustMax
-			}
+			adjustmentInt32 := FitPhaseAdjust(boardLabel, int32(totalAdjustment), pin)
 
 			// Build command
 			cmd := dpll.PinParentDeviceCtl{
@@ -922,6 +967,28 @@ func (hcm *HardwareConfigManager) buildPhaseAdjustmentCommands(subsystem ptpv2al
 	return commands, nil
 }
 
+// FitPhaseAdjust rounds a phase adjustment to the pin granularity and clamps it to the pin range
+func FitPhaseAdjust(boardLabel string, adjustment int32, pin *dpll.PinInfo) int32 {
+	// Round to granularity first (before clamping) to ensure we work with granularity-aligned values
+	original := adjustment
+	adjustment = roundToGranularity(adjustment, pin.PhaseAdjustGran)
+	if adjustment != original {
+		glog.V(3).Infof("Pin %s phase adjustment rounded from %d to %d ps (granularity: %d ps)",
+			boardLabel, original, adjustment, pin.PhaseAdjustGran)
+	}
+
+	// Clamp to pin's min/max range
+	if pin.PhaseAdjustMin != 0 && adjustment < pin.PhaseAdjustMin {
+		glog.Warningf("Pin %s phase adjustment %d ps clamped to minimum %d ps", boardLabel, adjustment, pin.PhaseAdjustMin)
+		adjustment = pin.PhaseAdjustMin
+	}
+	if pin.PhaseAdjustMax != 0 && adjustment > pin.PhaseAdjustMax {
+		glog.Warningf("Pin %s phase adjustment %d ps clamped to maximum %d ps", boardLabel, adjustment, pin.PhaseAdjustMax)
+		adjustment = pin.PhaseAdjustMax
+	}
+	return adjustment
+}
+
 // buildPinFrequencyCommands builds DPLL commands to set pin frequency (with optional eSync)
 // Returns a sequence of commands based on vendor-specific definitions
 func (hcm *HardwareConfigManager) buildPinFrequencyCommands(clockID uint64, boardLabel string, pinCfg ptpv2alpha1.PinConfig, clockChain *ptpv2alpha1.ClockChain, hwSpec *HardwareDefaults, isInput bool) []dpll.PinParentDeviceCtl {
@@ -1249,22 +1316,9 @@ func (hcm *HardwareConfigManager) createPinCommandForDPLLDesiredState(dpllDesire
 		return dpll.PinParentDeviceCtl{}, fmt.Errorf("subsystem not specified in DPLL desired state")
 	}
 
-	networkInterface, err := GetSubsystemNetworkInterface(clockChain, dpllDesiredState.Subsystem)
+	clockID, err := hcm.resolveSubsystemClockID(dpllDesiredState.Subsystem, clockChain)
 	if err != nil {
-		return dpll.PinParentDeviceCtl{}, fmt.Errorf("failed to get network interface for subsystem %s: %w", dpllDesiredState.Subsystem, err)
-	}
-
-	hwDefPath, hasHwDef, hwDefErr := getSubsystemHardwareDefinition(clockChain, dpllDesiredState.Subsystem)
-	if hwDefErr != nil {
-		return dpll.PinParentDeviceCtl{}, fmt.Errorf("failed to resolve hardware definition for subsystem %s: %w", dpllDesiredState.Subsystem, hwDefErr)
-	}
-	if !hasHwDef {
-		glog.V(3).Infof("Subsystem %s has no hardware-specific definitions; using fallback clock ID transformer", dpllDesiredState.Subsystem)
-	}
-
-	clockID, err := hcm.getClockIDCached(networkInterface, hwDefPath)
-	if err != nil {
-		return dpll.PinParentDeviceCtl{}, fmt.Errorf("failed to resolve clock ID for subsystem %s interface %s: %w", dpllDesiredState.Subsystem, networkInterface, err)
+		return dpll.PinParentDeviceCtl{}, err
 	}
 
 	pin, found := hcm.pinCache.GetPin(clockID, dpllDesiredState.BoardLabel)
@@ -1483,7 +1537,8 @@ func (hcm *HardwareConfigManager) applyDesiredStatesInOrder(condition ptpv2alpha
 			if err != nil {
 				return fmt.Errorf("desiredState[%d] dpll command build failed: %w", idx, err)
 			}
-			if err = hcm.pinApplier([]dpll.PinParentDeviceCtl{cmd}); err != nil {
+			source := PinCommandSource{Component: PinSourceHardwareConfig, Profile: profileName, Condition: condition.Name}
+			if err = hcm.pinApplier(source, []dpll.PinParentDeviceCtl{cmd}); err != nil {
 				return fmt.Errorf("desiredState[%d] dpll apply failed: %w", idx, err)
 			}
 		}
@@ -1499,8 +1554,12 @@ func (hcm *HardwareConfigManager) applyStructureDefaults(enrichedConfig *enriche
 		}
 	}
 
-	if len(enrichedConfig.structurePinCommands) > 0 {
-		if err := hcm.applyDpllPinCommands(profileName, "structure-defaults", enrichedConfig.structurePinCommands); err != nil {
+	// Calibrated output phase adjustments replace the configured ones, see CalibratePhaseAdjust. They
+	// are built at apply time since a calibration may be newer than the cached structure commands.
+	pinCommands := slices.Clone(enrichedConfig.structurePinCommands)
+	pinCommands = append(pinCommands, hcm.buildClockChainPhaseCalibrationCommands(enrichedConfig.Spec.Profile.ClockChain)...)
+	if len(pinCommands) > 0 {
+		if err := hcm.applyDpllPinCommands(profileName, "structure-defaults", pinCommands); err != nil {
 			return fmt.Errorf("failed to apply structure DPLL defaults: %w", err)
 		}
 	}
@@ -1550,7 +1609,8 @@ func (hcm *HardwareConfigManager) applyDpllPinCommands(profileName, context stri
 		}
 	}
 
-	if err := hcm.pinApplier(mergedCommands); err != nil {
+	source := PinCommandSource{Component: PinSourceHardwareConfig, Profile: profileName, Condition: context}
+	if err := hcm.pinApplier(source, mergedCommands); err != nil {
 		return fmt.Errorf("dpll pin apply (%s): %w", context, err)
 	}
 
@@ -1843,7 +1903,7 @@ func (hcm *HardwareConfigManager) writeSysFSValue(path, value string) error {
 	return hcm.sysfsWriter(path, value)
 }
 
-func (hcm *HardwareConfigManager) overrideExecutors(pin func([]dpll.PinParentDeviceCtl) error, sysfs func(string, string) error) {
+func (hcm *HardwareConfigManager) overrideExecutors(pin func(PinCommandSource, []dpll.PinParentDeviceCtl) error, sysfs func(string, string) error) {
 	if pin != nil {
 		hcm.pinApplier = pin
 	}
@@ -1853,7 +1913,8 @@ func (hcm *HardwareConfigManager) overrideExecutors(pin func([]dpll.PinParentDev
 }
 
 func (hcm *HardwareConfigManager) resetExecutors() {
-	hcm.pinApplier = func(cmds []dpll.PinParentDeviceCtl) error { return BatchPinSet(cmds) }
+	hcm.pinApplier = BatchPinSet
+	hcm.modeApplier = ApplyDPLLModeCommands
 	hcm.sysfsWriter = func(path, value string) error { return os.WriteFile(path, []byte(value), 0o644) }
 }
 
@@ -2176,6 +2237,15 @@ func (hcm *HardwareConfigManager) applyVendorDefaultsForRemovedConfigs(removedCo
 				}
 			}
 		}
+
+		// Set the DPLLs of its DPLL mode annotation back to automatic mode
+		if reverts := dpllModeReverts(removedConfig.dpllModeCommands, nil); len(reverts) > 0 {
+			glog.Infof("Reverting %d DPLLs of removed hardware config '%s' to automatic mode", len(reverts), removedConfig.Name)
+			source := PinCommandSource{Component: PinSourceHardwareConfig, Profile: profileName, Condition: "dpll-mode"}
+			if err := hcm.modeApplier(source, reverts); err != nil {
+				glog.Errorf("Failed to revert DPLL modes of removed hardware config '%s': %v", removedConfig.Name, err)
+			}
+		}
 	}
 
 	return nil
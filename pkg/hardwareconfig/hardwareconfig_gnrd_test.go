@@ -49,7 +49,7 @@ func TestGNRDHardwareConfigFullFlow(t *testing.T) {
 	var capturedSysFSCommands []SysFSCommand
 
 	// Override executors to capture commands instead of sending to hardware
-	dpllExecutor := func(cmds []dpll.PinParentDeviceCtl) error {
+	dpllExecutor := func(_ PinCommandSource, cmds []dpll.PinParentDeviceCtl) error {
 		snapshot := make([]dpll.PinParentDeviceCtl, len(cmds))
 		copy(snapshot, cmds)
 		capturedDpllCommands = append(capturedDpllCommands, snapshot...)
@@ -425,7 +425,7 @@ func validateGNRDBehaviorTransitions(t *testing.T, hcm *HardwareConfigManager, h
 			var conditionDpllCommands []dpll.PinParentDeviceCtl
 			var conditionSysFSCommands []SysFSCommand
 
-			dpllExecutor := func(cmds []dpll.PinParentDeviceCtl) error {
+			dpllExecutor := func(_ PinCommandSource, cmds []dpll.PinParentDeviceCtl) error {
 				snapshot := make([]dpll.PinParentDeviceCtl, len(cmds))
 				copy(snapshot, cmds)
 				conditionDpllCommands = append(conditionDpllCommands, snapshot...)
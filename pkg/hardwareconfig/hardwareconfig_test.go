@@ -90,7 +90,7 @@ func TestApplyHardwareConfigsForProfile(t *testing.T) {
 			hcm.overrideExecutors(nil, func(_, _ string) error { return nil })
 
 			var appliedPins []dpll.PinParentDeviceCtl
-			hcm.overrideExecutors(func(cmds []dpll.PinParentDeviceCtl) error {
+			hcm.overrideExecutors(func(_ PinCommandSource, cmds []dpll.PinParentDeviceCtl) error {
 				snapshot := make([]dpll.PinParentDeviceCtl, len(cmds))
 				copy(snapshot, cmds)
 				appliedPins = append(appliedPins, snapshot...)
@@ -496,7 +496,7 @@ func TestApplyConditionDesiredStatesWithRealData(t *testing.T) {
 	defer hcm.resetExecutors()
 
 	// Override executors to avoid actual hardware operations
-	hcm.overrideExecutors(func(_ []dpll.PinParentDeviceCtl) error {
+	hcm.overrideExecutors(func(_ PinCommandSource, _ []dpll.PinParentDeviceCtl) error {
 		// Mock DPLL executor - just return success without actually applying
 		return nil
 	}, func(_, _ string) error {
@@ -765,7 +765,7 @@ func TestApplyDefaultAndInitConditions(t *testing.T) {
 			hcm.mu.RUnlock()
 
 			// Override executors to avoid actual hardware operations
-			hcm.overrideExecutors(func(_ []dpll.PinParentDeviceCtl) error {
+			hcm.overrideExecutors(func(_ PinCommandSource, _ []dpll.PinParentDeviceCtl) error {
 				return nil
 			}, func(_, _ string) error {
 				return nil
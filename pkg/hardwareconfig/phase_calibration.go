@@ -0,0 +1,435 @@
+package hardwareconfig
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+
+	dpll "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+	ptpv2alpha1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v2alpha1"
+)
+
+const (
+	defaultPhaseCalibrationConfigMapName = "dpll-phase-calibration"
+	defaultPhaseCalibrationSamples       = 10
+	maxPhaseCalibrationSamples           = 300
+	maxPhaseCalibrationSaveAttempts      = 3
+)
+
+// PhaseCalibrationAnnotation is the HardwareConfig annotation holding a YAML (or JSON) list of
+// PhaseCalibrationRequest, calibrated in the background once the hardware config's PTP profile is applied, e.g.
+//
+//	ptp.openshift.io/phase-calibration: |
+//	  - subsystem: leader
+//	    output: SMA2
+//	    loopback: SMA1
+//	    samples: 60
+//
+// The requests are calibrated again when the annotation changes and when the daemon restarts, each
+// calibration measuring the offset left by the one applied. The calibrations are served read-only
+// by the /dpll-phase-calibration endpoint of the ready server.
+const PhaseCalibrationAnnotation = "ptp.openshift.io/phase-calibration"
+
+// phaseCalibrationInterval is the interval between two phase offset samples, swappable for testing
+var phaseCalibrationInterval = time.Second
+
+// PhaseCalibrationRequest requests the calibration of the phase adjustment of an output pin,
+// looped back to an input pin of the same DPLL
+type PhaseCalibrationRequest struct {
+	Subsystem string `json:"subsystem"`
+	// Output is the board label of the output pin to calibrate
+	Output string `json:"output"`
+	// Loopback is the board label of the input pin receiving the output signal
+	Loopback string `json:"loopback"`
+	// Samples is the number of phase offsets averaged, one per second
+	Samples int `json:"samples,omitempty"`
+}
+
+// Validate checks the request before any pin is measured
+func (r PhaseCalibrationRequest) Validate() error {
+	if r.Output == "" || r.Loopback == "" {
+		return fmt.Errorf("output and loopback pins are required for a phase calibration")
+	}
+	if r.Samples > maxPhaseCalibrationSamples {
+		return fmt.Errorf("%d samples exceed the maximum of %d", r.Samples, maxPhaseCalibrationSamples)
+	}
+	return nil
+}
+
+// phaseCalibrationRequests returns the validated requests of the PhaseCalibrationAnnotation of a hardware config
+func phaseCalibrationRequests(hwConfig ptpv2alpha1.HardwareConfig) ([]PhaseCalibrationRequest, error) {
+	value, ok := hwConfig.Annotations[PhaseCalibrationAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var requests []PhaseCalibrationRequest
+	if err := yaml.UnmarshalStrict([]byte(value), &requests); err != nil {
+		return nil, fmt.Errorf("failed to parse %s annotation: %w", PhaseCalibrationAnnotation, err)
+	}
+	for _, req := range requests {
+		if err := req.Validate(); err != nil {
+			return nil, fmt.Errorf("%s annotation: %w", PhaseCalibrationAnnotation, err)
+		}
+	}
+	return requests, nil
+}
+
+// startPhaseCalibrations calibrates in the background the annotated requests of the hardware configs
+// of a PTP profile, unless they were calibrated with the current annotation already
+func (hcm *HardwareConfigManager) startPhaseCalibrations(profileName string, configs []enrichedHardwareConfig) {
+	hcm.calibrationMu.Lock()
+	defer hcm.calibrationMu.Unlock()
+	for _, config := range configs {
+		if len(config.phaseCalibrationRequests) == 0 {
+			continue
+		}
+		annotation := config.Annotations[PhaseCalibrationAnnotation]
+		if calibrated, ok := hcm.calibratedAnnotations[config.Name]; ok && calibrated == annotation {
+			continue
+		}
+		if hcm.calibratedAnnotations == nil {
+			hcm.calibratedAnnotations = map[string]string{}
+		}
+		hcm.calibratedAnnotations[config.Name] = annotation
+		glog.Infof("Starting %d phase calibrations of hardware config %s", len(config.phaseCalibrationRequests), config.Name)
+		go hcm.runPhaseCalibrations(profileName, config.Name, config.phaseCalibrationRequests)
+	}
+}
+
+// runPhaseCalibrations calibrates the requests of a hardware config one after the other
+func (hcm *HardwareConfigManager) runPhaseCalibrations(profileName, hwConfigName string, requests []PhaseCalibrationRequest) {
+	for _, req := range requests {
+		calibration, err := hcm.CalibratePhaseAdjust(profileName, req)
+		if err != nil {
+			glog.Errorf("Phase calibration of output %s of hardware config %s failed: %v", req.Output, hwConfigName, err)
+			continue
+		}
+		glog.Infof("Calibrated output %s of hardware config %s: phase adjustment %d ps", req.Output, hwConfigName, calibration.PhaseAdjust)
+	}
+}
+
+// PhaseCalibration is the calibrated phase adjustment of an output pin
+type PhaseCalibration struct {
+	ClockID  uint64 `json:"clockId"`
+	Output   string `json:"output"`
+	Loopback string `json:"loopback"`
+	// PhaseOffset is the mean phase offset measured on the loopback input before calibration, in ps
+	PhaseOffset int64 `json:"phaseOffset"`
+	// PhaseAdjust is the phase adjustment of the output compensating it, in ps
+	PhaseAdjust int32     `json:"phaseAdjust"`
+	Time        time.Time `json:"time"`
+}
+
+// PhaseCalibrationStore persists the phase calibrations of a node in a ConfigMap, keyed by node name
+type PhaseCalibrationStore struct {
+	client        kubernetes.Interface
+	namespace     string
+	nodeName      string
+	configMapName string
+}
+
+// NewPhaseCalibrationStore creates a phase calibration store for a node
+func NewPhaseCalibrationStore(kubeClient kubernetes.Interface, namespace, nodeName string) *PhaseCalibrationStore {
+	return &PhaseCalibrationStore{
+		client:        kubeClient,
+		namespace:     namespace,
+		nodeName:      nodeName,
+		configMapName: defaultPhaseCalibrationConfigMapName,
+	}
+}
+
+// Load returns the phase calibrations of the node, none when nothing was calibrated yet
+func (s *PhaseCalibrationStore) Load() ([]PhaseCalibration, error) {
+	cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(context.TODO(), s.configMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ConfigMap %s/%s: %w", s.namespace, s.configMapName, err)
+	}
+	data, ok := cm.Data[s.nodeName]
+	if !ok {
+		return nil, nil
+	}
+	var calibrations []PhaseCalibration
+	if err = yaml.Unmarshal([]byte(data), &calibrations); err != nil {
+		return nil, fmt.Errorf("failed to parse the phase calibrations of %s: %w", s.nodeName, err)
+	}
+	return calibrations, nil
+}
+
+// Save stores the phase calibrations of the node, creating the ConfigMap when needed. The ConfigMap
+// is shared by all the nodes, conflicting updates are retried.
+func (s *PhaseCalibrationStore) Save(calibrations []PhaseCalibration) error {
+	data, err := yaml.Marshal(calibrations)
+	if err != nil {
+		return err
+	}
+	configMaps := s.client.CoreV1().ConfigMaps(s.namespace)
+	for attempt := 1; ; attempt++ {
+		cm, getErr := configMaps.Get(context.TODO(), s.configMapName, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(getErr):
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: s.configMapName, Namespace: s.namespace},
+				Data:       map[string]string{s.nodeName: string(data)},
+			}
+			_, err = configMaps.Create(context.TODO(), cm, metav1.CreateOptions{})
+		case getErr != nil:
+			return fmt.Errorf("failed to get ConfigMap %s/%s: %w", s.namespace, s.configMapName, getErr)
+		default:
+			if cm.Data == nil {
+				cm.Data = map[string]string{}
+			}
+			cm.Data[s.nodeName] = string(data)
+			_, err = configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{})
+		}
+		if err == nil || attempt == maxPhaseCalibrationSaveAttempts ||
+			!(apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)) {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save ConfigMap %s/%s: %w", s.namespace, s.configMapName, err)
+	}
+	return nil
+}
+
+// SetPhaseCalibrationStore sets the store persisting the phase calibrations (optional)
+func (hcm *HardwareConfigManager) SetPhaseCalibrationStore(store *PhaseCalibrationStore) {
+	hcm.calibrationMu.Lock()
+	defer hcm.calibrationMu.Unlock()
+	hcm.calibrationStore = store
+	hcm.calibrationsLoaded = false
+}
+
+// PhaseCalibrations returns the phase calibrations of the node
+func (hcm *HardwareConfigManager) PhaseCalibrations() []PhaseCalibration {
+	hcm.calibrationMu.Lock()
+	defer hcm.calibrationMu.Unlock()
+	calibrations, err := hcm.loadPhaseCalibrations()
+	if err != nil {
+		glog.Warningf("Failed to load the phase calibrations: %v", err)
+	}
+	return append([]PhaseCalibration(nil), calibrations...)
+}
+
+// loadPhaseCalibrations loads the persisted phase calibrations once. On failure, it returns the
+// calibrations of this run along with the error. Caller must hold calibrationMu.
+func (hcm *HardwareConfigManager) loadPhaseCalibrations() ([]PhaseCalibration, error) {
+	if hcm.calibrationsLoaded || hcm.calibrationStore == nil {
+		return hcm.phaseCalibrations, nil
+	}
+	calibrations, err := hcm.calibrationStore.Load()
+	if err != nil {
+		return hcm.phaseCalibrations, err
+	}
+	glog.Infof("Loaded %d phase calibrations", len(calibrations))
+	hcm.phaseCalibrations = calibrations
+	hcm.calibrationsLoaded = true
+	return hcm.phaseCalibrations, nil
+}
+
+// buildClockChainPhaseCalibrationCommands builds the phase adjustment commands re-applying the
+// calibrations of the subsystems of a clock chain
+func (hcm *HardwareConfigManager) buildClockChainPhaseCalibrationCommands(clockChain *ptpv2alpha1.ClockChain) []dpll.PinParentDeviceCtl {
+	var commands []dpll.PinParentDeviceCtl
+	if clockChain == nil {
+		return commands
+	}
+	for _, subsystem := range clockChain.Structure {
+		clockID, err := hcm.resolveSubsystemClockID(subsystem.Name, clockChain)
+		if err != nil {
+			glog.V(2).Infof("Skipping the phase calibrations of subsystem %s: %v", subsystem.Name, err)
+			continue
+		}
+		commands = append(commands, hcm.buildPhaseCalibrationCommands(clockID)...)
+	}
+	return commands
+}
+
+// buildPhaseCalibrationCommands builds the phase adjustment commands re-applying the calibrations of a clock
+func (hcm *HardwareConfigManager) buildPhaseCalibrationCommands(clockID uint64) []dpll.PinParentDeviceCtl {
+	hcm.calibrationMu.Lock()
+	defer hcm.calibrationMu.Unlock()
+	calibrations, err := hcm.loadPhaseCalibrations()
+	if err != nil {
+		glog.Warningf("Failed to load the phase calibrations: %v", err)
+	}
+	commands := make([]dpll.PinParentDeviceCtl, 0)
+	for _, calibration := range calibrations {
+		if calibration.ClockID != clockID {
+			continue
+		}
+		pin, found := hcm.pinCache.GetPin(clockID, calibration.Output)
+		if !found {
+			glog.Warningf("Calibrated output pin %s not found for clock %#x", calibration.Output, clockID)
+			continue
+		}
+		adjustment := FitPhaseAdjust(calibration.Output, calibration.PhaseAdjust, pin)
+		commands = append(commands, dpll.PinParentDeviceCtl{ID: pin.ID, PhaseAdjust: &adjustment})
+		glog.Infof("Phase adjustment command for pin %s (id=%d): %d ps (calibrated %s)",
+			calibration.Output, pin.ID, adjustment, calibration.Time.Format(time.RFC3339))
+	}
+	return commands
+}
+
+// CalibratePhaseAdjust calibrates the phase adjustment of an output pin of a subsystem of the hardware
+// configurations of a PTP profile, named as in the PtpConfig or as <config>_<profile>. The phase offset of the loopback input is averaged over the samples,
+// and subtracted from the current phase adjustment of the output, rounded to the pin granularity.
+// The calibration is applied, persisted, and re-applied with the structure defaults.
+func (hcm *HardwareConfigManager) CalibratePhaseAdjust(profileName string, req PhaseCalibrationRequest) (PhaseCalibration, error) {
+	if err := req.Validate(); err != nil {
+		return PhaseCalibration{}, err
+	}
+	clockID, output, loopback, err := hcm.resolvePhaseCalibration(profileName, req)
+	if err != nil {
+		return PhaseCalibration{}, err
+	}
+	samples := req.Samples
+	if samples <= 0 {
+		samples = defaultPhaseCalibrationSamples
+	}
+	glog.Infof("Calibrating the phase adjustment of output %s from loopback input %s (clock %#x, %d samples)",
+		req.Output, req.Loopback, clockID, samples)
+
+	current, offset, err := measureLoopbackPhaseOffset(clockID, output.ID, loopback.ID, samples)
+	if err != nil {
+		return PhaseCalibration{}, fmt.Errorf("phase calibration of %s failed: %w", req.Output, err)
+	}
+	adjustment := FitPhaseAdjust(req.Output, int32(int64(current)-offset), output)
+	calibration := PhaseCalibration{
+		ClockID:     clockID,
+		Output:      req.Output,
+		Loopback:    req.Loopback,
+		PhaseOffset: offset,
+		PhaseAdjust: adjustment,
+		Time:        time.Now(),
+	}
+	glog.Infof("Output %s phase adjustment %d -> %d ps (loopback %s phase offset %d ps)",
+		req.Output, current, adjustment, req.Loopback, offset)
+
+	source := PinCommandSource{Component: PinSourceHardwareConfig, Profile: profileName, Condition: "phase-calibration"}
+	if err = hcm.pinApplier(source, []dpll.PinParentDeviceCtl{{ID: output.ID, PhaseAdjust: &adjustment}}); err != nil {
+		return calibration, fmt.Errorf("failed to apply the phase adjustment of %s: %w", req.Output, err)
+	}
+	return calibration, hcm.savePhaseCalibration(calibration)
+}
+
+// resolvePhaseCalibration resolves the clock ID and the pins of a phase calibration request
+func (hcm *HardwareConfigManager) resolvePhaseCalibration(profileName string, req PhaseCalibrationRequest) (uint64, *dpll.PinInfo, *dpll.PinInfo, error) {
+	hcm.mu.RLock()
+	defer hcm.mu.RUnlock()
+
+	var clockChain *ptpv2alpha1.ClockChain
+	for _, hwConfig := range hcm.hardwareConfigs {
+		related := hwConfig.Spec.RelatedPtpProfileName
+		if (related != profileName && !ProfileNamesMatch(profileName, related)) || hwConfig.Spec.Profile.ClockChain == nil {
+			continue
+		}
+		for _, subsystem := range hwConfig.Spec.Profile.ClockChain.Structure {
+			if subsystem.Name == req.Subsystem {
+				clockChain = hwConfig.Spec.Profile.ClockChain
+			}
+		}
+	}
+	if clockChain == nil {
+		return 0, nil, nil, fmt.Errorf("subsystem %s not found in the hardware configurations of PTP profile %s", req.Subsystem, profileName)
+	}
+	clockID, err := hcm.resolveSubsystemClockID(req.Subsystem, clockChain)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	output, found := hcm.pinCache.GetPin(clockID, req.Output)
+	if !found {
+		return 0, nil, nil, fmt.Errorf("output pin %s not found for clock %#x", req.Output, clockID)
+	}
+	loopback, found := hcm.pinCache.GetPin(clockID, req.Loopback)
+	if !found {
+		return 0, nil, nil, fmt.Errorf("loopback pin %s not found for clock %#x", req.Loopback, clockID)
+	}
+	return clockID, output, loopback, nil
+}
+
+// measureLoopbackPhaseOffset returns the current phase adjustment of the output pin, and the mean
+// phase offset of the loopback input pin against the PPS DPLL of the clock, in ps
+func measureLoopbackPhaseOffset(clockID uint64, outputID, loopbackID uint32, samples int) (int32, int64, error) {
+	conn, err := dpllDialer()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to dial DPLL: %w", err)
+	}
+	//nolint:errcheck
+	defer conn.Close()
+	devices, err := conn.DumpDeviceGet()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to dump DPLL devices: %w", err)
+	}
+	ppsID, found := uint32(0), false
+	for _, device := range devices {
+		if device.ClockID == clockID && device.Type == dpll.DpllTypePPS {
+			ppsID, found = device.ID, true
+		}
+	}
+	if !found {
+		return 0, 0, fmt.Errorf("no PPS DPLL device with clock ID %#x", clockID)
+	}
+	output, err := conn.DoPinGet(dpll.DoPinGetRequest{ID: outputID})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get output pin %d: %w", outputID, err)
+	}
+
+	var sum int64
+	for i := 0; i < samples; i++ {
+		if i > 0 {
+			time.Sleep(phaseCalibrationInterval)
+		}
+		loopback, getErr := conn.DoPinGet(dpll.DoPinGetRequest{ID: loopbackID})
+		if getErr != nil {
+			return 0, 0, fmt.Errorf("failed to get loopback pin %d: %w", loopbackID, getErr)
+		}
+		var parent *dpll.PinParentDevice
+		for j := range loopback.ParentDevice {
+			if loopback.ParentDevice[j].ParentID == ppsID && loopback.ParentDevice[j].Direction == dpll.PinDirectionInput {
+				parent = &loopback.ParentDevice[j]
+			}
+		}
+		if parent == nil {
+			return 0, 0, fmt.Errorf("loopback pin %d is not an input of PPS DPLL %d", loopbackID, ppsID)
+		}
+		sum += parent.PhaseOffset / dpll.DpllPhaseOffsetDivider
+	}
+	return output.PhaseAdjust, sum / int64(samples), nil
+}
+
+// savePhaseCalibration replaces the calibration of the output pin, and persists the calibrations
+func (hcm *HardwareConfigManager) savePhaseCalibration(calibration PhaseCalibration) error {
+	hcm.calibrationMu.Lock()
+	defer hcm.calibrationMu.Unlock()
+	// the persisted calibrations must be known, saving without them would drop them
+	loaded, err := hcm.loadPhaseCalibrations()
+	if err != nil {
+		return fmt.Errorf("phase adjustment of %s applied but not persisted: failed to load the phase calibrations: %w", calibration.Output, err)
+	}
+	calibrations := make([]PhaseCalibration, 0, len(loaded)+1)
+	for _, c := range loaded {
+		if c.ClockID != calibration.ClockID || c.Output != calibration.Output {
+			calibrations = append(calibrations, c)
+		}
+	}
+	hcm.phaseCalibrations = append(calibrations, calibration)
+	if hcm.calibrationStore == nil {
+		glog.Warningf("No phase calibration store, the calibration of %s is lost on restart", calibration.Output)
+		return nil
+	}
+	if err := hcm.calibrationStore.Save(hcm.phaseCalibrations); err != nil {
+		return fmt.Errorf("phase adjustment of %s applied but not persisted: %w", calibration.Output, err)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package hardwareconfig
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
+
+	dpll "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink/dplltest"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	ptpv2alpha1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v2alpha1"
+)
+
+func TestCalibratePhaseAdjust(t *testing.T) {
+	const clockID uint64 = 0x507c6fffff1fb1b8
+	origInterval := phaseCalibrationInterval
+	phaseCalibrationInterval = 0
+	defer func() { phaseCalibrationInterval = origInterval }()
+
+	sim := dplltest.NewSimulator()
+	pps := sim.AddDevice(dpll.DoDeviceGetReply{ModuleName: "ice", ClockID: clockID, Type: dpll.DpllTypePPS})
+	output, err := sim.AddPin(dpll.PinInfo{
+		ModuleName: "ice", ClockID: clockID, BoardLabel: "SMA2", Type: dpll.PinTypeEXT,
+		PhaseAdjustMin: -16000, PhaseAdjustMax: 16000, PhaseAdjustGran: 8, PhaseAdjust: 96,
+		ParentDevice: []dpll.PinParentDevice{{ParentID: pps, Direction: dpll.PinDirectionOutput, State: dpll.PinStateConnected}},
+	})
+	require.NoError(t, err)
+	loopback, err := sim.AddPin(dpll.PinInfo{
+		ModuleName: "ice", ClockID: clockID, BoardLabel: "SMA1", Type: dpll.PinTypeEXT,
+		ParentDevice: []dpll.PinParentDevice{{ParentID: pps, Direction: dpll.PinDirectionInput, State: dpll.PinStateConnected}},
+	})
+	require.NoError(t, err)
+	// the output leads the loopback input by 1334 ps
+	require.NoError(t, sim.SetPhaseOffset(loopback, pps, 1334*dpll.DpllPhaseOffsetDivider))
+	SetDpllDialer(sim.Dialer())
+	defer ResetDpllDialer()
+
+	conn := sim.Dial()
+	pins, err := conn.DumpPinGet()
+	require.NoError(t, err)
+	conn.Close()
+
+	newManager := func(store *PhaseCalibrationStore) *HardwareConfigManager {
+		hcm := newHardwareConfigManagerForTests()
+		hcm.pinApplier = BatchPinSet
+		hcm.pinCache = buildPinCacheFromPins(pins)
+		hcm.clockIDCache = map[string]uint64{"ens1f0:": clockID}
+		hcm.hardwareConfigs = []enrichedHardwareConfig{{HardwareConfig: ptpv2alpha1.HardwareConfig{
+			Spec: ptpv2alpha1.HardwareConfigSpec{
+				RelatedPtpProfileName: "test-profile",
+				Profile: ptpv2alpha1.HardwareProfile{
+					ClockChain: &ptpv2alpha1.ClockChain{
+						Structure: []ptpv2alpha1.Subsystem{{
+							Name:     "leader",
+							Ethernet: []ptpv2alpha1.Ethernet{{Ports: []string{"ens1f0"}}},
+						}},
+					},
+				},
+			},
+		}}}
+		hcm.SetPhaseCalibrationStore(store)
+		return hcm
+	}
+	client := fake.NewSimpleClientset()
+	hcm := newManager(NewPhaseCalibrationStore(client, "openshift-ptp", "node-1"))
+
+	_, err = hcm.CalibratePhaseAdjust("test-profile", PhaseCalibrationRequest{Subsystem: "follower", Output: "SMA2", Loopback: "SMA1"})
+	assert.Error(t, err, "unknown subsystem")
+	_, err = hcm.CalibratePhaseAdjust("test-profile", PhaseCalibrationRequest{Subsystem: "leader", Output: "SMA3", Loopback: "SMA1"})
+	assert.Error(t, err, "unknown output pin")
+	_, err = hcm.CalibratePhaseAdjust("test-profile", PhaseCalibrationRequest{
+		Subsystem: "leader", Output: "SMA2", Loopback: "SMA1", Samples: maxPhaseCalibrationSamples + 1,
+	})
+	assert.ErrorContains(t, err, "samples exceed the maximum")
+
+	calibration, err := hcm.CalibratePhaseAdjust("test-profile", PhaseCalibrationRequest{
+		Subsystem: "leader", Output: "SMA2", Loopback: "SMA1", Samples: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1334), calibration.PhaseOffset)
+	assert.Equal(t, int32(-1240), calibration.PhaseAdjust, "96 - 1334 ps rounded to the 8 ps granularity")
+	pin, _ := sim.Pin(output)
+	assert.Equal(t, int32(-1240), pin.PhaseAdjust)
+	records := PinAuditRecords(PinAuditFilter{Label: "SMA2", Limit: 1})
+	require.Len(t, records, 1)
+	assert.Equal(t, "phase-calibration", records[0].Source.Condition)
+	assert.True(t, records[0].Confirmed)
+
+	cm, err := client.CoreV1().ConfigMaps("openshift-ptp").Get(context.TODO(), defaultPhaseCalibrationConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	var stored []PhaseCalibration
+	require.NoError(t, yaml.Unmarshal([]byte(cm.Data["node-1"]), &stored))
+	require.Len(t, stored, 1)
+	assert.Equal(t, clockID, stored[0].ClockID)
+	assert.Equal(t, int32(-1240), stored[0].PhaseAdjust)
+
+	// a new calibration of the same output replaces the previous one, other nodes are kept
+	cm.Data["node-2"] = "[]"
+	_, err = client.CoreV1().ConfigMaps("openshift-ptp").Update(context.TODO(), cm, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, sim.SetPhaseOffset(loopback, pps, 0))
+	calibration, err = hcm.CalibratePhaseAdjust("test-profile", PhaseCalibrationRequest{
+		Subsystem: "leader", Output: "SMA2", Loopback: "SMA1", Samples: 1,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(-1240), calibration.PhaseAdjust)
+	require.Len(t, hcm.PhaseCalibrations(), 1)
+
+	// after a restart, the calibration is loaded and re-applied with the structure defaults
+	restarted := newManager(NewPhaseCalibrationStore(client, "openshift-ptp", "node-1"))
+	calibrations := restarted.PhaseCalibrations()
+	require.Len(t, calibrations, 1)
+	assert.Equal(t, "SMA1", calibrations[0].Loopback)
+	commands := restarted.buildPhaseCalibrationCommands(clockID)
+	require.Len(t, commands, 1)
+	assert.Equal(t, output, commands[0].ID)
+	assert.Equal(t, int32(-1240), *commands[0].PhaseAdjust)
+	assert.Empty(t, restarted.buildPhaseCalibrationCommands(clockID+1))
+
+	// the calibration replaces the stale phase adjustment of the cached structure commands
+	stale := int32(96)
+	restarted.hardwareConfigs[0].structurePinCommands = []dpll.PinParentDeviceCtl{{ID: output, PhaseAdjust: &stale}}
+	profileName := "ptpconfig_test-profile"
+	require.NoError(t, restarted.ApplyHardwareConfigsForProfile(&ptpv1.PtpProfile{Name: &profileName}))
+	pin, _ = sim.Pin(output)
+	assert.Equal(t, int32(-1240), pin.PhaseAdjust)
+	records = PinAuditRecords(PinAuditFilter{Label: "SMA2", Limit: 1})
+	require.Len(t, records, 1)
+	assert.Equal(t, "structure-defaults", records[0].Source.Condition)
+	assert.Equal(t, int32(-1240), *records[0].Requested.PhaseAdjust)
+
+	// the calibrations of the node are kept when they can't be loaded
+	failing := newManager(NewPhaseCalibrationStore(client, "openshift-ptp", "node-1"))
+	failGet := true
+	client.PrependReactor("get", "configmaps", func(clienttesting.Action) (bool, runtime.Object, error) {
+		if failGet {
+			failGet = false
+			return true, nil, fmt.Errorf("apiserver unavailable")
+		}
+		return false, nil, nil
+	})
+	_, err = failing.CalibratePhaseAdjust("test-profile", PhaseCalibrationRequest{Subsystem: "leader", Output: "SMA2", Loopback: "SMA1", Samples: 1})
+	assert.ErrorContains(t, err, "not persisted")
+	cm, err = client.CoreV1().ConfigMaps("openshift-ptp").Get(context.TODO(), defaultPhaseCalibrationConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	stored = nil
+	require.NoError(t, yaml.Unmarshal([]byte(cm.Data["node-1"]), &stored))
+	require.Len(t, stored, 1)
+	assert.Equal(t, "SMA1", stored[0].Loopback)
+
+	other := newManager(NewPhaseCalibrationStore(client, "openshift-ptp", "node-2"))
+	assert.Empty(t, other.PhaseCalibrations())
+}
+
+func TestPhaseCalibrationAnnotation(t *testing.T) {
+	const clockID uint64 = 0x507c6fffff1fb1b8
+	origInterval := phaseCalibrationInterval
+	phaseCalibrationInterval = 0
+	defer func() { phaseCalibrationInterval = origInterval }()
+
+	sim := dplltest.NewSimulator()
+	pps := sim.AddDevice(dpll.DoDeviceGetReply{ModuleName: "ice", ClockID: clockID, Type: dpll.DpllTypePPS})
+	output, err := sim.AddPin(dpll.PinInfo{
+		ModuleName: "ice", ClockID: clockID, BoardLabel: "SMA2", Type: dpll.PinTypeEXT,
+		PhaseAdjustMin: -16000, PhaseAdjustMax: 16000, PhaseAdjustGran: 8,
+		ParentDevice: []dpll.PinParentDevice{{ParentID: pps, Direction: dpll.PinDirectionOutput, State: dpll.PinStateConnected}},
+	})
+	require.NoError(t, err)
+	loopback, err := sim.AddPin(dpll.PinInfo{
+		ModuleName: "ice", ClockID: clockID, BoardLabel: "SMA1", Type: dpll.PinTypeEXT,
+		ParentDevice: []dpll.PinParentDevice{{ParentID: pps, Direction: dpll.PinDirectionInput, State: dpll.PinStateConnected}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, sim.SetPhaseOffset(loopback, pps, 800*dpll.DpllPhaseOffsetDivider))
+	SetDpllDialer(sim.Dialer())
+	defer ResetDpllDialer()
+
+	conn := sim.Dial()
+	pins, err := conn.DumpPinGet()
+	require.NoError(t, err)
+	conn.Close()
+
+	origLog := pinAuditLog
+	pinAuditLog = NewPinAuditLog(DefaultPinAuditLogSize)
+	defer func() { pinAuditLog = origLog }()
+	calibrationRecords := func() int {
+		count := 0
+		for _, record := range PinAuditRecords(PinAuditFilter{Label: "SMA2"}) {
+			if record.Source.Condition == "phase-calibration" {
+				count++
+			}
+		}
+		return count
+	}
+
+	hcm := newHardwareConfigManagerForTests()
+	hcm.pinApplier = BatchPinSet
+	hcm.pinCache = buildPinCacheFromPins(pins)
+	hcm.clockIDCache = map[string]uint64{"ens1f0:": clockID}
+	// apply resolves the annotated requests of the hardware config and applies its PTP profile
+	apply := func(annotation string) error {
+		hw := ptpv2alpha1.HardwareConfig{
+			Spec: ptpv2alpha1.HardwareConfigSpec{
+				RelatedPtpProfileName: "test-profile",
+				Profile: ptpv2alpha1.HardwareProfile{
+					ClockChain: &ptpv2alpha1.ClockChain{
+						Structure: []ptpv2alpha1.Subsystem{{
+							Name:     "leader",
+							Ethernet: []ptpv2alpha1.Ethernet{{Ports: []string{"ens1f0"}}},
+						}},
+					},
+				},
+			},
+		}
+		hw.Name = "test-hwconfig"
+		hw.Annotations = map[string]string{PhaseCalibrationAnnotation: annotation}
+		requests, err := phaseCalibrationRequests(hw)
+		if err != nil {
+			return err
+		}
+		hcm.hardwareConfigs = []enrichedHardwareConfig{{HardwareConfig: hw, phaseCalibrationRequests: requests}}
+		profileName := "ptpconfig_test-profile"
+		return hcm.ApplyHardwareConfigsForProfile(&ptpv1.PtpProfile{Name: &profileName})
+	}
+
+	for _, annotation := range []string{
+		`subsystem: leader`,
+		`[{"subsystem": "leader", "output": "SMA2", "loopbak": "SMA1"}]`,
+		`[{"subsystem": "leader", "output": "SMA2"}]`,
+		`[{"subsystem": "leader", "output": "SMA2", "loopback": "SMA1", "samples": 301}]`,
+	} {
+		assert.ErrorContains(t, apply(annotation), PhaseCalibrationAnnotation, annotation)
+	}
+
+	// the annotated output is calibrated in the background
+	annotation := `
+- subsystem: leader
+  output: SMA2
+  loopback: SMA1
+  samples: 2
+`
+	require.NoError(t, apply(annotation))
+	assert.Eventually(t, func() bool { return len(hcm.PhaseCalibrations()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(-800), hcm.PhaseCalibrations()[0].PhaseAdjust)
+	pin, _ := sim.Pin(output)
+	assert.Equal(t, int32(-800), pin.PhaseAdjust)
+	assert.Equal(t, 1, calibrationRecords())
+
+	// applying the profile again doesn't calibrate the same annotation again
+	require.NoError(t, apply(annotation))
+	assert.Never(t, func() bool { return calibrationRecords() > 1 }, 200*time.Millisecond, 10*time.Millisecond)
+
+	// changing the annotation calibrates again
+	require.NoError(t, apply(annotation+"\n"+`- {subsystem: leader, output: SMA2, loopback: SMA1, samples: 1}`))
+	assert.Eventually(t, func() bool { return calibrationRecords() == 3 }, 2*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,240 @@
+package hardwareconfig
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	dpll "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+)
+
+// DefaultPinAuditLogSize is the number of pin commands kept in the audit log
+const DefaultPinAuditLogSize = 512
+
+// Pin command source components
+const (
+	PinSourceHardwareConfig = "hardwareconfig"
+	PinSourceDpllQualifier  = "dpll-qualifier"
+)
+
+// PinCommandSource identifies who issued a DPLL pin command and why
+type PinCommandSource struct {
+	// Component is the issuer, e.g. hardwareconfig or a plugin name
+	Component string `json:"component"`
+	// Profile is the PTP profile the command was issued for, if any
+	Profile string `json:"profile,omitempty"`
+	// Condition is the reason of the command, e.g. a behavior condition name, structure-defaults
+	// for vendor defaults, or the plugin action
+	Condition string `json:"condition,omitempty"`
+}
+
+// PinAuditParentState is the state of a pin towards one of its parent devices or pins
+type PinAuditParentState struct {
+	ParentID  uint32  `json:"parentId"`
+	Direction string  `json:"direction,omitempty"`
+	State     string  `json:"state,omitempty"`
+	Prio      *uint32 `json:"prio,omitempty"`
+}
+
+// PinAuditState is the state of a pin, or the part of it a command requests
+type PinAuditState struct {
+	Frequency      *uint64               `json:"frequency,omitempty"`
+	PhaseAdjust    *int32                `json:"phaseAdjust,omitempty"`
+	EsyncFrequency *uint64               `json:"esyncFrequency,omitempty"`
+	Parents        []PinAuditParentState `json:"parents,omitempty"`
+	// Mode is the mode of a DPLL device, for the device commands
+	Mode string `json:"mode,omitempty"`
+}
+
+// PinAuditRecord records a DPLL pin command with the pin state before and after it was applied.
+// After is read back from a fresh pin dump once the whole batch was sent. DPLL device mode
+// commands are recorded with their DeviceID and the device mode instead.
+type PinAuditRecord struct {
+	Seq       uint64           `json:"seq"`
+	Time      time.Time        `json:"time"`
+	Source    PinCommandSource `json:"source"`
+	PinID     uint32           `json:"pinId"`
+	DeviceID  *uint32          `json:"deviceId,omitempty"`
+	ClockID   uint64           `json:"clockId,omitempty"`
+	Label     string           `json:"label,omitempty"`
+	Before    *PinAuditState   `json:"before,omitempty"`
+	Requested PinAuditState    `json:"requested"`
+	After     *PinAuditState   `json:"after,omitempty"`
+	// Confirmed is set when the state read back matches the requested state
+	Confirmed bool   `json:"confirmed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// PinAuditFilter selects audit records, zero values match everything
+type PinAuditFilter struct {
+	Component string
+	Label     string
+	ClockID   uint64
+	// Limit returns only the latest records
+	Limit int
+}
+
+func (f PinAuditFilter) match(r *PinAuditRecord) bool {
+	return (f.Component == "" || f.Component == r.Source.Component) &&
+		(f.Label == "" || f.Label == r.Label) &&
+		(f.ClockID == 0 || f.ClockID == r.ClockID)
+}
+
+// PinAuditLog is a bounded log of the DPLL pin commands, dropping the oldest records first
+type PinAuditLog struct {
+	sync.Mutex
+	size    int
+	seq     uint64
+	records []PinAuditRecord
+}
+
+// NewPinAuditLog creates an audit log keeping the latest size records
+func NewPinAuditLog(size int) *PinAuditLog {
+	return &PinAuditLog{size: size, records: make([]PinAuditRecord, 0, size)}
+}
+
+// Add appends records to the log, numbering them
+func (l *PinAuditLog) Add(records ...PinAuditRecord) {
+	l.Lock()
+	defer l.Unlock()
+	for _, r := range records {
+		l.seq++
+		r.Seq = l.seq
+		l.records = append(l.records, r)
+	}
+	if overflow := len(l.records) - l.size; overflow > 0 {
+		l.records = slices.Delete(l.records, 0, overflow)
+	}
+}
+
+// Records returns the records matching the filter, oldest first
+func (l *PinAuditLog) Records(filter PinAuditFilter) []PinAuditRecord {
+	l.Lock()
+	defer l.Unlock()
+	result := make([]PinAuditRecord, 0, len(l.records))
+	for i := range l.records {
+		if filter.match(&l.records[i]) {
+			result = append(result, l.records[i])
+		}
+	}
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[len(result)-filter.Limit:]
+	}
+	return result
+}
+
+// pinAuditLog records the commands of BatchPinSet and ApplyDPLLModeCommands
+var pinAuditLog = NewPinAuditLog(DefaultPinAuditLogSize)
+
+// PinAuditRecords returns the audited DPLL pin commands matching the filter, oldest first
+func PinAuditRecords(filter PinAuditFilter) []PinAuditRecord {
+	return pinAuditLog.Records(filter)
+}
+
+// newPinAuditRecord starts the record of a pin command, before is nil when the pin is unknown
+func newPinAuditRecord(source PinCommandSource, command dpll.PinParentDeviceCtl, before *dpll.PinInfo) PinAuditRecord {
+	record := PinAuditRecord{
+		Time:      time.Now(),
+		Source:    source,
+		PinID:     command.ID,
+		Requested: requestedPinState(command),
+	}
+	if before != nil {
+		record.ClockID = before.ClockID
+		record.Label = dpll.GetPinLabel(before)
+		record.Before = observedPinState(before)
+	}
+	return record
+}
+
+// completePinAuditRecord sets the state read back after the command, after is nil when the pin is gone
+func completePinAuditRecord(record *PinAuditRecord, command dpll.PinParentDeviceCtl, after *dpll.PinInfo) {
+	if after == nil {
+		if record.Error == "" {
+			record.Error = "pin not found after the command"
+		}
+		return
+	}
+	record.ClockID = after.ClockID
+	record.Label = dpll.GetPinLabel(after)
+	record.After = observedPinState(after)
+	record.Confirmed = record.Error == "" && pinCommandApplied(command, after)
+}
+
+// requestedPinState returns the part of the pin state a command requests
+func requestedPinState(command dpll.PinParentDeviceCtl) PinAuditState {
+	state := PinAuditState{
+		Frequency:      command.Frequency,
+		PhaseAdjust:    command.PhaseAdjust,
+		EsyncFrequency: command.EsyncFrequency,
+	}
+	for _, pc := range command.PinParentCtl {
+		parent := PinAuditParentState{ParentID: pc.PinParentID, Prio: pc.Prio}
+		if pc.Direction != nil {
+			parent.Direction = dpll.GetPinDirection(*pc.Direction)
+		}
+		if pc.State != nil {
+			parent.State = dpll.GetPinState(*pc.State)
+		}
+		state.Parents = append(state.Parents, parent)
+	}
+	return state
+}
+
+// observedPinState returns the state of a pin as reported by the kernel
+func observedPinState(pin *dpll.PinInfo) *PinAuditState {
+	frequency, phaseAdjust, esync := pin.Frequency, pin.PhaseAdjust, uint64(pin.EsyncFrequency)
+	state := &PinAuditState{Frequency: &frequency, PhaseAdjust: &phaseAdjust, EsyncFrequency: &esync}
+	for _, pd := range pin.ParentDevice {
+		parent := PinAuditParentState{
+			ParentID:  pd.ParentID,
+			Direction: dpll.GetPinDirection(pd.Direction),
+			State:     dpll.GetPinState(pd.State),
+		}
+		if pd.Prio != nil {
+			prio := *pd.Prio
+			parent.Prio = &prio
+		}
+		state.Parents = append(state.Parents, parent)
+	}
+	for _, pp := range pin.ParentPin {
+		state.Parents = append(state.Parents, PinAuditParentState{ParentID: pp.ParentID, State: dpll.GetPinState(pp.State)})
+	}
+	return state
+}
+
+// pinCommandApplied reports whether the pin state matches every field requested by the command
+func pinCommandApplied(command dpll.PinParentDeviceCtl, pin *dpll.PinInfo) bool {
+	if command.Frequency != nil && *command.Frequency != pin.Frequency {
+		return false
+	}
+	if command.PhaseAdjust != nil && *command.PhaseAdjust != pin.PhaseAdjust {
+		return false
+	}
+	if command.EsyncFrequency != nil && *command.EsyncFrequency != uint64(pin.EsyncFrequency) {
+		return false
+	}
+	for _, pc := range command.PinParentCtl {
+		if !parentControlApplied(pc, pin) {
+			return false
+		}
+	}
+	return true
+}
+
+func parentControlApplied(pc dpll.PinControl, pin *dpll.PinInfo) bool {
+	for _, pd := range pin.ParentDevice {
+		if pd.ParentID != pc.PinParentID {
+			continue
+		}
+		return (pc.Direction == nil || *pc.Direction == pd.Direction) &&
+			(pc.State == nil || *pc.State == pd.State) &&
+			(pc.Prio == nil || (pd.Prio != nil && *pc.Prio == *pd.Prio))
+	}
+	for _, pp := range pin.ParentPin {
+		if pp.ParentID == pc.PinParentID {
+			return pc.State == nil || *pc.State == pp.State
+		}
+	}
+	return false
+}
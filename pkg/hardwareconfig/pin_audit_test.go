@@ -0,0 +1,77 @@
+package hardwareconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dpll "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll-netlink/dplltest"
+)
+
+func TestBatchPinSetAudit(t *testing.T) {
+	const clockID uint64 = 0x507c6fffff1fb1b8
+	origLog := pinAuditLog
+	pinAuditLog = NewPinAuditLog(DefaultPinAuditLogSize)
+	defer func() { pinAuditLog = origLog }()
+
+	sim := dplltest.NewSimulator()
+	eec := sim.AddDevice(dpll.DoDeviceGetReply{ModuleName: "ice", ClockID: clockID, Type: dpll.DpllTypeEEC})
+	prio := func(v uint32) *uint32 { return &v }
+	sma, err := sim.AddPin(dpll.PinInfo{
+		ModuleName: "ice", ClockID: clockID, BoardLabel: "SMA1", Type: dpll.PinTypeEXT,
+		Capabilities: dpll.PinCapPrio | dpll.PinCapState,
+		ParentDevice: []dpll.PinParentDevice{{ParentID: eec, Direction: dpll.PinDirectionInput, Prio: prio(3)}},
+	})
+	require.NoError(t, err)
+	synce, err := sim.AddPin(dpll.PinInfo{
+		ModuleName: "ice", ClockID: clockID, PackageLabel: "RCLKA", Type: dpll.PinTypeSYNCE,
+		ParentDevice: []dpll.PinParentDevice{{ParentID: eec, Direction: dpll.PinDirectionInput, Prio: prio(8)}},
+	})
+	require.NoError(t, err)
+	SetDpllDialer(sim.Dialer())
+	defer ResetDpllDialer()
+
+	source := PinCommandSource{Component: PinSourceHardwareConfig, Profile: "test-profile", Condition: "structure-defaults"}
+	err = BatchPinSet(source, []dpll.PinParentDeviceCtl{
+		{ID: sma, PinParentCtl: []dpll.PinControl{{PinParentID: eec, Prio: prio(1)}}},
+		// rejected by the driver: the pin has no priority capability
+		{ID: synce, PinParentCtl: []dpll.PinControl{{PinParentID: eec, Prio: prio(2)}}},
+	})
+	require.NoError(t, err)
+
+	records := PinAuditRecords(PinAuditFilter{})
+	require.Len(t, records, 2)
+	assert.Equal(t, source, records[0].Source)
+	assert.Equal(t, "SMA1", records[0].Label)
+	assert.Equal(t, clockID, records[0].ClockID)
+	assert.Equal(t, uint32(3), *records[0].Before.Parents[0].Prio)
+	assert.Equal(t, uint32(1), *records[0].Requested.Parents[0].Prio)
+	assert.Equal(t, uint32(1), *records[0].After.Parents[0].Prio)
+	assert.True(t, records[0].Confirmed)
+
+	assert.Equal(t, "RCLKA", records[1].Label, "pins without board label use their package label")
+	assert.Equal(t, uint32(8), *records[1].After.Parents[0].Prio)
+	assert.False(t, records[1].Confirmed)
+	assert.Less(t, records[0].Seq, records[1].Seq)
+
+	assert.Len(t, PinAuditRecords(PinAuditFilter{Label: "SMA1"}), 1)
+	assert.Empty(t, PinAuditRecords(PinAuditFilter{Component: "e810"}))
+	assert.Empty(t, PinAuditRecords(PinAuditFilter{ClockID: clockID + 1}))
+}
+
+func TestPinAuditLog(t *testing.T) {
+	log := NewPinAuditLog(3)
+	for _, label := range []string{"SMA1", "SMA2", "U.FL1", "U.FL2"} {
+		log.Add(PinAuditRecord{Label: label, Source: PinCommandSource{Component: "e810"}})
+	}
+	records := log.Records(PinAuditFilter{})
+	require.Len(t, records, 3, "the oldest record is dropped")
+	assert.Equal(t, "SMA2", records[0].Label)
+	assert.Equal(t, uint64(4), records[2].Seq)
+
+	records = log.Records(PinAuditFilter{Component: "e810", Limit: 1})
+	require.Len(t, records, 1)
+	assert.Equal(t, "U.FL2", records[0].Label)
+}
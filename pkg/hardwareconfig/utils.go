@@ -45,6 +45,19 @@ func ResetDpllPinsGetter() {
 	dpllPinsGetter = defaultDpllPinsGetter
 }
 
+// dpllDialer opens the DPLL netlink connections (can be swapped for testing)
+var dpllDialer dpll.Dialer = dpll.DefaultDialer
+
+// SetDpllDialer allows tests to connect to a simulated DPLL netlink family
+func SetDpllDialer(dial dpll.Dialer) {
+	dpllDialer = dial
+}
+
+// ResetDpllDialer resets to the kernel DPLL netlink family
+func ResetDpllDialer() {
+	dpllDialer = dpll.DefaultDialer
+}
+
 // GetDpllPins returns the DPLL pin cache using the current getter implementation
 func GetDpllPins() (*PinCache, error) {
 	return dpllPinsGetter()
@@ -295,7 +308,7 @@ var netdevDpllPinGetter = getNetdevDpllPin
 
 // queryDpllPin queries a single DPLL pin by ID using a new DPLL netlink connection.
 func queryDpllPin(pinID uint32) (*dpll.PinInfo, error) {
-	conn, err := dpll.Dial(nil)
+	conn, err := dpllDialer()
 	if err != nil {
 		return nil, fmt.Errorf("failed to dial DPLL: %w", err)
 	}
@@ -384,16 +397,26 @@ const (
 	ClockIDMethodDirect          = "direct"          // PCI serial number bytes used directly (E810)
 	ClockIDMethodEUI64           = "eui64"           // EUI-64 transform of serial number
 	ClockIDMethodDevlinkPinChain = "devlinkPinChain" // Trace NIC DPLL pin parent chain (E825/zl3073x)
+	ClockIDMethodNetdevPin       = "netdevPin"       // Follow the netdev DPLL pin link to its DPLL (any driver)
 )
 
+// configuredClockIDMethod returns the clock ID resolution method of the hardware defaults, empty if not configured
+func configuredClockIDMethod(hwDefPath string) string {
+	if hwDefPath == "" {
+		return ""
+	}
+	spec, err := LoadHardwareDefaults(hwDefPath, nil)
+	if err == nil && spec != nil && spec.ClockIDTransformation != nil {
+		return spec.ClockIDTransformation.Method
+	}
+	return ""
+}
+
 // getClockIDResolutionMethod loads the hardware defaults and returns the configured method.
 // Falls back to lspci-based detection when no hwDefPath is provided (legacy compatibility).
 func getClockIDResolutionMethod(hwDefPath string, busAddr string) string {
-	if hwDefPath != "" {
-		spec, err := LoadHardwareDefaults(hwDefPath, nil)
-		if err == nil && spec != nil && spec.ClockIDTransformation != nil && spec.ClockIDTransformation.Method != "" {
-			return spec.ClockIDTransformation.Method
-		}
+	if method := configuredClockIDMethod(hwDefPath); method != "" {
+		return method
 	}
 
 	// Legacy fallback: detect E825 via lspci when no hwDefPath or no method configured
@@ -410,7 +433,15 @@ func getClockIDResolutionMethod(hwDefPath string, busAddr string) string {
 // The resolution method is determined by the hardware definition's clockIdTransformation.method:
 //   - "direct" / "eui64": derive clock ID from NIC's PCI serial number (devlink)
 //   - "devlinkPinChain": trace NIC's DPLL pin parent chain to external DPLL module
+//   - "netdevPin": follow the NIC's netdev DPLL pin link to its DPLL, without vendor specific tools
+//
+// When the serial number derivation fails, e.g. for NICs without a devlink serial number,
+// the netdev DPLL pin link is used.
 func GetClockIDFromInterfaceWithCache(iface string, hwDefPath string, pinCache *PinCache) (uint64, error) {
+	if configuredClockIDMethod(hwDefPath) == ClockIDMethodNetdevPin {
+		glog.Infof("ClockID resolution for %s: method=%s (hwDef=%s)", iface, ClockIDMethodNetdevPin, hwDefPath)
+		return GetClockIDFromNetdevPin(iface)
+	}
 	ethtoolOutput, err := commandExecutor.Execute("ethtool", "-i", iface)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get bus info for interface %s: %w", iface, err)
@@ -434,7 +465,16 @@ func GetClockIDFromInterfaceWithCache(iface string, hwDefPath string, pinCache *
 	case ClockIDMethodDevlinkPinChain:
 		return resolveClockIDViaPinChain(iface, pinCache)
 	default:
-		return resolveClockIDViaSerialNumber(iface, busAddr, hwDefPath)
+		clockID, serialErr := resolveClockIDViaSerialNumber(iface, busAddr, hwDefPath)
+		if serialErr == nil {
+			return clockID, nil
+		}
+		clockID, err = GetClockIDFromNetdevPin(iface)
+		if err != nil {
+			return 0, fmt.Errorf("clock ID resolution failed for %s: serial number: %v, netdev pin: %w", iface, serialErr, err)
+		}
+		glog.Warningf("Serial number clock ID derivation failed for %s: %v; using the netdev DPLL pin link", iface, serialErr)
+		return clockID, nil
 	}
 }
 
@@ -568,7 +608,7 @@ func (pc *PinCache) GetPin(clockID uint64, boardLabel string) (*dpll.PinInfo, bo
 
 // getRealDpllPins connects to the real DPLL and returns the DPLL pin cache
 func getRealDpllPins() (*PinCache, error) {
-	conn, err := dpll.Dial(nil)
+	conn, err := dpllDialer()
 	if err != nil {
 		return nil, fmt.Errorf("failed to dial DPLL: %v", err)
 	}
@@ -639,35 +679,92 @@ func GetPinStateUint32(s string) (uint32, error) {
 	return 0, fmt.Errorf("invalid pin state: %s", s)
 }
 
-// BatchPinSet applies a batch of DPLL pin commands
-func BatchPinSet(commands []dpll.PinParentDeviceCtl) error {
-	conn, err := dpll.Dial(nil)
+// BatchPinSet applies a batch of DPLL pin commands issued by source. Every command is recorded
+// in the pin audit log with the pin state before the batch and the state read back after it.
+func BatchPinSet(source PinCommandSource, commands []dpll.PinParentDeviceCtl) error {
+	conn, err := dpllDialer()
 	if err != nil {
 		return fmt.Errorf("failed to dial DPLL: %v", err)
 	}
 	//nolint:errcheck
 	defer conn.Close()
+	before, dumpErr := conn.DumpPinGet()
+	if dumpErr != nil {
+		glog.Warningf("failed to dump pins before the pin commands of %s: %v", source.Component, dumpErr)
+	}
+	records := make([]PinAuditRecord, 0, len(commands))
+	sent := make([]dpll.PinParentDeviceCtl, 0, len(commands))
+	defer func() { auditPinCommands(records, sent) }()
+
 	for _, command := range commands {
-		glog.Infof("DPLL pin command %s", formatDpllPinCommand(command))
+		glog.Infof("DPLL pin command %s (source %s %s %s)", formatDpllPinCommand(command), source.Component, source.Profile, source.Condition)
+		record := newPinAuditRecord(source, command, findPin(before, command.ID))
 		b, encodeErr := dpll.EncodePinControl(command)
 		if encodeErr != nil {
+			record.Error = encodeErr.Error()
+			records, sent = append(records, record), append(sent, command)
 			return encodeErr
 		}
 		err = conn.SendCommand(dpll.DpllCmdPinSet, b)
 		if err != nil {
 			glog.Error("failed to send pin command: ", err)
+			record.Error = err.Error()
+		}
+		records, sent = append(records, record), append(sent, command)
+		if err != nil {
 			return err
 		}
-		// Read back the pin to confirm the command was applied, and log it as a
-		// table (see LogPinConfirmation) since LogPinTable never shows output
-		// pins and reflects state as of the next notification, not this write.
-		info, getErr := conn.DoPinGet(dpll.DoPinGetRequest{ID: command.ID})
-		if getErr != nil {
-			glog.Error("failed to get pin: ", getErr)
-			//TODO: handle properly after RHEL-137801 is fixed
-			return nil
+	}
+	return nil
+}
+
+// auditPinCommands reads the pins back after a batch of pin commands, logs them as a table
+// (see LogPinConfirmation) since LogPinTable never shows output pins and reflects state as of
+// the next notification, and adds the completed records to the pin audit log.
+// The pins are dumped on a new connection: the pin-set commands are not acknowledged, so the
+// errors of rejected commands are pending on the connection used to send them.
+func auditPinCommands(records []PinAuditRecord, commands []dpll.PinParentDeviceCtl) {
+	if len(records) == 0 {
+		return
+	}
+	var after []*dpll.PinInfo
+	conn, err := dpllDialer()
+	if err == nil {
+		after, err = conn.DumpPinGet()
+		//nolint:errcheck
+		conn.Close()
+	}
+	if err != nil {
+		//TODO: handle properly after RHEL-137801 is fixed
+		glog.Error("failed to dump pins after the pin commands: ", err)
+		for i := range records {
+			if records[i].Error == "" {
+				records[i].Error = fmt.Sprintf("failed to read the pin back: %v", err)
+			}
+		}
+		pinAuditLog.Add(records...)
+		return
+	}
+	for i := range records {
+		pin := findPin(after, commands[i].ID)
+		completePinAuditRecord(&records[i], commands[i], pin)
+		if pin != nil && records[i].Error == "" {
+			dpll.LogPinConfirmation(pin)
+		}
+		if !records[i].Confirmed {
+			glog.Warningf("DPLL pin %d (%s) command by %s not confirmed: %s", records[i].PinID, records[i].Label,
+				records[i].Source.Component, formatDpllPinCommand(commands[i]))
+		}
+	}
+	pinAuditLog.Add(records...)
+}
+
+// findPin returns the pin with the ID, nil when not found
+func findPin(pins []*dpll.PinInfo, id uint32) *dpll.PinInfo {
+	for _, pin := range pins {
+		if pin.ID == id {
+			return pin
 		}
-		dpll.LogPinConfirmation(info)
 	}
 	return nil
 }
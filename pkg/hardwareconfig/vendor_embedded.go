@@ -11,6 +11,7 @@ const (
 	HwDefIntelE830     = "intel/e830"
 	HwDefDellXR8720t   = "dell/XR8720t"
 	HwDefHPEEL140Gen12 = "hpe/EL140-Gen12"
+	HwDefGenericDPLL   = "generic/dpll"
 )
 
 // Clock type constants matching the values defined in the ptp-operator API.
@@ -37,6 +38,9 @@ var hpeEL140Gen12DefaultsYAML []byte
 //go:embed hardware-vendor/intel/e830/defaults.yaml
 var intelE830DefaultsYAML []byte
 
+//go:embed hardware-vendor/generic/dpll/defaults.yaml
+var genericDPLLDefaultsYAML []byte
+
 //go:embed hardware-vendor/intel/e810/behavior-profiles.yaml
 var intelE810BehaviorProfilesYAML []byte
 
@@ -66,6 +70,7 @@ var embeddedDefaults = map[string][]byte{
 	HwDefIntelE810:     intelE810DefaultsYAML,
 	HwDefIntelE825:     intelE825DefaultsYAML,
 	HwDefIntelE830:     intelE830DefaultsYAML,
+	HwDefGenericDPLL:   genericDPLLDefaultsYAML,
 }
 
 // embeddedBehaviorProfiles maps hwDefPath -> raw YAML contents for behavior profiles.
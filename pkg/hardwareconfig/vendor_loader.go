@@ -73,6 +73,8 @@ type ClockIDTransformation struct {
 	// Method specifies the transformation algorithm
 	// "direct" - use serial number bytes directly (e.g., Intel E810: keeps ff-ff in middle)
 	// "eui64" - EUI-64 format: remove bytes 3-4 (ff-ff) and insert ff-fe
+	// "devlinkPinChain" - trace the NIC DPLL pin parent chain to the external DPLL module
+	// "netdevPin" - follow the netdev DPLL pin link to its DPLL, for any driver exposing the DPLL netlink API
 	Method string `json:"method,omitempty" yaml:"method,omitempty"`
 }
 
@@ -0,0 +1,177 @@
+package ipc
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// MinVersion is the oldest IPC protocol version still spoken. Peers of version 1 do not send a
+// hello message, and take control commands as plain text lines.
+const MinVersion = 1
+
+// Control commands.
+const (
+	// CommandRestart asks cloud-event-proxy to drop its state, as the daemon restarts its processes.
+	CommandRestart = "restart"
+	// CommandLiveStart marks the end of the log replay on a process connection, all following data is live.
+	CommandLiveStart = "live_start"
+)
+
+// legacyControlPrefix starts the plain text control commands of protocol version 1
+const legacyControlPrefix = "CMD "
+
+// typeVersions is the protocol version each message type was introduced in
+var typeVersions = map[string]int{
+	TypePTPState:          1,
+	TypeOSClockState:      1,
+	TypeClockClass:        1,
+	TypeGNSSState:         1,
+	TypeSyncEState:        1,
+	TypeSyncEClockQuality: 1,
+	TypeSyncState:         1,
+	TypeCacheClear:        1,
+	TypeStatusRequest:     1,
+	TypeStatusResponse:    1,
+	TypeOffsetSummary:     2,
+	TypeHello:             2,
+	TypeControl:           2,
+	TypePortState:         2,
+	TypeTimeErrorMask:     2,
+}
+
+// HelloValue announces the protocol versions and message types a peer supports. Each peer sends it
+// first on every connection.
+type HelloValue struct {
+	Versions []int    `json:"versions"`
+	Types    []string `json:"types"`
+}
+
+// Value implements Value.
+func (HelloValue) Value() {}
+
+// ControlValue carries a control command.
+type ControlValue struct {
+	Command string `json:"command"`
+}
+
+// Value implements Value.
+func (ControlValue) Value() {}
+
+// Legacy returns the command as the plain text line of protocol version 1, without the newline.
+func (c ControlValue) Legacy() string {
+	return legacyControlPrefix + strings.ToUpper(c.Command)
+}
+
+// ParseLegacyControl parses a plain text control command line of protocol version 1.
+func ParseLegacyControl(line string) (ControlValue, bool) {
+	cmd, ok := strings.CutPrefix(strings.TrimSpace(line), legacyControlPrefix)
+	if !ok || cmd == "" {
+		return ControlValue{}, false
+	}
+	return ControlValue{Command: strings.ToLower(cmd)}, true
+}
+
+// KnownType returns whether the message type is part of the supported protocol versions.
+func KnownType(t string) bool {
+	_, ok := typeVersions[t]
+	return ok
+}
+
+// NewHello returns the hello message announcing the versions and message types of this side.
+func NewHello() Message {
+	versions := make([]int, 0, Version-MinVersion+1)
+	for v := MinVersion; v <= Version; v++ {
+		versions = append(versions, v)
+	}
+	types := make([]string, 0, len(typeVersions))
+	for t := range typeVersions {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	return Message{Version: Version, Type: TypeHello, Values: HelloValue{Versions: versions, Types: types}}
+}
+
+// Session is the protocol agreed with a peer by the handshake.
+type Session struct {
+	// Version is the highest protocol version both peers support, messages are sent with this version
+	Version int
+	types   map[string]bool
+}
+
+// Negotiate returns the session agreed with a peer that sent the given hello. It fails when the peer
+// supports none of our protocol versions.
+func Negotiate(peer HelloValue) (*Session, error) {
+	version := 0
+	for _, v := range peer.Versions {
+		if v >= MinVersion && v <= Version && v > version {
+			version = v
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("no common IPC protocol version: peer supports %v, we support %d..%d", peer.Versions, MinVersion, Version)
+	}
+	s := &Session{Version: version, types: map[string]bool{}}
+	for _, t := range peer.Types {
+		if v, ok := typeVersions[t]; ok && v <= version {
+			s.types[t] = true
+		}
+	}
+	return s, nil
+}
+
+// Supports returns whether the message type may be sent to the peer. Without a session, the peer did
+// not send a hello and only the message types of protocol version 1 are sent.
+func (s *Session) Supports(t string) bool {
+	if s == nil {
+		return typeVersions[t] == MinVersion
+	}
+	return s.types[t]
+}
+
+// MessageVersion returns the version messages are sent with.
+func (s *Session) MessageVersion() int {
+	if s == nil {
+		return MinVersion
+	}
+	return s.Version
+}
+
+// Unsupported returns our message types the peer does not support in the session, sorted.
+func (s *Session) Unsupported() []string {
+	var out []string
+	for t := range typeVersions {
+		if !s.Supports(t) {
+			out = append(out, t)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// UnknownTypes returns the message types of the hello that are not part of our protocol, sorted.
+func (h HelloValue) UnknownTypes() []string {
+	var out []string
+	for _, t := range h.Types {
+		if !KnownType(t) {
+			out = append(out, t)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// EncodeControl returns the control command as a line for the peer, without the newline: a control
+// message, or the plain text command when the peer does not support control messages.
+func (s *Session) EncodeControl(command string) string {
+	c := ControlValue{Command: command}
+	if !s.Supports(TypeControl) {
+		return c.Legacy()
+	}
+	data, err := json.Marshal(Message{Version: s.MessageVersion(), Type: TypeControl, Values: c})
+	if err != nil {
+		return c.Legacy()
+	}
+	return string(data)
+}
@@ -0,0 +1,88 @@
+package ipc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHelloJSON(t *testing.T) {
+	hello := NewHello()
+	data, err := json.Marshal(hello)
+	require.NoError(t, err)
+
+	var got Message
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, hello, got)
+	v := got.Values.(HelloValue)
+	assert.Equal(t, []int{MinVersion, Version}, v.Versions)
+	assert.Contains(t, v.Types, TypeControl)
+	assert.Empty(t, v.UnknownTypes())
+}
+
+func TestNegotiate(t *testing.T) {
+	t.Run("same protocol", func(t *testing.T) {
+		s, err := Negotiate(NewHello().Values.(HelloValue))
+		require.NoError(t, err)
+		assert.Equal(t, Version, s.Version)
+		assert.Equal(t, Version, s.MessageVersion())
+		assert.Empty(t, s.Unsupported())
+		assert.True(t, s.Supports(TypeControl))
+	})
+
+	t.Run("downgrade to an older peer", func(t *testing.T) {
+		s, err := Negotiate(HelloValue{Versions: []int{1}, Types: []string{TypePTPState, TypeControl}})
+		require.NoError(t, err)
+		assert.Equal(t, 1, s.Version)
+		assert.True(t, s.Supports(TypePTPState))
+		assert.False(t, s.Supports(TypeControl), "control messages need version 2")
+		assert.Contains(t, s.Unsupported(), TypeOffsetSummary)
+	})
+
+	t.Run("newer peer", func(t *testing.T) {
+		peer := HelloValue{Versions: []int{Version, Version + 1}, Types: []string{TypePTPState, "dpll_state"}}
+		s, err := Negotiate(peer)
+		require.NoError(t, err)
+		assert.Equal(t, Version, s.Version)
+		assert.Equal(t, []string{"dpll_state"}, peer.UnknownTypes())
+		assert.False(t, s.Supports("dpll_state"))
+	})
+
+	t.Run("no common version", func(t *testing.T) {
+		_, err := Negotiate(HelloValue{Versions: []int{Version + 1}})
+		assert.Error(t, err)
+	})
+
+	t.Run("no hello", func(t *testing.T) {
+		var s *Session
+		assert.Equal(t, MinVersion, s.MessageVersion())
+		assert.True(t, s.Supports(TypePTPState))
+		assert.False(t, s.Supports(TypeControl))
+		assert.False(t, s.Supports(TypeOffsetSummary), "offset summaries are held back from peers without a hello")
+		assert.False(t, s.Supports("unknown"))
+	})
+}
+
+func TestControl(t *testing.T) {
+	var legacy *Session
+	assert.Equal(t, "CMD RESTART", legacy.EncodeControl(CommandRestart))
+	assert.Equal(t, "CMD LIVE_START", legacy.EncodeControl(CommandLiveStart))
+
+	for _, line := range []string{"CMD RESTART", "CMD LIVE_START\n"} {
+		c, ok := ParseLegacyControl(line)
+		require.True(t, ok, line)
+		assert.Contains(t, []string{CommandRestart, CommandLiveStart}, c.Command)
+	}
+	_, ok := ParseLegacyControl(`{"version":2,"type":"control"}`)
+	assert.False(t, ok)
+	_, ok = ParseLegacyControl("CMD ")
+	assert.False(t, ok)
+
+	s, err := Negotiate(NewHello().Values.(HelloValue))
+	require.NoError(t, err)
+	var msg Message
+	require.NoError(t, json.Unmarshal([]byte(s.EncodeControl(CommandLiveStart)), &msg))
+	assert.Equal(t, Message{Version: Version, Type: TypeControl, Values: ControlValue{Command: CommandLiveStart}}, msg)
+}
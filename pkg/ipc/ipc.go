@@ -5,8 +5,8 @@ import (
 	"io"
 )
 
-// Version is the IPC protocol version.
-const Version = 1
+// Version is the newest IPC protocol version, negotiated down to the version of the peer by the hello handshake.
+const Version = 2
 
 // IPC message types.
 const (
@@ -17,9 +17,14 @@ const (
 	TypeSyncEState        = "synce_state"
 	TypeSyncEClockQuality = "synce_clock_quality"
 	TypeSyncState         = "sync_state"
+	TypeOffsetSummary     = "offset_summary"
 	TypeCacheClear        = "cache_clear"
 	TypeStatusRequest     = "status_request"
 	TypeStatusResponse    = "status_response"
+	TypeHello             = "hello"
+	TypeControl           = "control"
+	TypePortState         = "port_state"
+	TypeTimeErrorMask     = "time_error_mask"
 )
 
 // Synchronization state values.
@@ -118,6 +123,36 @@ func (m *Message) UnmarshalJSON(data []byte) error {
 			return err
 		}
 		m.Values = v
+	case TypeOffsetSummary:
+		var v OffsetSummaryValue
+		if err := json.Unmarshal(r.Values, &v); err != nil {
+			return err
+		}
+		m.Values = v
+	case TypeHello:
+		var v HelloValue
+		if err := json.Unmarshal(r.Values, &v); err != nil {
+			return err
+		}
+		m.Values = v
+	case TypeControl:
+		var v ControlValue
+		if err := json.Unmarshal(r.Values, &v); err != nil {
+			return err
+		}
+		m.Values = v
+	case TypePortState:
+		var v PortStateValue
+		if err := json.Unmarshal(r.Values, &v); err != nil {
+			return err
+		}
+		m.Values = v
+	case TypeTimeErrorMask:
+		var v TimeErrorMaskValue
+		if err := json.Unmarshal(r.Values, &v); err != nil {
+			return err
+		}
+		m.Values = v
 	}
 	return nil
 }
@@ -162,6 +197,14 @@ type ClockClassValue struct {
 // Value implements Value.
 func (ClockClassValue) Value() {}
 
+// PortStateValue carries the role of a PTP port, e.g. SLAVE, MASTER, PASSIVE, FAULTY or LISTENING.
+type PortStateValue struct {
+	Role string `json:"role"`
+}
+
+// Value implements Value.
+func (PortStateValue) Value() {}
+
 // SyncEClockQualityValue carries SyncE clock quality levels.
 type SyncEClockQualityValue struct {
 	QL         int `json:"ql"`
@@ -171,6 +214,45 @@ type SyncEClockQualityValue struct {
 // Value implements Value.
 func (SyncEClockQualityValue) Value() {}
 
+// OffsetSummaryValue carries offset statistics of a profile source over a window, in nanoseconds.
+type OffsetSummaryValue struct {
+	Source  string  `json:"source"`
+	Window  float64 `json:"window_seconds"`
+	Samples int     `json:"samples"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+	Mean    float64 `json:"mean"`
+	StdDev  float64 `json:"stddev"`
+}
+
+// Value implements Value.
+func (OffsetSummaryValue) Value() {}
+
+// Time error mask verdicts.
+const (
+	MaskPass = "PASS"
+	MaskFail = "FAIL"
+)
+
+// TimeErrorResult is a time error statistic that exceeds its mask limit, in nanoseconds.
+type TimeErrorResult struct {
+	Tau   int     `json:"tau_seconds"`
+	Value float64 `json:"value"`
+	Limit float64 `json:"limit"`
+}
+
+// TimeErrorMaskValue carries a change of the verdict of an MTIE or TDEV stream against its ITU-T mask.
+type TimeErrorMaskValue struct {
+	Source    string            `json:"source"`
+	Statistic string            `json:"statistic"`
+	Mask      string            `json:"mask"`
+	Verdict   string            `json:"verdict"`
+	Failed    []TimeErrorResult `json:"failed,omitempty"`
+}
+
+// Value implements Value.
+func (TimeErrorMaskValue) Value() {}
+
 // Encode encodes the given msgs as newline deliminated JSON, and writes them to the given writer
 func Encode(w io.Writer, msgs []Message) error {
 	enc := json.NewEncoder(w)
@@ -69,6 +69,35 @@ func TestMessageJSON(t *testing.T) {
 			},
 			wantValues: SyncEClockQualityValue{QL: 2, ExtendedQL: 10},
 		},
+		{
+			name: "offset summary value",
+			msg: Message{
+				Version: Version, Type: TypeOffsetSummary,
+				Profile: "ptp4l.0.config", IFace: "ens2f0",
+				Values: OffsetSummaryValue{Source: "ptp4l", Window: 60, Samples: 60, Min: -12, Max: 9, Mean: -1.5, StdDev: 4.2},
+			},
+			wantValues: OffsetSummaryValue{Source: "ptp4l", Window: 60, Samples: 60, Min: -12, Max: 9, Mean: -1.5, StdDev: 4.2},
+		},
+		{
+			name: "port state value",
+			msg: Message{
+				Version: Version, Type: TypePortState,
+				Profile: "ptp4l.0.config", IFace: "ens1f0",
+				Values: PortStateValue{Role: "FAULTY"},
+			},
+			wantValues: PortStateValue{Role: "FAULTY"},
+		},
+		{
+			name: "time error mask value",
+			msg: Message{
+				Version: Version, Type: TypeTimeErrorMask,
+				Profile: "ts2phc.0.config", IFace: "ens1f0",
+				Values: TimeErrorMaskValue{Source: "ts2phc", Statistic: "mtie", Mask: "G.8272-PRTC-A", Verdict: MaskFail,
+					Failed: []TimeErrorResult{{Tau: 10, Value: 120, Limit: 100}}},
+			},
+			wantValues: TimeErrorMaskValue{Source: "ts2phc", Statistic: "mtie", Mask: "G.8272-PRTC-A", Verdict: MaskFail,
+				Failed: []TimeErrorResult{{Tau: 10, Value: 120, Limit: 100}}},
+		},
 		{
 			name: "no values",
 			msg: Message{
@@ -0,0 +1,115 @@
+package timeerror
+
+import "sort"
+
+// Statistic identifies a time error statistic
+type Statistic string
+
+const (
+	// StatMTIE is the maximum time interval error
+	StatMTIE Statistic = "mtie"
+	// StatTDEV is the time deviation
+	StatTDEV Statistic = "tdev"
+)
+
+// Mask names that can be selected per profile
+const (
+	MaskPRTCA  = "G.8272-PRTC-A"
+	MaskPRTCB  = "G.8272-PRTC-B"
+	MaskClassA = "G.8273.2-Class-A"
+	MaskClassB = "G.8273.2-Class-B"
+	MaskClassC = "G.8273.2-Class-C"
+)
+
+// segment is a piece of a mask limit in ns, valid for observation intervals from < tau <= to (seconds)
+type segment struct {
+	from, to float64
+	limit    func(tau float64) float64
+}
+
+// Mask is an ITU-T MTIE/TDEV limit for the time error at a clock output
+type Mask struct {
+	Name string
+	mtie []segment
+	tdev []segment
+}
+
+func constant(ns float64) func(float64) float64 {
+	return func(float64) float64 { return ns }
+}
+
+// Masks of the supported ITU-T recommendations. G.8272 gives the PRTC output wander limits and
+// G.8273.2 the dTE_L wander generation of T-BC/T-TSC under constant temperature. Network limits
+// of G.8271.1 depend on the deployment case and are not included.
+var masks = map[string]Mask{
+	MaskPRTCA: {
+		Name: MaskPRTCA,
+		mtie: []segment{
+			{0, 273, func(tau float64) float64 { return 0.275*tau + 25 }},
+			{273, 1e7, constant(100)},
+		},
+		tdev: []segment{
+			{0, 100, constant(3)},
+			{100, 1000, func(tau float64) float64 { return 0.03 * tau }},
+			{1000, 1e4, constant(30)},
+		},
+	},
+	MaskPRTCB: {
+		Name: MaskPRTCB,
+		mtie: []segment{
+			{0, 54.5, func(tau float64) float64 { return 0.275*tau + 25 }},
+			{54.5, 1e7, constant(40)},
+		},
+		tdev: []segment{
+			{0, 100, constant(1)},
+			{100, 500, func(tau float64) float64 { return 0.01 * tau }},
+			{500, 1e5, constant(5)},
+		},
+	},
+	MaskClassA: {
+		Name: MaskClassA,
+		mtie: []segment{{0, 1000, constant(40)}},
+		tdev: []segment{{0, 1000, constant(4)}},
+	},
+	MaskClassB: {
+		Name: MaskClassB,
+		mtie: []segment{{0, 1000, constant(40)}},
+		tdev: []segment{{0, 1000, constant(4)}},
+	},
+	MaskClassC: {
+		Name: MaskClassC,
+		mtie: []segment{{0, 1000, constant(10)}},
+		tdev: []segment{{0, 1000, constant(2)}},
+	},
+}
+
+// GetMask returns the mask with the given name
+func GetMask(name string) (Mask, bool) {
+	m, ok := masks[name]
+	return m, ok
+}
+
+// MaskNames returns the names of all supported masks, sorted
+func MaskNames() []string {
+	names := make([]string, 0, len(masks))
+	for name := range masks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Limit returns the limit in ns of the statistic for an observation interval of tau seconds.
+// Returns false when the mask does not define the interval.
+func (m Mask) Limit(stat Statistic, tau float64) (float64, bool) {
+	segments := m.mtie
+	if stat == StatTDEV {
+		segments = m.tdev
+	}
+	for _, s := range segments {
+		if tau > s.from && tau <= s.to {
+			return s.limit(tau), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,323 @@
+package timeerror
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/utils"
+)
+
+const (
+	// IntervalsSettingKey is the PtpSettings key holding the comma separated observation intervals in seconds
+	IntervalsSettingKey = "timeErrorIntervals"
+	// MaskSettingKey is the PtpSettings key selecting the ITU-T mask the statistics are compared against
+	MaskSettingKey = "timeErrorMask"
+	// DefaultIntervals are used when only the mask is configured
+	DefaultIntervals = "1,10,100"
+	// DefaultEvaluationPeriod is how often statistics are recomputed
+	DefaultEvaluationPeriod = 10 * time.Second
+	// maxInterval bounds the sample history kept per stream (3*maxInterval+1 samples)
+	maxInterval = 10000
+)
+
+// Config holds the time error settings of a profile
+type Config struct {
+	Profile   string
+	Intervals []int // observation intervals in seconds
+	Mask      string
+}
+
+// ParseConfig reads the time error settings of a profile.
+// Returns nil when time error statistics are not enabled for the profile.
+func ParseConfig(profile string, settings map[string]string) (*Config, error) {
+	sIntervals, hasIntervals := settings[IntervalsSettingKey]
+	mask, hasMask := settings[MaskSettingKey]
+	if !hasIntervals && !hasMask {
+		return nil, nil
+	}
+	if hasMask {
+		if _, ok := GetMask(mask); !ok {
+			return nil, fmt.Errorf("unknown %s %q, supported masks: %s", MaskSettingKey, mask, strings.Join(MaskNames(), ", "))
+		}
+	}
+	if !hasIntervals || strings.TrimSpace(sIntervals) == "" {
+		sIntervals = DefaultIntervals
+	}
+	cfg := &Config{Profile: profile, Mask: mask}
+	for _, s := range strings.Split(sIntervals, ",") {
+		tau, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || tau < 1 || tau > maxInterval {
+			return nil, fmt.Errorf("invalid %s value %q: intervals must be integers between 1 and %d", IntervalsSettingKey, s, maxInterval)
+		}
+		cfg.Intervals = append(cfg.Intervals, tau)
+	}
+	sort.Ints(cfg.Intervals)
+	return cfg, nil
+}
+
+// Result is a statistic computed for one observation interval
+type Result struct {
+	Tau   int     `json:"tau"`
+	Value float64 `json:"value"`
+	Limit float64 `json:"limit"`
+}
+
+// MaskEvent is raised when a stream starts or stops meeting its mask
+type MaskEvent struct {
+	Profile   string
+	Process   string
+	CfgName   string
+	IFace     string
+	Mask      string
+	Statistic Statistic
+	Pass      bool
+	Failed    []Result // intervals exceeding the mask, empty on pass
+}
+
+func (m MaskEvent) String() string {
+	verdict := "PASS"
+	if !m.Pass {
+		verdict = "FAIL"
+	}
+	failed := make([]string, 0, len(m.Failed))
+	for _, r := range m.Failed {
+		failed = append(failed, fmt.Sprintf("tau=%ds %.2f>%.2fns", r.Tau, r.Value, r.Limit))
+	}
+	return fmt.Sprintf("time error %s %s mask %s: profile %s %s[%s] iface %s %s",
+		m.Statistic, verdict, m.Mask, m.Profile, m.Process, m.CfgName, m.IFace, strings.Join(failed, " "))
+}
+
+type streamKey struct {
+	process string
+	cfgName string
+	iface   string
+}
+
+type stream struct {
+	window *utils.Window
+	size   int       // capacity of the window
+	last   time.Time // time of the last sample kept
+	// interval is the sampling interval of the stream in seconds, learned from the spacing
+	// of its samples, 0 until known
+	interval int
+	pass     map[Statistic]bool // last mask verdict, absent until first evaluated
+}
+
+// minSampleSpacing is the fraction of the sampling interval below which samples are dropped, so that
+// faster streams are decimated to the interval without counting timestamp jitter as a missed sample
+const minSampleSpacing = 0.9
+
+// add keeps the sample if it is the next one of the stream at its sampling interval. A gap of one
+// or more missed samples restarts the history, the statistics are only computed over gap-free samples.
+func (s *stream) add(offset float64, t time.Time) {
+	if s.last.IsZero() {
+		s.last = t
+		s.window.Insert(offset)
+		return
+	}
+	elapsed := t.Sub(s.last).Seconds()
+	interval := max(s.interval, 1)
+	if elapsed < minSampleSpacing*float64(interval) {
+		return
+	}
+	if s.interval == 0 {
+		s.interval = max(int(math.Round(elapsed)), 1)
+	} else if steps := math.Round(elapsed / float64(s.interval)); steps > 1 {
+		glog.Infof("time error samples missed for %.0fs, restarting the statistics", elapsed)
+		s.window = utils.NewWindow(s.size)
+		s.interval = 0
+	}
+	s.last = t
+	s.window.Insert(offset)
+}
+
+// Monitor computes MTIE and TDEV of offset streams, decimated to one sample per second,
+// for the profiles that enable it and compares them against the selected mask
+type Monitor struct {
+	sync.Mutex
+	nodeName    string
+	configs     map[string]*Config // keyed by process config name
+	streams     map[streamKey]*stream
+	mtie        *prometheus.GaugeVec
+	tdev        *prometheus.GaugeVec
+	maskPass    *prometheus.GaugeVec
+	violations  *prometheus.CounterVec
+	onMaskEvent func(MaskEvent)
+}
+
+// NewMonitor creates a time error monitor exporting to the given metrics.
+// onMaskEvent, if not nil, is called for every mask verdict change.
+func NewMonitor(nodeName string, mtie, tdev, maskPass *prometheus.GaugeVec, violations *prometheus.CounterVec,
+	onMaskEvent func(MaskEvent)) *Monitor {
+	return &Monitor{
+		nodeName:    nodeName,
+		configs:     map[string]*Config{},
+		streams:     map[streamKey]*stream{},
+		mtie:        mtie,
+		tdev:        tdev,
+		maskPass:    maskPass,
+		violations:  violations,
+		onMaskEvent: onMaskEvent,
+	}
+}
+
+// Configure enables time error statistics for the process config name
+func (m *Monitor) Configure(cfgName string, cfg *Config) {
+	if m == nil || cfg == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.configs[cfgName] = cfg
+}
+
+// Reset drops all configurations, sample history and exported series
+func (m *Monitor) Reset() {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.configs = map[string]*Config{}
+	m.streams = map[streamKey]*stream{}
+	labels := prometheus.Labels{"node": m.nodeName}
+	m.mtie.DeletePartialMatch(labels)
+	m.tdev.DeletePartialMatch(labels)
+	m.maskPass.DeletePartialMatch(labels)
+	m.violations.DeletePartialMatch(labels)
+}
+
+// Observe records an offset in ns of a process for the interface, at time t. Streams are sampled at
+// their reporting interval, e.g. the summary interval of ptp4l, of at least one second: faster streams
+// are decimated to one sample per second.
+func (m *Monitor) Observe(process, cfgName, iface string, offset float64, t time.Time) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	cfg, ok := m.configs[cfgName]
+	if !ok {
+		return
+	}
+	key := streamKey{process: process, cfgName: cfgName, iface: iface}
+	s, ok := m.streams[key]
+	if !ok {
+		size := 3*cfg.Intervals[len(cfg.Intervals)-1] + 1
+		s = &stream{
+			window: utils.NewWindow(size),
+			size:   size,
+			pass:   map[Statistic]bool{},
+		}
+		m.streams[key] = s
+	}
+	s.add(offset, t)
+}
+
+// Evaluate recomputes the statistics of every stream, updates the metrics and raises mask events
+func (m *Monitor) Evaluate() {
+	if m == nil {
+		return
+	}
+	var events []MaskEvent
+	m.Lock()
+	for key, s := range m.streams {
+		cfg := m.configs[key.cfgName]
+		samples := s.window.Values()
+		results := map[Statistic][]Result{}
+		for _, tau := range cfg.Intervals {
+			// the observation interval in samples of the stream, intervals that are not a multiple
+			// of the sampling interval are not computed
+			if s.interval == 0 || tau%s.interval != 0 {
+				continue
+			}
+			n := tau / s.interval
+			labels := prometheus.Labels{"process": key.process, "node": m.nodeName, "profile": cfg.Profile,
+				"iface": key.iface, "tau": strconv.Itoa(tau)}
+			if v, ok := MTIE(samples, n); ok {
+				m.mtie.With(labels).Set(v)
+				results[StatMTIE] = append(results[StatMTIE], Result{Tau: tau, Value: v})
+			}
+			if v, ok := TDEV(samples, n); ok {
+				m.tdev.With(labels).Set(v)
+				results[StatTDEV] = append(results[StatTDEV], Result{Tau: tau, Value: v})
+			}
+		}
+		mask, ok := GetMask(cfg.Mask)
+		if !ok {
+			continue
+		}
+		for _, stat := range []Statistic{StatMTIE, StatTDEV} {
+			if len(results[stat]) == 0 {
+				continue
+			}
+			pass, failed := check(mask, stat, results[stat])
+			labels := prometheus.Labels{"process": key.process, "node": m.nodeName, "profile": cfg.Profile,
+				"iface": key.iface, "mask": mask.Name, "statistic": string(stat)}
+			if pass {
+				m.maskPass.With(labels).Set(1)
+			} else {
+				m.maskPass.With(labels).Set(0)
+			}
+			if previous, evaluated := s.pass[stat]; evaluated && previous == pass {
+				continue
+			}
+			if !pass {
+				m.violations.With(labels).Inc()
+			}
+			s.pass[stat] = pass
+			events = append(events, MaskEvent{Profile: cfg.Profile, Process: key.process, CfgName: key.cfgName,
+				IFace: key.iface, Mask: mask.Name, Statistic: stat, Pass: pass, Failed: failed})
+		}
+	}
+	m.Unlock()
+	if m.onMaskEvent == nil {
+		return
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].String() < events[j].String() })
+	for _, e := range events {
+		m.onMaskEvent(e)
+	}
+}
+
+// check compares the results with the mask limits, intervals the mask does not define are ignored
+func check(mask Mask, stat Statistic, results []Result) (bool, []Result) {
+	var failed []Result
+	for _, r := range results {
+		limit, ok := mask.Limit(stat, float64(r.Tau))
+		if !ok {
+			continue
+		}
+		if r.Value > limit {
+			r.Limit = limit
+			failed = append(failed, r)
+		}
+	}
+	return len(failed) == 0, failed
+}
+
+// Run evaluates the statistics every period until stopCh is closed
+func (m *Monitor) Run(stopCh <-chan struct{}, period time.Duration) {
+	if m == nil {
+		return
+	}
+	glog.Infof("starting time error monitoring, evaluation period %s", period)
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			m.Evaluate()
+		}
+	}
+}
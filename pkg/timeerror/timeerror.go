@@ -0,0 +1,63 @@
+// Package timeerror computes ITU-T time error statistics (MTIE and TDEV) over offset
+// streams sampled at a fixed interval and compares them against ITU-T masks.
+package timeerror
+
+import "math"
+
+// MTIE returns the maximum time interval error for an observation interval of tau samples,
+// i.e. the largest peak-to-peak time error over every window of tau+1 consecutive samples.
+// Samples must be ordered from oldest to newest. Returns false when there are not enough samples.
+func MTIE(samples []float64, tau int) (float64, bool) {
+	if tau < 1 || len(samples) < tau+1 {
+		return 0, false
+	}
+	// monotonic queues of sample indexes holding the window maximum and minimum at their heads
+	var maxQ, minQ []int
+	var mtie float64
+	for i, x := range samples {
+		for len(maxQ) > 0 && samples[maxQ[len(maxQ)-1]] <= x {
+			maxQ = maxQ[:len(maxQ)-1]
+		}
+		maxQ = append(maxQ, i)
+		for len(minQ) > 0 && samples[minQ[len(minQ)-1]] >= x {
+			minQ = minQ[:len(minQ)-1]
+		}
+		minQ = append(minQ, i)
+		if maxQ[0] < i-tau {
+			maxQ = maxQ[1:]
+		}
+		if minQ[0] < i-tau {
+			minQ = minQ[1:]
+		}
+		if i >= tau {
+			mtie = math.Max(mtie, samples[maxQ[0]]-samples[minQ[0]])
+		}
+	}
+	return mtie, true
+}
+
+// TDEV returns the time deviation for an observation interval of n samples, as defined in
+// ITU-T G.810 from N time error samples:
+//
+//	TDEV(n) = sqrt( 1/(6n²(N-3n+1)) · Σ_{j=1}^{N-3n+1} [ Σ_{i=j}^{n+j-1} (x_{i+2n} - 2x_{i+n} + x_i) ]² )
+//
+// Samples must be ordered from oldest to newest. Returns false when there are not enough samples.
+func TDEV(samples []float64, n int) (float64, bool) {
+	count := len(samples) - 3*n + 1
+	if n < 1 || count < 1 {
+		return 0, false
+	}
+	// second differences of the time error, summed over a sliding window of n terms
+	diffs := len(samples) - 2*n
+	var windowSum, total float64
+	for i := 0; i < diffs; i++ {
+		windowSum += samples[i+2*n] - 2*samples[i+n] + samples[i]
+		if i >= n {
+			windowSum -= samples[i+n] - 2*samples[i] + samples[i-n]
+		}
+		if i >= n-1 {
+			total += windowSum * windowSum
+		}
+	}
+	return math.Sqrt(total / (6 * float64(n) * float64(n) * float64(count))), true
+}
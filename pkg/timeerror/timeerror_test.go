@@ -0,0 +1,194 @@
+package timeerror_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/timeerror"
+)
+
+// tdevReference is a direct transcription of the G.810 estimator
+func tdevReference(x []float64, n int) float64 {
+	N := len(x)
+	var total float64
+	for j := 0; j <= N-3*n; j++ {
+		var inner float64
+		for i := j; i < j+n; i++ {
+			inner += x[i+2*n] - 2*x[i+n] + x[i]
+		}
+		total += inner * inner
+	}
+	return math.Sqrt(total / (6 * float64(n*n) * float64(N-3*n+1)))
+}
+
+func TestMTIE(t *testing.T) {
+	samples := []float64{0, 3, -2, 5, 1, 1, -4, 0}
+	v, ok := timeerror.MTIE(samples, 1)
+	assert.True(t, ok)
+	assert.Equal(t, 7.0, v) // -2 -> 5
+	v, _ = timeerror.MTIE(samples, 3)
+	assert.Equal(t, 9.0, v) // 5 -> -4
+	v, _ = timeerror.MTIE(samples, 7)
+	assert.Equal(t, 9.0, v)
+	_, ok = timeerror.MTIE(samples, 8)
+	assert.False(t, ok)
+}
+
+func TestTDEV(t *testing.T) {
+	samples := make([]float64, 100)
+	for i := range samples {
+		samples[i] = 3*float64(i) + 10 // constant frequency offset does not contribute
+	}
+	v, ok := timeerror.TDEV(samples, 5)
+	assert.True(t, ok)
+	assert.InDelta(t, 0, v, 1e-9)
+
+	for i := range samples {
+		samples[i] = math.Sin(float64(i)*0.7)*20 + float64(i%7)
+	}
+	for _, n := range []int{1, 2, 10, 33} {
+		v, ok = timeerror.TDEV(samples, n)
+		assert.True(t, ok)
+		assert.InDelta(t, tdevReference(samples, n), v, 1e-9, "n=%d", n)
+	}
+	_, ok = timeerror.TDEV(samples, 34)
+	assert.False(t, ok)
+}
+
+func TestMaskLimit(t *testing.T) {
+	mask, ok := timeerror.GetMask(timeerror.MaskPRTCA)
+	assert.True(t, ok)
+	limit, ok := mask.Limit(timeerror.StatMTIE, 100)
+	assert.True(t, ok)
+	assert.InDelta(t, 52.5, limit, 1e-9)
+	limit, _ = mask.Limit(timeerror.StatMTIE, 1000)
+	assert.Equal(t, 100.0, limit)
+	limit, _ = mask.Limit(timeerror.StatTDEV, 500)
+	assert.InDelta(t, 15, limit, 1e-9)
+
+	mask, _ = timeerror.GetMask(timeerror.MaskClassC)
+	_, ok = mask.Limit(timeerror.StatTDEV, 5000)
+	assert.False(t, ok)
+
+	_, ok = timeerror.GetMask("G.0000")
+	assert.False(t, ok)
+	assert.Contains(t, timeerror.MaskNames(), timeerror.MaskPRTCB)
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := timeerror.ParseConfig("p", map[string]string{})
+	assert.NoError(t, err)
+	assert.Nil(t, cfg)
+
+	cfg, err = timeerror.ParseConfig("p", map[string]string{timeerror.MaskSettingKey: timeerror.MaskClassA})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 10, 100}, cfg.Intervals)
+
+	cfg, err = timeerror.ParseConfig("p", map[string]string{timeerror.IntervalsSettingKey: "30, 4"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{4, 30}, cfg.Intervals)
+	assert.Empty(t, cfg.Mask)
+
+	_, err = timeerror.ParseConfig("p", map[string]string{timeerror.IntervalsSettingKey: "0"})
+	assert.Error(t, err)
+	_, err = timeerror.ParseConfig("p", map[string]string{timeerror.MaskSettingKey: "bogus"})
+	assert.Error(t, err)
+}
+
+func TestMonitor(t *testing.T) {
+	labels := []string{"process", "node", "profile", "iface", "tau"}
+	maskLabels := []string{"process", "node", "profile", "iface", "mask", "statistic"}
+	mtie := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "mtie"}, labels)
+	tdev := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "tdev"}, labels)
+	pass := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "pass"}, maskLabels)
+	violations := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "violations"}, maskLabels)
+	var events []timeerror.MaskEvent
+	m := timeerror.NewMonitor("node1", mtie, tdev, pass, violations, func(e timeerror.MaskEvent) { events = append(events, e) })
+
+	cfg, err := timeerror.ParseConfig("gm", map[string]string{
+		timeerror.IntervalsSettingKey: "1,2",
+		timeerror.MaskSettingKey:      timeerror.MaskClassC,
+	})
+	assert.NoError(t, err)
+	m.Configure("ts2phc.0.config", cfg)
+
+	start := time.Unix(1000, 0)
+	m.Observe("ts2phc", "unknown.config", "ens1f0", 1, start) // not configured, ignored
+	for i := 0; i < 7; i++ {
+		m.Observe("ts2phc", "ts2phc.0.config", "ens1f0", float64(i%2), start.Add(time.Duration(i)*time.Second))
+		// second sample within the same second is dropped
+		m.Observe("ts2phc", "ts2phc.0.config", "ens1f0", 100, start.Add(time.Duration(i)*time.Second+time.Millisecond))
+	}
+	m.Evaluate()
+	assert.Equal(t, 1.0, testutil.ToFloat64(mtie.WithLabelValues("ts2phc", "node1", "gm", "ens1f0", "1")))
+	assert.Equal(t, 2, testutil.CollectAndCount(mtie))
+	mtieLabels := prometheus.Labels{"process": "ts2phc", "node": "node1", "profile": "gm", "iface": "ens1f0",
+		"mask": timeerror.MaskClassC, "statistic": string(timeerror.StatMTIE)}
+	assert.Equal(t, 1.0, testutil.ToFloat64(pass.With(mtieLabels)))
+	assert.Len(t, events, 2)
+	assert.True(t, events[0].Pass)
+
+	// a 50ns step exceeds the 10ns class C MTIE limit
+	m.Observe("ts2phc", "ts2phc.0.config", "ens1f0", 50, start.Add(7*time.Second))
+	events = nil
+	m.Evaluate()
+	assert.Equal(t, 0.0, testutil.ToFloat64(pass.With(mtieLabels)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(violations.With(mtieLabels)))
+	if assert.NotEmpty(t, events) {
+		assert.False(t, events[0].Pass)
+		assert.Equal(t, timeerror.StatMTIE, events[0].Statistic)
+		assert.Contains(t, events[0].String(), "FAIL")
+	}
+
+	// no new event while the verdict does not change
+	events = nil
+	m.Evaluate()
+	assert.Empty(t, events)
+
+	m.Reset()
+	assert.Equal(t, 0, testutil.CollectAndCount(mtie))
+	m.Observe("ts2phc", "ts2phc.0.config", "ens1f0", 1, start.Add(8*time.Second))
+	m.Evaluate()
+	assert.Equal(t, 0, testutil.CollectAndCount(mtie))
+}
+
+func TestMonitor_SamplingInterval(t *testing.T) {
+	labels := []string{"process", "node", "profile", "iface", "tau"}
+	maskLabels := []string{"process", "node", "profile", "iface", "mask", "statistic"}
+	mtie := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "mtie"}, labels)
+	tdev := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "tdev"}, labels)
+	pass := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "pass"}, maskLabels)
+	violations := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "violations"}, maskLabels)
+	m := timeerror.NewMonitor("node1", mtie, tdev, pass, violations, nil)
+	cfg, err := timeerror.ParseConfig("bc", map[string]string{timeerror.IntervalsSettingKey: "1,2,4"})
+	assert.NoError(t, err)
+	m.Configure("ptp4l.0.config", cfg)
+
+	// a summary every 2s, with timestamp jitter
+	start := time.Unix(1000, 0)
+	offsets := []float64{0, 4, 0, 0, 0, 0, 0}
+	for i, offset := range offsets {
+		jitter := time.Duration(i%2) * 30 * time.Millisecond
+		m.Observe("ptp4l", "ptp4l.0.config", "ens1f0", offset, start.Add(time.Duration(2*i)*time.Second+jitter))
+	}
+	m.Evaluate()
+	assert.Equal(t, 2, testutil.CollectAndCount(mtie), "tau 1s is shorter than the sampling interval")
+	// tau 2s is one sample, tau 4s two
+	assert.Equal(t, 4.0, testutil.ToFloat64(mtie.WithLabelValues("ptp4l", "node1", "bc", "ens1f0", "2")))
+	assert.Equal(t, 4.0, testutil.ToFloat64(mtie.WithLabelValues("ptp4l", "node1", "bc", "ens1f0", "4")))
+
+	// missed samples restart the statistics
+	m.Reset()
+	m.Configure("ptp4l.0.config", cfg)
+	for i, offset := range offsets {
+		m.Observe("ptp4l", "ptp4l.0.config", "ens1f0", offset, start.Add(time.Duration(i)*time.Second))
+	}
+	m.Observe("ptp4l", "ptp4l.0.config", "ens1f0", 0, start.Add(100*time.Second))
+	m.Evaluate()
+	assert.Equal(t, 0, testutil.CollectAndCount(mtie), "a single sample after the gap")
+}
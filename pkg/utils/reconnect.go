@@ -5,6 +5,7 @@ package utils
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"time"
 
@@ -44,31 +45,46 @@ func DefaultReconnectConfig() ReconnectConfig {
 // cancellation via the provided context.
 // Returns the new connection, or nil if all attempts are exhausted or the context is cancelled.
 func ReconnectWithBackoff(ctx context.Context, dialFn func() (net.Conn, error), cfg ReconnectConfig) net.Conn {
-	glog.Info("Attempting to reconnect to event socket")
+	conn, err := RedialWithBackoff(ctx, "event socket", dialFn, cfg)
+	if err != nil {
+		return nil
+	}
+	return conn
+}
+
+// RedialWithBackoff is ReconnectWithBackoff for any kind of connection, e.g. a netlink
+// socket. The target names the connection in the logs.
+// Returns the new connection, or the last dial error if all attempts are exhausted,
+// or the context error if it is cancelled.
+func RedialWithBackoff[T any](ctx context.Context, target string, dialFn func() (T, error), cfg ReconnectConfig) (T, error) {
+	var zero T
+	glog.Infof("Attempting to reconnect to %s", target)
 
 	backoff := cfg.BackoffBase
+	err := fmt.Errorf("no reconnection attempt to %s", target)
 	for attempt := 1; attempt <= cfg.MaxAttempts; attempt++ {
 		select {
 		case <-ctx.Done():
 			glog.Info("Stop signal received, aborting reconnect attempt")
-			return nil
+			return zero, ctx.Err()
 		default:
 		}
 
-		newConn, err := dialFn()
+		var newConn T
+		newConn, err = dialFn()
 		if err == nil {
-			glog.Infof("Successfully reconnected to event socket after %d attempt(s)", attempt)
-			return newConn
+			glog.Infof("Successfully reconnected to %s after %d attempt(s)", target, attempt)
+			return newConn, nil
 		}
 
 		if attempt < cfg.MaxAttempts {
-			glog.Warningf("Failed to reconnect to event socket (attempt %d/%d): %v, retrying in %v",
-				attempt, cfg.MaxAttempts, err, backoff)
+			glog.Warningf("Failed to reconnect to %s (attempt %d/%d): %v, retrying in %v",
+				target, attempt, cfg.MaxAttempts, err, backoff)
 			select {
 			case <-time.After(backoff):
 			case <-ctx.Done():
 				glog.Info("Stop signal received during backoff, aborting reconnect")
-				return nil
+				return zero, ctx.Err()
 			}
 			backoff *= 2
 			if backoff > cfg.MaxBackoff {
@@ -77,6 +93,6 @@ func ReconnectWithBackoff(ctx context.Context, dialFn func() (net.Conn, error),
 		}
 	}
 
-	glog.Errorf("Failed to reconnect to event socket after %d attempts", cfg.MaxAttempts)
-	return nil
+	glog.Errorf("Failed to reconnect to %s after %d attempts", target, cfg.MaxAttempts)
+	return zero, err
 }
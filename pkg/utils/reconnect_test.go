@@ -141,3 +141,32 @@ func TestReconnectWithBackoff_BackoffCapsAtMaxBackoff(t *testing.T) {
 	// With cap at 2ms and 4 sleeps: 1 + 2 + 2 + 2 = 7ms total sleep (plus overhead)
 	assert.Less(t, elapsed, 200*time.Millisecond, "backoff should be capped and fast")
 }
+
+// --- RedialWithBackoff ---
+
+func TestRedialWithBackoff_ReturnsErrors(t *testing.T) {
+	cfg := utils.ReconnectConfig{
+		MaxAttempts: 3,
+		BackoffBase: 1 * time.Millisecond,
+		MaxBackoff:  2 * time.Millisecond,
+	}
+	attempts := 0
+	refused := errors.New("connection refused")
+	id, err := utils.RedialWithBackoff(context.Background(), "test socket", func() (int, error) {
+		attempts++
+		if attempts < 2 {
+			return 0, refused
+		}
+		return 42, nil
+	}, cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 42, id)
+
+	_, err = utils.RedialWithBackoff(context.Background(), "test socket", func() (int, error) { return 0, refused }, cfg)
+	assert.ErrorIs(t, err, refused, "the last dial error is returned")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = utils.RedialWithBackoff(ctx, "test socket", func() (int, error) { return 1, nil }, cfg)
+	assert.ErrorIs(t, err, context.Canceled)
+}
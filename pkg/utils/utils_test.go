@@ -89,3 +89,14 @@ func TestWindowIsEmpty(t *testing.T) {
 		assert.True(t, w.IsFull())
 	})
 }
+
+func TestWindowValues(t *testing.T) {
+	w := utils.NewWindow(3)
+	assert.Empty(t, w.Values())
+	w.Insert(1.0)
+	w.Insert(2.0)
+	assert.Equal(t, []float64{1, 2}, w.Values())
+	w.Insert(3.0)
+	w.Insert(4.0)
+	assert.Equal(t, []float64{2, 3, 4}, w.Values())
+}
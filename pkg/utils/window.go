@@ -136,6 +136,16 @@ func (w *Window) LastInserted() float64 {
 	return w.data[lastIndex]
 }
 
+// Values returns a copy of the values in the window, ordered from oldest to newest
+func (w *Window) Values() []float64 {
+	if !w.full {
+		return append([]float64(nil), w.data[:w.nextIndex]...)
+	}
+	values := make([]float64, 0, w.size)
+	values = append(values, w.data[w.nextIndex:]...)
+	return append(values, w.data[:w.nextIndex]...)
+}
+
 // IsFull returns true if the window has been filled to capacity
 func (w *Window) IsFull() bool {
 	return w.full
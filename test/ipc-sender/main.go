@@ -16,6 +16,10 @@ func main() {
 	iface := flag.String("iface", "", "Interface name")
 	state := flag.String("state", "", "State value (for state-type messages)")
 	clockClass := flag.String("clock-class", "", "Clock class value (for clock_class messages)")
+	command := flag.String("command", "", "Control command (for control messages, e.g. restart, live_start)")
+	source := flag.String("source", "", "Offset source (for time_error_mask messages, e.g. ts2phc)")
+	statistic := flag.String("statistic", "mtie", "Time error statistic (for time_error_mask messages, mtie or tdev)")
+	mask := flag.String("mask", "", "ITU-T mask (for time_error_mask messages, e.g. G.8272-PRTC-A)")
 	flag.Parse()
 
 	if *msgType == "" {
@@ -50,6 +54,16 @@ func main() {
 			log.Fatal("--state is required for state-type messages")
 		}
 		msg.Values = ipc.SyncStateValue{State: *state}
+	case ipc.TypePortState:
+		if *state == "" {
+			log.Fatal("--state is required for state-type messages")
+		}
+		msg.Values = ipc.PortStateValue{Role: *state}
+	case ipc.TypeTimeErrorMask:
+		if *state == "" || *source == "" {
+			log.Fatal("--state and --source are required for time_error_mask messages")
+		}
+		msg.Values = ipc.TimeErrorMaskValue{Source: *source, Statistic: *statistic, Mask: *mask, Verdict: *state}
 
 	case ipc.TypeClockClass:
 		if *clockClass == "" {
@@ -64,6 +78,12 @@ func main() {
 	case ipc.TypeCacheClear:
 		// no values needed
 
+	case ipc.TypeControl:
+		if *command == "" {
+			log.Fatal("--command is required for control messages")
+		}
+		msg.Values = ipc.ControlValue{Command: *command}
+
 	default:
 		log.Fatalf("unsupported message type: %s", *msgType)
 	}
@@ -74,7 +94,9 @@ func main() {
 	}
 	defer conn.Close()
 
-	if encodeErr := ipc.Encode(conn, []ipc.Message{msg}); encodeErr != nil {
+	// say hello like the daemon, cloud-event-proxy skips the message types introduced after protocol
+	// version 1 unless they were negotiated
+	if encodeErr := ipc.Encode(conn, []ipc.Message{ipc.NewHello(), msg}); encodeErr != nil {
 		log.Fatalf("failed to send message: %v", encodeErr)
 	}
 }